@@ -0,0 +1,98 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/bborbe/errors"
+	libhttp "github.com/bborbe/http"
+	"github.com/bborbe/run"
+	"github.com/bborbe/sample_cert/pkg"
+	libsentry "github.com/bborbe/sentry"
+	"github.com/bborbe/service"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+	app := &application{}
+	app.CASigner.Register(flag.CommandLine)
+	os.Exit(service.Main(context.Background(), app, &app.SentryDSN, &app.SentryProxy))
+}
+
+type application struct {
+	SentryDSN       string            `required:"false" arg:"sentry-dsn" env:"SENTRY_DSN" usage:"SentryDSN" display:"length"`
+	SentryProxy     string            `required:"false" arg:"sentry-proxy" env:"SENTRY_PROXY" usage:"Sentry Proxy"`
+	DataDir         string            `required:"true" arg:"datadir" env:"DATADIR" usage:"data directory"`
+	Listen          string            `required:"true" arg:"listen" env:"LISTEN" usage:"address to listen to"`
+	CASigner        pkg.CASignerFlags `display:"hidden"`
+	URL             string            `required:"true" arg:"url" env:"URL" usage:"external base url of the acme server, e.g. https://acme.example.com:8444"`
+	Validity        time.Duration     `required:"true" arg:"validity" env:"VALIDITY" usage:"validity of issued certificates" default:"2160h"`
+	HTTP01Port      int               `required:"true" arg:"http01-port" env:"HTTP01_PORT" usage:"port used to validate http-01 challenges" default:"80"`
+	TLSALPN01Port   int               `required:"true" arg:"tlsalpn01-port" env:"TLSALPN01_PORT" usage:"port used to validate tls-alpn-01 challenges" default:"443"`
+	ValidateTimeout time.Duration     `required:"true" arg:"validate-timeout" env:"VALIDATE_TIMEOUT" usage:"timeout of a single challenge validation" default:"10s"`
+	Name            string            `required:"false" arg:"name" env:"NAME" usage:"use <name>/cert.pem and <name>/key.pem for the acme endpoint"`
+	Cert            string            `required:"false" arg:"cert" env:"CERT" usage:"server certificate file of the acme endpoint, default server_cert.pem"`
+	Key             string            `required:"false" arg:"key" env:"KEY" usage:"server key file of the acme endpoint, default server_key.pem"`
+}
+
+func (a *application) Run(ctx context.Context, sentryClient libsentry.Client) error {
+	return service.Run(
+		ctx,
+		a.createHttpServer(),
+	)
+}
+
+func (a *application) createHttpServer() run.Func {
+	return func(ctx context.Context) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		dataDir := pkg.DataDir(a.DataDir)
+		ca, err := a.CASigner.LoadCA(ctx, dataDir)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "load ca failed")
+		}
+		defer ca.Close()
+		inventory, err := dataDir.Inventory(ctx)
+		if err != nil {
+			return err
+		}
+		auditLog, err := dataDir.AuditLog(ctx)
+		if err != nil {
+			return err
+		}
+
+		paths, err := dataDir.ServerIdentity(ctx, a.Name, pkg.IdentityPaths{CertPath: a.Cert, KeyPath: a.Key})
+		if err != nil {
+			return err
+		}
+
+		router := mux.NewRouter()
+		router.Path("/healthz").Handler(libhttp.NewPrintHandler("OK"))
+		router.Path("/readiness").Handler(libhttp.NewPrintHandler("OK"))
+		router.Path("/metrics").Handler(promhttp.Handler())
+		router.PathPrefix("/").Handler(pkg.NewACMEServer(ca, pkg.ACMEServerOptions{
+			BaseURL:   a.URL,
+			Validity:  a.Validity,
+			Validator: pkg.NewACMEChallengeValidator(a.HTTP01Port, a.TLSALPN01Port, a.ValidateTimeout),
+			Inventory: inventory,
+			AuditLog:  auditLog,
+		}))
+
+		glog.V(2).Infof("starting acme server listen on %s with directory %s/directory", a.Listen, a.URL)
+		return libhttp.NewServerTLS(
+			a.Listen,
+			router,
+			paths.CertPath,
+			paths.KeyPath,
+		).Run(ctx)
+	}
+}
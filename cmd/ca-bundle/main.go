@@ -0,0 +1,120 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bborbe/errors"
+	"github.com/bborbe/sample_cert/pkg"
+	libsentry "github.com/bborbe/sentry"
+	"github.com/bborbe/service"
+)
+
+func main() {
+	app := &application{}
+	os.Exit(service.Main(context.Background(), app, &app.SentryDSN, &app.SentryProxy))
+}
+
+type application struct {
+	SentryDSN   string `required:"false" arg:"sentry-dsn" env:"SENTRY_DSN" usage:"SentryDSN" display:"length"`
+	SentryProxy string `required:"false" arg:"sentry-proxy" env:"SENTRY_PROXY" usage:"Sentry Proxy"`
+	DataDir     string `required:"true" arg:"datadir" env:"DATADIR" usage:"data directory"`
+	Bundle      string `required:"true" arg:"bundle" env:"BUNDLE" usage:"PEM bundle to create or update, relative to datadir" default:"trust_bundle.pem"`
+	Manifest    string `required:"false" arg:"manifest" env:"MANIFEST" usage:"JSON manifest of the bundle, relative to datadir, empty to skip" default:"trust_bundle.json"`
+	Add         string `required:"false" arg:"add" env:"ADD" usage:"comma separated files with ca certificates to add"`
+	Remove      string `required:"false" arg:"remove" env:"REMOVE" usage:"comma separated SHA-256 fingerprints to remove"`
+	RemoveCert  string `required:"false" arg:"remove-cert" env:"REMOVE_CERT" usage:"comma separated files with ca certificates to remove"`
+	DropExpired bool   `required:"false" arg:"drop-expired" env:"DROP_EXPIRED" usage:"remove expired certificates"`
+}
+
+func (a *application) Run(ctx context.Context, sentryClient libsentry.Client) error {
+	dataDir := pkg.DataDir(a.DataDir)
+	bundlePath, err := dataDir.Path(ctx, a.Bundle)
+	if err != nil {
+		return err
+	}
+	bundle, err := pkg.LoadCABundle(ctx, bundlePath)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "load bundle failed")
+	}
+	now := time.Now()
+
+	for _, file := range pkg.SplitList(a.Add, ",") {
+		certs, err := a.load(ctx, dataDir, file)
+		if err != nil {
+			return err
+		}
+		var added []*x509.Certificate
+		if bundle, added, err = bundle.Add(ctx, certs...); err != nil {
+			return errors.Wrapf(ctx, err, "add %s failed", file)
+		}
+		printCertificates("added", added)
+	}
+	fingerprints := pkg.SplitList(a.Remove, ",")
+	for _, file := range pkg.SplitList(a.RemoveCert, ",") {
+		certs, err := a.load(ctx, dataDir, file)
+		if err != nil {
+			return err
+		}
+		for _, cert := range certs {
+			fingerprints = append(fingerprints, pkg.SHA256Fingerprint(cert))
+		}
+	}
+	for _, fingerprint := range fingerprints {
+		if !bundle.ContainsFingerprint(fingerprint) {
+			return errors.Errorf(ctx, "fingerprint %s not in bundle", fingerprint)
+		}
+	}
+	var removed []*x509.Certificate
+	bundle, removed = bundle.Remove(fingerprints...)
+	printCertificates("removed", removed)
+	if a.DropExpired {
+		bundle, removed = bundle.RemoveExpired(now)
+		printCertificates("expired", removed)
+	}
+	bundle = bundle.Sorted()
+
+	var manifestPath string
+	if a.Manifest != "" {
+		if manifestPath, err = dataDir.Path(ctx, a.Manifest); err != nil {
+			return err
+		}
+	}
+	if err := pkg.CreateParentDirs(ctx, bundlePath, manifestPath); err != nil {
+		return err
+	}
+	if err := pkg.WriteCABundle(ctx, bundle, bundlePath, manifestPath, now); err != nil {
+		return errors.Wrapf(ctx, err, "write bundle failed")
+	}
+	fmt.Printf("bundle %s contains %d certificates\n", bundlePath, len(bundle))
+	return nil
+}
+
+func (a *application) load(ctx context.Context, dataDir pkg.DataDir, file string) ([]*x509.Certificate, error) {
+	certPath, err := dataDir.Path(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "read %s failed", certPath)
+	}
+	certs, err := pkg.ParseCertificates(ctx, data)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "parse %s failed", certPath)
+	}
+	return certs, nil
+}
+
+func printCertificates(action string, certs []*x509.Certificate) {
+	for _, cert := range certs {
+		fmt.Printf("%s %s %s\n", action, pkg.SHA256Fingerprint(cert), cert.Subject.String())
+	}
+}
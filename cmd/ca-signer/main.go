@@ -0,0 +1,57 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/bborbe/errors"
+	"github.com/bborbe/sample_cert/pkg"
+	libsentry "github.com/bborbe/sentry"
+	"github.com/bborbe/service"
+	"github.com/golang/glog"
+)
+
+func main() {
+	app := &application{}
+	os.Exit(service.Main(context.Background(), app, &app.SentryDSN, &app.SentryProxy))
+}
+
+type application struct {
+	SentryDSN     string `required:"false" arg:"sentry-dsn" env:"SENTRY_DSN" usage:"SentryDSN" display:"length"`
+	SentryProxy   string `required:"false" arg:"sentry-proxy" env:"SENTRY_PROXY" usage:"Sentry Proxy"`
+	DataDir       string `required:"true" arg:"datadir" env:"DATADIR" usage:"data directory"`
+	CAKey         string `required:"true" arg:"ca-key" env:"CA_KEY" usage:"ca key file, relative to datadir" default:"ca_key.pem"`
+	CAKeyPassword string `required:"false" arg:"ca-key-password" env:"CA_KEY_PASSWORD" usage:"password of encrypted ca key" display:"length"`
+	Socket        string `required:"true" arg:"socket" env:"SOCKET" usage:"unix socket to listen on"`
+}
+
+func (a *application) Run(ctx context.Context, sentryClient libsentry.Client) error {
+	caKeyPath, err := pkg.DataDir(a.DataDir).Path(ctx, a.CAKey)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "generate caKey path failed")
+	}
+	signerLoader, err := pkg.NewSignerLoader(ctx, pkg.SignerConfig{
+		KeyPath:     caKeyPath,
+		KeyPassword: a.CAKeyPassword,
+	}, nil)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "create signer loader failed")
+	}
+	signer, err := signerLoader.LoadSigner(ctx)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "load ca key failed")
+	}
+
+	listener, err := pkg.ListenUnixSocket(ctx, a.Socket)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "listen failed")
+	}
+	defer os.Remove(a.Socket)
+
+	glog.V(2).Infof("serve ca signer on %s", a.Socket)
+	return pkg.ServeUnixSocketSigner(ctx, listener, signer)
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bborbe/errors"
+	"github.com/bborbe/sample_cert/pkg"
+	libsentry "github.com/bborbe/sentry"
+	"github.com/bborbe/service"
+)
+
+func main() {
+	app := &application{}
+	os.Exit(service.Main(context.Background(), app, &app.SentryDSN, &app.SentryProxy))
+}
+
+type application struct {
+	SentryDSN   string        `required:"false" arg:"sentry-dsn" env:"SENTRY_DSN" usage:"SentryDSN" display:"length"`
+	SentryProxy string        `required:"false" arg:"sentry-proxy" env:"SENTRY_PROXY" usage:"Sentry Proxy"`
+	DataDir     string        `required:"true" arg:"datadir" env:"DATADIR" usage:"data directory or any directory tree to scan"`
+	Threshold   time.Duration `required:"true" arg:"threshold" env:"THRESHOLD" usage:"report certificates expiring within this duration" default:"720h"`
+	Format      string        `required:"true" arg:"format" env:"FORMAT" usage:"table, json or prometheus" default:"table"`
+	Output      string        `required:"false" arg:"output" env:"OUTPUT" usage:"write the report atomically to this file instead of stdout, e.g. for the textfile collector"`
+	ReportOnly  bool          `required:"false" arg:"report-only" env:"REPORT_ONLY" usage:"exit zero even if certificates are expired or expiring"`
+	FailOnError bool          `required:"false" arg:"fail-on-error" env:"FAIL_ON_ERROR" usage:"exit non-zero if files or directories could not be read or parsed" default:"true"`
+	Exclude     string        `required:"false" arg:"exclude" env:"EXCLUDE" usage:"comma separated globs of file or directory names or paths relative to datadir to skip" default:"*.bak"`
+}
+
+func (a *application) Run(ctx context.Context, sentryClient libsentry.Client) error {
+	var exclude []string
+	for _, pattern := range strings.Split(a.Exclude, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			exclude = append(exclude, pattern)
+		}
+	}
+	report, err := pkg.ScanExpiry(ctx, a.DataDir, time.Now(), a.Threshold, exclude)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "scan failed")
+	}
+	var buf bytes.Buffer
+	switch a.Format {
+	case "table":
+		err = report.WriteTable(&buf)
+	case "json":
+		encoder := json.NewEncoder(&buf)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	case "prometheus":
+		err = report.WritePrometheus(&buf)
+	default:
+		return errors.Errorf(ctx, "unknown format '%s', expected table, json or prometheus", a.Format)
+	}
+	if err != nil {
+		return errors.Wrapf(ctx, err, "write %s report failed", a.Format)
+	}
+	if a.Output != "" {
+		if err := pkg.WriteFileAtomic(ctx, a.Output, buf.Bytes(), 0644); err != nil {
+			return errors.Wrapf(ctx, err, "write %s failed", a.Output)
+		}
+	} else if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
+		return errors.Wrapf(ctx, err, "write report failed")
+	}
+	expired, expiring := report.Count(pkg.ExpiryStatusExpired), report.Count(pkg.ExpiryStatusExpiring)
+	if !a.ReportOnly && expired+expiring > 0 {
+		return errors.Errorf(ctx, "%d certificates expired and %d expiring within %s", expired, expiring, a.Threshold)
+	}
+	if a.FailOnError && len(report.Errors) > 0 {
+		return errors.Errorf(ctx, "%d files or directories could not be scanned", len(report.Errors))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bborbe/errors"
+	"github.com/bborbe/sample_cert/pkg"
+	libsentry "github.com/bborbe/sentry"
+	"github.com/bborbe/service"
+)
+
+func main() {
+	app := &application{}
+	os.Exit(service.Main(context.Background(), app, &app.SentryDSN, &app.SentryProxy))
+}
+
+type application struct {
+	SentryDSN   string `required:"false" arg:"sentry-dsn" env:"SENTRY_DSN" usage:"SentryDSN" display:"length"`
+	SentryProxy string `required:"false" arg:"sentry-proxy" env:"SENTRY_PROXY" usage:"Sentry Proxy"`
+	DataDir     string `required:"false" arg:"datadir" env:"DATADIR" usage:"data directory, relative files are read from here"`
+	Files       string `required:"false" arg:"files" env:"FILES" usage:"comma separated PEM or DER files, reads stdin if empty"`
+	JSON        bool   `required:"false" arg:"json" env:"JSON" usage:"print as json"`
+}
+
+func (a *application) Run(ctx context.Context, sentryClient libsentry.Client) error {
+	now := time.Now()
+	infos := []pkg.CertificateInfo{}
+	for _, source := range a.sources() {
+		data, err := a.read(source)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "read %s failed", source)
+		}
+		certs, err := pkg.ParseCertificates(ctx, data)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "parse %s failed", source)
+		}
+		for _, cert := range certs {
+			infos = append(infos, pkg.NewCertificateInfo(source, cert, now))
+		}
+	}
+
+	if a.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(infos); err != nil {
+			return errors.Wrapf(ctx, err, "encode json failed")
+		}
+		return nil
+	}
+	for i, info := range infos {
+		if i > 0 {
+			if _, err := os.Stdout.WriteString("\n"); err != nil {
+				return errors.Wrapf(ctx, err, "write failed")
+			}
+		}
+		if err := info.WriteText(os.Stdout); err != nil {
+			return errors.Wrapf(ctx, err, "write failed")
+		}
+	}
+	return nil
+}
+
+func (a *application) sources() []string {
+	if a.Files == "" {
+		return []string{"-"}
+	}
+	var result []string
+	for _, file := range strings.Split(a.Files, ",") {
+		file = strings.TrimSpace(file)
+		if file == "" {
+			continue
+		}
+		if a.DataDir != "" && !filepath.IsAbs(file) {
+			file = path.Join(a.DataDir, file)
+		}
+		result = append(result, file)
+	}
+	return result
+}
+
+func (a *application) read(source string) ([]byte, error) {
+	if source == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(source)
+}
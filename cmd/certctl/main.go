@@ -0,0 +1,799 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bborbe/errors"
+	"github.com/bborbe/sample_cert/pkg"
+	libsentry "github.com/bborbe/sentry"
+	"github.com/bborbe/service"
+	"github.com/golang/glog"
+)
+
+// commands lists all subcommands with a short description.
+var commands = map[string]string{
+	"ca init":        "create a new CA",
+	"ca rotate":      "create and cross-sign the successor of the CA",
+	"ca promote":     "make the successor the issuing CA",
+	"ca retire":      "remove a previous CA generation from the trust bundle",
+	"ca generations": "list CA generations and the leaves issued by each",
+	"issue server":   "issue a server certificate",
+	"issue client":   "issue a client certificate",
+	"sign":           "sign a CSR",
+	"revoke":         "revoke a certificate and update the CRL",
+	"renew":          "issue a new certificate with the names of an existing one",
+	"inspect":        "print details of certificates",
+	"verify":         "verify a certificate against the CA",
+	"list":           "list all issued certificates",
+	"audit verify":   "verify the hash chain of the audit log",
+	"spiffe bundle":  "print the ca certificates as SPIFFE trust bundle",
+}
+
+func main() {
+	command, args := splitCommand(os.Args[1:])
+	if command != "" {
+		args = append([]string{"-command=" + command}, args...)
+	}
+	os.Args = append([]string{os.Args[0]}, args...)
+	app := &application{}
+	app.CASigner.Register(flag.CommandLine)
+	os.Exit(service.Main(context.Background(), app, &app.SentryDSN, &app.SentryProxy))
+}
+
+// splitCommand separates the leading subcommand words from the flags.
+func splitCommand(args []string) (string, []string) {
+	var words []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		words = append(words, args[0])
+		args = args[1:]
+	}
+	return strings.Join(words, " "), args
+}
+
+type application struct {
+	SentryDSN      string            `required:"false" arg:"sentry-dsn" env:"SENTRY_DSN" usage:"SentryDSN" display:"length"`
+	SentryProxy    string            `required:"false" arg:"sentry-proxy" env:"SENTRY_PROXY" usage:"Sentry Proxy"`
+	DataDir        string            `required:"true" arg:"datadir" env:"DATADIR" usage:"data directory"`
+	Force          bool              `required:"false" arg:"force" env:"FORCE" usage:"overwrite existing files"`
+	Backup         bool              `required:"false" arg:"backup" env:"BACKUP" usage:"keep a timestamped copy of existing files before overwriting"`
+	JSON           bool              `required:"false" arg:"json" env:"JSON" usage:"print as json"`
+	CASigner       pkg.CASignerFlags `display:"hidden"`
+	CommonName     string            `required:"false" arg:"cn" env:"CN" usage:"common name"`
+	Organization   string            `required:"false" arg:"org" env:"ORG" usage:"comma separated organizations"`
+	DNSNames       string            `required:"false" arg:"dns" env:"DNS" usage:"comma separated dns names"`
+	IPAddresses    string            `required:"false" arg:"ip" env:"IP" usage:"comma separated ip addresses"`
+	EmailAddresses string            `required:"false" arg:"email" env:"EMAIL" usage:"comma separated email addresses"`
+	URIs           string            `required:"false" arg:"uri" env:"URI" usage:"comma separated uris"`
+	Validity       time.Duration     `required:"false" arg:"validity" env:"VALIDITY" usage:"validity of issued certificates" default:"8760h"`
+	Profile        string            `required:"false" arg:"profile" env:"PROFILE" usage:"server or client" default:"server"`
+	Name           string            `required:"false" arg:"name" env:"NAME" usage:"use <name>/cert.pem, <name>/key.pem and <name>/chain.pem"`
+	Cert           string            `required:"false" arg:"cert" env:"CERT" usage:"certificate file, comma separated for inspect"`
+	Key            string            `required:"false" arg:"key" env:"KEY" usage:"private key file"`
+	Chain          string            `required:"false" arg:"chain" env:"CHAIN" usage:"chain file, only written with name or if set"`
+	CSR            string            `required:"false" arg:"csr" env:"CSR" usage:"certificate request file"`
+	Serial         string            `required:"false" arg:"serial" env:"SERIAL" usage:"serial number in hex"`
+	Reason         string            `required:"false" arg:"reason" env:"REASON" usage:"revocation reason, RFC 5280 name or code, e.g. keyCompromise or 1"`
+	Hostname       string            `required:"false" arg:"hostname" env:"VERIFY_HOSTNAME" usage:"hostname or ip the certificate must be valid for"`
+	At             string            `required:"false" arg:"at" env:"AT" usage:"point in time to verify (RFC3339), default now"`
+	PermittedDNS   string            `required:"false" arg:"permitted-dns" env:"PERMITTED_DNS" usage:"comma separated dns domains permitted below the ca"`
+	ExcludedDNS    string            `required:"false" arg:"excluded-dns" env:"EXCLUDED_DNS" usage:"comma separated dns domains excluded below the ca"`
+	PermittedIP    string            `required:"false" arg:"permitted-ip" env:"PERMITTED_IP" usage:"comma separated ip ranges (CIDR) permitted below the ca"`
+	ExcludedIP     string            `required:"false" arg:"excluded-ip" env:"EXCLUDED_IP" usage:"comma separated ip ranges (CIDR) excluded below the ca"`
+	PermittedEmail string            `required:"false" arg:"permitted-email" env:"PERMITTED_EMAIL" usage:"comma separated mailboxes or email domains permitted below the ca"`
+	ExcludedEmail  string            `required:"false" arg:"excluded-email" env:"EXCLUDED_EMAIL" usage:"comma separated mailboxes or email domains excluded below the ca"`
+	PermittedURI   string            `required:"false" arg:"permitted-uri" env:"PERMITTED_URI" usage:"comma separated uri hosts or domains permitted below the ca"`
+	ExcludedURI    string            `required:"false" arg:"excluded-uri" env:"EXCLUDED_URI" usage:"comma separated uri hosts or domains excluded below the ca"`
+	Sequence       uint64            `required:"false" arg:"sequence" env:"SEQUENCE" usage:"spiffe_sequence of the bundle, omitted if 0"`
+	RefreshHint    time.Duration     `required:"false" arg:"refresh-hint" env:"REFRESH_HINT" usage:"spiffe_refresh_hint of the bundle, omitted if 0"`
+	Generation     int               `required:"false" arg:"generation" env:"GENERATION" usage:"ca generation to retire"`
+	Command        string            `required:"false" arg:"command" env:"COMMAND" usage:"subcommand, usually given as leading words like 'issue server'"`
+}
+
+func (a *application) Run(ctx context.Context, sentryClient libsentry.Client) error {
+	switch a.Command {
+	case "ca init":
+		return a.caInit(ctx)
+	case "ca rotate":
+		return a.caRotate(ctx)
+	case "ca promote":
+		return a.caPromote(ctx)
+	case "ca retire":
+		return a.caRetire(ctx)
+	case "ca generations":
+		return a.caGenerations(ctx)
+	case "issue server":
+		return a.issue(ctx, pkg.ProfileServer)
+	case "issue client":
+		return a.issue(ctx, pkg.ProfileClient)
+	case "sign":
+		return a.sign(ctx)
+	case "revoke":
+		return a.revoke(ctx)
+	case "renew":
+		return a.renew(ctx)
+	case "inspect":
+		return a.inspect(ctx)
+	case "verify":
+		return a.verify(ctx)
+	case "list":
+		return a.list(ctx)
+	case "audit verify":
+		return a.auditVerify(ctx)
+	case "spiffe bundle":
+		return a.spiffeBundle(ctx)
+	default:
+		return errors.Errorf(ctx, "unknown command '%s', available: %s", a.Command, strings.Join(commandNames(), ", "))
+	}
+}
+
+func commandNames() []string {
+	var result []string
+	for name, description := range commands {
+		result = append(result, fmt.Sprintf("%s (%s)", name, description))
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (a *application) dataDir() pkg.DataDir {
+	return pkg.DataDir(a.DataDir)
+}
+
+func (a *application) path(ctx context.Context, value string, fallback string) (string, error) {
+	if value == "" {
+		value = fallback
+	}
+	return a.dataDir().Path(ctx, value)
+}
+
+// identityPaths resolves the name, cert, key and chain flags.
+func (a *application) identityPaths(ctx context.Context, fallback pkg.IdentityPaths) (pkg.IdentityPaths, error) {
+	return a.dataDir().IdentityPaths(
+		ctx,
+		a.Name,
+		pkg.IdentityPaths{CertPath: a.Cert, KeyPath: a.Key, ChainPath: a.Chain},
+		fallback,
+	)
+}
+
+func (a *application) overwriteMode() pkg.OverwriteMode {
+	return pkg.NewOverwriteMode(a.Force, a.Backup)
+}
+
+func (a *application) inventory(ctx context.Context) (pkg.Inventory, error) {
+	return a.dataDir().Inventory(ctx)
+}
+
+// audit appends entry to the audit log of the DataDir.
+func (a *application) audit(ctx context.Context, entry pkg.AuditEntry) error {
+	recorder, err := a.dataDir().IssuanceRecorder(ctx)
+	if err != nil {
+		return err
+	}
+	return recorder.Audit(ctx, entry)
+}
+
+func (a *application) loadCA(ctx context.Context) (*pkg.CA, error) {
+	return a.CASigner.LoadCA(ctx, a.dataDir())
+}
+
+// output prints value as json or the given text lines.
+func (a *application) output(ctx context.Context, value interface{}, lines ...string) error {
+	if a.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(value); err != nil {
+			return errors.Wrapf(ctx, err, "encode json failed")
+		}
+		return nil
+	}
+	for _, line := range lines {
+		if _, err := fmt.Println(line); err != nil {
+			return errors.Wrapf(ctx, err, "print failed")
+		}
+	}
+	return nil
+}
+
+func (a *application) caInit(ctx context.Context) error {
+	if a.CASigner.CAKeySocket != "" {
+		return errors.Errorf(ctx, "ca init does not support ca-key-socket, a new ca key is written to a file or generated in a PKCS#11 token")
+	}
+	caCertPath, caKeyPath, err := a.CASigner.Paths(ctx, a.dataDir())
+	if err != nil {
+		return err
+	}
+	if err := pkg.CreateParentDirs(ctx, caCertPath, caKeyPath); err != nil {
+		return err
+	}
+	req := pkg.DefaultCARequest()
+	if a.CommonName != "" {
+		req.Subject.CommonName = a.CommonName
+	}
+	if a.Organization != "" {
+		req.Subject.Organization = pkg.SplitList(a.Organization, ",")
+	}
+	if req.NameConstraints, err = a.nameConstraints(ctx); err != nil {
+		return errors.Wrapf(ctx, err, "invalid name constraints")
+	}
+
+	if pkcs11Config := a.CASigner.PKCS11Config(); pkcs11Config != nil {
+		if err := pkg.PrepareOverwrite(ctx, a.overwriteMode(), caCertPath); err != nil {
+			return errors.Wrapf(ctx, err, "prepare overwrite failed")
+		}
+		signer, err := pkg.GeneratePKCS11Key(ctx, *pkcs11Config)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "generate pkcs11 key failed")
+		}
+		defer pkg.CloseSigner(signer)
+		ca, err := pkg.CreateCAWithSigner(ctx, req, signer)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "create ca failed")
+		}
+		if err := a.audit(ctx, pkg.NewAuditEntry(pkg.AuditOperationCreateCA, pkg.LocalRequester(), ca.Certificate, "")); err != nil {
+			return err
+		}
+		if err := pkg.WriteCertificateFile(ctx, caCertPath, ca.CertificatePEM()); err != nil {
+			return errors.Wrapf(ctx, err, "write ca cert failed")
+		}
+		return a.output(ctx, pkg.NewCertificateInfo(caCertPath, ca.Certificate, time.Now()), fmt.Sprintf("created ca cert=%s key=pkcs11:%s", caCertPath, pkcs11Config.KeyLabel))
+	}
+
+	if err := pkg.PrepareOverwrite(ctx, a.overwriteMode(), caCertPath, caKeyPath); err != nil {
+		return errors.Wrapf(ctx, err, "prepare overwrite failed")
+	}
+	ca, err := pkg.CreateCA(ctx, req)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "create ca failed")
+	}
+	if err := a.audit(ctx, pkg.NewAuditEntry(pkg.AuditOperationCreateCA, pkg.LocalRequester(), ca.Certificate, "")); err != nil {
+		return err
+	}
+	if a.CASigner.CAKeyPassword != "" {
+		err = pkg.WriteEncryptedCA(ctx, ca, caCertPath, caKeyPath, []byte(a.CASigner.CAKeyPassword))
+	} else {
+		err = pkg.WriteCA(ctx, ca, caCertPath, caKeyPath)
+	}
+	if err != nil {
+		return errors.Wrapf(ctx, err, "write ca failed")
+	}
+	return a.output(ctx, pkg.NewCertificateInfo(caCertPath, ca.Certificate, time.Now()), fmt.Sprintf("created ca cert=%s key=%s", caCertPath, caKeyPath))
+}
+
+func (a *application) caRotate(ctx context.Context) error {
+	if a.CASigner.External() {
+		return errors.Errorf(ctx, "ca rotate requires the ca key in a file, the successor of a PKCS#11 or socket key would be a software key")
+	}
+	ca, err := a.loadCA(ctx)
+	if err != nil {
+		return err
+	}
+	defer ca.Close()
+	recorder, err := a.dataDir().IssuanceRecorder(ctx)
+	if err != nil {
+		return err
+	}
+	rotation, err := pkg.RotateCA(ctx, a.dataDir(), ca, a.CASigner.CACert, a.CASigner.CAKey, []byte(a.CASigner.CAKeyPassword), recorder)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "rotate ca failed")
+	}
+	return a.output(
+		ctx,
+		pkg.NewCertificateInfo("", rotation.Successor.Certificate, time.Now()),
+		fmt.Sprintf("created generation %d %s", rotation.Generation, rotation.Successor.Certificate.Subject.String()),
+		fmt.Sprintf("trust bundle %s contains the active ca and its successor", pkg.CABundleFile),
+	)
+}
+
+func (a *application) caPromote(ctx context.Context) error {
+	generations, err := pkg.PromoteCA(ctx, a.dataDir(), a.CASigner.CACert, a.CASigner.CAKey)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "promote ca failed")
+	}
+	active := generations.WithStatus(pkg.CAGenerationStatusActive)
+	return a.output(ctx, active, fmt.Sprintf("generation %d is active %s", active.Generation, active.Subject))
+}
+
+func (a *application) caRetire(ctx context.Context) error {
+	generations, err := pkg.RetireCA(ctx, a.dataDir(), a.Generation)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "retire ca failed")
+	}
+	return a.output(ctx, generations.Get(a.Generation), fmt.Sprintf("generation %d retired", a.Generation))
+}
+
+// caGenerationResult is the json output of ca generations.
+type caGenerationResult struct {
+	pkg.CAGeneration
+	Leaves int `json:"leaves"`
+}
+
+func (a *application) caGenerations(ctx context.Context) error {
+	generations, err := a.dataDir().CAGenerations(ctx)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "load ca generations failed")
+	}
+	if generations == nil {
+		return errors.Errorf(ctx, "ca was never rotated")
+	}
+	inventory, err := a.inventory(ctx)
+	if err != nil {
+		return err
+	}
+	entries, err := inventory.List(ctx)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "list inventory failed")
+	}
+	leaves := generations.CountLeaves(entries, time.Now())
+	result := []caGenerationResult{}
+	var lines []string
+	for _, generation := range generations.Generations {
+		result = append(result, caGenerationResult{CAGeneration: generation, Leaves: leaves[generation.Generation]})
+		lines = append(lines, fmt.Sprintf("g%d %-8s leaves=%-4d %s %s", generation.Generation, generation.Status, leaves[generation.Generation], generation.NotAfter.UTC().Format(time.RFC3339), generation.Subject))
+	}
+	if leaves[0] > 0 {
+		lines = append(lines, fmt.Sprintf("%d leaves of unknown generation", leaves[0]))
+	}
+	return a.output(ctx, result, lines...)
+}
+
+func (a *application) nameConstraints(ctx context.Context) (pkg.NameConstraints, error) {
+	return pkg.NameConstraintLists{
+		PermittedDNSDomains:     a.PermittedDNS,
+		ExcludedDNSDomains:      a.ExcludedDNS,
+		PermittedIPRanges:       a.PermittedIP,
+		ExcludedIPRanges:        a.ExcludedIP,
+		PermittedEmailAddresses: a.PermittedEmail,
+		ExcludedEmailAddresses:  a.ExcludedEmail,
+		PermittedURIDomains:     a.PermittedURI,
+		ExcludedURIDomains:      a.ExcludedURI,
+	}.NameConstraints(ctx)
+}
+
+func (a *application) issueRequest(ctx context.Context, profile pkg.Profile) (pkg.IssueRequest, error) {
+	req := pkg.DefaultServerIssueRequest()
+	if profile == pkg.ProfileClient {
+		req = pkg.DefaultClientIssueRequest()
+	}
+	req.Validity = a.Validity
+	if a.CommonName != "" {
+		req.CommonName = a.CommonName
+	}
+	if a.Organization != "" {
+		req.Organization = pkg.SplitList(a.Organization, ",")
+	}
+	if a.DNSNames != "" {
+		req.DNSNames = pkg.SplitList(a.DNSNames, ",")
+	}
+	req.EmailAddresses = pkg.SplitList(a.EmailAddresses, ",")
+	for _, value := range pkg.SplitList(a.IPAddresses, ",") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return pkg.IssueRequest{}, errors.Errorf(ctx, "invalid ip address '%s'", value)
+		}
+		req.IPAddresses = append(req.IPAddresses, ip)
+	}
+	for _, value := range pkg.SplitList(a.URIs, ",") {
+		uri, err := url.Parse(value)
+		if err != nil {
+			return pkg.IssueRequest{}, errors.Wrapf(ctx, err, "invalid uri '%s'", value)
+		}
+		req.URIs = append(req.URIs, uri)
+	}
+	return req, nil
+}
+
+func (a *application) issue(ctx context.Context, profile pkg.Profile) error {
+	fallback := pkg.IdentityPaths{CertPath: pkg.ServerCertFile, KeyPath: pkg.ServerKeyFile}
+	if profile == pkg.ProfileClient {
+		fallback = pkg.IdentityPaths{CertPath: pkg.ClientCertFile, KeyPath: pkg.ClientKeyFile}
+	}
+	paths, err := a.identityPaths(ctx, fallback)
+	if err != nil {
+		return err
+	}
+	req, err := a.issueRequest(ctx, profile)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "create issue request failed")
+	}
+	if err := pkg.PrepareOverwrite(ctx, a.overwriteMode(), paths.CertPath, paths.KeyPath, paths.ChainPath); err != nil {
+		return errors.Wrapf(ctx, err, "prepare overwrite failed")
+	}
+	ca, err := a.loadCA(ctx)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "load ca failed")
+	}
+	defer ca.Close()
+	keyPair, err := pkg.IssueCertificate(ctx, ca, req)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "issue %s certificate failed", profile)
+	}
+	entry, err := a.recordIssued(ctx, pkg.AuditOperationIssue, keyPair.Certificate, profile, paths.CertPath, paths.KeyPath)
+	if err != nil {
+		return err
+	}
+	if err := pkg.WriteIdentity(ctx, keyPair, ca, paths); err != nil {
+		return errors.Wrapf(ctx, err, "write %s certificate failed", profile)
+	}
+	return a.outputIssued(ctx, "issued", entry)
+}
+
+// recordIssued adds the certificate to inventory and audit log, before it is written.
+func (a *application) recordIssued(ctx context.Context, operation pkg.AuditOperation, cert *x509.Certificate, profile pkg.Profile, certPath string, keyPath string) (pkg.InventoryEntry, error) {
+	recorder, err := a.dataDir().IssuanceRecorder(ctx)
+	if err != nil {
+		return pkg.InventoryEntry{}, err
+	}
+	if err := recorder.Record(ctx, operation, cert, profile, certPath, keyPath); err != nil {
+		return pkg.InventoryEntry{}, err
+	}
+	return pkg.NewInventoryEntry(cert, profile, certPath, keyPath), nil
+}
+
+// outputIssued prints the written certificate.
+func (a *application) outputIssued(ctx context.Context, verb string, entry pkg.InventoryEntry) error {
+	line := fmt.Sprintf("%s %s certificate serial=%s cert=%s", verb, entry.Profile, entry.SerialNumber, entry.CertPath)
+	if entry.KeyPath != "" {
+		line += " key=" + entry.KeyPath
+	}
+	return a.output(ctx, entry, line)
+}
+
+func (a *application) sign(ctx context.Context) error {
+	if a.CSR == "" || a.Cert == "" && a.Name == "" {
+		return errors.Errorf(ctx, "define parameter csr and cert or name")
+	}
+	csrPath, err := a.path(ctx, a.CSR, "")
+	if err != nil {
+		return err
+	}
+	paths, err := a.identityPaths(ctx, pkg.IdentityPaths{})
+	if err != nil {
+		return err
+	}
+	// the key stays with the requester
+	paths.KeyPath = ""
+	csrData, err := os.ReadFile(csrPath)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "read csr failed")
+	}
+	csr, err := pkg.ParseCertificateRequestPEM(ctx, csrData)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "parse csr failed")
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return errors.Wrapf(ctx, err, "csr signature invalid")
+	}
+	if err := pkg.CreateParentDirs(ctx, paths.CertPath, paths.ChainPath); err != nil {
+		return err
+	}
+	if err := pkg.PrepareOverwrite(ctx, a.overwriteMode(), paths.CertPath, paths.ChainPath); err != nil {
+		return errors.Wrapf(ctx, err, "prepare overwrite failed")
+	}
+	ca, err := a.loadCA(ctx)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "load ca failed")
+	}
+	defer ca.Close()
+	profile := pkg.Profile(a.Profile)
+	cert, err := pkg.SignCertificate(ctx, ca, pkg.NewIssueRequestFromCSR(csr, profile, a.Validity), csr.PublicKey)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "sign csr failed")
+	}
+	entry, err := a.recordIssued(ctx, pkg.AuditOperationSign, cert, profile, paths.CertPath, "")
+	if err != nil {
+		return err
+	}
+	if err := pkg.WriteCertificateFile(ctx, paths.CertPath, pkg.EncodeCertificatePEM(cert)); err != nil {
+		return errors.Wrapf(ctx, err, "write certificate failed")
+	}
+	if paths.ChainPath != "" {
+		chain := (&pkg.KeyPair{Certificate: cert}).ChainPEM(ca.Intermediates()...)
+		if err := pkg.WriteCertificateFile(ctx, paths.ChainPath, chain); err != nil {
+			return errors.Wrapf(ctx, err, "write chain failed")
+		}
+	}
+	return a.outputIssued(ctx, "signed", entry)
+}
+
+func (a *application) revoke(ctx context.Context) error {
+	serial, err := a.serial(ctx)
+	if err != nil {
+		return err
+	}
+	reason, err := pkg.ParseRevocationReason(ctx, a.Reason)
+	if err != nil {
+		return err
+	}
+	inventory, err := a.inventory(ctx)
+	if err != nil {
+		return err
+	}
+	ca, err := a.loadCA(ctx)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "load ca failed")
+	}
+	defer ca.Close()
+	entry, err := inventory.Get(ctx, serial)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "get inventory entry failed")
+	}
+	if entry.Revoked() {
+		return errors.Errorf(ctx, "serial %s already revoked", entry.SerialNumber)
+	}
+	if err := a.audit(ctx, pkg.NewAuditEntryFromInventory(pkg.AuditOperationRevoke, pkg.LocalRequester(), *entry)); err != nil {
+		return err
+	}
+	now := time.Now()
+	entry, err = inventory.Revoke(ctx, serial, reason, now)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "revoke failed")
+	}
+	entries, err := inventory.List(ctx)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "list inventory failed")
+	}
+	if err := pkg.NewDataDirCRLPublisher(a.dataDir(), ca, []byte(a.CASigner.CAKeyPassword)).Publish(ctx, entries, now); err != nil {
+		return errors.Wrapf(ctx, err, "publish crl failed")
+	}
+	crlPath, err := a.dataDir().Path(ctx, pkg.CACRLFile)
+	if err != nil {
+		return err
+	}
+	return a.output(ctx, entry, fmt.Sprintf("revoked serial=%s crl=%s", entry.SerialNumber, crlPath))
+}
+
+// serial returns the serial flag or the serial of the cert flag.
+func (a *application) serial(ctx context.Context) (string, error) {
+	if a.Serial != "" {
+		return a.Serial, nil
+	}
+	if a.Cert == "" {
+		return "", errors.Errorf(ctx, "define parameter serial or cert")
+	}
+	certPath, err := a.path(ctx, a.Cert, "")
+	if err != nil {
+		return "", err
+	}
+	cert, err := pkg.LoadCertificate(ctx, certPath)
+	if err != nil {
+		return "", errors.Wrapf(ctx, err, "load certificate failed")
+	}
+	return pkg.FormatHex(cert.SerialNumber.Bytes()), nil
+}
+
+func (a *application) renew(ctx context.Context) error {
+	explicit := pkg.IdentityPaths{CertPath: a.Cert, KeyPath: a.Key, ChainPath: a.Chain}
+	if a.Serial != "" {
+		inventory, err := a.inventory(ctx)
+		if err != nil {
+			return err
+		}
+		entry, err := inventory.Get(ctx, a.Serial)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "get inventory entry failed")
+		}
+		explicit.CertPath, explicit.KeyPath = entry.CertPath, entry.KeyPath
+	}
+	paths, err := a.dataDir().IdentityPaths(ctx, a.Name, explicit, pkg.IdentityPaths{})
+	if err != nil {
+		return err
+	}
+	if paths.CertPath == "" || paths.KeyPath == "" {
+		return errors.Errorf(ctx, "define parameter serial, name or cert and key")
+	}
+	certPath := paths.CertPath
+	oldCert, err := pkg.LoadCertificate(ctx, certPath)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "load certificate failed")
+	}
+	req, err := pkg.NewIssueRequestFromCertificate(ctx, oldCert)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "create issue request failed")
+	}
+	ca, err := a.loadCA(ctx)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "load ca failed")
+	}
+	defer ca.Close()
+	keyPair, err := pkg.IssueCertificate(ctx, ca, req)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "issue certificate failed")
+	}
+	// renew always replaces the old files, -backup keeps a copy
+	if err := pkg.PrepareOverwrite(ctx, pkg.NewOverwriteMode(true, a.Backup), paths.CertPath, paths.KeyPath, paths.ChainPath); err != nil {
+		return errors.Wrapf(ctx, err, "prepare overwrite failed")
+	}
+	entry, err := a.recordIssued(ctx, pkg.AuditOperationRenew, keyPair.Certificate, req.Profile, paths.CertPath, paths.KeyPath)
+	if err != nil {
+		return err
+	}
+	if err := pkg.WriteIdentity(ctx, keyPair, ca, paths); err != nil {
+		return errors.Wrapf(ctx, err, "write certificate failed")
+	}
+	glog.V(2).Infof("renewed %s, old serial %s", certPath, pkg.FormatHex(oldCert.SerialNumber.Bytes()))
+	return a.outputIssued(ctx, "renewed", entry)
+}
+
+func (a *application) inspect(ctx context.Context) error {
+	if a.Cert == "" {
+		return errors.Errorf(ctx, "define parameter cert")
+	}
+	now := time.Now()
+	infos := []pkg.CertificateInfo{}
+	for _, file := range pkg.SplitList(a.Cert, ",") {
+		certPath, err := a.path(ctx, file, "")
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(certPath)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "read %s failed", certPath)
+		}
+		certs, err := pkg.ParseCertificates(ctx, data)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "parse %s failed", certPath)
+		}
+		for _, cert := range certs {
+			infos = append(infos, pkg.NewCertificateInfo(certPath, cert, now))
+		}
+	}
+	if a.JSON {
+		return a.output(ctx, infos)
+	}
+	for i, info := range infos {
+		if i > 0 {
+			fmt.Println()
+		}
+		if err := info.WriteText(os.Stdout); err != nil {
+			return errors.Wrapf(ctx, err, "write failed")
+		}
+	}
+	return nil
+}
+
+func (a *application) verify(ctx context.Context) error {
+	certPath, err := a.path(ctx, a.Cert, pkg.ServerCertFile)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "read %s failed", certPath)
+	}
+	certs, err := pkg.ParseCertificates(ctx, data)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "parse %s failed", certPath)
+	}
+	caCertPath, err := a.dataDir().Path(ctx, a.CASigner.CACert)
+	if err != nil {
+		return err
+	}
+	caCert, err := pkg.LoadCertificate(ctx, caCertPath)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "load ca failed")
+	}
+	var verifyTime time.Time
+	if a.At != "" {
+		verifyTime, err = time.Parse(time.RFC3339, a.At)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "parse at '%s' failed", a.At)
+		}
+	}
+	_, err = pkg.VerifyCertificate(ctx, pkg.VerifyRequest{
+		Leaf:          certs[0],
+		Intermediates: certs[1:],
+		Roots:         []*x509.Certificate{caCert},
+		Purpose:       pkg.Profile(a.Profile),
+		Hostname:      a.Hostname,
+		Time:          verifyTime,
+	})
+	result := struct {
+		Cert  string `json:"cert"`
+		Valid bool   `json:"valid"`
+		Error string `json:"error,omitempty"`
+	}{
+		Cert:  certPath,
+		Valid: err == nil,
+	}
+	line := fmt.Sprintf("OK: %s", certPath)
+	var verifyErr pkg.VerifyError
+	if errors.As(err, &verifyErr) {
+		result.Error = verifyErr.Explanation
+		line = fmt.Sprintf("FAILED: %s", verifyErr.Explanation)
+	}
+	if outputErr := a.output(ctx, result, line); outputErr != nil {
+		return outputErr
+	}
+	if err != nil {
+		return errors.Wrapf(ctx, err, "verify %s failed", certPath)
+	}
+	return nil
+}
+
+func (a *application) list(ctx context.Context) error {
+	inventory, err := a.inventory(ctx)
+	if err != nil {
+		return err
+	}
+	entries, err := inventory.List(ctx)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "list inventory failed")
+	}
+	var lines []string
+	for _, entry := range entries {
+		status := "valid"
+		if entry.Revoked() {
+			status = "revoked"
+		} else if time.Now().After(entry.NotAfter) {
+			status = "expired"
+		}
+		lines = append(lines, fmt.Sprintf("%s %-7s %-7s %s %s", entry.SerialNumber, entry.Profile, status, entry.NotAfter.UTC().Format(time.RFC3339), entry.Subject))
+	}
+	if entries == nil {
+		entries = []pkg.InventoryEntry{}
+	}
+	return a.output(ctx, entries, lines...)
+}
+
+// auditVerifyResult is the json output of audit verify.
+type auditVerifyResult struct {
+	Entries  int    `json:"entries"`
+	LastHash string `json:"lastHash,omitempty"`
+}
+
+func (a *application) auditVerify(ctx context.Context) error {
+	auditLogPath, err := a.dataDir().Path(ctx, pkg.AuditLogFile)
+	if err != nil {
+		return err
+	}
+	last, count, err := pkg.VerifyAuditLog(ctx, auditLogPath)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "audit log invalid after %d valid entries", count)
+	}
+	result := auditVerifyResult{Entries: count}
+	if last != nil {
+		result.LastHash = last.Hash
+	}
+	return a.output(ctx, result, fmt.Sprintf("audit log ok entries=%d lastHash=%s", result.Entries, result.LastHash))
+}
+
+// spiffeBundle prints all certificates of the ca file in the SPIFFE bundle format.
+func (a *application) spiffeBundle(ctx context.Context) error {
+	caCertPath, err := a.dataDir().Path(ctx, a.CASigner.CACert)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(caCertPath)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "read %s failed", caCertPath)
+	}
+	cas, err := pkg.ParseCertificates(ctx, data)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "parse %s failed", caCertPath)
+	}
+	bundle, err := pkg.NewSPIFFEBundle(ctx, a.Sequence, a.RefreshHint, cas...)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "create spiffe bundle failed")
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(bundle); err != nil {
+		return errors.Wrapf(ctx, err, "encode json failed")
+	}
+	return nil
+}
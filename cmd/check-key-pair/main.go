@@ -0,0 +1,71 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/bborbe/errors"
+	"github.com/bborbe/sample_cert/pkg"
+	libsentry "github.com/bborbe/sentry"
+	"github.com/bborbe/service"
+)
+
+func main() {
+	app := &application{}
+	os.Exit(service.Main(context.Background(), app, &app.SentryDSN, &app.SentryProxy))
+}
+
+type application struct {
+	SentryDSN   string `required:"false" arg:"sentry-dsn" env:"SENTRY_DSN" usage:"SentryDSN" display:"length"`
+	SentryProxy string `required:"false" arg:"sentry-proxy" env:"SENTRY_PROXY" usage:"Sentry Proxy"`
+	DataDir     string `required:"true" arg:"datadir" env:"DATADIR" usage:"data directory"`
+	Cert        string `required:"true" arg:"cert" env:"CERT" usage:"certificate" default:"server_cert.pem"`
+	Key         string `required:"false" arg:"key" env:"KEY" usage:"private key that must match the certificate"`
+	CSR         string `required:"false" arg:"csr" env:"CSR" usage:"certificate request the certificate must be issued for"`
+}
+
+func (a *application) Run(ctx context.Context, sentryClient libsentry.Client) error {
+	if a.Key == "" && a.CSR == "" {
+		return errors.Errorf(ctx, "define parameter key or csr")
+	}
+	certPath := a.path(a.Cert)
+	if a.Key != "" {
+		if err := pkg.CheckKeyPairFiles(ctx, certPath, a.path(a.Key)); err != nil {
+			return errors.Wrapf(ctx, err, "check key failed")
+		}
+		fmt.Printf("OK: %s matches %s\n", a.Key, a.Cert)
+	}
+	if a.CSR != "" {
+		cert, err := pkg.LoadCertificate(ctx, certPath)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "load cert failed")
+		}
+		csrData, err := os.ReadFile(a.path(a.CSR))
+		if err != nil {
+			return errors.Wrapf(ctx, err, "read csr failed")
+		}
+		csr, err := pkg.ParseCertificateRequestPEM(ctx, csrData)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "parse csr failed")
+		}
+		if err := pkg.CheckCertificateRequest(ctx, cert, csr); err != nil {
+			return errors.Wrapf(ctx, err, "check csr failed")
+		}
+		fmt.Printf("OK: %s matches %s\n", a.CSR, a.Cert)
+	}
+	return nil
+}
+
+func (a *application) path(file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return path.Join(a.DataDir, file)
+}
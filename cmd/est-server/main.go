@@ -0,0 +1,110 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/bborbe/errors"
+	libhttp "github.com/bborbe/http"
+	"github.com/bborbe/run"
+	"github.com/bborbe/sample_cert/pkg"
+	libsentry "github.com/bborbe/sentry"
+	"github.com/bborbe/service"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+	app := &application{}
+	app.CASigner.Register(flag.CommandLine)
+	os.Exit(service.Main(context.Background(), app, &app.SentryDSN, &app.SentryProxy))
+}
+
+type application struct {
+	SentryDSN    string            `required:"false" arg:"sentry-dsn" env:"SENTRY_DSN" usage:"SentryDSN" display:"length"`
+	SentryProxy  string            `required:"false" arg:"sentry-proxy" env:"SENTRY_PROXY" usage:"Sentry Proxy"`
+	DataDir      string            `required:"true" arg:"datadir" env:"DATADIR" usage:"data directory"`
+	Listen       string            `required:"true" arg:"listen" env:"LISTEN" usage:"address to listen to"`
+	CASigner     pkg.CASignerFlags `display:"hidden"`
+	Validity     time.Duration     `required:"true" arg:"validity" env:"VALIDITY" usage:"validity of issued certificates" default:"8760h"`
+	Username     string            `required:"false" arg:"username" env:"EST_USERNAME" usage:"basic auth user allowed to enroll without client certificate"`
+	Password     string            `required:"false" arg:"password" env:"EST_PASSWORD" usage:"basic auth password allowed to enroll without client certificate" display:"length"`
+	NamePatterns string            `required:"false" arg:"name-patterns" env:"EST_NAME_PATTERNS" usage:"comma separated patterns of names allowed besides the authenticated identity, e.g. device-*,spiffe://example.org/device/*"`
+	Name         string            `required:"false" arg:"name" env:"NAME" usage:"use <name>/cert.pem and <name>/key.pem for the est endpoint"`
+	Cert         string            `required:"false" arg:"cert" env:"CERT" usage:"server certificate file of the est endpoint, default server_cert.pem"`
+	Key          string            `required:"false" arg:"key" env:"KEY" usage:"server key file of the est endpoint, default server_key.pem"`
+}
+
+func (a *application) Run(ctx context.Context, sentryClient libsentry.Client) error {
+	return service.Run(
+		ctx,
+		a.createHttpServer(),
+	)
+}
+
+func (a *application) createHttpServer() run.Func {
+	return func(ctx context.Context) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		namePatterns := pkg.SplitList(a.NamePatterns, ",")
+		if err := pkg.ValidateNamePatterns(ctx, namePatterns); err != nil {
+			return err
+		}
+		dataDir := pkg.DataDir(a.DataDir)
+		ca, err := a.CASigner.LoadCA(ctx, dataDir)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "load ca failed")
+		}
+		defer ca.Close()
+		inventory, err := dataDir.Inventory(ctx)
+		if err != nil {
+			return err
+		}
+		auditLog, err := dataDir.AuditLog(ctx)
+		if err != nil {
+			return err
+		}
+
+		paths, err := dataDir.ServerIdentity(ctx, a.Name, pkg.IdentityPaths{CertPath: a.Cert, KeyPath: a.Key})
+		if err != nil {
+			return err
+		}
+
+		router := mux.NewRouter()
+		router.Path("/healthz").Handler(libhttp.NewPrintHandler("OK"))
+		router.Path("/readiness").Handler(libhttp.NewPrintHandler("OK"))
+		router.Path("/metrics").Handler(promhttp.Handler())
+		router.PathPrefix(pkg.ESTPathPrefix).Handler(pkg.NewESTServer(ca, pkg.ESTServerOptions{
+			Validity:     a.Validity,
+			Username:     a.Username,
+			Password:     a.Password,
+			NamePatterns: namePatterns,
+			Inventory:    inventory,
+			AuditLog:     auditLog,
+		}))
+
+		// client certificates are optional, enrollment also accepts basic auth
+		clientCAs := x509.NewCertPool()
+		clientCAs.AddCert(ca.Certificate)
+
+		glog.V(2).Infof("starting est server listen on %s", a.Listen)
+		return pkg.NewMTLSServer(
+			a.Listen,
+			router,
+			paths.CertPath,
+			paths.KeyPath,
+			clientCAs,
+			tls.VerifyClientCertIfGiven,
+		).Run(ctx)
+	}
+}
@@ -6,9 +6,8 @@ package main
 
 import (
 	"context"
+	"flag"
 	"os"
-	"path"
-	"path/filepath"
 
 	"github.com/bborbe/errors"
 	"github.com/bborbe/sample_cert/pkg"
@@ -19,28 +18,128 @@ import (
 
 func main() {
 	app := &application{}
+	app.CASigner.Register(flag.CommandLine)
 	os.Exit(service.Main(context.Background(), app, &app.SentryDSN, &app.SentryProxy))
 }
 
 type application struct {
-	SentryDSN   string `required:"false" arg:"sentry-dsn" env:"SENTRY_DSN" usage:"SentryDSN" display:"length"`
-	SentryProxy string `required:"false" arg:"sentry-proxy" env:"SENTRY_PROXY" usage:"Sentry Proxy"`
-	DataDir     string `required:"true" arg:"datadir" env:"DATADIR" usage:"data directory"`
+	SentryDSN        string            `required:"false" arg:"sentry-dsn" env:"SENTRY_DSN" usage:"SentryDSN" display:"length"`
+	SentryProxy      string            `required:"false" arg:"sentry-proxy" env:"SENTRY_PROXY" usage:"Sentry Proxy"`
+	DataDir          string            `required:"true" arg:"datadir" env:"DATADIR" usage:"data directory"`
+	Force            bool              `required:"false" arg:"force" env:"FORCE" usage:"overwrite existing files"`
+	Backup           bool              `required:"false" arg:"backup" env:"BACKUP" usage:"keep a timestamped copy of existing files before overwriting"`
+	CASigner         pkg.CASignerFlags `display:"hidden"`
+	K8sConfigMap     string            `required:"false" arg:"k8s-configmap" env:"K8S_CONFIGMAP" usage:"write ConfigMap YAML with the ca certificate to this file, relative to datadir"`
+	K8sConfigMapName string            `required:"false" arg:"k8s-configmap-name" env:"K8S_CONFIGMAP_NAME" usage:"name of the ConfigMap" default:"ca-bundle"`
+	K8sNamespace     string            `required:"false" arg:"k8s-namespace" env:"K8S_NAMESPACE" usage:"namespace of the ConfigMap"`
+	K8sLabels        string            `required:"false" arg:"k8s-labels" env:"K8S_LABELS" usage:"comma separated key=value labels of the ConfigMap"`
+	PermittedDNS     string            `required:"false" arg:"permitted-dns" env:"PERMITTED_DNS" usage:"comma separated dns domains permitted below the ca"`
+	ExcludedDNS      string            `required:"false" arg:"excluded-dns" env:"EXCLUDED_DNS" usage:"comma separated dns domains excluded below the ca"`
+	PermittedIP      string            `required:"false" arg:"permitted-ip" env:"PERMITTED_IP" usage:"comma separated ip ranges (CIDR) permitted below the ca"`
+	ExcludedIP       string            `required:"false" arg:"excluded-ip" env:"EXCLUDED_IP" usage:"comma separated ip ranges (CIDR) excluded below the ca"`
+	PermittedEmail   string            `required:"false" arg:"permitted-email" env:"PERMITTED_EMAIL" usage:"comma separated mailboxes or email domains permitted below the ca"`
+	ExcludedEmail    string            `required:"false" arg:"excluded-email" env:"EXCLUDED_EMAIL" usage:"comma separated mailboxes or email domains excluded below the ca"`
+	PermittedURI     string            `required:"false" arg:"permitted-uri" env:"PERMITTED_URI" usage:"comma separated uri hosts or domains permitted below the ca"`
+	ExcludedURI      string            `required:"false" arg:"excluded-uri" env:"EXCLUDED_URI" usage:"comma separated uri hosts or domains excluded below the ca"`
 }
 
 func (a *application) Run(ctx context.Context, sentryClient libsentry.Client) error {
-	caCertPath, err := filepath.Abs(path.Join(a.DataDir, "ca_cert.pem"))
+	if a.CASigner.CAKeySocket != "" {
+		return errors.Errorf(ctx, "ca-key-socket is not supported, a new ca key is written to a file or generated in a PKCS#11 token")
+	}
+	dataDir := pkg.DataDir(a.DataDir)
+	caCertPath, caKeyPath, err := a.CASigner.Paths(ctx, dataDir)
 	if err != nil {
-		return errors.Wrapf(ctx, err, "generate caCert path failed")
+		return err
+	}
+	if err := pkg.CreateParentDirs(ctx, caCertPath, caKeyPath); err != nil {
+		return err
 	}
-	caKeyPath, err := filepath.Abs(path.Join(a.DataDir, "ca_key.pem"))
+	kubernetesOutput, err := pkg.KubernetesOutput{
+		ConfigMapPath: a.K8sConfigMap,
+		ConfigMapName: a.K8sConfigMapName,
+		Namespace:     a.K8sNamespace,
+		Labels:        a.K8sLabels,
+	}.Resolve(ctx, dataDir)
 	if err != nil {
-		return errors.Wrapf(ctx, err, "generate caKey path failed")
+		return errors.Wrapf(ctx, err, "invalid kubernetes output")
+	}
+	req := pkg.DefaultCARequest()
+	if req.NameConstraints, err = a.nameConstraints(ctx); err != nil {
+		return errors.Wrapf(ctx, err, "invalid name constraints")
 	}
-	if err := pkg.GenerateCaCerts(ctx, caCertPath, caKeyPath); err != nil {
-		return errors.Wrapf(ctx, err, "generate ca certs failed")
+	if pkcs11Config := a.CASigner.PKCS11Config(); pkcs11Config != nil {
+		if err := pkg.PrepareOverwrite(ctx, pkg.NewOverwriteMode(a.Force, a.Backup), append([]string{caCertPath}, kubernetesOutput.Paths()...)...); err != nil {
+			return errors.Wrapf(ctx, err, "prepare overwrite failed")
+		}
+		signer, err := pkg.GeneratePKCS11Key(ctx, *pkcs11Config)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "generate pkcs11 key failed")
+		}
+		defer pkg.CloseSigner(signer)
+		ca, err := pkg.CreateCAWithSigner(ctx, req, signer)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "create ca failed")
+		}
+		if err := a.audit(ctx, dataDir, ca); err != nil {
+			return err
+		}
+		if err := pkg.WriteCertificateFile(ctx, caCertPath, ca.CertificatePEM()); err != nil {
+			return errors.Wrapf(ctx, err, "write ca cert failed")
+		}
+		glog.V(2).Infof("CA cert was written to %s, key %s is stored in token", caCertPath, pkcs11Config.KeyLabel)
+		if err := pkg.WriteKubernetesManifests(ctx, kubernetesOutput, nil, ca); err != nil {
+			return errors.Wrapf(ctx, err, "write kubernetes manifests failed")
+		}
+		return nil
+	}
+
+	if err := pkg.PrepareOverwrite(ctx, pkg.NewOverwriteMode(a.Force, a.Backup), append([]string{caCertPath, caKeyPath}, kubernetesOutput.Paths()...)...); err != nil {
+		return errors.Wrapf(ctx, err, "prepare overwrite failed")
+	}
+
+	ca, err := pkg.CreateCA(ctx, req)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "create ca failed")
+	}
+	if err := a.audit(ctx, dataDir, ca); err != nil {
+		return err
+	}
+	if a.CASigner.CAKeyPassword == "" {
+		if err := pkg.WriteCA(ctx, ca, caCertPath, caKeyPath); err != nil {
+			return errors.Wrapf(ctx, err, "write ca failed")
+		}
+	} else {
+		if err := pkg.WriteEncryptedCA(ctx, ca, caCertPath, caKeyPath, []byte(a.CASigner.CAKeyPassword)); err != nil {
+			return errors.Wrapf(ctx, err, "write encrypted ca failed")
+		}
+	}
+	glog.V(2).Infof("CA certs was written to %s and %s", caCertPath, caKeyPath)
+	if err := pkg.WriteKubernetesManifests(ctx, kubernetesOutput, nil, ca); err != nil {
+		return errors.Wrapf(ctx, err, "write kubernetes manifests failed")
 	}
-	glog.V(2).Infof("CA certs was written to %s and %s", path.Join(a.DataDir, "ca_cert.pem"), path.Join(a.DataDir, "ca_key.pem"))
 
 	return nil
 }
+
+// audit records the new CA before it is written.
+func (a *application) audit(ctx context.Context, dataDir pkg.DataDir, ca *pkg.CA) error {
+	recorder, err := dataDir.IssuanceRecorder(ctx)
+	if err != nil {
+		return err
+	}
+	return recorder.Audit(ctx, pkg.NewAuditEntry(pkg.AuditOperationCreateCA, "", ca.Certificate, ""))
+}
+
+func (a *application) nameConstraints(ctx context.Context) (pkg.NameConstraints, error) {
+	return pkg.NameConstraintLists{
+		PermittedDNSDomains:     a.PermittedDNS,
+		ExcludedDNSDomains:      a.ExcludedDNS,
+		PermittedIPRanges:       a.PermittedIP,
+		ExcludedIPRanges:        a.ExcludedIP,
+		PermittedEmailAddresses: a.PermittedEmail,
+		ExcludedEmailAddresses:  a.ExcludedEmail,
+		PermittedURIDomains:     a.PermittedURI,
+		ExcludedURIDomains:      a.ExcludedURI,
+	}.NameConstraints(ctx)
+}
@@ -6,9 +6,8 @@ package main
 
 import (
 	"context"
+	"flag"
 	"os"
-	"path"
-	"path/filepath"
 
 	"github.com/bborbe/errors"
 	"github.com/bborbe/sample_cert/pkg"
@@ -19,38 +18,141 @@ import (
 
 func main() {
 	app := &application{}
+	app.CASigner.Register(flag.CommandLine)
 	os.Exit(service.Main(context.Background(), app, &app.SentryDSN, &app.SentryProxy))
 }
 
 type application struct {
-	SentryDSN   string `required:"false" arg:"sentry-dsn" env:"SENTRY_DSN" usage:"SentryDSN" display:"length"`
-	SentryProxy string `required:"false" arg:"sentry-proxy" env:"SENTRY_PROXY" usage:"Sentry Proxy"`
-	DataDir     string `required:"true" arg:"datadir" env:"DATADIR" usage:"data directory"`
+	SentryDSN        string            `required:"false" arg:"sentry-dsn" env:"SENTRY_DSN" usage:"SentryDSN" display:"length"`
+	SentryProxy      string            `required:"false" arg:"sentry-proxy" env:"SENTRY_PROXY" usage:"Sentry Proxy"`
+	DataDir          string            `required:"true" arg:"datadir" env:"DATADIR" usage:"data directory"`
+	Force            bool              `required:"false" arg:"force" env:"FORCE" usage:"overwrite existing files"`
+	Backup           bool              `required:"false" arg:"backup" env:"BACKUP" usage:"keep a timestamped copy of existing files before overwriting"`
+	CASigner         pkg.CASignerFlags `display:"hidden"`
+	Name             string            `required:"false" arg:"name" env:"NAME" usage:"write to <name>/cert.pem, <name>/key.pem and <name>/chain.pem"`
+	Cert             string            `required:"false" arg:"cert" env:"CERT" usage:"certificate output file, default client_cert.pem"`
+	Key              string            `required:"false" arg:"key" env:"KEY" usage:"key output file, default client_key.pem"`
+	Chain            string            `required:"false" arg:"chain" env:"CHAIN" usage:"chain output file, only written with name or if set"`
+	SPIFFEID         string            `required:"false" arg:"spiffe-id" env:"SPIFFE_ID" usage:"issue an X.509-SVID with this SPIFFE ID, e.g. spiffe://example.org/ns/prod/sa/web"`
+	K8sSecret        string            `required:"false" arg:"k8s-secret" env:"K8S_SECRET" usage:"write kubernetes.io/tls Secret YAML to this file, relative to datadir"`
+	K8sSecretName    string            `required:"false" arg:"k8s-secret-name" env:"K8S_SECRET_NAME" usage:"name of the Secret" default:"client-tls"`
+	K8sConfigMap     string            `required:"false" arg:"k8s-configmap" env:"K8S_CONFIGMAP" usage:"write ConfigMap YAML with the ca certificate to this file, relative to datadir"`
+	K8sConfigMapName string            `required:"false" arg:"k8s-configmap-name" env:"K8S_CONFIGMAP_NAME" usage:"name of the ConfigMap" default:"ca-bundle"`
+	K8sNamespace     string            `required:"false" arg:"k8s-namespace" env:"K8S_NAMESPACE" usage:"namespace of Secret and ConfigMap"`
+	K8sLabels        string            `required:"false" arg:"k8s-labels" env:"K8S_LABELS" usage:"comma separated key=value labels of Secret and ConfigMap"`
+	Batch            string            `required:"false" arg:"batch" env:"BATCH" usage:"CSV or JSON file with identities to issue, relative to datadir"`
+	Manifest         string            `required:"false" arg:"manifest" env:"MANIFEST" usage:"manifest written by batch, relative to datadir" default:"manifest.json"`
+	Concurrency      int               `required:"false" arg:"concurrency" env:"CONCURRENCY" usage:"certificates issued in parallel by batch" default:"4"`
 }
 
 func (a *application) Run(ctx context.Context, sentryClient libsentry.Client) error {
-	caCertPath, err := filepath.Abs(path.Join(a.DataDir, "ca_cert.pem"))
+	dataDir := pkg.DataDir(a.DataDir)
+	if a.Batch != "" {
+		return a.runBatch(ctx, dataDir)
+	}
+	paths, err := dataDir.IdentityPaths(
+		ctx,
+		a.Name,
+		pkg.IdentityPaths{CertPath: a.Cert, KeyPath: a.Key, ChainPath: a.Chain},
+		pkg.IdentityPaths{CertPath: pkg.ClientCertFile, KeyPath: pkg.ClientKeyFile},
+	)
 	if err != nil {
-		return errors.Wrapf(ctx, err, "generate caCert path failed")
+		return errors.Wrapf(ctx, err, "generate client paths failed")
 	}
-	caKeyPath, err := filepath.Abs(path.Join(a.DataDir, "ca_key.pem"))
+
+	kubernetesOutput, err := a.kubernetesOutput().Resolve(ctx, dataDir)
 	if err != nil {
-		return errors.Wrapf(ctx, err, "generate caKey path failed")
+		return errors.Wrapf(ctx, err, "invalid kubernetes output")
 	}
-	clientCertPath, err := filepath.Abs(path.Join(a.DataDir, "client_cert.pem"))
+	issueRequest, err := a.issueRequest(ctx)
 	if err != nil {
-		return errors.Wrapf(ctx, err, "generate clientKey path failed")
+		return errors.Wrapf(ctx, err, "invalid issue request")
+	}
+
+	if err := pkg.PrepareOverwrite(ctx, pkg.NewOverwriteMode(a.Force, a.Backup), append([]string{paths.CertPath, paths.KeyPath, paths.ChainPath}, kubernetesOutput.Paths()...)...); err != nil {
+		return errors.Wrapf(ctx, err, "prepare overwrite failed")
 	}
-	clientKeyPath, err := filepath.Abs(path.Join(a.DataDir, "client_key.pem"))
+
+	// Load the CA certificate and the configured signer
+	ca, err := a.CASigner.LoadCA(ctx, dataDir)
 	if err != nil {
-		return errors.Wrapf(ctx, err, "generate clientKey path failed")
+		return errors.Wrapf(ctx, err, "load ca failed")
 	}
+	defer ca.Close()
 
 	// Generate the client certificate signed by the CA
-	if err := pkg.GenerateClientCert(ctx, caCertPath, caKeyPath, clientCertPath, clientKeyPath); err != nil {
+	keyPair, err := pkg.IssueCertificate(ctx, ca, issueRequest)
+	if err != nil {
 		return errors.Wrapf(ctx, err, "Failed to generate client certificate")
 	}
-	glog.V(2).Infof("generate client cert(%s) and key(%s) completed", clientCertPath, clientKeyPath)
+	recorder, err := dataDir.IssuanceRecorder(ctx)
+	if err != nil {
+		return err
+	}
+	if err := recorder.Record(ctx, pkg.AuditOperationIssue, keyPair.Certificate, pkg.ProfileClient, paths.CertPath, paths.KeyPath); err != nil {
+		return err
+	}
+	if err := pkg.WriteIdentity(ctx, keyPair, ca, paths); err != nil {
+		return errors.Wrapf(ctx, err, "write client certificate failed")
+	}
+	if err := pkg.WriteKubernetesManifests(ctx, kubernetesOutput, keyPair, ca); err != nil {
+		return errors.Wrapf(ctx, err, "write kubernetes manifests failed")
+	}
+	glog.V(2).Infof("generate client cert(%s) and key(%s) completed", paths.CertPath, paths.KeyPath)
+
+	return nil
+}
 
+// runBatch issues all identities of the batch file with the CA loaded once.
+func (a *application) runBatch(ctx context.Context, dataDir pkg.DataDir) error {
+	batchPath, err := dataDir.Path(ctx, a.Batch)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "generate batch path failed")
+	}
+	manifestPath, err := dataDir.Path(ctx, a.Manifest)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "generate manifest path failed")
+	}
+	identities, err := pkg.LoadBatchIdentities(ctx, batchPath)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "load batch failed")
+	}
+	ca, err := a.CASigner.LoadCA(ctx, dataDir)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "load ca failed")
+	}
+	defer ca.Close()
+	recorder, err := dataDir.IssuanceRecorder(ctx)
+	if err != nil {
+		return err
+	}
+	manifest, issueErr := pkg.IssueBatch(ctx, ca, dataDir, identities, pkg.NewOverwriteMode(a.Force, a.Backup), a.Concurrency, recorder)
+	if err := pkg.WriteBatchManifest(ctx, manifestPath, manifest); err != nil {
+		return errors.Wrapf(ctx, err, "write manifest failed")
+	}
+	if issueErr != nil {
+		return errors.Wrapf(ctx, issueErr, "issue batch failed, see %s", manifestPath)
+	}
+	glog.V(2).Infof("issued %d client certs, manifest written to %s", len(manifest), manifestPath)
 	return nil
 }
+
+// issueRequest returns the default client request, as X.509-SVID if a SPIFFE ID is given.
+func (a *application) issueRequest(ctx context.Context) (pkg.IssueRequest, error) {
+	req := pkg.DefaultClientIssueRequest()
+	if a.SPIFFEID == "" {
+		return req, nil
+	}
+	return pkg.NewSVIDIssueRequest(ctx, req, a.SPIFFEID)
+}
+
+func (a *application) kubernetesOutput() pkg.KubernetesOutput {
+	return pkg.KubernetesOutput{
+		SecretPath:    a.K8sSecret,
+		SecretName:    a.K8sSecretName,
+		ConfigMapPath: a.K8sConfigMap,
+		ConfigMapName: a.K8sConfigMapName,
+		Namespace:     a.K8sNamespace,
+		Labels:        a.K8sLabels,
+	}
+}
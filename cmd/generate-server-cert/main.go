@@ -6,9 +6,8 @@ package main
 
 import (
 	"context"
+	"flag"
 	"os"
-	"path"
-	"path/filepath"
 
 	"github.com/bborbe/errors"
 	"github.com/bborbe/sample_cert/pkg"
@@ -19,38 +18,101 @@ import (
 
 func main() {
 	app := &application{}
+	app.CASigner.Register(flag.CommandLine)
 	os.Exit(service.Main(context.Background(), app, &app.SentryDSN, &app.SentryProxy))
 }
 
 type application struct {
-	SentryDSN   string `required:"false" arg:"sentry-dsn" env:"SENTRY_DSN" usage:"SentryDSN" display:"length"`
-	SentryProxy string `required:"false" arg:"sentry-proxy" env:"SENTRY_PROXY" usage:"Sentry Proxy"`
-	DataDir     string `required:"true" arg:"datadir" env:"DATADIR" usage:"data directory"`
+	SentryDSN        string            `required:"false" arg:"sentry-dsn" env:"SENTRY_DSN" usage:"SentryDSN" display:"length"`
+	SentryProxy      string            `required:"false" arg:"sentry-proxy" env:"SENTRY_PROXY" usage:"Sentry Proxy"`
+	DataDir          string            `required:"true" arg:"datadir" env:"DATADIR" usage:"data directory"`
+	Force            bool              `required:"false" arg:"force" env:"FORCE" usage:"overwrite existing files"`
+	Backup           bool              `required:"false" arg:"backup" env:"BACKUP" usage:"keep a timestamped copy of existing files before overwriting"`
+	CASigner         pkg.CASignerFlags `display:"hidden"`
+	Name             string            `required:"false" arg:"name" env:"NAME" usage:"write to <name>/cert.pem, <name>/key.pem and <name>/chain.pem"`
+	Cert             string            `required:"false" arg:"cert" env:"CERT" usage:"certificate output file, default server_cert.pem"`
+	Key              string            `required:"false" arg:"key" env:"KEY" usage:"key output file, default server_key.pem"`
+	Chain            string            `required:"false" arg:"chain" env:"CHAIN" usage:"chain output file, only written with name or if set"`
+	SPIFFEID         string            `required:"false" arg:"spiffe-id" env:"SPIFFE_ID" usage:"issue an X.509-SVID with this SPIFFE ID, e.g. spiffe://example.org/ns/prod/sa/web"`
+	K8sSecret        string            `required:"false" arg:"k8s-secret" env:"K8S_SECRET" usage:"write kubernetes.io/tls Secret YAML to this file, relative to datadir"`
+	K8sSecretName    string            `required:"false" arg:"k8s-secret-name" env:"K8S_SECRET_NAME" usage:"name of the Secret" default:"server-tls"`
+	K8sConfigMap     string            `required:"false" arg:"k8s-configmap" env:"K8S_CONFIGMAP" usage:"write ConfigMap YAML with the ca certificate to this file, relative to datadir"`
+	K8sConfigMapName string            `required:"false" arg:"k8s-configmap-name" env:"K8S_CONFIGMAP_NAME" usage:"name of the ConfigMap" default:"ca-bundle"`
+	K8sNamespace     string            `required:"false" arg:"k8s-namespace" env:"K8S_NAMESPACE" usage:"namespace of Secret and ConfigMap"`
+	K8sLabels        string            `required:"false" arg:"k8s-labels" env:"K8S_LABELS" usage:"comma separated key=value labels of Secret and ConfigMap"`
 }
 
 func (a *application) Run(ctx context.Context, sentryClient libsentry.Client) error {
-	caCertPath, err := filepath.Abs(path.Join(a.DataDir, "ca_cert.pem"))
+	dataDir := pkg.DataDir(a.DataDir)
+	paths, err := dataDir.IdentityPaths(
+		ctx,
+		a.Name,
+		pkg.IdentityPaths{CertPath: a.Cert, KeyPath: a.Key, ChainPath: a.Chain},
+		pkg.IdentityPaths{CertPath: pkg.ServerCertFile, KeyPath: pkg.ServerKeyFile},
+	)
 	if err != nil {
-		return errors.Wrapf(ctx, err, "generate caCert path failed")
+		return errors.Wrapf(ctx, err, "generate server paths failed")
 	}
-	caKeyPath, err := filepath.Abs(path.Join(a.DataDir, "ca_key.pem"))
+
+	kubernetesOutput, err := a.kubernetesOutput().Resolve(ctx, dataDir)
 	if err != nil {
-		return errors.Wrapf(ctx, err, "generate caKey path failed")
+		return errors.Wrapf(ctx, err, "invalid kubernetes output")
 	}
-	serverCertPath, err := filepath.Abs(path.Join(a.DataDir, "server_cert.pem"))
+	issueRequest, err := a.issueRequest(ctx)
 	if err != nil {
-		return errors.Wrapf(ctx, err, "generate serverKey path failed")
+		return errors.Wrapf(ctx, err, "invalid issue request")
+	}
+
+	if err := pkg.PrepareOverwrite(ctx, pkg.NewOverwriteMode(a.Force, a.Backup), append([]string{paths.CertPath, paths.KeyPath, paths.ChainPath}, kubernetesOutput.Paths()...)...); err != nil {
+		return errors.Wrapf(ctx, err, "prepare overwrite failed")
 	}
-	serverKeyPath, err := filepath.Abs(path.Join(a.DataDir, "server_key.pem"))
+
+	// Load the CA certificate and the configured signer
+	ca, err := a.CASigner.LoadCA(ctx, dataDir)
 	if err != nil {
-		return errors.Wrapf(ctx, err, "generate serverKey path failed")
+		return errors.Wrapf(ctx, err, "load ca failed")
 	}
+	defer ca.Close()
 
 	// Generate the server certificate signed by the CA
-	if err := pkg.GenerateServerCert(ctx, caCertPath, caKeyPath, serverCertPath, serverKeyPath); err != nil {
+	keyPair, err := pkg.IssueCertificate(ctx, ca, issueRequest)
+	if err != nil {
 		return errors.Wrapf(ctx, err, "Failed to generate server certificate")
 	}
-	glog.V(2).Infof("generate server cert(%s) and key(%s) completed", serverCertPath, serverKeyPath)
+	recorder, err := dataDir.IssuanceRecorder(ctx)
+	if err != nil {
+		return err
+	}
+	if err := recorder.Record(ctx, pkg.AuditOperationIssue, keyPair.Certificate, pkg.ProfileServer, paths.CertPath, paths.KeyPath); err != nil {
+		return err
+	}
+	if err := pkg.WriteIdentity(ctx, keyPair, ca, paths); err != nil {
+		return errors.Wrapf(ctx, err, "write server certificate failed")
+	}
+	if err := pkg.WriteKubernetesManifests(ctx, kubernetesOutput, keyPair, ca); err != nil {
+		return errors.Wrapf(ctx, err, "write kubernetes manifests failed")
+	}
+	glog.V(2).Infof("generate server cert(%s) and key(%s) completed", paths.CertPath, paths.KeyPath)
 
 	return nil
 }
+
+// issueRequest returns the default server request, as X.509-SVID if a SPIFFE ID is given.
+func (a *application) issueRequest(ctx context.Context) (pkg.IssueRequest, error) {
+	req := pkg.DefaultServerIssueRequest()
+	if a.SPIFFEID == "" {
+		return req, nil
+	}
+	return pkg.NewSVIDIssueRequest(ctx, req, a.SPIFFEID)
+}
+
+func (a *application) kubernetesOutput() pkg.KubernetesOutput {
+	return pkg.KubernetesOutput{
+		SecretPath:    a.K8sSecret,
+		SecretName:    a.K8sSecretName,
+		ConfigMapPath: a.K8sConfigMap,
+		ConfigMapName: a.K8sConfigMapName,
+		Namespace:     a.K8sNamespace,
+		Labels:        a.K8sLabels,
+	}
+}
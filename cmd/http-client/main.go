@@ -9,11 +9,10 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"path"
-	"path/filepath"
 
 	"github.com/bborbe/errors"
 	libhttp "github.com/bborbe/http"
+	"github.com/bborbe/sample_cert/pkg"
 	libsentry "github.com/bborbe/sentry"
 	"github.com/bborbe/service"
 )
@@ -28,24 +27,30 @@ type application struct {
 	SentryProxy string `required:"false" arg:"sentry-proxy" env:"SENTRY_PROXY" usage:"Sentry Proxy"`
 	DataDir     string `required:"true" arg:"datadir" env:"DATADIR" usage:"data directory"`
 	Listen      string `required:"true" arg:"listen" env:"LISTEN" usage:"address to listen to"`
+	CACert      string `required:"true" arg:"ca-cert" env:"CA_CERT" usage:"ca certificate file, relative to datadir" default:"ca_cert.pem"`
+	Name        string `required:"false" arg:"name" env:"NAME" usage:"use <name>/cert.pem and <name>/key.pem"`
+	Cert        string `required:"false" arg:"cert" env:"CERT" usage:"client certificate file, default client_cert.pem"`
+	Key         string `required:"false" arg:"key" env:"KEY" usage:"client key file, default client_key.pem"`
 }
 
 func (a *application) Run(ctx context.Context, sentryClient libsentry.Client) error {
-	caCertPath, err := filepath.Abs(path.Join(a.DataDir, "ca_cert.pem"))
+	dataDir := pkg.DataDir(a.DataDir)
+	caCertPath, err := dataDir.Path(ctx, a.CACert)
 	if err != nil {
 		return errors.Wrapf(ctx, err, "generate caCert path failed")
 	}
-	clientCertPath, err := filepath.Abs(path.Join(a.DataDir, "client_cert.pem"))
+	paths, err := dataDir.IdentityPaths(
+		ctx,
+		a.Name,
+		pkg.IdentityPaths{CertPath: a.Cert, KeyPath: a.Key},
+		pkg.IdentityPaths{CertPath: pkg.ClientCertFile, KeyPath: pkg.ClientKeyFile},
+	)
 	if err != nil {
-		return errors.Wrapf(ctx, err, "generate clientKey path failed")
-	}
-	clientKeyPath, err := filepath.Abs(path.Join(a.DataDir, "client_key.pem"))
-	if err != nil {
-		return errors.Wrapf(ctx, err, "generate clientKey path failed")
+		return errors.Wrapf(ctx, err, "generate client paths failed")
 	}
 
 	clientBuilder := libhttp.NewClientBuilder()
-	clientBuilder.WithClientCert(caCertPath, clientCertPath, clientKeyPath)
+	clientBuilder.WithClientCert(caCertPath, paths.CertPath, paths.KeyPath)
 	httpClient, err := clientBuilder.Build(ctx)
 	if err != nil {
 		return errors.Wrapf(ctx, err, "create httpClient failed")
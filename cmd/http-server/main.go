@@ -6,13 +6,15 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"net/http"
 	"os"
-	"path"
-	"path/filepath"
+	"time"
 
 	"github.com/bborbe/errors"
 	libhttp "github.com/bborbe/http"
+	"github.com/bborbe/sample_cert/pkg"
 	libsentry "github.com/bborbe/sentry"
 	"github.com/bborbe/service"
 	"github.com/golang/glog"
@@ -28,10 +30,20 @@ func main() {
 }
 
 type application struct {
-	SentryDSN   string `required:"false" arg:"sentry-dsn" env:"SENTRY_DSN" usage:"SentryDSN" display:"length"`
-	SentryProxy string `required:"false" arg:"sentry-proxy" env:"SENTRY_PROXY" usage:"Sentry Proxy"`
-	DataDir     string `required:"true" arg:"datadir" env:"DATADIR" usage:"data directory"`
-	Listen      string `required:"true" arg:"listen" env:"LISTEN" usage:"address to listen to"`
+	SentryDSN     string  `required:"false" arg:"sentry-dsn" env:"SENTRY_DSN" usage:"SentryDSN" display:"length"`
+	SentryProxy   string  `required:"false" arg:"sentry-proxy" env:"SENTRY_PROXY" usage:"Sentry Proxy"`
+	DataDir       string  `required:"true" arg:"datadir" env:"DATADIR" usage:"data directory"`
+	Listen        string  `required:"true" arg:"listen" env:"LISTEN" usage:"address to listen to"`
+	Name          string  `required:"false" arg:"name" env:"NAME" usage:"use <name>/cert.pem and <name>/key.pem"`
+	Cert          string  `required:"false" arg:"cert" env:"CERT" usage:"server certificate file, default server_cert.pem"`
+	Key           string  `required:"false" arg:"key" env:"KEY" usage:"server key file, default server_key.pem"`
+	ClientCA      string  `required:"false" arg:"client-ca" env:"CLIENT_CA" usage:"require client certificates issued by a ca of this file, relative to datadir"`
+	SPIFFEIDs     string  `required:"false" arg:"allowed-spiffe-ids" env:"ALLOWED_SPIFFE_IDS" usage:"comma separated SPIFFE ID patterns of allowed clients, e.g. spiffe://example.org/ns/*/sa/*, requires client-ca"`
+	AutoRenew     bool    `required:"false" arg:"auto-renew" env:"AUTO_RENEW" usage:"renew the server certificate with the ca cert and ca key file of the datadir and use it without restart"`
+	RenewFraction float64 `required:"false" arg:"renew-fraction" env:"RENEW_FRACTION" usage:"fraction of the certificate lifetime after which it is renewed" default:"0.66"`
+	CACert        string  `required:"false" arg:"ca-cert" env:"CA_CERT" usage:"ca certificate file for auto-renew, relative to datadir" default:"ca_cert.pem"`
+	CAKey         string  `required:"false" arg:"ca-key" env:"CA_KEY" usage:"ca key file for auto-renew, relative to datadir, external signers are not supported" default:"ca_key.pem"`
+	CAKeyPassword string  `required:"false" arg:"ca-key-password" env:"CA_KEY_PASSWORD" usage:"password of encrypted ca key" display:"length"`
 }
 
 func (a *application) Run(ctx context.Context, sentryClient libsentry.Client) error {
@@ -62,21 +74,142 @@ func (a *application) createHttpServer() run.Func {
 			libhttp.WriteAndGlog(resp, "test loglevel completed")
 		}))
 
-		serverCertPath, err := filepath.Abs(path.Join(a.DataDir, "server_cert.pem"))
+		dataDir := pkg.DataDir(a.DataDir)
+		paths, err := dataDir.ServerIdentity(ctx, a.Name, pkg.IdentityPaths{CertPath: a.Cert, KeyPath: a.Key})
 		if err != nil {
-			return errors.Wrapf(ctx, err, "generate serverKey path failed")
+			return err
 		}
-		serverKeyPath, err := filepath.Abs(path.Join(a.DataDir, "server_key.pem"))
-		if err != nil {
-			return errors.Wrapf(ctx, err, "generate serverKey path failed")
+		serverCertPath, serverKeyPath := paths.CertPath, paths.KeyPath
+
+		if a.AutoRenew {
+			return a.runWithAutoRenew(ctx, dataDir, paths, router)
 		}
 
-		glog.V(2).Infof("starting http server listen on %s", a.Listen)
-		return libhttp.NewServerTLS(
+		if a.ClientCA == "" {
+			if a.SPIFFEIDs != "" {
+				return errors.Errorf(ctx, "allowed-spiffe-ids requires client-ca")
+			}
+			glog.V(2).Infof("starting http server listen on %s", a.Listen)
+			return libhttp.NewServerTLS(
+				a.Listen,
+				router,
+				serverCertPath,
+				serverKeyPath,
+			).Run(ctx)
+		}
+
+		clientCAs, err := a.clientCAs(ctx, dataDir)
+		if err != nil {
+			return err
+		}
+		handler, err := a.authorizer(ctx, router)
+		if err != nil {
+			return err
+		}
+		glog.V(2).Infof("starting http server with client authentication listen on %s", a.Listen)
+		return pkg.NewMTLSServer(
 			a.Listen,
-			router,
+			handler,
 			serverCertPath,
 			serverKeyPath,
+			clientCAs,
+			tls.RequireAndVerifyClientCert,
 		).Run(ctx)
 	}
 }
+
+// runWithAutoRenew serves the certificate of an AutoRenewer and renews it in the background.
+func (a *application) runWithAutoRenew(ctx context.Context, dataDir pkg.DataDir, paths pkg.IdentityPaths, router http.Handler) error {
+	renewer, err := a.autoRenewer(ctx, dataDir, paths)
+	if err != nil {
+		return err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: renewer.GetCertificate,
+	}
+	var handler http.Handler = router
+	if a.ClientCA != "" {
+		if tlsConfig.ClientCAs, err = a.clientCAs(ctx, dataDir); err != nil {
+			return err
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		if handler, err = a.authorizer(ctx, router); err != nil {
+			return err
+		}
+	} else if a.SPIFFEIDs != "" {
+		return errors.Errorf(ctx, "allowed-spiffe-ids requires client-ca")
+	}
+	glog.V(2).Infof("starting http server with auto-renewal listen on %s, next renewal at %s", a.Listen, renewer.RenewAt().UTC().Format(time.RFC3339))
+	return run.CancelOnFirstError(
+		ctx,
+		pkg.NewTLSServerWithConfig(a.Listen, handler, tlsConfig),
+		renewer.Run,
+	)
+}
+
+// autoRenewer renews with the ca of the datadir and records renewals in its inventory and audit log.
+func (a *application) autoRenewer(ctx context.Context, dataDir pkg.DataDir, paths pkg.IdentityPaths) (*pkg.AutoRenewer, error) {
+	if a.CAKey == "" {
+		return nil, errors.Errorf(ctx, "auto-renew requires ca-key, renewal only supports a ca key file")
+	}
+	caCertPath, err := dataDir.Path(ctx, a.CACert)
+	if err != nil {
+		return nil, err
+	}
+	caKeyPath, err := dataDir.Path(ctx, a.CAKey)
+	if err != nil {
+		return nil, err
+	}
+	caStore := pkg.NewDataDirCAStore(dataDir, caCertPath, pkg.SignerConfig{KeyPath: caKeyPath, KeyPassword: a.CAKeyPassword})
+	// Fail fast instead of failing the first renewal
+	if _, err := caStore.Load(ctx); err != nil {
+		return nil, errors.Wrapf(ctx, err, "load ca failed")
+	}
+	inventoryPath, err := dataDir.Path(ctx, pkg.InventoryFile)
+	if err != nil {
+		return nil, err
+	}
+	auditLog, err := dataDir.AuditLog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	renewer, err := pkg.NewAutoRenewer(ctx, caStore, paths, a.RenewFraction, pkg.NewFileInventory(inventoryPath), auditLog)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "create auto renewer failed")
+	}
+	return renewer, nil
+}
+
+// authorizer wraps handler with the SPIFFE authorizer if allowed SPIFFE IDs are set.
+func (a *application) authorizer(ctx context.Context, handler http.Handler) (http.Handler, error) {
+	if a.SPIFFEIDs == "" {
+		return handler, nil
+	}
+	patterns, err := pkg.ParseSPIFFEIDPatterns(ctx, a.SPIFFEIDs)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "invalid allowed spiffe ids")
+	}
+	return pkg.NewSPIFFEAuthorizer(patterns, handler), nil
+}
+
+// clientCAs returns a pool of all certificates in the client ca file.
+func (a *application) clientCAs(ctx context.Context, dataDir pkg.DataDir) (*x509.CertPool, error) {
+	clientCAPath, err := dataDir.Path(ctx, a.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(clientCAPath)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "read client ca failed")
+	}
+	certs, err := pkg.ParseCertificates(ctx, data)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "parse client ca failed")
+	}
+	clientCAs := x509.NewCertPool()
+	for _, cert := range certs {
+		clientCAs.AddCert(cert)
+	}
+	return clientCAs, nil
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"os"
+
+	"github.com/bborbe/errors"
+	libhttp "github.com/bborbe/http"
+	"github.com/bborbe/run"
+	"github.com/bborbe/sample_cert/pkg"
+	libsentry "github.com/bborbe/sentry"
+	"github.com/bborbe/service"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+	app := &application{}
+	app.CASigner.Register(flag.CommandLine)
+	os.Exit(service.Main(context.Background(), app, &app.SentryDSN, &app.SentryProxy))
+}
+
+type application struct {
+	SentryDSN   string            `required:"false" arg:"sentry-dsn" env:"SENTRY_DSN" usage:"SentryDSN" display:"length"`
+	SentryProxy string            `required:"false" arg:"sentry-proxy" env:"SENTRY_PROXY" usage:"Sentry Proxy"`
+	DataDir     string            `required:"true" arg:"datadir" env:"DATADIR" usage:"data directory"`
+	Listen      string            `required:"true" arg:"listen" env:"LISTEN" usage:"address to listen to"`
+	CASigner    pkg.CASignerFlags `display:"hidden"`
+	Operators   string            `required:"true" arg:"operators" env:"OPERATORS" usage:"operators file, relative to datadir" default:"operators.yaml"`
+	Name        string            `required:"false" arg:"name" env:"NAME" usage:"use <name>/cert.pem and <name>/key.pem for the api"`
+	Cert        string            `required:"false" arg:"cert" env:"CERT" usage:"server certificate file of the api, default server_cert.pem"`
+	Key         string            `required:"false" arg:"key" env:"KEY" usage:"server key file of the api, default server_key.pem"`
+}
+
+func (a *application) Run(ctx context.Context, sentryClient libsentry.Client) error {
+	return service.Run(
+		ctx,
+		a.createHttpServer(),
+	)
+}
+
+func (a *application) createHttpServer() run.Func {
+	return func(ctx context.Context) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		dataDir := pkg.DataDir(a.DataDir)
+		ca, err := a.CASigner.LoadCA(ctx, dataDir)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "load ca failed")
+		}
+		defer ca.Close()
+		inventory, err := dataDir.Inventory(ctx)
+		if err != nil {
+			return err
+		}
+		auditLog, err := dataDir.AuditLog(ctx)
+		if err != nil {
+			return err
+		}
+		operatorsPath, err := dataDir.Path(ctx, a.Operators)
+		if err != nil {
+			return err
+		}
+		operators, err := pkg.LoadOperators(ctx, operatorsPath)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "load operators failed")
+		}
+
+		paths, err := dataDir.ServerIdentity(ctx, a.Name, pkg.IdentityPaths{CertPath: a.Cert, KeyPath: a.Key})
+		if err != nil {
+			return err
+		}
+
+		router := mux.NewRouter()
+		router.Path("/healthz").Handler(libhttp.NewPrintHandler("OK"))
+		router.Path("/readiness").Handler(libhttp.NewPrintHandler("OK"))
+		router.Path("/metrics").Handler(promhttp.Handler())
+		router.PathPrefix(pkg.IssuanceAPIPathPrefix).Handler(pkg.NewIssuanceAPI(ca, pkg.IssuanceAPIOptions{
+			Operators:    operators,
+			Inventory:    inventory,
+			AuditLog:     auditLog,
+			CRLPublisher: pkg.NewDataDirCRLPublisher(dataDir, ca, []byte(a.CASigner.CAKeyPassword)),
+		}))
+
+		// health and metrics stay reachable without client certificate
+		clientCAs := x509.NewCertPool()
+		clientCAs.AddCert(ca.Certificate)
+
+		glog.V(2).Infof("starting issuance api listen on %s with %d operators", a.Listen, len(operators))
+		return pkg.NewMTLSServer(
+			a.Listen,
+			router,
+			paths.CertPath,
+			paths.KeyPath,
+			clientCAs,
+			tls.VerifyClientCertIfGiven,
+		).Run(ctx)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/bborbe/errors"
+	"github.com/bborbe/sample_cert/pkg"
+	libsentry "github.com/bborbe/sentry"
+	"github.com/bborbe/service"
+)
+
+func main() {
+	app := &application{}
+	os.Exit(service.Main(context.Background(), app, &app.SentryDSN, &app.SentryProxy))
+}
+
+type application struct {
+	SentryDSN   string `required:"false" arg:"sentry-dsn" env:"SENTRY_DSN" usage:"SentryDSN" display:"length"`
+	SentryProxy string `required:"false" arg:"sentry-proxy" env:"SENTRY_PROXY" usage:"Sentry Proxy"`
+	DataDir     string `required:"true" arg:"datadir" env:"DATADIR" usage:"data directory, relative paths of the config are below it"`
+	Config      string `required:"true" arg:"config" env:"CONFIG" usage:"YAML or JSON file describing CAs and leaves" default:"pki.yaml"`
+	Backup      bool   `required:"false" arg:"backup" env:"BACKUP" usage:"keep a timestamped copy of renewed files"`
+}
+
+func (a *application) Run(ctx context.Context, sentryClient libsentry.Client) error {
+	configPath := a.Config
+	if !filepath.IsAbs(configPath) {
+		configPath = path.Join(a.DataDir, configPath)
+	}
+	config, err := pkg.LoadPKIConfig(ctx, configPath)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "load config failed")
+	}
+	recorder, err := pkg.DataDir(a.DataDir).IssuanceRecorder(ctx)
+	if err != nil {
+		return err
+	}
+	actions, err := pkg.ApplyPKIConfig(ctx, a.DataDir, *config, pkg.NewOverwriteMode(true, a.Backup), recorder)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "apply config failed")
+	}
+	for _, action := range actions {
+		fmt.Println(action.String())
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bborbe/errors"
+	"github.com/bborbe/sample_cert/pkg"
+	libsentry "github.com/bborbe/sentry"
+	"github.com/bborbe/service"
+)
+
+func main() {
+	app := &application{}
+	os.Exit(service.Main(context.Background(), app, &app.SentryDSN, &app.SentryProxy))
+}
+
+type application struct {
+	SentryDSN     string `required:"false" arg:"sentry-dsn" env:"SENTRY_DSN" usage:"SentryDSN" display:"length"`
+	SentryProxy   string `required:"false" arg:"sentry-proxy" env:"SENTRY_PROXY" usage:"Sentry Proxy"`
+	DataDir       string `required:"true" arg:"datadir" env:"DATADIR" usage:"data directory"`
+	Cert          string `required:"true" arg:"cert" env:"CERT" usage:"leaf certificate to verify" default:"server_cert.pem"`
+	Intermediates string `required:"false" arg:"intermediates" env:"INTERMEDIATES" usage:"comma separated intermediate certificates"`
+	CA            string `required:"true" arg:"ca" env:"CA" usage:"trusted ca certificates" default:"ca_cert.pem"`
+	Purpose       string `required:"true" arg:"purpose" env:"PURPOSE" usage:"server or client" default:"server"`
+	Hostname      string `required:"false" arg:"hostname" env:"VERIFY_HOSTNAME" usage:"hostname or ip the certificate must be valid for"`
+	At            string `required:"false" arg:"at" env:"AT" usage:"point in time to verify (RFC3339), default now"`
+}
+
+func (a *application) Run(ctx context.Context, sentryClient libsentry.Client) error {
+	leafs, err := a.load(ctx, a.Cert)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "load cert failed")
+	}
+	roots, err := a.load(ctx, a.CA)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "load ca failed")
+	}
+	intermediates := leafs[1:]
+	for _, file := range strings.Split(a.Intermediates, ",") {
+		if strings.TrimSpace(file) == "" {
+			continue
+		}
+		certs, err := a.load(ctx, strings.TrimSpace(file))
+		if err != nil {
+			return errors.Wrapf(ctx, err, "load intermediate failed")
+		}
+		intermediates = append(intermediates, certs...)
+	}
+	var verifyTime time.Time
+	if a.At != "" {
+		verifyTime, err = time.Parse(time.RFC3339, a.At)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "parse at '%s' failed", a.At)
+		}
+	}
+
+	chains, err := pkg.VerifyCertificate(ctx, pkg.VerifyRequest{
+		Leaf:          leafs[0],
+		Intermediates: intermediates,
+		Roots:         roots,
+		Purpose:       pkg.Profile(a.Purpose),
+		Hostname:      a.Hostname,
+		Time:          verifyTime,
+	})
+	if err != nil {
+		var verifyErr pkg.VerifyError
+		if errors.As(err, &verifyErr) {
+			fmt.Printf("FAILED: %s\n", verifyErr.Explanation)
+		}
+		return errors.Wrapf(ctx, err, "verify %s failed", a.Cert)
+	}
+	for _, chain := range chains {
+		var subjects []string
+		for _, cert := range chain {
+			subjects = append(subjects, cert.Subject.String())
+		}
+		fmt.Printf("OK: %s\n", strings.Join(subjects, " -> "))
+	}
+	return nil
+}
+
+func (a *application) load(ctx context.Context, file string) ([]*x509.Certificate, error) {
+	if !filepath.IsAbs(file) {
+		file = path.Join(a.DataDir, file)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "read %s failed", file)
+	}
+	return pkg.ParseCertificates(ctx, data)
+}
@@ -5,6 +5,7 @@ go 1.23.2
 //replace github.com/bborbe/http => ../http
 
 require (
+	github.com/ThalesGroup/crypto11 v1.3.0
 	github.com/bborbe/errors v1.3.0
 	github.com/bborbe/http v1.5.6
 	github.com/bborbe/run v1.5.3
@@ -19,8 +20,11 @@ require (
 	github.com/onsi/ginkgo/v2 v2.21.0
 	github.com/onsi/gomega v1.35.0
 	github.com/prometheus/client_golang v1.20.5
+	golang.org/x/crypto v0.28.0
 	golang.org/x/lint v0.0.0-20210508222113-6edffad5e616
+	golang.org/x/sys v0.26.0
 	golang.org/x/vuln v1.1.3
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -42,18 +46,18 @@ require (
 	github.com/google/go-cmp v0.6.0 // indirect
 	github.com/google/pprof v0.0.0-20241029153458-d1b30febd7db // indirect
 	github.com/klauspost/compress v1.17.11 // indirect
+	github.com/miekg/pkcs11 v1.1.1 // indirect
 	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/prometheus/client_model v0.6.1 // indirect
 	github.com/prometheus/common v0.60.1 // indirect
 	github.com/prometheus/procfs v0.15.1 // indirect
+	github.com/thales-e-security/pool v0.0.2 // indirect
 	golang.org/x/mod v0.21.0 // indirect
 	golang.org/x/net v0.30.0 // indirect
 	golang.org/x/sync v0.8.0 // indirect
-	golang.org/x/sys v0.26.0 // indirect
 	golang.org/x/telemetry v0.0.0-20241028140143-9c0d19e65ba0 // indirect
 	golang.org/x/text v0.19.0 // indirect
 	golang.org/x/tools v0.26.0 // indirect
 	google.golang.org/protobuf v1.35.1 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
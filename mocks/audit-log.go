@@ -0,0 +1,119 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package mocks
+
+import (
+	"context"
+	"sync"
+
+	"github.com/bborbe/sample_cert/pkg"
+)
+
+type AuditLog struct {
+	AppendStub        func(context.Context, pkg.AuditEntry) (*pkg.AuditEntry, error)
+	appendMutex       sync.RWMutex
+	appendArgsForCall []struct {
+		arg1 context.Context
+		arg2 pkg.AuditEntry
+	}
+	appendReturns struct {
+		result1 *pkg.AuditEntry
+		result2 error
+	}
+	appendReturnsOnCall map[int]struct {
+		result1 *pkg.AuditEntry
+		result2 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *AuditLog) Append(arg1 context.Context, arg2 pkg.AuditEntry) (*pkg.AuditEntry, error) {
+	fake.appendMutex.Lock()
+	ret, specificReturn := fake.appendReturnsOnCall[len(fake.appendArgsForCall)]
+	fake.appendArgsForCall = append(fake.appendArgsForCall, struct {
+		arg1 context.Context
+		arg2 pkg.AuditEntry
+	}{arg1, arg2})
+	stub := fake.AppendStub
+	fakeReturns := fake.appendReturns
+	fake.recordInvocation("Append", []interface{}{arg1, arg2})
+	fake.appendMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *AuditLog) AppendCallCount() int {
+	fake.appendMutex.RLock()
+	defer fake.appendMutex.RUnlock()
+	return len(fake.appendArgsForCall)
+}
+
+func (fake *AuditLog) AppendCalls(stub func(context.Context, pkg.AuditEntry) (*pkg.AuditEntry, error)) {
+	fake.appendMutex.Lock()
+	defer fake.appendMutex.Unlock()
+	fake.AppendStub = stub
+}
+
+func (fake *AuditLog) AppendArgsForCall(i int) (context.Context, pkg.AuditEntry) {
+	fake.appendMutex.RLock()
+	defer fake.appendMutex.RUnlock()
+	argsForCall := fake.appendArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *AuditLog) AppendReturns(result1 *pkg.AuditEntry, result2 error) {
+	fake.appendMutex.Lock()
+	defer fake.appendMutex.Unlock()
+	fake.AppendStub = nil
+	fake.appendReturns = struct {
+		result1 *pkg.AuditEntry
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *AuditLog) AppendReturnsOnCall(i int, result1 *pkg.AuditEntry, result2 error) {
+	fake.appendMutex.Lock()
+	defer fake.appendMutex.Unlock()
+	fake.AppendStub = nil
+	if fake.appendReturnsOnCall == nil {
+		fake.appendReturnsOnCall = make(map[int]struct {
+			result1 *pkg.AuditEntry
+			result2 error
+		})
+	}
+	fake.appendReturnsOnCall[i] = struct {
+		result1 *pkg.AuditEntry
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *AuditLog) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.appendMutex.RLock()
+	defer fake.appendMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *AuditLog) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ pkg.AuditLog = new(AuditLog)
@@ -0,0 +1,204 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package mocks
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"sync"
+
+	"github.com/bborbe/sample_cert/pkg"
+)
+
+type CertificateIssuer struct {
+	IssueStub        func(context.Context, pkg.IssueRequest) (*pkg.KeyPair, error)
+	issueMutex       sync.RWMutex
+	issueArgsForCall []struct {
+		arg1 context.Context
+		arg2 pkg.IssueRequest
+	}
+	issueReturns struct {
+		result1 *pkg.KeyPair
+		result2 error
+	}
+	issueReturnsOnCall map[int]struct {
+		result1 *pkg.KeyPair
+		result2 error
+	}
+	SignStub        func(context.Context, pkg.IssueRequest, crypto.PublicKey) (*x509.Certificate, error)
+	signMutex       sync.RWMutex
+	signArgsForCall []struct {
+		arg1 context.Context
+		arg2 pkg.IssueRequest
+		arg3 crypto.PublicKey
+	}
+	signReturns struct {
+		result1 *x509.Certificate
+		result2 error
+	}
+	signReturnsOnCall map[int]struct {
+		result1 *x509.Certificate
+		result2 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *CertificateIssuer) Issue(arg1 context.Context, arg2 pkg.IssueRequest) (*pkg.KeyPair, error) {
+	fake.issueMutex.Lock()
+	ret, specificReturn := fake.issueReturnsOnCall[len(fake.issueArgsForCall)]
+	fake.issueArgsForCall = append(fake.issueArgsForCall, struct {
+		arg1 context.Context
+		arg2 pkg.IssueRequest
+	}{arg1, arg2})
+	stub := fake.IssueStub
+	fakeReturns := fake.issueReturns
+	fake.recordInvocation("Issue", []interface{}{arg1, arg2})
+	fake.issueMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *CertificateIssuer) IssueCallCount() int {
+	fake.issueMutex.RLock()
+	defer fake.issueMutex.RUnlock()
+	return len(fake.issueArgsForCall)
+}
+
+func (fake *CertificateIssuer) IssueCalls(stub func(context.Context, pkg.IssueRequest) (*pkg.KeyPair, error)) {
+	fake.issueMutex.Lock()
+	defer fake.issueMutex.Unlock()
+	fake.IssueStub = stub
+}
+
+func (fake *CertificateIssuer) IssueArgsForCall(i int) (context.Context, pkg.IssueRequest) {
+	fake.issueMutex.RLock()
+	defer fake.issueMutex.RUnlock()
+	argsForCall := fake.issueArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *CertificateIssuer) IssueReturns(result1 *pkg.KeyPair, result2 error) {
+	fake.issueMutex.Lock()
+	defer fake.issueMutex.Unlock()
+	fake.IssueStub = nil
+	fake.issueReturns = struct {
+		result1 *pkg.KeyPair
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *CertificateIssuer) IssueReturnsOnCall(i int, result1 *pkg.KeyPair, result2 error) {
+	fake.issueMutex.Lock()
+	defer fake.issueMutex.Unlock()
+	fake.IssueStub = nil
+	if fake.issueReturnsOnCall == nil {
+		fake.issueReturnsOnCall = make(map[int]struct {
+			result1 *pkg.KeyPair
+			result2 error
+		})
+	}
+	fake.issueReturnsOnCall[i] = struct {
+		result1 *pkg.KeyPair
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *CertificateIssuer) Sign(arg1 context.Context, arg2 pkg.IssueRequest, arg3 crypto.PublicKey) (*x509.Certificate, error) {
+	fake.signMutex.Lock()
+	ret, specificReturn := fake.signReturnsOnCall[len(fake.signArgsForCall)]
+	fake.signArgsForCall = append(fake.signArgsForCall, struct {
+		arg1 context.Context
+		arg2 pkg.IssueRequest
+		arg3 crypto.PublicKey
+	}{arg1, arg2, arg3})
+	stub := fake.SignStub
+	fakeReturns := fake.signReturns
+	fake.recordInvocation("Sign", []interface{}{arg1, arg2, arg3})
+	fake.signMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *CertificateIssuer) SignCallCount() int {
+	fake.signMutex.RLock()
+	defer fake.signMutex.RUnlock()
+	return len(fake.signArgsForCall)
+}
+
+func (fake *CertificateIssuer) SignCalls(stub func(context.Context, pkg.IssueRequest, crypto.PublicKey) (*x509.Certificate, error)) {
+	fake.signMutex.Lock()
+	defer fake.signMutex.Unlock()
+	fake.SignStub = stub
+}
+
+func (fake *CertificateIssuer) SignArgsForCall(i int) (context.Context, pkg.IssueRequest, crypto.PublicKey) {
+	fake.signMutex.RLock()
+	defer fake.signMutex.RUnlock()
+	argsForCall := fake.signArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *CertificateIssuer) SignReturns(result1 *x509.Certificate, result2 error) {
+	fake.signMutex.Lock()
+	defer fake.signMutex.Unlock()
+	fake.SignStub = nil
+	fake.signReturns = struct {
+		result1 *x509.Certificate
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *CertificateIssuer) SignReturnsOnCall(i int, result1 *x509.Certificate, result2 error) {
+	fake.signMutex.Lock()
+	defer fake.signMutex.Unlock()
+	fake.SignStub = nil
+	if fake.signReturnsOnCall == nil {
+		fake.signReturnsOnCall = make(map[int]struct {
+			result1 *x509.Certificate
+			result2 error
+		})
+	}
+	fake.signReturnsOnCall[i] = struct {
+		result1 *x509.Certificate
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *CertificateIssuer) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.issueMutex.RLock()
+	defer fake.issueMutex.RUnlock()
+	fake.signMutex.RLock()
+	defer fake.signMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *CertificateIssuer) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ pkg.CertificateIssuer = new(CertificateIssuer)
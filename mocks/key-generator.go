@@ -0,0 +1,118 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package mocks
+
+import (
+	"context"
+	"crypto"
+	"sync"
+
+	"github.com/bborbe/sample_cert/pkg"
+)
+
+type KeyGenerator struct {
+	GenerateKeyStub        func(context.Context) (crypto.Signer, error)
+	generateKeyMutex       sync.RWMutex
+	generateKeyArgsForCall []struct {
+		arg1 context.Context
+	}
+	generateKeyReturns struct {
+		result1 crypto.Signer
+		result2 error
+	}
+	generateKeyReturnsOnCall map[int]struct {
+		result1 crypto.Signer
+		result2 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *KeyGenerator) GenerateKey(arg1 context.Context) (crypto.Signer, error) {
+	fake.generateKeyMutex.Lock()
+	ret, specificReturn := fake.generateKeyReturnsOnCall[len(fake.generateKeyArgsForCall)]
+	fake.generateKeyArgsForCall = append(fake.generateKeyArgsForCall, struct {
+		arg1 context.Context
+	}{arg1})
+	stub := fake.GenerateKeyStub
+	fakeReturns := fake.generateKeyReturns
+	fake.recordInvocation("GenerateKey", []interface{}{arg1})
+	fake.generateKeyMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *KeyGenerator) GenerateKeyCallCount() int {
+	fake.generateKeyMutex.RLock()
+	defer fake.generateKeyMutex.RUnlock()
+	return len(fake.generateKeyArgsForCall)
+}
+
+func (fake *KeyGenerator) GenerateKeyCalls(stub func(context.Context) (crypto.Signer, error)) {
+	fake.generateKeyMutex.Lock()
+	defer fake.generateKeyMutex.Unlock()
+	fake.GenerateKeyStub = stub
+}
+
+func (fake *KeyGenerator) GenerateKeyArgsForCall(i int) context.Context {
+	fake.generateKeyMutex.RLock()
+	defer fake.generateKeyMutex.RUnlock()
+	argsForCall := fake.generateKeyArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *KeyGenerator) GenerateKeyReturns(result1 crypto.Signer, result2 error) {
+	fake.generateKeyMutex.Lock()
+	defer fake.generateKeyMutex.Unlock()
+	fake.GenerateKeyStub = nil
+	fake.generateKeyReturns = struct {
+		result1 crypto.Signer
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *KeyGenerator) GenerateKeyReturnsOnCall(i int, result1 crypto.Signer, result2 error) {
+	fake.generateKeyMutex.Lock()
+	defer fake.generateKeyMutex.Unlock()
+	fake.GenerateKeyStub = nil
+	if fake.generateKeyReturnsOnCall == nil {
+		fake.generateKeyReturnsOnCall = make(map[int]struct {
+			result1 crypto.Signer
+			result2 error
+		})
+	}
+	fake.generateKeyReturnsOnCall[i] = struct {
+		result1 crypto.Signer
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *KeyGenerator) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.generateKeyMutex.RLock()
+	defer fake.generateKeyMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *KeyGenerator) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ pkg.KeyGenerator = new(KeyGenerator)
@@ -0,0 +1,118 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package mocks
+
+import (
+	"context"
+	"crypto"
+	"sync"
+
+	"github.com/bborbe/sample_cert/pkg"
+)
+
+type SignerLoader struct {
+	LoadSignerStub        func(context.Context) (crypto.Signer, error)
+	loadSignerMutex       sync.RWMutex
+	loadSignerArgsForCall []struct {
+		arg1 context.Context
+	}
+	loadSignerReturns struct {
+		result1 crypto.Signer
+		result2 error
+	}
+	loadSignerReturnsOnCall map[int]struct {
+		result1 crypto.Signer
+		result2 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *SignerLoader) LoadSigner(arg1 context.Context) (crypto.Signer, error) {
+	fake.loadSignerMutex.Lock()
+	ret, specificReturn := fake.loadSignerReturnsOnCall[len(fake.loadSignerArgsForCall)]
+	fake.loadSignerArgsForCall = append(fake.loadSignerArgsForCall, struct {
+		arg1 context.Context
+	}{arg1})
+	stub := fake.LoadSignerStub
+	fakeReturns := fake.loadSignerReturns
+	fake.recordInvocation("LoadSigner", []interface{}{arg1})
+	fake.loadSignerMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *SignerLoader) LoadSignerCallCount() int {
+	fake.loadSignerMutex.RLock()
+	defer fake.loadSignerMutex.RUnlock()
+	return len(fake.loadSignerArgsForCall)
+}
+
+func (fake *SignerLoader) LoadSignerCalls(stub func(context.Context) (crypto.Signer, error)) {
+	fake.loadSignerMutex.Lock()
+	defer fake.loadSignerMutex.Unlock()
+	fake.LoadSignerStub = stub
+}
+
+func (fake *SignerLoader) LoadSignerArgsForCall(i int) context.Context {
+	fake.loadSignerMutex.RLock()
+	defer fake.loadSignerMutex.RUnlock()
+	argsForCall := fake.loadSignerArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *SignerLoader) LoadSignerReturns(result1 crypto.Signer, result2 error) {
+	fake.loadSignerMutex.Lock()
+	defer fake.loadSignerMutex.Unlock()
+	fake.LoadSignerStub = nil
+	fake.loadSignerReturns = struct {
+		result1 crypto.Signer
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *SignerLoader) LoadSignerReturnsOnCall(i int, result1 crypto.Signer, result2 error) {
+	fake.loadSignerMutex.Lock()
+	defer fake.loadSignerMutex.Unlock()
+	fake.LoadSignerStub = nil
+	if fake.loadSignerReturnsOnCall == nil {
+		fake.loadSignerReturnsOnCall = make(map[int]struct {
+			result1 crypto.Signer
+			result2 error
+		})
+	}
+	fake.loadSignerReturnsOnCall[i] = struct {
+		result1 crypto.Signer
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *SignerLoader) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.loadSignerMutex.RLock()
+	defer fake.loadSignerMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *SignerLoader) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ pkg.SignerLoader = new(SignerLoader)
@@ -0,0 +1,226 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/bborbe/errors"
+)
+
+// ACMEJWK is a JSON Web Key (RFC 7517) as used for ACME account keys.
+type ACMEJWK struct {
+	KeyType string `json:"kty"`
+	Curve   string `json:"crv,omitempty"`
+	X       string `json:"x,omitempty"`
+	Y       string `json:"y,omitempty"`
+	N       string `json:"n,omitempty"`
+	E       string `json:"e,omitempty"`
+}
+
+// PublicKey returns the public key described by the JWK.
+func (j ACMEJWK) PublicKey(ctx context.Context) (crypto.PublicKey, error) {
+	switch j.KeyType {
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf(ctx, "unsupported curve '%s'", j.Curve)
+		}
+		x, err := decodeBase64URLInt(ctx, j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBase64URLInt(ctx, j.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.Errorf(ctx, "point is not on curve %s", j.Curve)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "RSA":
+		n, err := decodeBase64URLInt(ctx, j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBase64URLInt(ctx, j.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.Errorf(ctx, "invalid rsa exponent")
+		}
+		if n.BitLen() < 2048 {
+			return nil, errors.Errorf(ctx, "rsa key too short: %d bit", n.BitLen())
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "OKP":
+		if j.Curve != "Ed25519" {
+			return nil, errors.Errorf(ctx, "unsupported curve '%s'", j.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "decode x failed")
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.Errorf(ctx, "invalid ed25519 key length %d", len(x))
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, errors.Errorf(ctx, "unsupported key type '%s'", j.KeyType)
+	}
+}
+
+// Thumbprint returns the base64url encoded SHA-256 thumbprint (RFC 7638).
+func (j ACMEJWK) Thumbprint() string {
+	var canonical string
+	// members in lexicographic order without whitespace
+	switch j.KeyType {
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, j.Curve, j.KeyType, j.X, j.Y)
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":%q,"n":%q}`, j.E, j.KeyType, j.N)
+	default:
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q}`, j.Curve, j.KeyType, j.X)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ACMEKeyAuthorization returns the key authorization of a challenge token for the account key.
+func ACMEKeyAuthorization(token string, jwk ACMEJWK) string {
+	return token + "." + jwk.Thumbprint()
+}
+
+// ACMEJWS is a JWS in flattened JSON serialization.
+type ACMEJWS struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// ACMEJWSHeader is the protected header of an ACME request.
+type ACMEJWSHeader struct {
+	Algorithm string   `json:"alg"`
+	JWK       *ACMEJWK `json:"jwk,omitempty"`
+	KeyID     string   `json:"kid,omitempty"`
+	Nonce     string   `json:"nonce"`
+	URL       string   `json:"url"`
+}
+
+// ParseACMEJWS decodes the body and the protected header of an ACME request.
+func ParseACMEJWS(ctx context.Context, body []byte) (*ACMEJWS, *ACMEJWSHeader, error) {
+	var jws ACMEJWS
+	if err := json.Unmarshal(body, &jws); err != nil {
+		return nil, nil, errors.Wrapf(ctx, err, "unmarshal jws failed")
+	}
+	protected, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err != nil {
+		return nil, nil, errors.Wrapf(ctx, err, "decode protected header failed")
+	}
+	var header ACMEJWSHeader
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return nil, nil, errors.Wrapf(ctx, err, "unmarshal protected header failed")
+	}
+	if header.JWK != nil && header.KeyID != "" || header.JWK == nil && header.KeyID == "" {
+		return nil, nil, errors.Errorf(ctx, "protected header must contain either jwk or kid")
+	}
+	return &jws, &header, nil
+}
+
+// Verify checks the signature with the given public key and returns the decoded payload.
+func (j *ACMEJWS) Verify(ctx context.Context, algorithm string, publicKey crypto.PublicKey) ([]byte, error) {
+	signature, err := base64.RawURLEncoding.DecodeString(j.Signature)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "decode signature failed")
+	}
+	signingInput := []byte(j.Protected + "." + j.Payload)
+	if err := verifyJWSSignature(ctx, algorithm, publicKey, signingInput, signature); err != nil {
+		return nil, err
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(j.Payload)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "decode payload failed")
+	}
+	return payload, nil
+}
+
+func verifyJWSSignature(ctx context.Context, algorithm string, publicKey crypto.PublicKey, signingInput []byte, signature []byte) error {
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		var digest []byte
+		switch {
+		case algorithm == "ES256" && key.Curve == elliptic.P256():
+			sum := sha256.Sum256(signingInput)
+			digest = sum[:]
+		case algorithm == "ES384" && key.Curve == elliptic.P384():
+			sum := sha512.Sum384(signingInput)
+			digest = sum[:]
+		case algorithm == "ES512" && key.Curve == elliptic.P521():
+			sum := sha512.Sum512(signingInput)
+			digest = sum[:]
+		default:
+			return errors.Errorf(ctx, "algorithm %s does not match ec key", algorithm)
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.Errorf(ctx, "invalid signature length %d", len(signature))
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errors.Errorf(ctx, "invalid signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		if algorithm != "RS256" {
+			return errors.Errorf(ctx, "algorithm %s does not match rsa key", algorithm)
+		}
+		sum := sha256.Sum256(signingInput)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], signature); err != nil {
+			return errors.Wrapf(ctx, err, "invalid signature")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if algorithm != "EdDSA" {
+			return errors.Errorf(ctx, "algorithm %s does not match ed25519 key", algorithm)
+		}
+		if !ed25519.Verify(key, signingInput, signature) {
+			return errors.Errorf(ctx, "invalid signature")
+		}
+		return nil
+	default:
+		return errors.Errorf(ctx, "unsupported key %T", publicKey)
+	}
+}
+
+func decodeBase64URLInt(ctx context.Context, value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "decode base64url failed")
+	}
+	if len(data) == 0 {
+		return nil, errors.Errorf(ctx, "empty integer")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,927 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+// ACMEServerOptions configures the ACME server.
+type ACMEServerOptions struct {
+	// BaseURL is the external URL of the server, e.g. https://acme.example.com:8444
+	BaseURL string
+	// Validity of issued certificates.
+	Validity time.Duration
+	// Validator checks http-01 and tls-alpn-01 challenges.
+	Validator ACMEChallengeValidator
+	// Inventory records issued certificates, optional.
+	Inventory Inventory
+	// AuditLog records issued certificates, optional.
+	AuditLog AuditLog
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+}
+
+// NewACMEServer returns an http.Handler implementing the ACME protocol (RFC 8555)
+// issuing server certificates signed by ca. All state is kept in memory.
+func NewACMEServer(ca *CA, options ACMEServerOptions) http.Handler {
+	s := &acmeServer{
+		ca:             ca,
+		baseURL:        strings.TrimSuffix(options.BaseURL, "/"),
+		validity:       options.Validity,
+		validator:      options.Validator,
+		inventory:      options.Inventory,
+		auditLog:       options.AuditLog,
+		now:            options.Now,
+		nonces:         make(map[string]time.Time),
+		accounts:       make(map[string]*acmeAccount),
+		orders:         make(map[string]*acmeOrder),
+		authorizations: make(map[string]*acmeAuthorization),
+		challenges:     make(map[string]*acmeChallenge),
+		certificates:   make(map[string][]byte),
+	}
+	router := mux.NewRouter()
+	router.Path("/directory").Methods(http.MethodGet).HandlerFunc(s.handleDirectory)
+	router.Path("/new-nonce").Methods(http.MethodGet, http.MethodHead).HandlerFunc(s.handleNewNonce)
+	router.Path("/new-account").Methods(http.MethodPost).HandlerFunc(s.handleNewAccount)
+	router.Path("/account/{id}").Methods(http.MethodPost).HandlerFunc(s.handleAccount)
+	router.Path("/account/{id}/orders").Methods(http.MethodPost).HandlerFunc(s.handleAccountOrders)
+	router.Path("/new-order").Methods(http.MethodPost).HandlerFunc(s.handleNewOrder)
+	router.Path("/order/{id}").Methods(http.MethodPost).HandlerFunc(s.handleOrder)
+	router.Path("/authz/{id}").Methods(http.MethodPost).HandlerFunc(s.handleAuthorization)
+	router.Path("/challenge/{id}").Methods(http.MethodPost).HandlerFunc(s.handleChallenge)
+	router.Path("/finalize/{id}").Methods(http.MethodPost).HandlerFunc(s.handleFinalize)
+	router.Path("/cert/{id}").Methods(http.MethodPost).HandlerFunc(s.handleCertificate)
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		// every response carries a fresh nonce and the directory link
+		resp.Header().Set("Replay-Nonce", s.newNonce())
+		resp.Header().Set("Cache-Control", "no-store")
+		resp.Header().Add("Link", fmt.Sprintf("<%s>;rel=\"index\"", s.url("directory")))
+		router.ServeHTTP(resp, req)
+	})
+}
+
+const (
+	acmeStatusPending     = "pending"
+	acmeStatusReady       = "ready"
+	acmeStatusProcessing  = "processing"
+	acmeStatusValid       = "valid"
+	acmeStatusInvalid     = "invalid"
+	acmeStatusDeactivated = "deactivated"
+)
+
+// acmeLifetime is the time an order and its authorizations stay usable.
+const acmeLifetime = 7 * 24 * time.Hour
+
+// acmeMaxNonces limits the outstanding nonces, older ones are dropped.
+const acmeMaxNonces = 10000
+
+// acmeNonceLifetime is the time a nonce stays usable.
+const acmeNonceLifetime = time.Hour
+
+// acmeAccountLifetime is the time an account without requests and orders is kept.
+const acmeAccountLifetime = 90 * 24 * time.Hour
+
+// acmeCleanupInterval is the minimal time between removals of expired nonces, orders and accounts.
+const acmeCleanupInterval = time.Minute
+
+type acmeServer struct {
+	ca        *CA
+	baseURL   string
+	validity  time.Duration
+	validator ACMEChallengeValidator
+	inventory Inventory
+	auditLog  AuditLog
+	now       func() time.Time
+
+	mux            sync.Mutex
+	nextCleanup    time.Time
+	nonces         map[string]time.Time
+	nonceOrder     []string
+	accounts       map[string]*acmeAccount
+	orders         map[string]*acmeOrder
+	authorizations map[string]*acmeAuthorization
+	challenges     map[string]*acmeChallenge
+	certificates   map[string][]byte
+}
+
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type acmeAccount struct {
+	id         string
+	thumbprint string
+	jwk        ACMEJWK
+	publicKey  crypto.PublicKey
+	status     string
+	contact    []string
+	orderIDs   []string
+	lastUsed   time.Time
+}
+
+type acmeOrder struct {
+	id               string
+	accountID        string
+	status           string
+	expires          time.Time
+	identifiers      []acmeIdentifier
+	authorizationIDs []string
+	certificateID    string
+	problem          *acmeProblem
+}
+
+type acmeAuthorization struct {
+	id           string
+	accountID    string
+	status       string
+	expires      time.Time
+	identifier   acmeIdentifier
+	challengeIDs []string
+}
+
+type acmeChallenge struct {
+	id              string
+	authorizationID string
+	challengeType   string
+	token           string
+	status          string
+	validated       *time.Time
+	problem         *acmeProblem
+}
+
+// acmeRequest is a verified JWS request.
+type acmeRequest struct {
+	header  *ACMEJWSHeader
+	payload []byte
+	// account is set for requests signed with kid
+	account *acmeAccount
+	// jwk is set for requests signed with jwk
+	jwk       *ACMEJWK
+	publicKey crypto.PublicKey
+}
+
+// postAsGet returns true for POST-as-GET requests with empty payload.
+func (a *acmeRequest) postAsGet() bool {
+	return len(a.payload) == 0
+}
+
+func (s *acmeServer) url(parts ...string) string {
+	return s.baseURL + "/" + strings.Join(parts, "/")
+}
+
+func (s *acmeServer) handleDirectory(resp http.ResponseWriter, req *http.Request) {
+	s.writeJSON(resp, http.StatusOK, map[string]interface{}{
+		"newNonce":   s.url("new-nonce"),
+		"newAccount": s.url("new-account"),
+		"newOrder":   s.url("new-order"),
+		"meta": map[string]interface{}{
+			"externalAccountRequired": false,
+		},
+	}, "")
+}
+
+func (s *acmeServer) handleNewNonce(resp http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodHead {
+		resp.WriteHeader(http.StatusOK)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+func (s *acmeServer) handleNewAccount(resp http.ResponseWriter, req *http.Request) {
+	request, problem := s.verifyRequest(req, true)
+	if problem != nil {
+		s.writeProblem(resp, problem)
+		return
+	}
+	var payload struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	}
+	if err := json.Unmarshal(request.payload, &payload); err != nil {
+		s.writeProblem(resp, newACMEProblem(http.StatusBadRequest, "malformed", "invalid payload: %v", err))
+		return
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	thumbprint := request.jwk.Thumbprint()
+	for _, account := range s.accounts {
+		if account.thumbprint == thumbprint {
+			account.lastUsed = s.currentTime()
+			s.writeJSON(resp, http.StatusOK, s.accountView(account), s.url("account", account.id))
+			return
+		}
+	}
+	if payload.OnlyReturnExisting {
+		s.writeProblem(resp, newACMEProblem(http.StatusBadRequest, "accountDoesNotExist", "no account for this key"))
+		return
+	}
+	account := &acmeAccount{
+		id:         newACMEID(),
+		thumbprint: thumbprint,
+		jwk:        *request.jwk,
+		publicKey:  request.publicKey,
+		status:     acmeStatusValid,
+		contact:    payload.Contact,
+		lastUsed:   s.currentTime(),
+	}
+	s.accounts[account.id] = account
+	glog.V(2).Infof("acme account %s registered", account.id)
+	s.writeJSON(resp, http.StatusCreated, s.accountView(account), s.url("account", account.id))
+}
+
+func (s *acmeServer) handleAccount(resp http.ResponseWriter, req *http.Request) {
+	request, problem := s.verifyRequest(req, false)
+	if problem != nil {
+		s.writeProblem(resp, problem)
+		return
+	}
+	if request.account.id != mux.Vars(req)["id"] {
+		s.writeProblem(resp, newACMEProblem(http.StatusForbidden, "unauthorized", "account does not match key"))
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if !request.postAsGet() {
+		var payload struct {
+			Contact []string `json:"contact"`
+			Status  string   `json:"status"`
+		}
+		if err := json.Unmarshal(request.payload, &payload); err != nil {
+			s.writeProblem(resp, newACMEProblem(http.StatusBadRequest, "malformed", "invalid payload: %v", err))
+			return
+		}
+		if payload.Contact != nil {
+			request.account.contact = payload.Contact
+		}
+		switch payload.Status {
+		case "":
+		case acmeStatusDeactivated:
+			request.account.status = acmeStatusDeactivated
+		default:
+			s.writeProblem(resp, newACMEProblem(http.StatusBadRequest, "malformed", "invalid status '%s'", payload.Status))
+			return
+		}
+	}
+	s.writeJSON(resp, http.StatusOK, s.accountView(request.account), s.url("account", request.account.id))
+}
+
+func (s *acmeServer) handleAccountOrders(resp http.ResponseWriter, req *http.Request) {
+	request, problem := s.verifyRequest(req, false)
+	if problem != nil {
+		s.writeProblem(resp, problem)
+		return
+	}
+	if request.account.id != mux.Vars(req)["id"] {
+		s.writeProblem(resp, newACMEProblem(http.StatusForbidden, "unauthorized", "account does not match key"))
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	orders := []string{}
+	for _, id := range request.account.orderIDs {
+		orders = append(orders, s.url("order", id))
+	}
+	s.writeJSON(resp, http.StatusOK, map[string]interface{}{"orders": orders}, "")
+}
+
+func (s *acmeServer) handleNewOrder(resp http.ResponseWriter, req *http.Request) {
+	request, problem := s.verifyRequest(req, false)
+	if problem != nil {
+		s.writeProblem(resp, problem)
+		return
+	}
+	var payload struct {
+		Identifiers []acmeIdentifier `json:"identifiers"`
+	}
+	if err := json.Unmarshal(request.payload, &payload); err != nil {
+		s.writeProblem(resp, newACMEProblem(http.StatusBadRequest, "malformed", "invalid payload: %v", err))
+		return
+	}
+	if len(payload.Identifiers) == 0 {
+		s.writeProblem(resp, newACMEProblem(http.StatusBadRequest, "malformed", "identifiers missing"))
+		return
+	}
+	for _, identifier := range payload.Identifiers {
+		if identifier.Type != "dns" {
+			s.writeProblem(resp, newACMEProblem(http.StatusBadRequest, "unsupportedIdentifier", "identifier type '%s' not supported", identifier.Type))
+			return
+		}
+		if !validDNSName(identifier.Value) {
+			s.writeProblem(resp, newACMEProblem(http.StatusBadRequest, "rejectedIdentifier", "invalid dns name '%s'", identifier.Value))
+			return
+		}
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	order := &acmeOrder{
+		id:        newACMEID(),
+		accountID: request.account.id,
+		status:    acmeStatusPending,
+		expires:   s.currentTime().Add(acmeLifetime),
+	}
+	seen := make(map[string]bool)
+	for _, identifier := range payload.Identifiers {
+		identifier.Value = strings.ToLower(identifier.Value)
+		if seen[identifier.Value] {
+			continue
+		}
+		seen[identifier.Value] = true
+		order.identifiers = append(order.identifiers, identifier)
+		authorization := &acmeAuthorization{
+			id:         newACMEID(),
+			accountID:  request.account.id,
+			status:     acmeStatusPending,
+			expires:    order.expires,
+			identifier: identifier,
+		}
+		for _, challengeType := range []string{ACMEChallengeHTTP01, ACMEChallengeTLSALPN01} {
+			challenge := &acmeChallenge{
+				id:              newACMEID(),
+				authorizationID: authorization.id,
+				challengeType:   challengeType,
+				token:           newACMEToken(),
+				status:          acmeStatusPending,
+			}
+			s.challenges[challenge.id] = challenge
+			authorization.challengeIDs = append(authorization.challengeIDs, challenge.id)
+		}
+		s.authorizations[authorization.id] = authorization
+		order.authorizationIDs = append(order.authorizationIDs, authorization.id)
+	}
+	s.orders[order.id] = order
+	request.account.orderIDs = append(request.account.orderIDs, order.id)
+	s.writeJSON(resp, http.StatusCreated, s.orderView(order), s.url("order", order.id))
+}
+
+func (s *acmeServer) handleOrder(resp http.ResponseWriter, req *http.Request) {
+	request, problem := s.verifyRequest(req, false)
+	if problem != nil {
+		s.writeProblem(resp, problem)
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	order, problem := s.findOrder(request, mux.Vars(req)["id"])
+	if problem != nil {
+		s.writeProblem(resp, problem)
+		return
+	}
+	s.writeJSON(resp, http.StatusOK, s.orderView(order), s.url("order", order.id))
+}
+
+func (s *acmeServer) handleAuthorization(resp http.ResponseWriter, req *http.Request) {
+	request, problem := s.verifyRequest(req, false)
+	if problem != nil {
+		s.writeProblem(resp, problem)
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	authorization, ok := s.authorizations[mux.Vars(req)["id"]]
+	if !ok || authorization.accountID != request.account.id {
+		s.writeProblem(resp, newACMEProblem(http.StatusNotFound, "malformed", "authorization not found"))
+		return
+	}
+	if !request.postAsGet() {
+		var payload struct {
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal(request.payload, &payload); err != nil || payload.Status != acmeStatusDeactivated {
+			s.writeProblem(resp, newACMEProblem(http.StatusBadRequest, "malformed", "only deactivation is supported"))
+			return
+		}
+		authorization.status = acmeStatusDeactivated
+	}
+	s.writeJSON(resp, http.StatusOK, s.authorizationView(authorization), "")
+}
+
+func (s *acmeServer) handleChallenge(resp http.ResponseWriter, req *http.Request) {
+	request, problem := s.verifyRequest(req, false)
+	if problem != nil {
+		s.writeProblem(resp, problem)
+		return
+	}
+	s.mux.Lock()
+	challenge, ok := s.challenges[mux.Vars(req)["id"]]
+	var authorization *acmeAuthorization
+	if ok {
+		authorization = s.authorizations[challenge.authorizationID]
+	}
+	if !ok || authorization.accountID != request.account.id {
+		s.mux.Unlock()
+		s.writeProblem(resp, newACMEProblem(http.StatusNotFound, "malformed", "challenge not found"))
+		return
+	}
+	resp.Header().Add("Link", fmt.Sprintf("<%s>;rel=\"up\"", s.url("authz", authorization.id)))
+	if request.postAsGet() || challenge.status != acmeStatusPending || authorization.status != acmeStatusPending {
+		defer s.mux.Unlock()
+		s.writeJSON(resp, http.StatusOK, s.challengeView(challenge), "")
+		return
+	}
+	challenge.status = acmeStatusProcessing
+	domain := authorization.identifier.Value
+	challengeType := challenge.challengeType
+	keyAuthorization := ACMEKeyAuthorization(challenge.token, request.account.jwk)
+	token := challenge.token
+	s.mux.Unlock()
+
+	// validate without holding the lock, it connects back to the applicant
+	err := s.validator.Validate(req.Context(), challengeType, domain, token, keyAuthorization)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err != nil {
+		glog.V(2).Infof("acme challenge %s for %s failed: %v", challengeType, domain, err)
+		challenge.status = acmeStatusInvalid
+		challenge.problem = newACMEProblem(http.StatusForbidden, acmeChallengeProblemType(challengeType), "%v", err)
+		authorization.status = acmeStatusInvalid
+	} else {
+		glog.V(2).Infof("acme challenge %s for %s passed", challengeType, domain)
+		now := s.currentTime()
+		challenge.status = acmeStatusValid
+		challenge.validated = &now
+		authorization.status = acmeStatusValid
+	}
+	s.writeJSON(resp, http.StatusOK, s.challengeView(challenge), "")
+}
+
+func (s *acmeServer) handleFinalize(resp http.ResponseWriter, req *http.Request) {
+	request, problem := s.verifyRequest(req, false)
+	if problem != nil {
+		s.writeProblem(resp, problem)
+		return
+	}
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	if err := json.Unmarshal(request.payload, &payload); err != nil {
+		s.writeProblem(resp, newACMEProblem(http.StatusBadRequest, "malformed", "invalid payload: %v", err))
+		return
+	}
+	der, err := base64.RawURLEncoding.DecodeString(payload.CSR)
+	if err != nil {
+		s.writeProblem(resp, newACMEProblem(http.StatusBadRequest, "badCSR", "decode csr failed: %v", err))
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		s.writeProblem(resp, newACMEProblem(http.StatusBadRequest, "badCSR", "parse csr failed: %v", err))
+		return
+	}
+	if err := csr.CheckSignature(); err != nil {
+		s.writeProblem(resp, newACMEProblem(http.StatusBadRequest, "badCSR", "invalid csr signature: %v", err))
+		return
+	}
+	// RFC 8555 section 11.1, the account key must not be used for certificates
+	if publicKey, ok := csr.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); ok && publicKey.Equal(request.publicKey) {
+		s.writeProblem(resp, newACMEProblem(http.StatusBadRequest, "badCSR", "csr uses the account key"))
+		return
+	}
+
+	s.mux.Lock()
+	order, problem := s.findOrder(request, mux.Vars(req)["id"])
+	if problem != nil {
+		s.mux.Unlock()
+		s.writeProblem(resp, problem)
+		return
+	}
+	if order.status != acmeStatusReady {
+		s.mux.Unlock()
+		s.writeProblem(resp, newACMEProblem(http.StatusForbidden, "orderNotReady", "order is %s", order.status))
+		return
+	}
+	if problem := checkACMECSR(csr, order.identifiers); problem != nil {
+		s.mux.Unlock()
+		s.writeProblem(resp, problem)
+		return
+	}
+	order.status = acmeStatusProcessing
+	dnsNames := make([]string, 0, len(order.identifiers))
+	for _, identifier := range order.identifiers {
+		dnsNames = append(dnsNames, identifier.Value)
+	}
+	requester := "acme:" + order.accountID
+	s.mux.Unlock()
+
+	// sign and record without holding the lock, the signer and the files may be slow
+	cert, err := SignCertificate(req.Context(), s.ca, IssueRequest{
+		Profile:    ProfileServer,
+		CommonName: dnsNames[0],
+		DNSNames:   dnsNames,
+		Validity:   s.validity,
+	}, csr.PublicKey)
+	if rejection := rejectionOf(err); rejection != nil {
+		s.failOrder(resp, order, newACMEProblem(http.StatusForbidden, "rejectedIdentifier", "%s", rejection.Error()))
+		return
+	}
+	if err != nil {
+		glog.Warningf("acme sign certificate failed: %v", err)
+		s.failOrder(resp, order, newACMEProblem(http.StatusInternalServerError, "serverInternal", "sign certificate failed"))
+		return
+	}
+	recorder := IssuanceRecorder{Inventory: s.inventory, AuditLog: s.auditLog, Requester: requester}
+	if err := recorder.Record(req.Context(), AuditOperationIssue, cert, ProfileServer, "", ""); err != nil {
+		glog.Warningf("acme record certificate failed: %v", err)
+		s.failOrder(resp, order, newACMEProblem(http.StatusInternalServerError, "serverInternal", "record certificate failed"))
+		return
+	}
+	chain := (&KeyPair{Certificate: cert}).ChainPEM(s.ca.Intermediates()...)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	order.certificateID = newACMEID()
+	order.status = acmeStatusValid
+	s.certificates[order.certificateID] = chain
+	glog.V(2).Infof("acme certificate %s issued for %v", FormatHex(cert.SerialNumber.Bytes()), dnsNames)
+	s.writeJSON(resp, http.StatusOK, s.orderView(order), s.url("order", order.id))
+}
+
+// failOrder marks order invalid with problem and writes problem.
+func (s *acmeServer) failOrder(resp http.ResponseWriter, order *acmeOrder, problem *acmeProblem) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	order.status = acmeStatusInvalid
+	order.problem = problem
+	s.writeProblem(resp, problem)
+}
+
+func (s *acmeServer) handleCertificate(resp http.ResponseWriter, req *http.Request) {
+	request, problem := s.verifyRequest(req, false)
+	if problem != nil {
+		s.writeProblem(resp, problem)
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	id := mux.Vars(req)["id"]
+	chain, ok := s.certificates[id]
+	if ok {
+		ok = false
+		for _, orderID := range request.account.orderIDs {
+			if s.orders[orderID].certificateID == id {
+				ok = true
+			}
+		}
+	}
+	if !ok {
+		s.writeProblem(resp, newACMEProblem(http.StatusNotFound, "malformed", "certificate not found"))
+		return
+	}
+	resp.Header().Set("Content-Type", "application/pem-certificate-chain")
+	resp.WriteHeader(http.StatusOK)
+	_, _ = resp.Write(chain)
+}
+
+// findOrder returns the order of the requesting account with status updated from its authorizations.
+func (s *acmeServer) findOrder(request *acmeRequest, id string) (*acmeOrder, *acmeProblem) {
+	order, ok := s.orders[id]
+	if !ok || order.accountID != request.account.id {
+		return nil, newACMEProblem(http.StatusNotFound, "malformed", "order not found")
+	}
+	s.updateOrderStatus(order)
+	return order, nil
+}
+
+func (s *acmeServer) updateOrderStatus(order *acmeOrder) {
+	if order.status != acmeStatusPending {
+		return
+	}
+	if s.currentTime().After(order.expires) {
+		order.status = acmeStatusInvalid
+		return
+	}
+	ready := true
+	for _, id := range order.authorizationIDs {
+		switch s.authorizations[id].status {
+		case acmeStatusValid:
+		case acmeStatusPending:
+			ready = false
+		default:
+			order.status = acmeStatusInvalid
+			return
+		}
+	}
+	if ready {
+		order.status = acmeStatusReady
+	}
+}
+
+// verifyRequest checks nonce, url and signature of a JWS request.
+// Requests signed with jwk are only allowed if allowJWK is set, all others must reference a valid account.
+func (s *acmeServer) verifyRequest(req *http.Request, allowJWK bool) (*acmeRequest, *acmeProblem) {
+	ctx := req.Context()
+	body, err := io.ReadAll(io.LimitReader(req.Body, 1<<20))
+	if err != nil {
+		return nil, newACMEProblem(http.StatusBadRequest, "malformed", "read body failed")
+	}
+	jws, header, err := ParseACMEJWS(ctx, body)
+	if err != nil {
+		return nil, newACMEProblem(http.StatusBadRequest, "malformed", "%v", err)
+	}
+	if header.Algorithm == "" || header.Algorithm == "none" || strings.HasPrefix(header.Algorithm, "HS") {
+		return nil, newACMEProblem(http.StatusBadRequest, "badSignatureAlgorithm", "algorithm '%s' not allowed", header.Algorithm)
+	}
+	if !s.consumeNonce(header.Nonce) {
+		return nil, newACMEProblem(http.StatusBadRequest, "badNonce", "invalid nonce")
+	}
+	if header.URL != s.baseURL+req.URL.Path {
+		return nil, newACMEProblem(http.StatusUnauthorized, "unauthorized", "url in protected header does not match request")
+	}
+	request := &acmeRequest{
+		header: header,
+	}
+	if header.JWK != nil {
+		if !allowJWK {
+			return nil, newACMEProblem(http.StatusBadRequest, "malformed", "request must be signed with kid")
+		}
+		request.jwk = header.JWK
+		request.publicKey, err = header.JWK.PublicKey(ctx)
+		if err != nil {
+			return nil, newACMEProblem(http.StatusBadRequest, "badPublicKey", "%v", err)
+		}
+	} else {
+		if allowJWK {
+			return nil, newACMEProblem(http.StatusBadRequest, "malformed", "request must be signed with jwk")
+		}
+		s.mux.Lock()
+		account, ok := s.accounts[strings.TrimPrefix(header.KeyID, s.url("account")+"/")]
+		var status string
+		if ok {
+			status = account.status
+			account.lastUsed = s.currentTime()
+		}
+		s.mux.Unlock()
+		if !ok {
+			return nil, newACMEProblem(http.StatusBadRequest, "accountDoesNotExist", "account '%s' not found", header.KeyID)
+		}
+		if status != acmeStatusValid {
+			return nil, newACMEProblem(http.StatusUnauthorized, "unauthorized", "account is %s", status)
+		}
+		request.account = account
+		request.publicKey = account.publicKey
+	}
+	request.payload, err = jws.Verify(ctx, header.Algorithm, request.publicKey)
+	if err != nil {
+		return nil, newACMEProblem(http.StatusBadRequest, "malformed", "%v", err)
+	}
+	return request, nil
+}
+
+func (s *acmeServer) newNonce() string {
+	nonce := newACMEToken()
+	now := s.currentTime()
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.cleanup(now)
+	s.nonces[nonce] = now
+	s.nonceOrder = append(s.nonceOrder, nonce)
+	if len(s.nonceOrder) > acmeMaxNonces {
+		delete(s.nonces, s.nonceOrder[0])
+		s.nonceOrder = s.nonceOrder[1:]
+	}
+	return nonce
+}
+
+func (s *acmeServer) consumeNonce(nonce string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	issued, ok := s.nonces[nonce]
+	if !ok {
+		return false
+	}
+	delete(s.nonces, nonce)
+	return s.currentTime().Before(issued.Add(acmeNonceLifetime))
+}
+
+// cleanup removes expired nonces, expired orders with their authorizations, challenges and certificates,
+// and accounts without orders unused for acmeAccountLifetime. It runs at most every acmeCleanupInterval.
+// The caller must hold s.mux.
+func (s *acmeServer) cleanup(now time.Time) {
+	if now.Before(s.nextCleanup) {
+		return
+	}
+	s.nextCleanup = now.Add(acmeCleanupInterval)
+	for len(s.nonceOrder) > 0 {
+		issued, ok := s.nonces[s.nonceOrder[0]]
+		if ok && now.Before(issued.Add(acmeNonceLifetime)) {
+			break
+		}
+		delete(s.nonces, s.nonceOrder[0])
+		s.nonceOrder = s.nonceOrder[1:]
+	}
+	for id, order := range s.orders {
+		if now.Before(order.expires) {
+			continue
+		}
+		for _, authorizationID := range order.authorizationIDs {
+			if authorization, ok := s.authorizations[authorizationID]; ok {
+				for _, challengeID := range authorization.challengeIDs {
+					delete(s.challenges, challengeID)
+				}
+			}
+			delete(s.authorizations, authorizationID)
+		}
+		delete(s.certificates, order.certificateID)
+		delete(s.orders, id)
+	}
+	for id, account := range s.accounts {
+		orderIDs := account.orderIDs[:0]
+		for _, orderID := range account.orderIDs {
+			if _, ok := s.orders[orderID]; ok {
+				orderIDs = append(orderIDs, orderID)
+			}
+		}
+		account.orderIDs = orderIDs
+		if len(account.orderIDs) == 0 && now.After(account.lastUsed.Add(acmeAccountLifetime)) {
+			delete(s.accounts, id)
+		}
+	}
+}
+
+func (s *acmeServer) currentTime() time.Time {
+	if s.now == nil {
+		return time.Now()
+	}
+	return s.now()
+}
+
+func (s *acmeServer) accountView(account *acmeAccount) interface{} {
+	return map[string]interface{}{
+		"status":  account.status,
+		"contact": account.contact,
+		"orders":  s.url("account", account.id, "orders"),
+	}
+}
+
+func (s *acmeServer) orderView(order *acmeOrder) interface{} {
+	authorizations := []string{}
+	for _, id := range order.authorizationIDs {
+		authorizations = append(authorizations, s.url("authz", id))
+	}
+	view := map[string]interface{}{
+		"status":         order.status,
+		"expires":        order.expires.UTC().Format(time.RFC3339),
+		"identifiers":    order.identifiers,
+		"authorizations": authorizations,
+		"finalize":       s.url("finalize", order.id),
+	}
+	if order.certificateID != "" {
+		view["certificate"] = s.url("cert", order.certificateID)
+	}
+	if order.problem != nil {
+		view["error"] = order.problem
+	}
+	return view
+}
+
+func (s *acmeServer) authorizationView(authorization *acmeAuthorization) interface{} {
+	challenges := []interface{}{}
+	for _, id := range authorization.challengeIDs {
+		challenges = append(challenges, s.challengeView(s.challenges[id]))
+	}
+	return map[string]interface{}{
+		"status":     authorization.status,
+		"expires":    authorization.expires.UTC().Format(time.RFC3339),
+		"identifier": authorization.identifier,
+		"challenges": challenges,
+	}
+}
+
+func (s *acmeServer) challengeView(challenge *acmeChallenge) interface{} {
+	view := map[string]interface{}{
+		"type":   challenge.challengeType,
+		"url":    s.url("challenge", challenge.id),
+		"token":  challenge.token,
+		"status": challenge.status,
+	}
+	if challenge.validated != nil {
+		view["validated"] = challenge.validated.UTC().Format(time.RFC3339)
+	}
+	if challenge.problem != nil {
+		view["error"] = challenge.problem
+	}
+	return view
+}
+
+func (s *acmeServer) writeJSON(resp http.ResponseWriter, status int, value interface{}, location string) {
+	if location != "" {
+		resp.Header().Set("Location", location)
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+	if err := json.NewEncoder(resp).Encode(value); err != nil {
+		glog.Warningf("write acme response failed: %v", err)
+	}
+}
+
+func (s *acmeServer) writeProblem(resp http.ResponseWriter, problem *acmeProblem) {
+	glog.V(3).Infof("acme request failed: %s", problem.Detail)
+	resp.Header().Set("Content-Type", "application/problem+json")
+	resp.WriteHeader(problem.Status)
+	if err := json.NewEncoder(resp).Encode(problem); err != nil {
+		glog.Warningf("write acme problem failed: %v", err)
+	}
+}
+
+// acmeProblem is a problem document (RFC 7807) with an ACME error type.
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func newACMEProblem(status int, errorType string, format string, args ...interface{}) *acmeProblem {
+	return &acmeProblem{
+		Type:   "urn:ietf:params:acme:error:" + errorType,
+		Detail: fmt.Sprintf(format, args...),
+		Status: status,
+	}
+}
+
+func acmeChallengeProblemType(challengeType string) string {
+	if challengeType == ACMEChallengeTLSALPN01 {
+		return "tls"
+	}
+	return "incorrectResponse"
+}
+
+// checkACMECSR returns a problem if the CSR requests other names than the order identifiers.
+func checkACMECSR(csr *x509.CertificateRequest, identifiers []acmeIdentifier) *acmeProblem {
+	if len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return newACMEProblem(http.StatusBadRequest, "badCSR", "csr must only contain dns names")
+	}
+	requested := make(map[string]bool)
+	for _, name := range csr.DNSNames {
+		requested[strings.ToLower(name)] = true
+	}
+	if csr.Subject.CommonName != "" {
+		requested[strings.ToLower(csr.Subject.CommonName)] = true
+	}
+	var names []string
+	for name := range requested {
+		names = append(names, name)
+	}
+	var ordered []string
+	for _, identifier := range identifiers {
+		ordered = append(ordered, identifier.Value)
+	}
+	if !equalStrings(names, ordered) {
+		return newACMEProblem(http.StatusBadRequest, "badCSR", "csr names %v do not match order %v", names, ordered)
+	}
+	return nil
+}
+
+// validDNSName accepts hostnames without wildcard.
+func validDNSName(name string) bool {
+	if name == "" || len(name) > 253 || strings.HasSuffix(name, ".") {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func newACMEID() string {
+	return randomBase64URL(16)
+}
+
+func newACMEToken() string {
+	return randomBase64URL(32)
+}
+
+func randomBase64URL(size int) string {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		panic(fmt.Sprintf("read random failed: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
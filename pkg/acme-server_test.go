@@ -0,0 +1,296 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	stderrors "errors"
+
+	"github.com/bborbe/sample_cert/mocks"
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/acme"
+)
+
+var _ = Describe("ACMEServer", func() {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var ca *pkg.CA
+	var validator pkg.ACMEChallengeValidator
+	var inventory pkg.Inventory
+	var server *httptest.Server
+	var client *acme.Client
+	var certKey *ecdsa.PrivateKey
+	// clockOffset moves the clock of the server
+	var clockOffset atomic.Int64
+	BeforeEach(func() {
+		clockOffset.Store(0)
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		var err error
+		ca, err = pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		inventory = pkg.NewFileInventory(filepath.Join(GinkgoT().TempDir(), pkg.InventoryFile))
+		certKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).To(BeNil())
+	})
+	JustBeforeEach(func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		server = httptest.NewUnstartedServer(pkg.NewACMEServer(ca, pkg.ACMEServerOptions{
+			BaseURL:   "http://" + listener.Addr().String(),
+			Validity:  24 * time.Hour,
+			Validator: validator,
+			Inventory: inventory,
+			Now: func() time.Time {
+				return time.Now().Add(time.Duration(clockOffset.Load()))
+			},
+		}))
+		server.Listener.Close()
+		server.Listener = listener
+		server.Start()
+
+		accountKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).To(BeNil())
+		client = &acme.Client{
+			Key:          accountKey,
+			DirectoryURL: server.URL + "/directory",
+		}
+		_, err = client.Register(ctx, &acme.Account{Contact: []string{"mailto:admin@example.com"}}, acme.AcceptTOS)
+		Expect(err).To(BeNil())
+	})
+	AfterEach(func() {
+		server.Close()
+		cancel()
+	})
+	createCSR := func(names ...string) []byte {
+		csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			Subject:  pkix.Name{CommonName: names[0]},
+			DNSNames: names,
+		}, certKey)
+		Expect(err).To(BeNil())
+		return csr
+	}
+	// solve accepts the challenge of challengeType for every authorization of the order.
+	solve := func(order *acme.Order, challengeType string, prepare func(challenge *acme.Challenge, domain string)) error {
+		for _, authzURL := range order.AuthzURLs {
+			authz, err := client.GetAuthorization(ctx, authzURL)
+			Expect(err).To(BeNil())
+			var challenge *acme.Challenge
+			for _, c := range authz.Challenges {
+				if c.Type == challengeType {
+					challenge = c
+				}
+			}
+			Expect(challenge).NotTo(BeNil())
+			prepare(challenge, authz.Identifier.Value)
+			if _, err := client.Accept(ctx, challenge); err != nil {
+				return err
+			}
+			if _, err := client.WaitAuthorization(ctx, authzURL); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	Context("with real validator", func() {
+		var http01Listener net.Listener
+		var tlsALPN01Listener net.Listener
+		var http01Responses map[string]string
+		var tlsALPN01Certs map[string]*tls.Certificate
+		BeforeEach(func() {
+			var err error
+			http01Responses = map[string]string{}
+			tlsALPN01Certs = map[string]*tls.Certificate{}
+			http01Listener, err = net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).To(BeNil())
+			go func() {
+				_ = http.Serve(http01Listener, http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+					response, ok := http01Responses[strings.TrimPrefix(req.URL.Path, "/.well-known/acme-challenge/")]
+					if !ok {
+						http.NotFound(resp, req)
+						return
+					}
+					_, _ = resp.Write([]byte(response))
+				}))
+			}()
+			tlsALPN01Listener, err = tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+				NextProtos: []string{acme.ALPNProto},
+				GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+					return tlsALPN01Certs[hello.ServerName], nil
+				},
+			})
+			Expect(err).To(BeNil())
+			go func() {
+				for {
+					conn, err := tlsALPN01Listener.Accept()
+					if err != nil {
+						return
+					}
+					_ = conn.(*tls.Conn).Handshake()
+					_ = conn.Close()
+				}
+			}()
+			validator = pkg.NewACMEChallengeValidator(
+				http01Listener.Addr().(*net.TCPAddr).Port,
+				tlsALPN01Listener.Addr().(*net.TCPAddr).Port,
+				5*time.Second,
+			)
+		})
+		AfterEach(func() {
+			http01Listener.Close()
+			tlsALPN01Listener.Close()
+		})
+		issue := func(challengeType string, prepare func(challenge *acme.Challenge, domain string)) {
+			order, err := client.AuthorizeOrder(ctx, acme.DomainIDs("localhost"))
+			Expect(err).To(BeNil())
+			Expect(order.Status).To(Equal(acme.StatusPending))
+			Expect(solve(order, challengeType, prepare)).To(Succeed())
+			order, err = client.WaitOrder(ctx, order.URI)
+			Expect(err).To(BeNil())
+			Expect(order.Status).To(Equal(acme.StatusReady))
+
+			der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, createCSR("localhost"), true)
+			Expect(err).To(BeNil())
+			Expect(der).To(HaveLen(1))
+			cert, err := x509.ParseCertificate(der[0])
+			Expect(err).To(BeNil())
+			Expect(cert.DNSNames).To(Equal([]string{"localhost"}))
+			_, err = pkg.VerifyCertificate(ctx, pkg.VerifyRequest{
+				Leaf:     cert,
+				Roots:    []*x509.Certificate{ca.Certificate},
+				Purpose:  pkg.ProfileServer,
+				Hostname: "localhost",
+			})
+			Expect(err).To(BeNil())
+			Expect(pkg.CheckKeyPair(ctx, cert, certKey)).To(Succeed())
+
+			entries, err := inventory.List(ctx)
+			Expect(err).To(BeNil())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].SerialNumber).To(Equal(pkg.FormatHex(cert.SerialNumber.Bytes())))
+		}
+		It("issues certificate with http-01", func() {
+			issue("http-01", func(challenge *acme.Challenge, domain string) {
+				response, err := client.HTTP01ChallengeResponse(challenge.Token)
+				Expect(err).To(BeNil())
+				http01Responses[challenge.Token] = response
+			})
+		})
+		It("issues certificate with tls-alpn-01", func() {
+			issue("tls-alpn-01", func(challenge *acme.Challenge, domain string) {
+				cert, err := client.TLSALPN01ChallengeCert(challenge.Token, domain)
+				Expect(err).To(BeNil())
+				tlsALPN01Certs[domain] = &cert
+			})
+		})
+		It("rejects wrong key authorization", func() {
+			order, err := client.AuthorizeOrder(ctx, acme.DomainIDs("localhost"))
+			Expect(err).To(BeNil())
+			err = solve(order, "http-01", func(challenge *acme.Challenge, domain string) {
+				http01Responses[challenge.Token] = challenge.Token + ".wrong"
+			})
+			Expect(err).NotTo(BeNil())
+			order, err = client.GetOrder(ctx, order.URI)
+			Expect(err).To(BeNil())
+			Expect(order.Status).To(Equal(acme.StatusInvalid))
+		})
+	})
+	Context("with fake validator", func() {
+		var fakeValidator *mocks.ACMEChallengeValidator
+		BeforeEach(func() {
+			fakeValidator = &mocks.ACMEChallengeValidator{}
+			validator = fakeValidator
+		})
+		It("passes key authorization of the account to validator", func() {
+			order, err := client.AuthorizeOrder(ctx, acme.DomainIDs("a.example.com"))
+			Expect(err).To(BeNil())
+			var token string
+			Expect(solve(order, "http-01", func(challenge *acme.Challenge, domain string) {
+				token = challenge.Token
+			})).To(Succeed())
+			Expect(fakeValidator.ValidateCallCount()).To(Equal(1))
+			_, challengeType, domain, argToken, keyAuthorization := fakeValidator.ValidateArgsForCall(0)
+			Expect(challengeType).To(Equal("http-01"))
+			Expect(domain).To(Equal("a.example.com"))
+			Expect(argToken).To(Equal(token))
+			expected, err := client.HTTP01ChallengeResponse(token)
+			Expect(err).To(BeNil())
+			Expect(keyAuthorization).To(Equal(expected))
+		})
+		It("rejects finalize before authorization", func() {
+			order, err := client.AuthorizeOrder(ctx, acme.DomainIDs("a.example.com"))
+			Expect(err).To(BeNil())
+			_, _, err = client.CreateOrderCert(ctx, order.FinalizeURL, createCSR("a.example.com"), true)
+			Expect(err).NotTo(BeNil())
+			var acmeErr *acme.Error
+			Expect(stderrors.As(err, &acmeErr)).To(BeTrue())
+			Expect(acmeErr.ProblemType).To(Equal("urn:ietf:params:acme:error:orderNotReady"))
+		})
+		It("rejects csr with other names than the order", func() {
+			order, err := client.AuthorizeOrder(ctx, acme.DomainIDs("a.example.com"))
+			Expect(err).To(BeNil())
+			Expect(solve(order, "tls-alpn-01", func(challenge *acme.Challenge, domain string) {})).To(Succeed())
+			_, _, err = client.CreateOrderCert(ctx, order.FinalizeURL, createCSR("a.example.com", "b.example.com"), true)
+			Expect(err).NotTo(BeNil())
+			var acmeErr *acme.Error
+			Expect(stderrors.As(err, &acmeErr)).To(BeTrue())
+			Expect(acmeErr.ProblemType).To(Equal("urn:ietf:params:acme:error:badCSR"))
+		})
+		It("rejects csr with the account key", func() {
+			order, err := client.AuthorizeOrder(ctx, acme.DomainIDs("a.example.com"))
+			Expect(err).To(BeNil())
+			Expect(solve(order, "http-01", func(challenge *acme.Challenge, domain string) {})).To(Succeed())
+			csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+				DNSNames: []string{"a.example.com"},
+			}, client.Key)
+			Expect(err).To(BeNil())
+			_, _, err = client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+			Expect(err).NotTo(BeNil())
+			var acmeErr *acme.Error
+			Expect(stderrors.As(err, &acmeErr)).To(BeTrue())
+			Expect(acmeErr.ProblemType).To(Equal("urn:ietf:params:acme:error:badCSR"))
+		})
+		It("removes expired orders", func() {
+			order, err := client.AuthorizeOrder(ctx, acme.DomainIDs("a.example.com"))
+			Expect(err).To(BeNil())
+			_, err = client.GetOrder(ctx, order.URI)
+			Expect(err).To(BeNil())
+			clockOffset.Store(int64(8 * 24 * time.Hour))
+			_, err = client.GetOrder(ctx, order.URI)
+			Expect(err).NotTo(BeNil())
+			var acmeErr *acme.Error
+			Expect(stderrors.As(err, &acmeErr)).To(BeTrue())
+			Expect(acmeErr.StatusCode).To(Equal(http.StatusNotFound))
+			_, err = client.GetAuthorization(ctx, order.AuthzURLs[0])
+			Expect(err).NotTo(BeNil())
+		})
+		It("rejects wildcard identifiers", func() {
+			_, err := client.AuthorizeOrder(ctx, acme.DomainIDs("*.example.com"))
+			Expect(err).NotTo(BeNil())
+		})
+		It("rejects replayed nonce", func() {
+			resp, err := http.Post(server.URL+"/new-order", "application/jose+json", strings.NewReader(`{"protected":"eyJhbGciOiJFUzI1NiIsImtpZCI6IngiLCJub25jZSI6Im5vbmUiLCJ1cmwiOiJ4In0","payload":"","signature":""}`))
+			Expect(err).To(BeNil())
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(resp.Header.Get("Replay-Nonce")).NotTo(BeEmpty())
+		})
+	})
+})
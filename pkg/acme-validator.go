@@ -0,0 +1,138 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/asn1"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bborbe/errors"
+)
+
+// Supported ACME challenge types.
+const (
+	ACMEChallengeHTTP01    = "http-01"
+	ACMEChallengeTLSALPN01 = "tls-alpn-01"
+)
+
+// acmeTLSALPNProtocol is the ALPN protocol used by tls-alpn-01 (RFC 8737).
+const acmeTLSALPNProtocol = "acme-tls/1"
+
+// oidACMEIdentifier is the id-pe-acmeIdentifier certificate extension (RFC 8737).
+var oidACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+//counterfeiter:generate -o ../mocks/acme-challenge-validator.go --fake-name ACMEChallengeValidator . ACMEChallengeValidator
+
+// ACMEChallengeValidator checks that the applicant controls domain.
+type ACMEChallengeValidator interface {
+	Validate(ctx context.Context, challengeType string, domain string, token string, keyAuthorization string) error
+}
+
+// NewACMEChallengeValidator returns a validator connecting to domain on the given ports,
+// usually 80 for http-01 and 443 for tls-alpn-01.
+func NewACMEChallengeValidator(http01Port int, tlsALPN01Port int, timeout time.Duration) ACMEChallengeValidator {
+	return &acmeChallengeValidator{
+		http01Port:    http01Port,
+		tlsALPN01Port: tlsALPN01Port,
+		timeout:       timeout,
+	}
+}
+
+type acmeChallengeValidator struct {
+	http01Port    int
+	tlsALPN01Port int
+	timeout       time.Duration
+}
+
+func (a *acmeChallengeValidator) Validate(ctx context.Context, challengeType string, domain string, token string, keyAuthorization string) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+	switch challengeType {
+	case ACMEChallengeHTTP01:
+		return a.validateHTTP01(ctx, domain, token, keyAuthorization)
+	case ACMEChallengeTLSALPN01:
+		return a.validateTLSALPN01(ctx, domain, keyAuthorization)
+	default:
+		return errors.Errorf(ctx, "unsupported challenge type '%s'", challengeType)
+	}
+}
+
+func (a *acmeChallengeValidator) validateHTTP01(ctx context.Context, domain string, token string, keyAuthorization string) error {
+	url := "http://" + net.JoinHostPort(domain, strconv.Itoa(a.http01Port)) + "/.well-known/acme-challenge/" + token
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "create request failed")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "get %s failed", url)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf(ctx, "get %s returned status %d", url, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return errors.Wrapf(ctx, err, "read %s failed", url)
+	}
+	if string(bytes.TrimSpace(body)) != keyAuthorization {
+		return errors.Errorf(ctx, "key authorization of %s does not match", url)
+	}
+	return nil
+}
+
+func (a *acmeChallengeValidator) validateTLSALPN01(ctx context.Context, domain string, keyAuthorization string) error {
+	address := net.JoinHostPort(domain, strconv.Itoa(a.tlsALPN01Port))
+	dialer := &tls.Dialer{
+		Config: &tls.Config{
+			ServerName: domain,
+			NextProtos: []string{acmeTLSALPNProtocol},
+			// the presented certificate is self-signed, it is checked below
+			InsecureSkipVerify: true,
+		},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "connect to %s failed", address)
+	}
+	defer conn.Close()
+	state := conn.(*tls.Conn).ConnectionState()
+	if state.NegotiatedProtocol != acmeTLSALPNProtocol {
+		return errors.Errorf(ctx, "%s did not negotiate %s", address, acmeTLSALPNProtocol)
+	}
+	if len(state.PeerCertificates) == 0 {
+		return errors.Errorf(ctx, "%s presented no certificate", address)
+	}
+	cert := state.PeerCertificates[0]
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != domain || len(cert.IPAddresses) > 0 || len(cert.EmailAddresses) > 0 || len(cert.URIs) > 0 {
+		return errors.Errorf(ctx, "certificate of %s must only contain %s", address, domain)
+	}
+	expected := sha256.Sum256([]byte(keyAuthorization))
+	for _, extension := range cert.Extensions {
+		if !extension.Id.Equal(oidACMEIdentifier) {
+			continue
+		}
+		if !extension.Critical {
+			return errors.Errorf(ctx, "acmeIdentifier extension of %s is not critical", address)
+		}
+		var value []byte
+		if _, err := asn1.Unmarshal(extension.Value, &value); err != nil {
+			return errors.Wrapf(ctx, err, "parse acmeIdentifier extension failed")
+		}
+		if !bytes.Equal(value, expected[:]) {
+			return errors.Errorf(ctx, "acmeIdentifier of %s does not match", address)
+		}
+		return nil
+	}
+	return errors.Errorf(ctx, "certificate of %s has no acmeIdentifier extension", address)
+}
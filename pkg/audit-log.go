@@ -0,0 +1,274 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bborbe/errors"
+	"github.com/golang/glog"
+)
+
+// AuditLogFile is the name of the audit log in the DataDir.
+const AuditLogFile = "audit.jsonl"
+
+// AuditOperation is the kind of CA operation recorded in the audit log.
+type AuditOperation string
+
+const (
+	AuditOperationCreateCA  AuditOperation = "create-ca"
+	AuditOperationIssue     AuditOperation = "issue"
+	AuditOperationSign      AuditOperation = "sign"
+	AuditOperationRevoke    AuditOperation = "revoke"
+	AuditOperationRenew     AuditOperation = "renew"
+	AuditOperationCrossSign AuditOperation = "cross-sign"
+)
+
+// auditTailSize is the part of the log read first to find the last entry, entries are far smaller.
+const auditTailSize = 4096
+
+// auditGenesisHash is the previous hash of the first entry.
+var auditGenesisHash = strings.Repeat("0", sha256.Size*2)
+
+// AuditEntry is one line of the audit log.
+// Hash is the SHA-256 of the entry without hash, which includes the hash of the previous entry.
+type AuditEntry struct {
+	Sequence     int64          `json:"seq"`
+	Timestamp    time.Time      `json:"timestamp"`
+	Operation    AuditOperation `json:"operation"`
+	Requester    string         `json:"requester"`
+	Subject      string         `json:"subject,omitempty"`
+	SerialNumber string         `json:"serialNumber,omitempty"`
+	Profile      Profile        `json:"profile,omitempty"`
+	PrevHash     string         `json:"prevHash"`
+	Hash         string         `json:"hash,omitempty"`
+}
+
+// NewAuditEntry returns the entry for an operation on cert, profile may be empty.
+func NewAuditEntry(operation AuditOperation, requester string, cert *x509.Certificate, profile Profile) AuditEntry {
+	return AuditEntry{
+		Operation:    operation,
+		Requester:    requester,
+		Subject:      cert.Subject.String(),
+		SerialNumber: FormatHex(cert.SerialNumber.Bytes()),
+		Profile:      profile,
+	}
+}
+
+// NewAuditEntryFromInventory returns the entry for an operation on an inventory entry.
+func NewAuditEntryFromInventory(operation AuditOperation, requester string, entry InventoryEntry) AuditEntry {
+	return AuditEntry{
+		Operation:    operation,
+		Requester:    requester,
+		Subject:      entry.Subject,
+		SerialNumber: entry.SerialNumber,
+		Profile:      entry.Profile,
+	}
+}
+
+// computeHash returns the hash of the entry without its hash field.
+func (a AuditEntry) computeHash() (string, error) {
+	a.Hash = ""
+	data, err := json.Marshal(a)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// LocalRequester identifies the user running a command as user@host.
+func LocalRequester() string {
+	name := "unknown"
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return name
+	}
+	return name + "@" + host
+}
+
+// AuditLog returns the file AuditLog of the DataDir.
+func (d DataDir) AuditLog(ctx context.Context) (AuditLog, error) {
+	path, err := d.Path(ctx, AuditLogFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewFileAuditLog(path), nil
+}
+
+//counterfeiter:generate -o ../mocks/audit-log.go --fake-name AuditLog . AuditLog
+
+// AuditLog is an append-only record of CA operations.
+type AuditLog interface {
+	// Append sets sequence, timestamp and hashes of entry and appends it.
+	Append(ctx context.Context, entry AuditEntry) (*AuditEntry, error)
+}
+
+// NewFileAuditLog returns an AuditLog appending JSON lines to path.
+// The file is locked while appending, so several processes can share it.
+func NewFileAuditLog(path string) AuditLog {
+	return &fileAuditLog{
+		path: path,
+	}
+}
+
+type fileAuditLog struct {
+	path string
+	mux  sync.Mutex
+}
+
+func (f *fileAuditLog) Append(ctx context.Context, entry AuditEntry) (*AuditEntry, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	file, err := os.OpenFile(f.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, CertificateFileMode)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "open %s failed", f.path)
+	}
+	defer file.Close()
+	unlock, err := lockFile(ctx, file, true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	last, err := lastAuditEntry(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	entry.Sequence = 1
+	entry.PrevHash = auditGenesisHash
+	if last != nil {
+		entry.Sequence = last.Sequence + 1
+		entry.PrevHash = last.Hash
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now().UTC()
+	}
+	if entry.Hash, err = entry.computeHash(); err != nil {
+		return nil, errors.Wrapf(ctx, err, "compute hash failed")
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "marshal audit entry failed")
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return nil, errors.Wrapf(ctx, err, "write %s failed", f.path)
+	}
+	if err := file.Sync(); err != nil {
+		return nil, errors.Wrapf(ctx, err, "sync %s failed", f.path)
+	}
+	return &entry, nil
+}
+
+// lastAuditEntry reads the last entry from the end of the log.
+// A last line without line feed is the rest of an append interrupted by a crash, that operation failed
+// and the line is truncated. Any other unreadable last entry requires a repair of the log.
+func lastAuditEntry(ctx context.Context, file *os.File) (*AuditEntry, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "stat audit log failed")
+	}
+	size := info.Size()
+	for window := int64(auditTailSize); ; window *= 2 {
+		offset := max(size-window, 0)
+		tail := make([]byte, size-offset)
+		if _, err := file.ReadAt(tail, offset); err != nil {
+			return nil, errors.Wrapf(ctx, err, "read audit log failed")
+		}
+		if end := bytes.LastIndexByte(tail, '\n') + 1; end < len(tail) && (end > 0 || offset == 0) {
+			glog.Warningf("truncate incomplete last line of audit log %s: %q", file.Name(), tail[end:])
+			if err := file.Truncate(offset + int64(end)); err != nil {
+				return nil, errors.Wrapf(ctx, err, "truncate incomplete last line of audit log failed")
+			}
+			size = offset + int64(end)
+			tail = tail[:end]
+		}
+		tail = bytes.TrimSpace(tail)
+		start := bytes.LastIndexByte(tail, '\n') + 1
+		if start == 0 && offset > 0 {
+			// the last line is longer than the window
+			continue
+		}
+		if len(tail) == 0 {
+			return nil, nil
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(tail[start:], &entry); err != nil {
+			return nil, errors.Wrapf(ctx, err, "last entry of audit log %s is invalid, repair the audit log and check it with certctl audit verify", file.Name())
+		}
+		return &entry, nil
+	}
+}
+
+func newAuditScanner(file *os.File) *bufio.Scanner {
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return scanner
+}
+
+// VerifyAuditLog checks sequence numbers and the hash chain of all entries
+// and returns the last entry, nil for an empty log.
+// Removing entries from the end can only be detected by comparing the last hash with a copy kept elsewhere.
+func VerifyAuditLog(ctx context.Context, path string) (*AuditEntry, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, errors.Wrapf(ctx, err, "open %s failed", path)
+	}
+	defer file.Close()
+
+	var last *AuditEntry
+	count := 0
+	lineNumber := 0
+	scanner := newAuditScanner(file)
+	for scanner.Scan() {
+		lineNumber++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return last, count, errors.Wrapf(ctx, err, "line %d: invalid json", lineNumber)
+		}
+		expectedSequence, expectedPrevHash := int64(1), auditGenesisHash
+		if last != nil {
+			expectedSequence, expectedPrevHash = last.Sequence+1, last.Hash
+		}
+		if entry.Sequence != expectedSequence {
+			return last, count, errors.Errorf(ctx, "line %d: sequence %d, expected %d", lineNumber, entry.Sequence, expectedSequence)
+		}
+		if entry.PrevHash != expectedPrevHash {
+			return last, count, errors.Errorf(ctx, "line %d: previous hash does not match entry %d", lineNumber, expectedSequence-1)
+		}
+		hash, err := entry.computeHash()
+		if err != nil {
+			return last, count, errors.Wrapf(ctx, err, "line %d: compute hash failed", lineNumber)
+		}
+		if entry.Hash != hash {
+			return last, count, errors.Errorf(ctx, "line %d: hash mismatch, entry was modified", lineNumber)
+		}
+		last = &entry
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return last, count, errors.Wrapf(ctx, err, "read %s failed", path)
+	}
+	return last, count, nil
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AuditLog", func() {
+	var ctx context.Context
+	var path string
+	var auditLog pkg.AuditLog
+	var keyPair *pkg.KeyPair
+	BeforeEach(func() {
+		ctx = context.Background()
+		path = filepath.Join(GinkgoT().TempDir(), pkg.AuditLogFile)
+		auditLog = pkg.NewFileAuditLog(path)
+		ca, err := pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		keyPair, err = pkg.IssueCertificate(ctx, ca, pkg.DefaultClientIssueRequest())
+		Expect(err).To(BeNil())
+	})
+	appendEntries := func(count int) {
+		for i := 0; i < count; i++ {
+			_, err := auditLog.Append(ctx, pkg.NewAuditEntry(pkg.AuditOperationIssue, "alice@host", keyPair.Certificate, pkg.ProfileClient))
+			Expect(err).To(BeNil())
+		}
+	}
+	It("chains entries", func() {
+		first, err := auditLog.Append(ctx, pkg.NewAuditEntry(pkg.AuditOperationIssue, "alice@host", keyPair.Certificate, pkg.ProfileClient))
+		Expect(err).To(BeNil())
+		Expect(first.Sequence).To(Equal(int64(1)))
+		Expect(first.PrevHash).To(Equal(strings.Repeat("0", 64)))
+		Expect(first.SerialNumber).To(Equal(pkg.FormatHex(keyPair.Certificate.SerialNumber.Bytes())))
+		Expect(first.Timestamp.IsZero()).To(BeFalse())
+
+		second, err := pkg.NewFileAuditLog(path).Append(ctx, pkg.AuditEntry{Operation: pkg.AuditOperationRevoke, Requester: "bob@host"})
+		Expect(err).To(BeNil())
+		Expect(second.Sequence).To(Equal(int64(2)))
+		Expect(second.PrevHash).To(Equal(first.Hash))
+
+		last, count, err := pkg.VerifyAuditLog(ctx, path)
+		Expect(err).To(BeNil())
+		Expect(count).To(Equal(2))
+		Expect(last.Hash).To(Equal(second.Hash))
+	})
+	It("keeps chain with concurrent appends", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer GinkgoRecover()
+				_, err := pkg.NewFileAuditLog(path).Append(ctx, pkg.AuditEntry{Operation: pkg.AuditOperationIssue, Requester: "x"})
+				Expect(err).To(BeNil())
+			}()
+		}
+		wg.Wait()
+		_, count, err := pkg.VerifyAuditLog(ctx, path)
+		Expect(err).To(BeNil())
+		Expect(count).To(Equal(10))
+	})
+	It("continues the chain beyond the tail window", func() {
+		appendEntries(50)
+		last, count, err := pkg.VerifyAuditLog(ctx, path)
+		Expect(err).To(BeNil())
+		Expect(count).To(Equal(50))
+		Expect(last.Sequence).To(Equal(int64(50)))
+	})
+	It("truncates an incomplete last line left by a crash", func() {
+		appendEntries(2)
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		Expect(err).To(BeNil())
+		_, err = file.WriteString(`{"seq":3,"operation":"iss`)
+		Expect(err).To(BeNil())
+		Expect(file.Close()).To(Succeed())
+
+		entry, err := auditLog.Append(ctx, pkg.AuditEntry{Operation: pkg.AuditOperationRevoke, Requester: "bob@host"})
+		Expect(err).To(BeNil())
+		Expect(entry.Sequence).To(Equal(int64(3)))
+		_, count, err := pkg.VerifyAuditLog(ctx, path)
+		Expect(err).To(BeNil())
+		Expect(count).To(Equal(3))
+	})
+	It("refuses to append after an invalid last entry", func() {
+		appendEntries(2)
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		Expect(err).To(BeNil())
+		_, err = file.WriteString("garbage\n")
+		Expect(err).To(BeNil())
+		Expect(file.Close()).To(Succeed())
+
+		_, err = auditLog.Append(ctx, pkg.AuditEntry{Operation: pkg.AuditOperationRevoke, Requester: "bob@host"})
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("repair the audit log"))
+	})
+	Context("tampered", func() {
+		var lines []string
+		BeforeEach(func() {
+			appendEntries(3)
+			data, err := os.ReadFile(path)
+			Expect(err).To(BeNil())
+			lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+			Expect(lines).To(HaveLen(3))
+		})
+		write := func() {
+			Expect(os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600)).To(Succeed())
+		}
+		It("detects modified entry", func() {
+			lines[1] = strings.Replace(lines[1], "alice@host", "mallory@host", 1)
+			write()
+			_, count, err := pkg.VerifyAuditLog(ctx, path)
+			Expect(err).To(MatchError(ContainSubstring("line 2: hash mismatch")))
+			Expect(count).To(Equal(1))
+		})
+		It("detects removed entry", func() {
+			lines = append(lines[:1], lines[2:]...)
+			write()
+			_, _, err := pkg.VerifyAuditLog(ctx, path)
+			Expect(err).To(MatchError(ContainSubstring("line 2")))
+		})
+		It("detects reordered entries", func() {
+			lines[0], lines[1] = lines[1], lines[0]
+			write()
+			_, _, err := pkg.VerifyAuditLog(ctx, path)
+			Expect(err).To(MatchError(ContainSubstring("line 1")))
+		})
+	})
+})
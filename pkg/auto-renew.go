@@ -0,0 +1,143 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"sync/atomic"
+	"time"
+
+	"github.com/bborbe/errors"
+	"github.com/golang/glog"
+)
+
+const (
+	// autoRenewCheckInterval bounds the sleep between checks, so suspended hosts and clock changes are noticed.
+	autoRenewCheckInterval = time.Hour
+	// autoRenewRetryInterval is the wait after a failed renewal.
+	autoRenewRetryInterval = time.Minute
+)
+
+// AutoRenewer serves a certificate from files and renews it with the CA once a fraction of its lifetime has
+// elapsed. The new certificate and key are written to the same files and used for new TLS handshakes.
+type AutoRenewer struct {
+	caStore     CAStore
+	paths       IdentityPaths
+	fraction    float64
+	inventory   Inventory
+	auditLog    AuditLog
+	certificate atomic.Pointer[tls.Certificate]
+}
+
+// NewAutoRenewer loads the certificate of paths and renews it after fraction (between 0 and 1) of its lifetime.
+// Renewals are added to inventory and auditLog if set.
+func NewAutoRenewer(ctx context.Context, caStore CAStore, paths IdentityPaths, fraction float64, inventory Inventory, auditLog AuditLog) (*AutoRenewer, error) {
+	if fraction <= 0 || fraction >= 1 {
+		return nil, errors.Errorf(ctx, "renew fraction %g must be between 0 and 1", fraction)
+	}
+	a := &AutoRenewer{
+		caStore:   caStore,
+		paths:     paths,
+		fraction:  fraction,
+		inventory: inventory,
+		auditLog:  auditLog,
+	}
+	if err := a.load(ctx); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// GetCertificate returns the current certificate, use it as tls.Config.GetCertificate.
+func (a *AutoRenewer) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return a.certificate.Load(), nil
+}
+
+// Certificate returns the current leaf certificate.
+func (a *AutoRenewer) Certificate() *x509.Certificate {
+	return a.certificate.Load().Leaf
+}
+
+// RenewAt returns the time the current certificate is due for renewal.
+func (a *AutoRenewer) RenewAt() time.Time {
+	return renewAt(a.Certificate(), a.fraction)
+}
+
+// Run renews the certificate whenever it is due until ctx is canceled.
+// Failed renewals are retried, the current certificate stays in use meanwhile.
+func (a *AutoRenewer) Run(ctx context.Context) error {
+	for {
+		wait := time.Until(a.RenewAt())
+		if wait <= 0 {
+			if err := a.Renew(ctx); err != nil {
+				glog.Warningf("renew %s failed, retry in %s: %v", a.paths.CertPath, autoRenewRetryInterval, err)
+			} else {
+				glog.V(1).Infof("renewed %s, next renewal at %s", a.paths.CertPath, a.RenewAt().UTC().Format(time.RFC3339))
+			}
+			wait = max(time.Until(a.RenewAt()), autoRenewRetryInterval)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(min(wait, autoRenewCheckInterval)):
+		}
+	}
+}
+
+// Renew issues a new certificate with the names of the current one, writes it and swaps it in.
+// If the files were already renewed by someone else, they are loaded instead.
+func (a *AutoRenewer) Renew(ctx context.Context) error {
+	if err := a.load(ctx); err != nil {
+		return err
+	}
+	if time.Now().Before(a.RenewAt()) {
+		return nil
+	}
+	oldCert := a.Certificate()
+	req, err := NewIssueRequestFromCertificate(ctx, oldCert)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "create issue request failed")
+	}
+	ca, err := a.caStore.Load(ctx)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "load ca failed")
+	}
+	keyPair, err := IssueCertificate(ctx, ca, req)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "issue certificate failed")
+	}
+	recorder := IssuanceRecorder{Inventory: a.inventory, AuditLog: a.auditLog, Requester: LocalRequester()}
+	if err := recorder.Record(ctx, AuditOperationRenew, keyPair.Certificate, req.Profile, a.paths.CertPath, a.paths.KeyPath); err != nil {
+		return err
+	}
+	if err := WriteIdentity(ctx, keyPair, ca, a.paths); err != nil {
+		return errors.Wrapf(ctx, err, "write certificate failed")
+	}
+	glog.V(2).Infof("renewed %s, old serial %s", a.paths.CertPath, FormatHex(oldCert.SerialNumber.Bytes()))
+	return a.load(ctx)
+}
+
+// load reads certificate and key from the files and makes them current.
+func (a *AutoRenewer) load(ctx context.Context) error {
+	certificate, err := tls.LoadX509KeyPair(a.paths.CertPath, a.paths.KeyPath)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "load %s failed", a.paths.CertPath)
+	}
+	if certificate.Leaf == nil {
+		if certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0]); err != nil {
+			return errors.Wrapf(ctx, err, "parse %s failed", a.paths.CertPath)
+		}
+	}
+	a.certificate.Store(&certificate)
+	return nil
+}
+
+// renewAt returns the point after fraction of the lifetime of cert.
+func renewAt(cert *x509.Certificate, fraction float64) time.Time {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotBefore.Add(time.Duration(float64(lifetime) * fraction))
+}
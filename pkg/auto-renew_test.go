@@ -0,0 +1,107 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	stderrors "errors"
+	"path/filepath"
+	"time"
+
+	"github.com/bborbe/sample_cert/mocks"
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AutoRenewer", func() {
+	var ctx context.Context
+	var ca *pkg.CA
+	var paths pkg.IdentityPaths
+	var original *pkg.KeyPair
+	var inventory *mocks.Inventory
+	var auditLog *mocks.AuditLog
+	var err error
+	issue := func() *pkg.KeyPair {
+		keyPair, err := pkg.IssueCertificate(ctx, ca, pkg.IssueRequest{
+			Profile:    pkg.ProfileServer,
+			CommonName: "localhost",
+			DNSNames:   []string{"localhost"},
+			Validity:   time.Hour,
+		})
+		Expect(err).To(BeNil())
+		Expect(pkg.WriteIdentity(ctx, keyPair, ca, paths)).To(BeNil())
+		return keyPair
+	}
+	newAutoRenewer := func(fraction float64) *pkg.AutoRenewer {
+		renewer, err := pkg.NewAutoRenewer(ctx, pkg.NewMemoryCAStore(ca), paths, fraction, inventory, auditLog)
+		Expect(err).To(BeNil())
+		return renewer
+	}
+	BeforeEach(func() {
+		ctx = context.Background()
+		ca, err = pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		dir := GinkgoT().TempDir()
+		paths = pkg.IdentityPaths{
+			CertPath: filepath.Join(dir, pkg.ServerCertFile),
+			KeyPath:  filepath.Join(dir, pkg.ServerKeyFile),
+		}
+		original = issue()
+		inventory = &mocks.Inventory{}
+		auditLog = &mocks.AuditLog{}
+	})
+	It("serves the certificate of the files", func() {
+		renewer := newAutoRenewer(0.5)
+		certificate, err := renewer.GetCertificate(nil)
+		Expect(err).To(BeNil())
+		Expect(certificate.Leaf.Equal(original.Certificate)).To(BeTrue())
+		Expect(renewer.RenewAt()).To(BeTemporally("~", original.Certificate.NotBefore.Add(30*time.Minute), time.Second))
+	})
+	It("rejects invalid fraction", func() {
+		_, err := pkg.NewAutoRenewer(ctx, pkg.NewMemoryCAStore(ca), paths, 1, inventory, auditLog)
+		Expect(err).NotTo(BeNil())
+	})
+	It("does not renew before due", func() {
+		renewer := newAutoRenewer(0.5)
+		Expect(renewer.Renew(ctx)).To(BeNil())
+		Expect(renewer.Certificate().Equal(original.Certificate)).To(BeTrue())
+		Expect(inventory.AddCallCount()).To(Equal(0))
+	})
+	It("renews, writes and swaps the certificate when due", func() {
+		renewer := newAutoRenewer(0.0001)
+		time.Sleep(time.Until(renewer.RenewAt()))
+		Expect(renewer.Renew(ctx)).To(BeNil())
+
+		renewed := renewer.Certificate()
+		Expect(renewed.SerialNumber).NotTo(Equal(original.Certificate.SerialNumber))
+		Expect(renewed.DNSNames).To(Equal([]string{"localhost"}))
+		Expect(renewed.NotAfter.Sub(renewed.NotBefore)).To(Equal(time.Hour))
+		onDisk, err := pkg.LoadCertificate(ctx, paths.CertPath)
+		Expect(err).To(BeNil())
+		Expect(onDisk.Equal(renewed)).To(BeTrue())
+		Expect(inventory.AddCallCount()).To(Equal(1))
+		Expect(auditLog.AppendCallCount()).To(Equal(1))
+		_, entry := auditLog.AppendArgsForCall(0)
+		Expect(entry.Operation).To(Equal(pkg.AuditOperationRenew))
+	})
+	It("keeps the current certificate if the audit log fails", func() {
+		renewer := newAutoRenewer(0.0001)
+		time.Sleep(time.Until(renewer.RenewAt()))
+		auditLog.AppendReturns(nil, stderrors.New("disk full"))
+		Expect(renewer.Renew(ctx)).NotTo(BeNil())
+		Expect(renewer.Certificate().Equal(original.Certificate)).To(BeTrue())
+		onDisk, err := pkg.LoadCertificate(ctx, paths.CertPath)
+		Expect(err).To(BeNil())
+		Expect(onDisk.Equal(original.Certificate)).To(BeTrue())
+	})
+	It("loads files renewed by someone else", func() {
+		renewer := newAutoRenewer(0.5)
+		replaced := issue()
+		Expect(renewer.Renew(ctx)).To(BeNil())
+		Expect(renewer.Certificate().Equal(replaced.Certificate)).To(BeTrue())
+		Expect(inventory.AddCallCount()).To(Equal(0))
+	})
+})
@@ -0,0 +1,243 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bborbe/errors"
+	"github.com/bborbe/run"
+	"gopkg.in/yaml.v3"
+)
+
+// BatchManifestFile is the default name of the manifest written by a batch.
+const BatchManifestFile = "manifest.json"
+
+// BatchIdentity is one client identity issued in a batch.
+type BatchIdentity struct {
+	// Name is the directory of the identity inside the DataDir, CommonName if empty.
+	Name           string        `yaml:"name" json:"name"`
+	CommonName     string        `yaml:"commonName" json:"commonName"`
+	Organization   []string      `yaml:"organization" json:"organization"`
+	EmailAddresses []string      `yaml:"emailAddresses" json:"emailAddresses"`
+	Validity       time.Duration `yaml:"validity" json:"validity"`
+}
+
+// IssueRequest returns the client IssueRequest for this identity.
+func (b BatchIdentity) IssueRequest() IssueRequest {
+	return IssueRequest{
+		Profile:        ProfileClient,
+		CommonName:     b.CommonName,
+		Organization:   b.Organization,
+		EmailAddresses: b.EmailAddresses,
+		Validity:       b.Validity,
+	}
+}
+
+// LoadBatchIdentities reads identities from a CSV file (*.csv) or a JSON or YAML file.
+func LoadBatchIdentities(ctx context.Context, path string) ([]BatchIdentity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "read %s failed", path)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return ParseBatchIdentitiesCSV(ctx, data)
+	}
+	return ParseBatchIdentitiesJSON(ctx, data)
+}
+
+// ParseBatchIdentitiesJSON parses a JSON or YAML list of identities, durations are written like 720h.
+func ParseBatchIdentitiesJSON(ctx context.Context, data []byte) ([]BatchIdentity, error) {
+	var identities []BatchIdentity
+	// JSON is a subset of YAML, so the yaml decoder reads both
+	if err := yaml.Unmarshal(data, &identities); err != nil {
+		return nil, errors.Wrapf(ctx, err, "unmarshal identities failed")
+	}
+	return validateBatchIdentities(ctx, identities)
+}
+
+// ParseBatchIdentitiesCSV parses CSV with a header line.
+// Known columns are name, cn, org, email and validity, multiple orgs or emails are separated by ';'.
+func ParseBatchIdentitiesCSV(ctx context.Context, data []byte) ([]BatchIdentity, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "read csv failed")
+	}
+	if len(records) == 0 {
+		return nil, errors.Errorf(ctx, "csv header missing")
+	}
+	header := records[0]
+	for _, column := range header {
+		switch strings.ToLower(column) {
+		case "name", "cn", "org", "email", "validity":
+		default:
+			return nil, errors.Errorf(ctx, "unknown csv column '%s'", column)
+		}
+	}
+	var identities []BatchIdentity
+	for i, record := range records[1:] {
+		var identity BatchIdentity
+		for j, value := range record {
+			value = strings.TrimSpace(value)
+			switch strings.ToLower(header[j]) {
+			case "name":
+				identity.Name = value
+			case "cn":
+				identity.CommonName = value
+			case "org":
+				identity.Organization = SplitList(value, ";")
+			case "email":
+				identity.EmailAddresses = SplitList(value, ";")
+			case "validity":
+				if value == "" {
+					continue
+				}
+				identity.Validity, err = time.ParseDuration(value)
+				if err != nil {
+					return nil, errors.Wrapf(ctx, err, "parse validity in line %d failed", i+2)
+				}
+			}
+		}
+		identities = append(identities, identity)
+	}
+	return validateBatchIdentities(ctx, identities)
+}
+
+// validateBatchIdentities applies defaults and rejects incomplete or duplicate identities.
+func validateBatchIdentities(ctx context.Context, identities []BatchIdentity) ([]BatchIdentity, error) {
+	if len(identities) == 0 {
+		return nil, errors.Errorf(ctx, "no identities found")
+	}
+	names := make(map[string]bool)
+	for i := range identities {
+		identity := &identities[i]
+		if identity.CommonName == "" {
+			return nil, errors.Errorf(ctx, "common name of identity %d missing", i+1)
+		}
+		if identity.Name == "" {
+			identity.Name = identity.CommonName
+		}
+		if identity.Validity == 0 {
+			identity.Validity = DefaultClientIssueRequest().Validity
+		}
+		if err := validateIdentityName(ctx, identity.Name); err != nil {
+			return nil, err
+		}
+		if names[identity.Name] {
+			return nil, errors.Errorf(ctx, "duplicate identity '%s'", identity.Name)
+		}
+		names[identity.Name] = true
+	}
+	return identities, nil
+}
+
+// BatchManifestEntry is the result of one identity in a batch.
+type BatchManifestEntry struct {
+	Name              string    `json:"name"`
+	CommonName        string    `json:"commonName"`
+	Subject           string    `json:"subject,omitempty"`
+	SerialNumber      string    `json:"serialNumber,omitempty"`
+	SHA256Fingerprint string    `json:"sha256Fingerprint,omitempty"`
+	NotAfter          time.Time `json:"notAfter"`
+	CertPath          string    `json:"certPath,omitempty"`
+	KeyPath           string    `json:"keyPath,omitempty"`
+	ChainPath         string    `json:"chainPath,omitempty"`
+	Error             string    `json:"error,omitempty"`
+}
+
+// IssueBatch issues a client certificate for each identity with at most concurrency in parallel
+// and writes them to <name>/cert.pem, <name>/key.pem and <name>/chain.pem.
+// Each certificate is recorded with recorder before it is written.
+// The manifest contains an entry for every identity, failed ones carry the error.
+func IssueBatch(
+	ctx context.Context,
+	ca *CA,
+	dataDir DataDir,
+	identities []BatchIdentity,
+	overwriteMode OverwriteMode,
+	concurrency int,
+	recorder IssuanceRecorder,
+) ([]BatchManifestEntry, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	manifest := make([]BatchManifestEntry, len(identities))
+	limit := make(chan struct{}, concurrency)
+	funcs := make([]run.Func, len(identities))
+	for i, identity := range identities {
+		funcs[i] = func(ctx context.Context) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case limit <- struct{}{}:
+			}
+			defer func() { <-limit }()
+
+			entry, err := issueBatchIdentity(ctx, ca, dataDir, identity, overwriteMode, recorder)
+			if err != nil {
+				entry.Error = err.Error()
+			}
+			manifest[i] = entry
+			if err != nil {
+				return errors.Wrapf(ctx, err, "issue '%s' failed", identity.Name)
+			}
+			return nil
+		}
+	}
+	if err := run.All(ctx, funcs...); err != nil {
+		return manifest, err
+	}
+	return manifest, nil
+}
+
+func issueBatchIdentity(ctx context.Context, ca *CA, dataDir DataDir, identity BatchIdentity, overwriteMode OverwriteMode, recorder IssuanceRecorder) (BatchManifestEntry, error) {
+	entry := BatchManifestEntry{
+		Name:       identity.Name,
+		CommonName: identity.CommonName,
+	}
+	paths, err := dataDir.IdentityPaths(ctx, identity.Name, IdentityPaths{}, IdentityPaths{})
+	if err != nil {
+		return entry, err
+	}
+	if err := PrepareOverwrite(ctx, overwriteMode, paths.CertPath, paths.KeyPath, paths.ChainPath); err != nil {
+		return entry, errors.Wrapf(ctx, err, "prepare overwrite failed")
+	}
+	keyPair, err := IssueCertificate(ctx, ca, identity.IssueRequest())
+	if err != nil {
+		return entry, errors.Wrapf(ctx, err, "issue certificate failed")
+	}
+	if err := recorder.Record(ctx, AuditOperationIssue, keyPair.Certificate, ProfileClient, paths.CertPath, paths.KeyPath); err != nil {
+		return entry, err
+	}
+	if err := WriteIdentity(ctx, keyPair, ca, paths); err != nil {
+		return entry, errors.Wrapf(ctx, err, "write certificate failed")
+	}
+	entry.Subject = keyPair.Certificate.Subject.String()
+	entry.SerialNumber = FormatHex(keyPair.Certificate.SerialNumber.Bytes())
+	entry.SHA256Fingerprint = SHA256Fingerprint(keyPair.Certificate)
+	entry.NotAfter = keyPair.Certificate.NotAfter
+	entry.CertPath = paths.CertPath
+	entry.KeyPath = paths.KeyPath
+	entry.ChainPath = paths.ChainPath
+	return entry, nil
+}
+
+// WriteBatchManifest writes the manifest as JSON.
+func WriteBatchManifest(ctx context.Context, path string, manifest []BatchManifestEntry) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.Wrapf(ctx, err, "marshal manifest failed")
+	}
+	return WriteFileAtomic(ctx, path, data, CertificateFileMode)
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batch", func() {
+	var ctx context.Context
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+	Context("ParseBatchIdentitiesCSV", func() {
+		It("parses all columns", func() {
+			identities, err := pkg.ParseBatchIdentitiesCSV(ctx, []byte("name,cn,org,email,validity\nalice,Alice,Acme;Dev,alice@example.com,720h\n,bob,,,\n"))
+			Expect(err).To(BeNil())
+			Expect(identities).To(HaveLen(2))
+			Expect(identities[0]).To(Equal(pkg.BatchIdentity{
+				Name:           "alice",
+				CommonName:     "Alice",
+				Organization:   []string{"Acme", "Dev"},
+				EmailAddresses: []string{"alice@example.com"},
+				Validity:       720 * time.Hour,
+			}))
+			Expect(identities[1].Name).To(Equal("bob"))
+			Expect(identities[1].Validity).To(Equal(pkg.DefaultClientIssueRequest().Validity))
+		})
+		It("rejects unknown columns", func() {
+			_, err := pkg.ParseBatchIdentitiesCSV(ctx, []byte("cn,phone\nalice,123\n"))
+			Expect(err).NotTo(BeNil())
+		})
+		It("rejects duplicate names", func() {
+			_, err := pkg.ParseBatchIdentitiesCSV(ctx, []byte("cn\nalice\nalice\n"))
+			Expect(err).NotTo(BeNil())
+		})
+		It("rejects missing common name", func() {
+			_, err := pkg.ParseBatchIdentitiesCSV(ctx, []byte("name,cn\nalice,\n"))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+	Context("ParseBatchIdentitiesJSON", func() {
+		It("parses identities", func() {
+			identities, err := pkg.ParseBatchIdentitiesJSON(ctx, []byte(`[{"commonName":"alice","emailAddresses":["alice@example.com"],"validity":"48h"}]`))
+			Expect(err).To(BeNil())
+			Expect(identities).To(HaveLen(1))
+			Expect(identities[0].Name).To(Equal("alice"))
+			Expect(identities[0].Validity).To(Equal(48 * time.Hour))
+		})
+		It("rejects names outside the datadir", func() {
+			_, err := pkg.ParseBatchIdentitiesJSON(ctx, []byte(`[{"name":"../alice","commonName":"alice"}]`))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+	Context("IssueBatch", func() {
+		var ca *pkg.CA
+		var dir string
+		var identities []pkg.BatchIdentity
+		BeforeEach(func() {
+			var err error
+			ca, err = pkg.CreateCA(ctx, pkg.DefaultCARequest())
+			Expect(err).To(BeNil())
+			dir = GinkgoT().TempDir()
+			identities = nil
+			for i := 0; i < 10; i++ {
+				identities = append(identities, pkg.BatchIdentity{
+					Name:       fmt.Sprintf("user%d", i),
+					CommonName: fmt.Sprintf("user%d", i),
+					Validity:   time.Hour,
+				})
+			}
+		})
+		It("issues all identities", func() {
+			manifest, err := pkg.IssueBatch(ctx, ca, pkg.DataDir(dir), identities, pkg.OverwriteModeFail, 3, pkg.IssuanceRecorder{})
+			Expect(err).To(BeNil())
+			Expect(manifest).To(HaveLen(10))
+			serials := map[string]bool{}
+			for i, entry := range manifest {
+				Expect(entry.Name).To(Equal(identities[i].Name))
+				Expect(entry.Error).To(BeEmpty())
+				Expect(entry.CertPath).To(Equal(filepath.Join(dir, entry.Name, "cert.pem")))
+				cert, err := pkg.LoadCertificate(ctx, entry.CertPath)
+				Expect(err).To(BeNil())
+				Expect(cert.Subject.CommonName).To(Equal(entry.CommonName))
+				Expect(cert.ExtKeyUsage).To(Equal([]x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}))
+				Expect(pkg.SHA256Fingerprint(cert)).To(Equal(entry.SHA256Fingerprint))
+				serials[entry.SerialNumber] = true
+			}
+			Expect(serials).To(HaveLen(10))
+		})
+		It("records every identity in the inventory of the datadir", func() {
+			recorder, err := pkg.DataDir(dir).IssuanceRecorder(ctx)
+			Expect(err).To(BeNil())
+			manifest, err := pkg.IssueBatch(ctx, ca, pkg.DataDir(dir), identities, pkg.OverwriteModeFail, 3, recorder)
+			Expect(err).To(BeNil())
+			entries, err := recorder.Inventory.List(ctx)
+			Expect(err).To(BeNil())
+			Expect(entries).To(HaveLen(10))
+			for _, entry := range entries {
+				Expect(entry.AuthorityKeyID).To(Equal(pkg.FormatHex(ca.Certificate.SubjectKeyId)))
+			}
+			_, count, err := pkg.VerifyAuditLog(ctx, filepath.Join(dir, pkg.AuditLogFile))
+			Expect(err).To(BeNil())
+			Expect(count).To(Equal(len(manifest)))
+		})
+		It("reports failed identities in the manifest", func() {
+			Expect(os.MkdirAll(filepath.Join(dir, "user3"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "user3", "cert.pem"), []byte("old"), 0644)).To(Succeed())
+			manifest, err := pkg.IssueBatch(ctx, ca, pkg.DataDir(dir), identities, pkg.OverwriteModeFail, 3, pkg.IssuanceRecorder{})
+			Expect(err).NotTo(BeNil())
+			Expect(manifest).To(HaveLen(10))
+			Expect(manifest[3].Error).NotTo(BeEmpty())
+			Expect(manifest[3].SerialNumber).To(BeEmpty())
+			Expect(manifest[4].Error).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,197 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bborbe/errors"
+)
+
+// CABundle is a list of CA certificates trusted together, unique by SHA-256 fingerprint.
+type CABundle []*x509.Certificate
+
+// LoadCABundle reads a PEM bundle, a missing file is an empty bundle.
+func LoadCABundle(ctx context.Context, path string) (CABundle, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return CABundle{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "read %s failed", path)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return CABundle{}, nil
+	}
+	certs, err := ParseCertificates(ctx, data)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "parse %s failed", path)
+	}
+	bundle, _, err := CABundle{}.Add(ctx, certs...)
+	return bundle, err
+}
+
+// Contains returns true if the bundle contains cert.
+func (b CABundle) Contains(cert *x509.Certificate) bool {
+	return b.indexOf(SHA256Fingerprint(cert)) >= 0
+}
+
+// ContainsFingerprint returns true if the bundle contains a certificate with the given SHA-256 fingerprint.
+// The fingerprint is compared case-insensitive, with or without colons.
+func (b CABundle) ContainsFingerprint(fingerprint string) bool {
+	return b.indexOf(fingerprint) >= 0
+}
+
+func (b CABundle) indexOf(fingerprint string) int {
+	fingerprint = normalizeFingerprint(fingerprint)
+	for i, cert := range b {
+		if normalizeFingerprint(SHA256Fingerprint(cert)) == fingerprint {
+			return i
+		}
+	}
+	return -1
+}
+
+// Add returns the bundle with all certs not contained yet and the added certs.
+// Certificates that are no CA are rejected.
+func (b CABundle) Add(ctx context.Context, certs ...*x509.Certificate) (CABundle, []*x509.Certificate, error) {
+	result := append(CABundle{}, b...)
+	var added []*x509.Certificate
+	for _, cert := range certs {
+		if !cert.IsCA {
+			return nil, nil, errors.Errorf(ctx, "certificate '%s' is no ca", cert.Subject.String())
+		}
+		if result.Contains(cert) {
+			continue
+		}
+		result = append(result, cert)
+		added = append(added, cert)
+	}
+	return result, added, nil
+}
+
+// Remove returns the bundle without the certificates of the given SHA-256 fingerprints and the removed certs.
+// Fingerprints are compared case-insensitive, with or without colons.
+func (b CABundle) Remove(fingerprints ...string) (CABundle, []*x509.Certificate) {
+	normalized := make(map[string]bool, len(fingerprints))
+	for _, fingerprint := range fingerprints {
+		normalized[normalizeFingerprint(fingerprint)] = true
+	}
+	return b.removeFunc(func(cert *x509.Certificate) bool {
+		return normalized[normalizeFingerprint(SHA256Fingerprint(cert))]
+	})
+}
+
+// RemoveExpired returns the bundle without certificates expired at now and the removed certs.
+func (b CABundle) RemoveExpired(now time.Time) (CABundle, []*x509.Certificate) {
+	return b.removeFunc(func(cert *x509.Certificate) bool {
+		return now.After(cert.NotAfter)
+	})
+}
+
+func (b CABundle) removeFunc(remove func(cert *x509.Certificate) bool) (CABundle, []*x509.Certificate) {
+	result := CABundle{}
+	var removed []*x509.Certificate
+	for _, cert := range b {
+		if remove(cert) {
+			removed = append(removed, cert)
+			continue
+		}
+		result = append(result, cert)
+	}
+	return result, removed
+}
+
+// Sorted returns the bundle ordered by subject, not before and fingerprint, so equal bundles produce equal files.
+func (b CABundle) Sorted() CABundle {
+	result := append(CABundle{}, b...)
+	sort.SliceStable(result, func(i, j int) bool {
+		if left, right := result[i].Subject.String(), result[j].Subject.String(); left != right {
+			return left < right
+		}
+		if !result[i].NotBefore.Equal(result[j].NotBefore) {
+			return result[i].NotBefore.Before(result[j].NotBefore)
+		}
+		return SHA256Fingerprint(result[i]) < SHA256Fingerprint(result[j])
+	})
+	return result
+}
+
+// PEM returns all certificates PEM encoded.
+func (b CABundle) PEM() []byte {
+	var buf bytes.Buffer
+	for _, cert := range b {
+		buf.Write(EncodeCertificatePEM(cert))
+	}
+	return buf.Bytes()
+}
+
+// CABundleManifest describes the certificates of a bundle.
+type CABundleManifest struct {
+	GeneratedAt  time.Time               `json:"generatedAt"`
+	Certificates []CABundleManifestEntry `json:"certificates"`
+}
+
+// CABundleManifestEntry describes one certificate of a bundle.
+type CABundleManifestEntry struct {
+	SHA256Fingerprint string    `json:"sha256Fingerprint"`
+	Subject           string    `json:"subject"`
+	Issuer            string    `json:"issuer"`
+	SerialNumber      string    `json:"serialNumber"`
+	SubjectKeyID      string    `json:"subjectKeyId,omitempty"`
+	NotBefore         time.Time `json:"notBefore"`
+	NotAfter          time.Time `json:"notAfter"`
+	SelfSigned        bool      `json:"selfSigned"`
+}
+
+// Manifest returns the manifest of the bundle generated at now.
+func (b CABundle) Manifest(now time.Time) CABundleManifest {
+	result := CABundleManifest{
+		GeneratedAt:  now.UTC(),
+		Certificates: make([]CABundleManifestEntry, 0, len(b)),
+	}
+	for _, cert := range b {
+		result.Certificates = append(result.Certificates, CABundleManifestEntry{
+			SHA256Fingerprint: SHA256Fingerprint(cert),
+			Subject:           cert.Subject.String(),
+			Issuer:            cert.Issuer.String(),
+			SerialNumber:      FormatHex(cert.SerialNumber.Bytes()),
+			SubjectKeyID:      FormatHex(cert.SubjectKeyId),
+			NotBefore:         cert.NotBefore,
+			NotAfter:          cert.NotAfter,
+			SelfSigned:        isSelfSigned(cert),
+		})
+	}
+	return result
+}
+
+// WriteCABundle writes the bundle as PEM to bundlePath and its manifest as JSON to manifestPath if set.
+func WriteCABundle(ctx context.Context, bundle CABundle, bundlePath string, manifestPath string, now time.Time) error {
+	if err := WriteCertificateFile(ctx, bundlePath, bundle.PEM()); err != nil {
+		return errors.Wrapf(ctx, err, "write bundle failed")
+	}
+	if manifestPath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(bundle.Manifest(now), "", "  ")
+	if err != nil {
+		return errors.Wrapf(ctx, err, "marshal manifest failed")
+	}
+	if err := WriteFileAtomic(ctx, manifestPath, append(data, '\n'), 0644); err != nil {
+		return errors.Wrapf(ctx, err, "write manifest failed")
+	}
+	return nil
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CABundle", func() {
+	var ctx context.Context
+	var caA *pkg.CA
+	var caB *pkg.CA
+	var bundle pkg.CABundle
+	var err error
+	createCA := func(commonName string, validity time.Duration) *pkg.CA {
+		req := pkg.DefaultCARequest()
+		req.Subject.CommonName = commonName
+		req.Validity = validity
+		ca, err := pkg.CreateCA(ctx, req)
+		Expect(err).To(BeNil())
+		return ca
+	}
+	BeforeEach(func() {
+		ctx = context.Background()
+		caA = createCA("A", 24*time.Hour)
+		caB = createCA("B", 48*time.Hour)
+		bundle, _, err = pkg.CABundle{}.Add(ctx, caB.Certificate, caA.Certificate)
+		Expect(err).To(BeNil())
+	})
+	It("deduplicates by fingerprint", func() {
+		result, added, err := bundle.Add(ctx, caA.Certificate)
+		Expect(err).To(BeNil())
+		Expect(added).To(BeEmpty())
+		Expect(result).To(HaveLen(2))
+	})
+	It("rejects leaf certificates", func() {
+		leaf, err := pkg.IssueCertificate(ctx, caA, pkg.IssueRequest{Profile: pkg.ProfileServer, DNSNames: []string{"a.example.com"}, Validity: time.Hour})
+		Expect(err).To(BeNil())
+		_, _, err = bundle.Add(ctx, leaf.Certificate)
+		Expect(err).NotTo(BeNil())
+	})
+	It("removes by fingerprint ignoring case and colons", func() {
+		fingerprint := strings.ToLower(strings.ReplaceAll(pkg.SHA256Fingerprint(caA.Certificate), ":", ""))
+		result, removed := bundle.Remove(fingerprint)
+		Expect(removed).To(HaveLen(1))
+		Expect(result).To(HaveLen(1))
+		Expect(result.Contains(caB.Certificate)).To(BeTrue())
+	})
+	It("finds fingerprints ignoring case and colons", func() {
+		Expect(bundle.ContainsFingerprint(strings.ToLower(pkg.SHA256Fingerprint(caA.Certificate)))).To(BeTrue())
+		Expect(bundle.ContainsFingerprint("00")).To(BeFalse())
+	})
+	It("removes expired", func() {
+		result, removed := bundle.RemoveExpired(time.Now().Add(36 * time.Hour))
+		Expect(removed).To(HaveLen(1))
+		Expect(removed[0].Equal(caA.Certificate)).To(BeTrue())
+		Expect(result).To(HaveLen(1))
+	})
+	It("sorts by subject", func() {
+		sorted := bundle.Sorted()
+		Expect(sorted[0].Equal(caA.Certificate)).To(BeTrue())
+		Expect(sorted[1].Equal(caB.Certificate)).To(BeTrue())
+	})
+	It("writes and loads bundle with manifest", func() {
+		dir := GinkgoT().TempDir()
+		bundlePath := filepath.Join(dir, "bundle.pem")
+		manifestPath := filepath.Join(dir, "bundle.json")
+		Expect(pkg.WriteCABundle(ctx, bundle.Sorted(), bundlePath, manifestPath, time.Now())).To(BeNil())
+
+		loaded, err := pkg.LoadCABundle(ctx, bundlePath)
+		Expect(err).To(BeNil())
+		Expect(loaded).To(HaveLen(2))
+
+		data, err := os.ReadFile(manifestPath)
+		Expect(err).To(BeNil())
+		var manifest pkg.CABundleManifest
+		Expect(json.Unmarshal(data, &manifest)).To(BeNil())
+		Expect(manifest.Certificates).To(HaveLen(2))
+		Expect(manifest.Certificates[0].SHA256Fingerprint).To(Equal(pkg.SHA256Fingerprint(caA.Certificate)))
+		Expect(manifest.Certificates[0].SelfSigned).To(BeTrue())
+	})
+	It("loads missing bundle as empty", func() {
+		loaded, err := pkg.LoadCABundle(ctx, filepath.Join(GinkgoT().TempDir(), "missing.pem"))
+		Expect(err).To(BeNil())
+		Expect(loaded).To(BeEmpty())
+	})
+})
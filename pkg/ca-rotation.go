@@ -0,0 +1,683 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/bborbe/errors"
+)
+
+// Files of the CA rotation inside a DataDir.
+const (
+	CAGenerationsFile = "ca_generations.json"
+	CABundleFile      = "ca_bundle.pem"
+	// CAPromotionFile is the journal of an unfinished PromoteCA.
+	CAPromotionFile = "ca_promotion.json"
+)
+
+// CAGenerationStatus is the state of a CA generation during rotation.
+type CAGenerationStatus string
+
+const (
+	// CAGenerationStatusNext is the successor created by RotateCA, trusted but not yet issuing.
+	CAGenerationStatusNext CAGenerationStatus = "next"
+	// CAGenerationStatusActive is the generation issuing certificates.
+	CAGenerationStatusActive CAGenerationStatus = "active"
+	// CAGenerationStatusPrevious was active before and is still trusted.
+	CAGenerationStatusPrevious CAGenerationStatus = "previous"
+	// CAGenerationStatusRetired is no longer part of the trust bundle.
+	CAGenerationStatusRetired CAGenerationStatus = "retired"
+)
+
+// CAGeneration is one root CA of the DataDir, numbered from 1.
+type CAGeneration struct {
+	Generation   int                `json:"generation"`
+	Status       CAGenerationStatus `json:"status"`
+	Subject      string             `json:"subject"`
+	SerialNumber string             `json:"serialNumber"`
+	SubjectKeyID string             `json:"subjectKeyId"`
+	NotBefore    time.Time          `json:"notBefore"`
+	NotAfter     time.Time          `json:"notAfter"`
+	// CertPath and KeyPath are relative to the DataDir, KeyPath is empty if the key is not stored in a file.
+	CertPath          string               `json:"certPath"`
+	KeyPath           string               `json:"keyPath,omitempty"`
+	CrossCertificates []CACrossCertificate `json:"crossCertificates,omitempty"`
+}
+
+// CACrossCertificate is the key of a generation signed by another generation.
+type CACrossCertificate struct {
+	IssuerGeneration int    `json:"issuerGeneration"`
+	CertPath         string `json:"certPath"`
+}
+
+func newCAGeneration(generation int, status CAGenerationStatus, cert *x509.Certificate, certPath string, keyPath string) CAGeneration {
+	return CAGeneration{
+		Generation:   generation,
+		Status:       status,
+		Subject:      cert.Subject.String(),
+		SerialNumber: FormatHex(cert.SerialNumber.Bytes()),
+		SubjectKeyID: FormatHex(cert.SubjectKeyId),
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+		CertPath:     certPath,
+		KeyPath:      keyPath,
+	}
+}
+
+// CAGenerations tracks all generations of the root CA, stored as ca_generations.json in the DataDir.
+type CAGenerations struct {
+	Generations []CAGeneration `json:"generations"`
+}
+
+// Get returns the given generation or nil.
+func (c *CAGenerations) Get(generation int) *CAGeneration {
+	for i := range c.Generations {
+		if c.Generations[i].Generation == generation {
+			return &c.Generations[i]
+		}
+	}
+	return nil
+}
+
+// WithStatus returns the first generation with status or nil.
+func (c *CAGenerations) WithStatus(status CAGenerationStatus) *CAGeneration {
+	for i := range c.Generations {
+		if c.Generations[i].Status == status {
+			return &c.Generations[i]
+		}
+	}
+	return nil
+}
+
+// OfSubjectKeyID returns the generation with the given subject key id or nil.
+// Leaves match the generation of their authority key id.
+func (c *CAGenerations) OfSubjectKeyID(subjectKeyID string) *CAGeneration {
+	if subjectKeyID == "" {
+		return nil
+	}
+	for i := range c.Generations {
+		if c.Generations[i].SubjectKeyID == subjectKeyID {
+			return &c.Generations[i]
+		}
+	}
+	return nil
+}
+
+// CountLeaves returns the number of valid leaves per issuing generation,
+// leaves of unknown issuers are counted for generation 0.
+func (c *CAGenerations) CountLeaves(entries []InventoryEntry, now time.Time) map[int]int {
+	result := make(map[int]int)
+	for _, entry := range entries {
+		if entry.Revoked() || now.After(entry.NotAfter) {
+			continue
+		}
+		generation := 0
+		if g := c.OfSubjectKeyID(entry.AuthorityKeyID); g != nil {
+			generation = g.Generation
+		}
+		result[generation]++
+	}
+	return result
+}
+
+func (c *CAGenerations) nextGeneration() int {
+	result := 0
+	for _, generation := range c.Generations {
+		result = max(result, generation.Generation)
+	}
+	return result + 1
+}
+
+// CAGenerations returns the CA generations of the DataDir or nil if the CA was never rotated.
+func (d DataDir) CAGenerations(ctx context.Context) (*CAGenerations, error) {
+	generationsPath, err := d.Path(ctx, CAGenerationsFile)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(generationsPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "read %s failed", generationsPath)
+	}
+	var result CAGenerations
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, errors.Wrapf(ctx, err, "unmarshal %s failed", generationsPath)
+	}
+	return &result, nil
+}
+
+// saveCAGenerations writes the generations and the trust bundle with the certificates of all generations not retired.
+func (d DataDir) saveCAGenerations(ctx context.Context, generations *CAGenerations) error {
+	bundle := CABundle{}
+	for _, generation := range generations.Generations {
+		if generation.Status == CAGenerationStatusRetired {
+			continue
+		}
+		cert, err := d.loadCertificate(ctx, generation.CertPath)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "load generation %d failed", generation.Generation)
+		}
+		if bundle, _, err = bundle.Add(ctx, cert); err != nil {
+			return errors.Wrapf(ctx, err, "add generation %d failed", generation.Generation)
+		}
+	}
+	bundlePath, err := d.Path(ctx, CABundleFile)
+	if err != nil {
+		return err
+	}
+	if err := WriteCABundle(ctx, bundle, bundlePath, "", time.Now()); err != nil {
+		return errors.Wrapf(ctx, err, "write ca bundle failed")
+	}
+	data, err := json.MarshalIndent(generations, "", "  ")
+	if err != nil {
+		return errors.Wrapf(ctx, err, "marshal ca generations failed")
+	}
+	generationsPath, err := d.Path(ctx, CAGenerationsFile)
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(ctx, generationsPath, append(data, '\n'), 0644); err != nil {
+		return errors.Wrapf(ctx, err, "write ca generations failed")
+	}
+	return nil
+}
+
+func (d DataDir) loadCertificate(ctx context.Context, name string) (*x509.Certificate, error) {
+	certPath, err := d.Path(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return LoadCertificate(ctx, certPath)
+}
+
+// LoadCA loads the CA of certPath with the signer of config and configures it from the DataDir.
+// The signer is closed if the CA can not be configured.
+func (d DataDir) LoadCA(ctx context.Context, certPath string, config SignerConfig) (*CA, error) {
+	ca, err := LoadCAWithSignerConfig(ctx, certPath, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.ConfigureCA(ctx, ca); err != nil {
+		_ = ca.Close()
+		return nil, errors.Wrapf(ctx, err, "configure ca failed")
+	}
+	return ca, nil
+}
+
+// ConfigureCA sets policy and cross certificates of ca from the DataDir.
+// Cross certificates are added while their issuing generation is not retired.
+func (d DataDir) ConfigureCA(ctx context.Context, ca *CA) error {
+	var err error
+	if ca.Policy, err = d.Policy(ctx); err != nil {
+		return errors.Wrapf(ctx, err, "load policy failed")
+	}
+	generations, err := d.CAGenerations(ctx)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "load ca generations failed")
+	}
+	if generations == nil {
+		return nil
+	}
+	generation := generations.OfSubjectKeyID(FormatHex(ca.Certificate.SubjectKeyId))
+	if generation == nil {
+		return nil
+	}
+	for _, crossCertificate := range generation.CrossCertificates {
+		issuer := generations.Get(crossCertificate.IssuerGeneration)
+		if issuer == nil || issuer.Status == CAGenerationStatusRetired {
+			continue
+		}
+		cert, err := d.loadCertificate(ctx, crossCertificate.CertPath)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "load cross certificate of generation %d failed", generation.Generation)
+		}
+		ca.CrossCertificates = append(ca.CrossCertificates, cert)
+	}
+	return nil
+}
+
+var generationSuffix = regexp.MustCompile(` G[0-9]+$`)
+
+// NewSuccessorCARequest returns the request for the successor of cert with the same subject, validity,
+// path length and name constraints. The common name gets the suffix " G<generation>" to keep subjects distinct.
+func NewSuccessorCARequest(cert *x509.Certificate, generation int) CARequest {
+	name := generationSuffix.ReplaceAllString(cert.Subject.CommonName, "")
+	if name == "" && len(cert.Subject.Organization) > 0 {
+		name = cert.Subject.Organization[0]
+	}
+	if name == "" {
+		name = "CA"
+	}
+	subject := cert.Subject
+	subject.CommonName = fmt.Sprintf("%s G%d", name, generation)
+	subject.Names = nil
+	req := CARequest{
+		Subject:         subject,
+		Validity:        cert.NotAfter.Sub(cert.NotBefore),
+		NameConstraints: NameConstraintsOfCertificate(cert),
+	}
+	if cert.MaxPathLen > 0 || cert.MaxPathLenZero {
+		maxPathLen := cert.MaxPathLen
+		req.MaxPathLen = &maxPathLen
+	}
+	return req
+}
+
+// CrossSignCA returns a certificate with subject and key of cert signed by issuer.
+// It expires with cert or issuer, whichever is first.
+func CrossSignCA(ctx context.Context, cert *x509.Certificate, issuer *CA) (*x509.Certificate, error) {
+	if !cert.IsCA || !issuer.Certificate.IsCA {
+		return nil, errors.Errorf(ctx, "cross signing requires two cas")
+	}
+	serialNumber, err := newSerialNumber(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "generate serial number failed")
+	}
+	notAfter := cert.NotAfter
+	if issuer.Certificate.NotAfter.Before(notAfter) {
+		notAfter = issuer.Certificate.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber:                serialNumber,
+		RawSubject:                  cert.RawSubject,
+		Subject:                     cert.Subject,
+		NotBefore:                   time.Now(),
+		NotAfter:                    notAfter,
+		KeyUsage:                    cert.KeyUsage,
+		BasicConstraintsValid:       true,
+		IsCA:                        true,
+		MaxPathLen:                  cert.MaxPathLen,
+		MaxPathLenZero:              cert.MaxPathLenZero,
+		SubjectKeyId:                cert.SubjectKeyId,
+		PermittedDNSDomainsCritical: cert.PermittedDNSDomainsCritical,
+		PermittedDNSDomains:         cert.PermittedDNSDomains,
+		ExcludedDNSDomains:          cert.ExcludedDNSDomains,
+		PermittedIPRanges:           cert.PermittedIPRanges,
+		ExcludedIPRanges:            cert.ExcludedIPRanges,
+		PermittedEmailAddresses:     cert.PermittedEmailAddresses,
+		ExcludedEmailAddresses:      cert.ExcludedEmailAddresses,
+		PermittedURIDomains:         cert.PermittedURIDomains,
+		ExcludedURIDomains:          cert.ExcludedURIDomains,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer.Certificate, cert.PublicKey, issuer.Signer)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "create cross certificate failed")
+	}
+	result, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "parse cross certificate failed")
+	}
+	return result, nil
+}
+
+// CARotation is the result of RotateCA.
+type CARotation struct {
+	Generation int
+	Successor  *CA
+	// SuccessorCross is the successor signed by the active CA.
+	SuccessorCross *x509.Certificate
+	// ActiveCross is the active CA signed by the successor.
+	ActiveCross *x509.Certificate
+}
+
+// RotateCA creates the successor of the active root CA in ca-g<n>/ of the DataDir, cross-signs both CAs with
+// each other and rewrites the trust bundle. The active CA keeps issuing until PromoteCA.
+// certPath and keyPath are the files of ca relative to the DataDir. CAs with a PKCS#11 or socket signer
+// (empty keyPath) are rejected, their successor would be a software key.
+// The key of the successor is encrypted if password is set. The new certificates are recorded with recorder
+// before they are written.
+func RotateCA(ctx context.Context, dataDir DataDir, ca *CA, certPath string, keyPath string, password []byte, recorder IssuanceRecorder) (*CARotation, error) {
+	if !isSelfSigned(ca.Certificate) {
+		return nil, errors.Errorf(ctx, "only root cas can be rotated")
+	}
+	if keyPath == "" {
+		return nil, errors.Errorf(ctx, "only cas with a key file can be rotated")
+	}
+	if err := dataDir.checkNoPromotion(ctx); err != nil {
+		return nil, err
+	}
+	generations, err := dataDir.CAGenerations(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "load ca generations failed")
+	}
+	if generations == nil {
+		generations = &CAGenerations{
+			Generations: []CAGeneration{newCAGeneration(1, CAGenerationStatusActive, ca.Certificate, certPath, keyPath)},
+		}
+	}
+	active := generations.WithStatus(CAGenerationStatusActive)
+	if active == nil || active.SubjectKeyID != FormatHex(ca.Certificate.SubjectKeyId) {
+		return nil, errors.Errorf(ctx, "ca '%s' is not the active generation", ca.Certificate.Subject.String())
+	}
+	if active.KeyPath == "" {
+		return nil, errors.Errorf(ctx, "only cas with a key file can be rotated")
+	}
+	if next := generations.WithStatus(CAGenerationStatusNext); next != nil {
+		return nil, errors.Errorf(ctx, "generation %d is already prepared, promote it first", next.Generation)
+	}
+
+	generation := generations.nextGeneration()
+	successor, err := CreateCA(ctx, NewSuccessorCARequest(ca.Certificate, generation))
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "create successor failed")
+	}
+	successorCross, err := CrossSignCA(ctx, successor.Certificate, ca)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "cross sign successor failed")
+	}
+	activeCross, err := CrossSignCA(ctx, ca.Certificate, successor)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "cross sign active ca failed")
+	}
+	for _, entry := range []AuditEntry{
+		NewAuditEntry(AuditOperationCreateCA, "", successor.Certificate, ""),
+		NewAuditEntry(AuditOperationCrossSign, "", successorCross, ""),
+		NewAuditEntry(AuditOperationCrossSign, "", activeCross, ""),
+	} {
+		if err := recorder.Audit(ctx, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	successorPaths := NewIdentityPaths(generationDir(generation))
+	successorCrossPath := crossCertificatePath(generation, active.Generation)
+	activeCrossPath := crossCertificatePath(active.Generation, generation)
+	files := make(map[string]string)
+	for _, name := range []string{successorPaths.CertPath, successorPaths.KeyPath, successorCrossPath, activeCrossPath} {
+		if files[name], err = dataDir.Path(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+	if err := CreateParentDirs(ctx, files[successorPaths.CertPath], files[activeCrossPath]); err != nil {
+		return nil, err
+	}
+	if len(password) > 0 {
+		err = WriteEncryptedCA(ctx, successor, files[successorPaths.CertPath], files[successorPaths.KeyPath], password)
+	} else {
+		err = WriteCA(ctx, successor, files[successorPaths.CertPath], files[successorPaths.KeyPath])
+	}
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "write successor failed")
+	}
+	if err := WriteCertificateFile(ctx, files[successorCrossPath], EncodeCertificatePEM(successorCross)); err != nil {
+		return nil, errors.Wrapf(ctx, err, "write cross certificate failed")
+	}
+	if err := WriteCertificateFile(ctx, files[activeCrossPath], EncodeCertificatePEM(activeCross)); err != nil {
+		return nil, errors.Wrapf(ctx, err, "write cross certificate failed")
+	}
+
+	active.CrossCertificates = append(active.CrossCertificates, CACrossCertificate{IssuerGeneration: generation, CertPath: activeCrossPath})
+	next := newCAGeneration(generation, CAGenerationStatusNext, successor.Certificate, successorPaths.CertPath, successorPaths.KeyPath)
+	next.CrossCertificates = []CACrossCertificate{{IssuerGeneration: active.Generation, CertPath: successorCrossPath}}
+	generations.Generations = append(generations.Generations, next)
+	if err := dataDir.saveCAGenerations(ctx, generations); err != nil {
+		return nil, err
+	}
+	return &CARotation{
+		Generation:     generation,
+		Successor:      successor,
+		SuccessorCross: successorCross,
+		ActiveCross:    activeCross,
+	}, nil
+}
+
+// PromoteCA makes the successor created by RotateCA the active CA. Certificate and key of the active CA move to
+// ca-g<n>/ and the successor takes their place at certPath and keyPath, so commands keep working unchanged.
+// The previous CA stays in the trust bundle until RetireCA.
+// The moves are recorded in ca_promotion.json first; an interrupted promotion is completed by calling PromoteCA again.
+// Meanwhile the CA files are missing or do not match and loading the CA fails.
+func PromoteCA(ctx context.Context, dataDir DataDir, certPath string, keyPath string) (*CAGenerations, error) {
+	promotion, err := dataDir.caPromotion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if promotion != nil {
+		return dataDir.completePromotion(ctx, promotion)
+	}
+	generations, err := dataDir.CAGenerations(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "load ca generations failed")
+	}
+	if generations == nil || generations.WithStatus(CAGenerationStatusNext) == nil {
+		return nil, errors.Errorf(ctx, "no successor prepared, rotate the ca first")
+	}
+	next := generations.WithStatus(CAGenerationStatusNext)
+	active := generations.WithStatus(CAGenerationStatusActive)
+	if active == nil {
+		return nil, errors.Errorf(ctx, "no active generation")
+	}
+
+	promotion = &caPromotion{}
+	move := func(src string, dst string) {
+		promotion.Moves = append(promotion.Moves, caFileMove{Src: src, Dst: dst})
+	}
+	archive := NewIdentityPaths(generationDir(active.Generation))
+	if active.CertPath == certPath {
+		move(active.CertPath, archive.CertPath)
+		active.CertPath = archive.CertPath
+	}
+	if active.KeyPath != "" && active.KeyPath == keyPath {
+		move(active.KeyPath, archive.KeyPath)
+		active.KeyPath = archive.KeyPath
+	}
+	move(next.CertPath, certPath)
+	next.CertPath = certPath
+	if next.KeyPath != "" {
+		move(next.KeyPath, keyPath)
+		next.KeyPath = keyPath
+	}
+	active.Status = CAGenerationStatusPrevious
+	next.Status = CAGenerationStatusActive
+	promotion.Generations = *generations
+	moved := make(map[string]bool)
+	for _, move := range promotion.Moves {
+		if err := dataDir.checkMove(ctx, move.Src, move.Dst, moved[move.Dst]); err != nil {
+			return nil, err
+		}
+		moved[move.Src] = true
+	}
+	if err := dataDir.saveCAPromotion(ctx, promotion); err != nil {
+		return nil, err
+	}
+	return dataDir.completePromotion(ctx, promotion)
+}
+
+// caPromotion is the journal of PromoteCA, Done counts the completed moves.
+type caPromotion struct {
+	Moves       []caFileMove  `json:"moves"`
+	Done        int           `json:"done"`
+	Generations CAGenerations `json:"generations"`
+}
+
+type caFileMove struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+}
+
+// completePromotion executes the remaining moves of promotion, saves its generations and removes the journal.
+// A move whose source is gone and whose destination exists was done before an interruption.
+func (d DataDir) completePromotion(ctx context.Context, promotion *caPromotion) (*CAGenerations, error) {
+	for promotion.Done < len(promotion.Moves) {
+		move := promotion.Moves[promotion.Done]
+		srcPath, err := d.Path(ctx, move.Src)
+		if err != nil {
+			return nil, err
+		}
+		dstPath, err := d.Path(ctx, move.Dst)
+		if err != nil {
+			return nil, err
+		}
+		srcExists, err := fileExists(srcPath)
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "check %s failed", srcPath)
+		}
+		dstExists, err := fileExists(dstPath)
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "check %s failed", dstPath)
+		}
+		if srcExists || !dstExists {
+			if err := d.moveFile(ctx, move.Src, move.Dst); err != nil {
+				return nil, err
+			}
+		}
+		promotion.Done++
+		if err := d.saveCAPromotion(ctx, promotion); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.saveCAGenerations(ctx, &promotion.Generations); err != nil {
+		return nil, err
+	}
+	promotionPath, err := d.Path(ctx, CAPromotionFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(promotionPath); err != nil {
+		return nil, errors.Wrapf(ctx, err, "remove %s failed", promotionPath)
+	}
+	return &promotion.Generations, nil
+}
+
+// caPromotion returns the journal of an unfinished PromoteCA or nil.
+func (d DataDir) caPromotion(ctx context.Context) (*caPromotion, error) {
+	promotionPath, err := d.Path(ctx, CAPromotionFile)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(promotionPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "read %s failed", promotionPath)
+	}
+	var result caPromotion
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, errors.Wrapf(ctx, err, "unmarshal %s failed", promotionPath)
+	}
+	return &result, nil
+}
+
+func (d DataDir) saveCAPromotion(ctx context.Context, promotion *caPromotion) error {
+	data, err := json.MarshalIndent(promotion, "", "  ")
+	if err != nil {
+		return errors.Wrapf(ctx, err, "marshal ca promotion failed")
+	}
+	promotionPath, err := d.Path(ctx, CAPromotionFile)
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(ctx, promotionPath, append(data, '\n'), 0644); err != nil {
+		return errors.Wrapf(ctx, err, "write ca promotion failed")
+	}
+	return nil
+}
+
+// checkNoPromotion returns an error while a promotion is unfinished.
+func (d DataDir) checkNoPromotion(ctx context.Context) error {
+	promotion, err := d.caPromotion(ctx)
+	if err != nil {
+		return err
+	}
+	if promotion != nil {
+		return errors.Errorf(ctx, "promotion of the ca is unfinished, run promote again")
+	}
+	return nil
+}
+
+// RetireCA removes a previous generation from the trust bundle and stops sending its cross certificates.
+// Leaves issued by it are no longer accepted by verifiers using the new bundle.
+func RetireCA(ctx context.Context, dataDir DataDir, generation int) (*CAGenerations, error) {
+	if err := dataDir.checkNoPromotion(ctx); err != nil {
+		return nil, err
+	}
+	generations, err := dataDir.CAGenerations(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "load ca generations failed")
+	}
+	if generations == nil || generations.Get(generation) == nil {
+		return nil, errors.Errorf(ctx, "generation %d not found", generation)
+	}
+	retired := generations.Get(generation)
+	if retired.Status != CAGenerationStatusPrevious {
+		return nil, errors.Errorf(ctx, "generation %d is %s, only previous generations can be retired", generation, retired.Status)
+	}
+	retired.Status = CAGenerationStatusRetired
+	if err := dataDir.saveCAGenerations(ctx, generations); err != nil {
+		return nil, err
+	}
+	return generations, nil
+}
+
+// checkMove returns an error if src does not exist or dst exists inside the DataDir,
+// unless dst is moved away before.
+func (d DataDir) checkMove(ctx context.Context, src string, dst string, dstMovedBefore bool) error {
+	srcPath, err := d.Path(ctx, src)
+	if err != nil {
+		return err
+	}
+	if exists, err := fileExists(srcPath); err != nil {
+		return errors.Wrapf(ctx, err, "check %s failed", srcPath)
+	} else if !exists {
+		return errors.Errorf(ctx, "%s does not exist", srcPath)
+	}
+	if dstMovedBefore {
+		return nil
+	}
+	dstPath, err := d.Path(ctx, dst)
+	if err != nil {
+		return err
+	}
+	if exists, err := fileExists(dstPath); err != nil {
+		return errors.Wrapf(ctx, err, "check %s failed", dstPath)
+	} else if exists {
+		return errors.Errorf(ctx, "%s already exists", dstPath)
+	}
+	return nil
+}
+
+// moveFile renames src to dst inside the DataDir and refuses to replace an existing dst.
+func (d DataDir) moveFile(ctx context.Context, src string, dst string) error {
+	srcPath, err := d.Path(ctx, src)
+	if err != nil {
+		return err
+	}
+	dstPath, err := d.Path(ctx, dst)
+	if err != nil {
+		return err
+	}
+	if exists, err := fileExists(dstPath); err != nil {
+		return errors.Wrapf(ctx, err, "check %s failed", dstPath)
+	} else if exists {
+		return errors.Errorf(ctx, "%s already exists", dstPath)
+	}
+	if err := CreateParentDirs(ctx, dstPath); err != nil {
+		return err
+	}
+	if err := os.Rename(srcPath, dstPath); err != nil {
+		return errors.Wrapf(ctx, err, "move %s to %s failed", srcPath, dstPath)
+	}
+	return nil
+}
+
+func generationDir(generation int) string {
+	return fmt.Sprintf("ca-g%d", generation)
+}
+
+// crossCertificatePath returns ca-g<generation>/cross-g<issuer>.pem.
+func crossCertificatePath(generation int, issuerGeneration int) string {
+	return path.Join(generationDir(generation), fmt.Sprintf("cross-g%d.pem", issuerGeneration))
+}
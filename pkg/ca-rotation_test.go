@@ -0,0 +1,213 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CA rotation", func() {
+	var ctx context.Context
+	var dir string
+	var dataDir pkg.DataDir
+	var ca *pkg.CA
+	var oldLeaf *pkg.KeyPair
+	var rotation *pkg.CARotation
+	var err error
+	BeforeEach(func() {
+		ctx = context.Background()
+		dir = GinkgoT().TempDir()
+		dataDir = pkg.DataDir(dir)
+		req := pkg.DefaultCARequest()
+		req.Subject.CommonName = "Test CA"
+		ca, err = pkg.CreateCA(ctx, req)
+		Expect(err).To(BeNil())
+		Expect(pkg.WriteCA(ctx, ca, filepath.Join(dir, pkg.CACertFile), filepath.Join(dir, pkg.CAKeyFile))).To(BeNil())
+		oldLeaf, err = pkg.IssueCertificate(ctx, ca, pkg.IssueRequest{
+			Profile:  pkg.ProfileServer,
+			DNSNames: []string{"old.example.com"},
+			Validity: time.Hour,
+		})
+		Expect(err).To(BeNil())
+		rotation, err = pkg.RotateCA(ctx, dataDir, ca, pkg.CACertFile, pkg.CAKeyFile, nil, pkg.IssuanceRecorder{})
+		Expect(err).To(BeNil())
+	})
+	verify := func(leaf *x509.Certificate, root *x509.Certificate, intermediates ...*x509.Certificate) error {
+		roots := x509.NewCertPool()
+		roots.AddCert(root)
+		pool := x509.NewCertPool()
+		for _, intermediate := range intermediates {
+			pool.AddCert(intermediate)
+		}
+		_, err := leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: pool})
+		return err
+	}
+	bundle := func() []*x509.Certificate {
+		data, err := os.ReadFile(filepath.Join(dir, pkg.CABundleFile))
+		Expect(err).To(BeNil())
+		certs, err := pkg.ParseCertificates(ctx, data)
+		Expect(err).To(BeNil())
+		return certs
+	}
+	It("creates successor with distinct subject", func() {
+		Expect(rotation.Generation).To(Equal(2))
+		Expect(rotation.Successor.Certificate.Subject.CommonName).To(Equal("Test CA G2"))
+		Expect(rotation.Successor.Certificate.NotAfter.Sub(rotation.Successor.Certificate.NotBefore)).To(Equal(ca.Certificate.NotAfter.Sub(ca.Certificate.NotBefore)))
+	})
+	It("cross signs successor leaves for clients trusting the old ca", func() {
+		newLeaf, err := pkg.IssueCertificate(ctx, rotation.Successor, pkg.IssueRequest{
+			Profile:  pkg.ProfileServer,
+			DNSNames: []string{"new.example.com"},
+			Validity: time.Hour,
+		})
+		Expect(err).To(BeNil())
+		Expect(verify(newLeaf.Certificate, ca.Certificate)).NotTo(BeNil())
+		Expect(verify(newLeaf.Certificate, ca.Certificate, rotation.SuccessorCross)).To(BeNil())
+	})
+	It("cross signs old leaves for clients trusting the successor", func() {
+		Expect(verify(oldLeaf.Certificate, rotation.Successor.Certificate)).NotTo(BeNil())
+		Expect(verify(oldLeaf.Certificate, rotation.Successor.Certificate, rotation.ActiveCross)).To(BeNil())
+	})
+	It("writes bundle with both generations", func() {
+		certs := bundle()
+		Expect(certs).To(HaveLen(2))
+		Expect(certs[0].Equal(ca.Certificate)).To(BeTrue())
+		Expect(certs[1].Equal(rotation.Successor.Certificate)).To(BeTrue())
+	})
+	It("rejects second rotation before promote", func() {
+		_, err := pkg.RotateCA(ctx, dataDir, ca, pkg.CACertFile, pkg.CAKeyFile, nil, pkg.IssuanceRecorder{})
+		Expect(err).NotTo(BeNil())
+	})
+	It("counts leaves per generation", func() {
+		generations, err := dataDir.CAGenerations(ctx)
+		Expect(err).To(BeNil())
+		entries := []pkg.InventoryEntry{
+			pkg.NewInventoryEntry(oldLeaf.Certificate, pkg.ProfileServer, "", ""),
+			{NotAfter: time.Now().Add(time.Hour)},
+		}
+		Expect(generations.CountLeaves(entries, time.Now())).To(Equal(map[int]int{1: 1, 0: 1}))
+	})
+	It("rejects rotation of a ca without key file", func() {
+		otherDataDir := pkg.DataDir(GinkgoT().TempDir())
+		Expect(pkg.WriteCA(ctx, ca, filepath.Join(string(otherDataDir), pkg.CACertFile), filepath.Join(string(otherDataDir), pkg.CAKeyFile))).To(BeNil())
+		_, err := pkg.RotateCA(ctx, otherDataDir, ca, pkg.CACertFile, "", nil, pkg.IssuanceRecorder{})
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("key file"))
+	})
+	It("completes an interrupted promotion", func() {
+		generations, err := dataDir.CAGenerations(ctx)
+		Expect(err).To(BeNil())
+		previous, next := generations.Get(1), generations.Get(2)
+		previous.Status, previous.CertPath, previous.KeyPath = pkg.CAGenerationStatusPrevious, "ca-g1/cert.pem", "ca-g1/key.pem"
+		next.Status, next.CertPath, next.KeyPath = pkg.CAGenerationStatusActive, pkg.CACertFile, pkg.CAKeyFile
+		data, err := json.Marshal(map[string]interface{}{
+			"moves": []map[string]string{
+				{"src": pkg.CACertFile, "dst": "ca-g1/cert.pem"},
+				{"src": pkg.CAKeyFile, "dst": "ca-g1/key.pem"},
+				{"src": "ca-g2/cert.pem", "dst": pkg.CACertFile},
+				{"src": "ca-g2/key.pem", "dst": pkg.CAKeyFile},
+			},
+			"done":        0,
+			"generations": generations,
+		})
+		Expect(err).To(BeNil())
+		Expect(os.WriteFile(filepath.Join(dir, pkg.CAPromotionFile), data, 0600)).To(Succeed())
+		// crashed after the first move, before the journal was updated
+		Expect(os.Rename(filepath.Join(dir, pkg.CACertFile), filepath.Join(dir, "ca-g1", "cert.pem"))).To(Succeed())
+
+		_, err = pkg.LoadCA(ctx, filepath.Join(dir, pkg.CACertFile), filepath.Join(dir, pkg.CAKeyFile))
+		Expect(err).NotTo(BeNil())
+		_, err = pkg.RetireCA(ctx, dataDir, 1)
+		Expect(err).NotTo(BeNil())
+
+		promoted, err := pkg.PromoteCA(ctx, dataDir, pkg.CACertFile, pkg.CAKeyFile)
+		Expect(err).To(BeNil())
+		Expect(promoted.WithStatus(pkg.CAGenerationStatusActive).Generation).To(Equal(2))
+		Expect(filepath.Join(dir, pkg.CAPromotionFile)).NotTo(BeAnExistingFile())
+		active, err := pkg.LoadCA(ctx, filepath.Join(dir, pkg.CACertFile), filepath.Join(dir, pkg.CAKeyFile))
+		Expect(err).To(BeNil())
+		Expect(active.Certificate.Equal(rotation.Successor.Certificate)).To(BeTrue())
+		_, err = pkg.LoadCA(ctx, filepath.Join(dir, "ca-g1", "cert.pem"), filepath.Join(dir, "ca-g1", "key.pem"))
+		Expect(err).To(BeNil())
+	})
+	Context("promote", func() {
+		var generations *pkg.CAGenerations
+		BeforeEach(func() {
+			generations, err = pkg.PromoteCA(ctx, dataDir, pkg.CACertFile, pkg.CAKeyFile)
+			Expect(err).To(BeNil())
+		})
+		It("moves successor to ca files", func() {
+			Expect(filepath.Join(dir, pkg.CAPromotionFile)).NotTo(BeAnExistingFile())
+			Expect(generations.WithStatus(pkg.CAGenerationStatusActive).Generation).To(Equal(2))
+			Expect(generations.Get(1).Status).To(Equal(pkg.CAGenerationStatusPrevious))
+			active, err := pkg.LoadCA(ctx, filepath.Join(dir, pkg.CACertFile), filepath.Join(dir, pkg.CAKeyFile))
+			Expect(err).To(BeNil())
+			Expect(active.Certificate.Equal(rotation.Successor.Certificate)).To(BeTrue())
+			previous, err := pkg.LoadCA(ctx, filepath.Join(dir, "ca-g1", "cert.pem"), filepath.Join(dir, "ca-g1", "key.pem"))
+			Expect(err).To(BeNil())
+			Expect(previous.Certificate.Equal(ca.Certificate)).To(BeTrue())
+		})
+		It("sends cross certificate until previous generation is retired", func() {
+			active := &pkg.CA{Certificate: rotation.Successor.Certificate, Signer: rotation.Successor.Signer}
+			Expect(dataDir.ConfigureCA(ctx, active)).To(BeNil())
+			Expect(active.Intermediates()).To(HaveLen(1))
+			Expect(active.Intermediates()[0].Equal(rotation.SuccessorCross)).To(BeTrue())
+
+			_, err := pkg.RetireCA(ctx, dataDir, 1)
+			Expect(err).To(BeNil())
+			active = &pkg.CA{Certificate: rotation.Successor.Certificate, Signer: rotation.Successor.Signer}
+			Expect(dataDir.ConfigureCA(ctx, active)).To(BeNil())
+			Expect(active.Intermediates()).To(BeEmpty())
+			Expect(bundle()).To(HaveLen(1))
+		})
+		It("publishes one crl per live generation", func() {
+			newLeaf, err := pkg.IssueCertificate(ctx, rotation.Successor, pkg.IssueRequest{
+				Profile:  pkg.ProfileServer,
+				DNSNames: []string{"new.example.com"},
+				Validity: time.Hour,
+			})
+			Expect(err).To(BeNil())
+			now := time.Now()
+			var entries []pkg.InventoryEntry
+			for _, leaf := range []*pkg.KeyPair{oldLeaf, newLeaf} {
+				entry := pkg.NewInventoryEntry(leaf.Certificate, pkg.ProfileServer, "", "")
+				entry.RevokedAt = &now
+				entries = append(entries, entry)
+			}
+			Expect(pkg.NewDataDirCRLPublisher(dataDir, rotation.Successor, nil).Publish(ctx, entries, now)).To(Succeed())
+			readCRL := func(name string) *x509.RevocationList {
+				data, err := os.ReadFile(filepath.Join(dir, name))
+				Expect(err).To(BeNil())
+				block, _ := pem.Decode(data)
+				Expect(block).NotTo(BeNil())
+				crl, err := x509.ParseRevocationList(block.Bytes)
+				Expect(err).To(BeNil())
+				return crl
+			}
+			activeCRL := readCRL(pkg.CACRLFile)
+			Expect(activeCRL.CheckSignatureFrom(rotation.Successor.Certificate)).To(Succeed())
+			Expect(activeCRL.RevokedCertificateEntries).To(HaveLen(1))
+			Expect(activeCRL.RevokedCertificateEntries[0].SerialNumber).To(Equal(newLeaf.Certificate.SerialNumber))
+			previousCRL := readCRL(filepath.Join("ca-g1", "crl.pem"))
+			Expect(previousCRL.CheckSignatureFrom(ca.Certificate)).To(Succeed())
+			Expect(previousCRL.RevokedCertificateEntries).To(HaveLen(1))
+			Expect(previousCRL.RevokedCertificateEntries[0].SerialNumber).To(Equal(oldLeaf.Certificate.SerialNumber))
+		})
+		It("refuses to retire the active generation", func() {
+			_, err := pkg.RetireCA(ctx, dataDir, 2)
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
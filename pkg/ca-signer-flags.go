@@ -0,0 +1,112 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bborbe/errors"
+)
+
+// CASignerFlags are the command line flags selecting the CA certificate and its signer,
+// a key file, an external signer on a unix socket or a PKCS#11 token.
+// The argument package only fills tagged top level fields of an application, so the flags
+// are added to the FlagSet by Register before service.Main parses the command line.
+type CASignerFlags struct {
+	CACert           string
+	CAKey            string
+	CAKeyPassword    string
+	CAKeySocket      string
+	PKCS11Module     string
+	PKCS11Slot       int
+	PKCS11TokenLabel string
+	PKCS11PIN        string
+	PKCS11KeyLabel   string
+	// envErr is an invalid environment variable found by Register, returned by Paths and LoadCA
+	envErr error
+}
+
+// Register adds the flags to fs. Flags not given on the command line are taken from their environment variable.
+func (f *CASignerFlags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.CACert, "ca-cert", CACertFile, "ca certificate file, relative to datadir, env CA_CERT")
+	fs.StringVar(&f.CAKey, "ca-key", CAKeyFile, "ca key file, relative to datadir, env CA_KEY")
+	fs.StringVar(&f.CAKeyPassword, "ca-key-password", "", "password of encrypted ca key, a new ca key is encrypted with it, env CA_KEY_PASSWORD")
+	fs.StringVar(&f.CAKeySocket, "ca-key-socket", "", "unix socket of external ca signer, used instead of ca key file, env CA_KEY_SOCKET")
+	fs.StringVar(&f.PKCS11Module, "pkcs11-module", "", "path of PKCS#11 module holding the ca key, env PKCS11_MODULE")
+	fs.IntVar(&f.PKCS11Slot, "pkcs11-slot", -1, "PKCS#11 slot number, negative selects token by label, env PKCS11_SLOT")
+	fs.StringVar(&f.PKCS11TokenLabel, "pkcs11-token-label", "", "PKCS#11 token label, env PKCS11_TOKEN_LABEL")
+	fs.StringVar(&f.PKCS11PIN, "pkcs11-pin", "", "PKCS#11 user PIN, env PKCS11_PIN")
+	fs.StringVar(&f.PKCS11KeyLabel, "pkcs11-key-label", DefaultPKCS11KeyLabel, "PKCS#11 label of the ca key, env PKCS11_KEY_LABEL")
+	for name, env := range map[string]string{
+		"ca-cert":            "CA_CERT",
+		"ca-key":             "CA_KEY",
+		"ca-key-password":    "CA_KEY_PASSWORD",
+		"ca-key-socket":      "CA_KEY_SOCKET",
+		"pkcs11-module":      "PKCS11_MODULE",
+		"pkcs11-slot":        "PKCS11_SLOT",
+		"pkcs11-token-label": "PKCS11_TOKEN_LABEL",
+		"pkcs11-pin":         "PKCS11_PIN",
+		"pkcs11-key-label":   "PKCS11_KEY_LABEL",
+	} {
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil && f.envErr == nil {
+			f.envErr = fmt.Errorf("invalid env %s: %w", env, err)
+		}
+	}
+}
+
+// Paths returns the absolute CA certificate and key file inside dataDir.
+func (f CASignerFlags) Paths(ctx context.Context, dataDir DataDir) (string, string, error) {
+	if f.envErr != nil {
+		return "", "", errors.Wrapf(ctx, f.envErr, "invalid ca signer flags")
+	}
+	if f.CACert == "" || f.CAKey == "" {
+		return "", "", errors.Errorf(ctx, "ca-cert and ca-key are required")
+	}
+	caCertPath, err := dataDir.Path(ctx, f.CACert)
+	if err != nil {
+		return "", "", errors.Wrapf(ctx, err, "generate caCert path failed")
+	}
+	caKeyPath, err := dataDir.Path(ctx, f.CAKey)
+	if err != nil {
+		return "", "", errors.Wrapf(ctx, err, "generate caKey path failed")
+	}
+	return caCertPath, caKeyPath, nil
+}
+
+// PKCS11Config returns the configured token, nil if no PKCS#11 module is set.
+func (f CASignerFlags) PKCS11Config() *PKCS11Config {
+	return NewPKCS11Config(f.PKCS11Module, f.PKCS11Slot, f.PKCS11TokenLabel, f.PKCS11PIN, f.PKCS11KeyLabel)
+}
+
+// External returns true if the CA key is held by an external signer or a PKCS#11 token instead of a file.
+func (f CASignerFlags) External() bool {
+	return f.CAKeySocket != "" || f.PKCS11Config() != nil
+}
+
+// SignerConfig returns the signer of the CA key at caKeyPath.
+func (f CASignerFlags) SignerConfig(caKeyPath string) SignerConfig {
+	return SignerConfig{
+		KeyPath:     caKeyPath,
+		KeyPassword: f.CAKeyPassword,
+		SocketPath:  f.CAKeySocket,
+		PKCS11:      f.PKCS11Config(),
+	}
+}
+
+// LoadCA loads the CA certificate of dataDir with the configured signer and the policy of dataDir.
+func (f CASignerFlags) LoadCA(ctx context.Context, dataDir DataDir) (*CA, error) {
+	caCertPath, caKeyPath, err := f.Paths(ctx, dataDir)
+	if err != nil {
+		return nil, err
+	}
+	return dataDir.LoadCA(ctx, caCertPath, f.SignerConfig(caKeyPath))
+}
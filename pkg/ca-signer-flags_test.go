@@ -0,0 +1,78 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CASignerFlags", func() {
+	var ctx context.Context
+	var dir string
+	var flags pkg.CASignerFlags
+	var fs *flag.FlagSet
+	BeforeEach(func() {
+		ctx = context.Background()
+		dir = GinkgoT().TempDir()
+		flags = pkg.CASignerFlags{}
+		fs = flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+	})
+	setenv := func(key string, value string) {
+		previous, ok := os.LookupEnv(key)
+		Expect(os.Setenv(key, value)).To(Succeed())
+		DeferCleanup(func() {
+			if ok {
+				_ = os.Setenv(key, previous)
+			} else {
+				_ = os.Unsetenv(key)
+			}
+		})
+	}
+	It("uses defaults", func() {
+		flags.Register(fs)
+		Expect(fs.Parse(nil)).To(Succeed())
+		Expect(flags.CACert).To(Equal(pkg.CACertFile))
+		Expect(flags.CAKey).To(Equal(pkg.CAKeyFile))
+		Expect(flags.PKCS11Config()).To(BeNil())
+		Expect(flags.External()).To(BeFalse())
+	})
+	It("prefers command line over environment", func() {
+		setenv("CA_CERT", "env_cert.pem")
+		setenv("CA_KEY_SOCKET", "/run/signer.sock")
+		flags.Register(fs)
+		Expect(fs.Parse([]string{"-ca-cert=arg_cert.pem"})).To(Succeed())
+		Expect(flags.CACert).To(Equal("arg_cert.pem"))
+		Expect(flags.CAKeySocket).To(Equal("/run/signer.sock"))
+		Expect(flags.External()).To(BeTrue())
+	})
+	It("reports invalid environment when loading the ca", func() {
+		setenv("PKCS11_SLOT", "first")
+		flags.Register(fs)
+		Expect(fs.Parse(nil)).To(Succeed())
+		_, err := flags.LoadCA(ctx, pkg.DataDir(dir))
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("PKCS11_SLOT"))
+	})
+	It("loads the ca of the datadir", func() {
+		ca, err := pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		Expect(pkg.WriteEncryptedCA(ctx, ca, filepath.Join(dir, pkg.CACertFile), filepath.Join(dir, pkg.CAKeyFile), []byte("secret"))).To(Succeed())
+		flags.Register(fs)
+		Expect(fs.Parse([]string{"-ca-key-password=secret"})).To(Succeed())
+		loaded, err := flags.LoadCA(ctx, pkg.DataDir(dir))
+		Expect(err).To(BeNil())
+		defer loaded.Close()
+		Expect(loaded.Certificate.Equal(ca.Certificate)).To(BeTrue())
+	})
+})
@@ -0,0 +1,87 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+
+	"github.com/bborbe/errors"
+)
+
+//counterfeiter:generate -o ../mocks/ca-store.go --fake-name CAStore . CAStore
+
+// CAStore loads and saves the CA used for issuance.
+type CAStore interface {
+	Load(ctx context.Context) (*CA, error)
+	Save(ctx context.Context, ca *CA) error
+}
+
+// NewFileCAStore returns a CAStore reading and writing PEM files.
+func NewFileCAStore(certPath string, keyPath string) CAStore {
+	return &fileCAStore{
+		certPath: certPath,
+		keyPath:  keyPath,
+	}
+}
+
+type fileCAStore struct {
+	certPath string
+	keyPath  string
+}
+
+func (f *fileCAStore) Load(ctx context.Context) (*CA, error) {
+	return LoadCA(ctx, f.certPath, f.keyPath)
+}
+
+func (f *fileCAStore) Save(ctx context.Context, ca *CA) error {
+	return WriteCA(ctx, ca, f.certPath, f.keyPath)
+}
+
+// NewMemoryCAStore returns a CAStore holding the CA in memory.
+func NewMemoryCAStore(ca *CA) CAStore {
+	return &memoryCAStore{
+		ca: ca,
+	}
+}
+
+type memoryCAStore struct {
+	ca *CA
+}
+
+func (m *memoryCAStore) Load(ctx context.Context) (*CA, error) {
+	if m.ca == nil {
+		return nil, errors.Errorf(ctx, "ca not found")
+	}
+	return m.ca, nil
+}
+
+func (m *memoryCAStore) Save(ctx context.Context, ca *CA) error {
+	m.ca = ca
+	return nil
+}
+
+// NewDataDirCAStore returns a CAStore loading the CA with the signer of config and configuring it
+// from the DataDir on every Load, so a rotated CA is picked up. Save is not supported.
+func NewDataDirCAStore(dataDir DataDir, certPath string, config SignerConfig) CAStore {
+	return &dataDirCAStore{
+		dataDir:  dataDir,
+		certPath: certPath,
+		config:   config,
+	}
+}
+
+type dataDirCAStore struct {
+	dataDir  DataDir
+	certPath string
+	config   SignerConfig
+}
+
+func (d *dataDirCAStore) Load(ctx context.Context) (*CA, error) {
+	return d.dataDir.LoadCA(ctx, d.certPath, d.config)
+}
+
+func (d *dataDirCAStore) Save(ctx context.Context, ca *CA) error {
+	return errors.Errorf(ctx, "ca store of %s is read only", d.certPath)
+}
@@ -0,0 +1,227 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"time"
+
+	"github.com/bborbe/errors"
+)
+
+// CA is an in-memory certificate authority used to sign certificates.
+type CA struct {
+	Certificate *x509.Certificate
+	Signer      crypto.Signer
+	// Policy checked before signing leaf certificates, unrestricted if nil.
+	Policy *Policy
+	// CrossCertificates contain the CA key signed by other CAs and are sent as intermediates,
+	// so verifiers trusting only one of those CAs accept the chain.
+	CrossCertificates []*x509.Certificate
+	// Issuers are the certificates of the CAs above an intermediate up to the root. Their name constraints
+	// are checked before signing, all but a self-signed root are sent as intermediates.
+	Issuers []*x509.Certificate
+}
+
+// Close releases the signer, e.g. the PKCS#11 session. The CA can not sign afterwards.
+func (c *CA) Close() error {
+	return CloseSigner(c.Signer)
+}
+
+// CARequest describes the CA certificate to create.
+type CARequest struct {
+	Subject  pkix.Name
+	Validity time.Duration
+	// MaxPathLen limits the number of intermediate CAs below this CA, unlimited if nil.
+	MaxPathLen *int
+	// NameConstraints restrict the names of all certificates below this CA.
+	NameConstraints NameConstraints
+}
+
+// DefaultCARequest returns the request used by GenerateCaCerts.
+func DefaultCARequest() CARequest {
+	return CARequest{
+		Subject: pkix.Name{
+			Organization:  []string{"My CA Organization"},
+			Country:       []string{"US"},
+			Province:      []string{"California"},
+			Locality:      []string{"San Francisco"},
+			StreetAddress: []string{"123 CA Street"},
+			PostalCode:    []string{"94111"},
+		},
+		Validity: 10 * 365 * 24 * time.Hour, // 10 years
+	}
+}
+
+// CreateCA generates a new private key and a self-signed CA certificate.
+func CreateCA(ctx context.Context, req CARequest) (*CA, error) {
+	priv, err := generateKey(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "generate key failed")
+	}
+	return CreateCAWithSigner(ctx, req, priv)
+}
+
+// CreateCAWithSigner creates a self-signed CA certificate for an existing key,
+// e.g. a key generated inside a PKCS#11 token.
+func CreateCAWithSigner(ctx context.Context, req CARequest, priv crypto.Signer) (*CA, error) {
+	return createCA(ctx, req, nil, priv)
+}
+
+// CreateIntermediateCA generates a new private key and a CA certificate signed by parent.
+func CreateIntermediateCA(ctx context.Context, parent *CA, req CARequest) (*CA, error) {
+	priv, err := generateKey(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "generate key failed")
+	}
+	return SignIntermediateCA(ctx, parent, req, priv)
+}
+
+// SignIntermediateCA creates a CA certificate for an existing key signed by parent.
+func SignIntermediateCA(ctx context.Context, parent *CA, req CARequest, priv crypto.Signer) (*CA, error) {
+	return createCA(ctx, req, parent, priv)
+}
+
+func createCA(ctx context.Context, req CARequest, parent *CA, priv crypto.Signer) (*CA, error) {
+	if req.Validity <= 0 {
+		return nil, errors.Errorf(ctx, "ca validity must be positive")
+	}
+	serialNumber, err := newSerialNumber(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "generate serial number failed")
+	}
+
+	notBefore := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               req.Subject,
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(req.Validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if parent != nil {
+		if err := parent.checkIntermediateNameConstraints(ctx, req.NameConstraints); err != nil {
+			return nil, err
+		}
+	}
+	if req.MaxPathLen != nil {
+		template.MaxPathLen = *req.MaxPathLen
+		template.MaxPathLenZero = *req.MaxPathLen == 0
+	}
+	if err := req.NameConstraints.apply(ctx, template); err != nil {
+		return nil, errors.Wrapf(ctx, err, "invalid name constraints")
+	}
+
+	// Self-sign the CA certificate if no parent is given
+	issuerCert, issuerSigner := template, priv
+	if parent != nil {
+		issuerCert, issuerSigner = parent.Certificate, parent.Signer
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuerCert, priv.Public(), issuerSigner)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "create ca certificate failed")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "parse ca certificate failed")
+	}
+	ca := &CA{
+		Certificate: cert,
+		Signer:      priv,
+	}
+	if parent != nil {
+		ca.Issuers = append([]*x509.Certificate{parent.Certificate}, parent.Issuers...)
+	}
+	return ca, nil
+}
+
+// ParseCA parses a CA from PEM encoded certificate and private key.
+func ParseCA(ctx context.Context, certPEM []byte, keyPEM []byte) (*CA, error) {
+	cert, err := ParseCertificatePEM(ctx, certPEM)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "parse ca certificate failed")
+	}
+	signer, err := ParsePrivateKeyPEM(ctx, keyPEM)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "parse ca key failed")
+	}
+	return &CA{
+		Certificate: cert,
+		Signer:      signer,
+	}, nil
+}
+
+// CertificatePEM returns the PEM encoded CA certificate.
+func (c *CA) CertificatePEM() []byte {
+	return EncodeCertificatePEM(c.Certificate)
+}
+
+// Intermediates returns the CA certificate and its issuers without a self-signed root,
+// followed by the cross certificates.
+func (c *CA) Intermediates() []*x509.Certificate {
+	var result []*x509.Certificate
+	for _, cert := range append([]*x509.Certificate{c.Certificate}, c.Issuers...) {
+		if !isSelfSigned(cert) {
+			result = append(result, cert)
+		}
+	}
+	return append(result, c.CrossCertificates...)
+}
+
+// Root returns the self-signed root of the CA, the topmost known issuer if the root is not loaded.
+func (c *CA) Root() *x509.Certificate {
+	chain := append([]*x509.Certificate{c.Certificate}, c.Issuers...)
+	for _, cert := range chain {
+		if isSelfSigned(cert) {
+			return cert
+		}
+	}
+	return chain[len(chain)-1]
+}
+
+// constrainingCertificates returns all certificates whose name constraints verifiers apply to certificates of the CA:
+// the CA certificate, its issuers up to the root and the cross certificates.
+func (c *CA) constrainingCertificates() []*x509.Certificate {
+	result := append([]*x509.Certificate{c.Certificate}, c.Issuers...)
+	return append(result, c.CrossCertificates...)
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// PrivateKeyPEM returns the PEM encoded CA private key.
+func (c *CA) PrivateKeyPEM(ctx context.Context) ([]byte, error) {
+	return EncodePrivateKeyPEM(ctx, c.Signer)
+}
+
+// WriteCA writes certificate and private key of the given CA to files.
+func WriteCA(ctx context.Context, ca *CA, certPath string, keyPath string) error {
+	return WriteKeyPair(ctx, &KeyPair{Certificate: ca.Certificate, PrivateKey: ca.Signer}, certPath, keyPath)
+}
+
+// WriteEncryptedCA writes the CA certificate and the private key encrypted with password.
+// Both files are written before either is replaced, the key first like WriteKeyPair.
+func WriteEncryptedCA(ctx context.Context, ca *CA, certPath string, keyPath string, password []byte) error {
+	keyPEM, err := EncryptPrivateKeyPEM(ctx, ca.Signer, password)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "encrypt key failed")
+	}
+	if err := writeFilesAtomic(
+		ctx,
+		atomicFile{path: keyPath, data: keyPEM, perm: PrivateKeyFileMode},
+		atomicFile{path: certPath, data: ca.CertificatePEM(), perm: CertificateFileMode},
+	); err != nil {
+		return errors.Wrapf(ctx, err, "write ca failed")
+	}
+	return nil
+}
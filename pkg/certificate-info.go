@@ -0,0 +1,248 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/bborbe/errors"
+)
+
+// CertificateInfo contains the human relevant fields of a certificate.
+type CertificateInfo struct {
+	Source            string           `json:"source,omitempty"`
+	Subject           string           `json:"subject"`
+	Issuer            string           `json:"issuer"`
+	SerialNumber      string           `json:"serialNumber"`
+	NotBefore         time.Time        `json:"notBefore"`
+	NotAfter          time.Time        `json:"notAfter"`
+	Expired           bool             `json:"expired"`
+	RemainingLifetime string           `json:"remainingLifetime"`
+	KeyType           string           `json:"keyType"`
+	KeySize           int              `json:"keySize"`
+	DNSNames          []string         `json:"dnsNames,omitempty"`
+	IPAddresses       []string         `json:"ipAddresses,omitempty"`
+	EmailAddresses    []string         `json:"emailAddresses,omitempty"`
+	URIs              []string         `json:"uris,omitempty"`
+	KeyUsages         []string         `json:"keyUsages,omitempty"`
+	ExtKeyUsages      []string         `json:"extKeyUsages,omitempty"`
+	IsCA              bool             `json:"isCA"`
+	MaxPathLen        *int             `json:"maxPathLen,omitempty"`
+	NameConstraints   *NameConstraints `json:"nameConstraints,omitempty"`
+	SHA256Fingerprint string           `json:"sha256Fingerprint"`
+	SHA1Fingerprint   string           `json:"sha1Fingerprint"`
+}
+
+// NewCertificateInfo collects the CertificateInfo of cert relative to now.
+func NewCertificateInfo(source string, cert *x509.Certificate, now time.Time) CertificateInfo {
+	keyType, keySize := DescribePublicKey(cert.PublicKey)
+	info := CertificateInfo{
+		Source:            source,
+		Subject:           cert.Subject.String(),
+		Issuer:            cert.Issuer.String(),
+		SerialNumber:      FormatHex(cert.SerialNumber.Bytes()),
+		NotBefore:         cert.NotBefore,
+		NotAfter:          cert.NotAfter,
+		Expired:           now.After(cert.NotAfter),
+		RemainingLifetime: FormatRemainingLifetime(cert.NotAfter.Sub(now)),
+		KeyType:           keyType,
+		KeySize:           keySize,
+		DNSNames:          cert.DNSNames,
+		EmailAddresses:    cert.EmailAddresses,
+		KeyUsages:         KeyUsageNames(cert.KeyUsage),
+		ExtKeyUsages:      ExtKeyUsageNames(cert.ExtKeyUsage),
+		IsCA:              cert.IsCA,
+		SHA256Fingerprint: SHA256Fingerprint(cert),
+		SHA1Fingerprint:   sha1Fingerprint(cert),
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		info.URIs = append(info.URIs, uri.String())
+	}
+	if cert.BasicConstraintsValid && cert.IsCA && (cert.MaxPathLen > 0 || cert.MaxPathLenZero) {
+		maxPathLen := cert.MaxPathLen
+		info.MaxPathLen = &maxPathLen
+	}
+	if nameConstraints := NameConstraintsOfCertificate(cert); !nameConstraints.IsEmpty() {
+		info.NameConstraints = &nameConstraints
+	}
+	return info
+}
+
+// WriteText writes the info in a human readable form.
+func (c CertificateInfo) WriteText(w io.Writer) error {
+	var lines []string
+	add := func(name string, value string) {
+		lines = append(lines, fmt.Sprintf("%-20s %s", name+":", value))
+	}
+	if c.Source != "" {
+		add("Source", c.Source)
+	}
+	add("Subject", c.Subject)
+	add("Issuer", c.Issuer)
+	add("Serial", c.SerialNumber)
+	add("Not Before", c.NotBefore.UTC().Format(time.RFC3339))
+	add("Not After", c.NotAfter.UTC().Format(time.RFC3339))
+	add("Remaining", c.RemainingLifetime)
+	add("Key", fmt.Sprintf("%s %d bit", c.KeyType, c.KeySize))
+	add("DNS Names", strings.Join(c.DNSNames, ", "))
+	add("IP Addresses", strings.Join(c.IPAddresses, ", "))
+	add("Email Addresses", strings.Join(c.EmailAddresses, ", "))
+	add("URIs", strings.Join(c.URIs, ", "))
+	add("Key Usages", strings.Join(c.KeyUsages, ", "))
+	add("Ext Key Usages", strings.Join(c.ExtKeyUsages, ", "))
+	basicConstraints := fmt.Sprintf("CA:%t", c.IsCA)
+	if c.MaxPathLen != nil {
+		basicConstraints += fmt.Sprintf(", pathlen:%d", *c.MaxPathLen)
+	}
+	add("Basic Constraints", basicConstraints)
+	if c.NameConstraints != nil {
+		add("Name Constraints", c.NameConstraints.String())
+	}
+	add("SHA256 Fingerprint", c.SHA256Fingerprint)
+	add("SHA1 Fingerprint", c.SHA1Fingerprint)
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+// ParseCertificates parses all certificates in data, which is either PEM with one or more
+// CERTIFICATE blocks or a single DER encoded certificate.
+func ParseCertificates(ctx context.Context, data []byte) ([]*x509.Certificate, error) {
+	var result []*x509.Certificate
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != pemTypeCertificate {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "parse certificate failed")
+		}
+		result = append(result, cert)
+	}
+	if len(result) > 0 {
+		return result, nil
+	}
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "no pem certificate found and parse as der failed")
+	}
+	return []*x509.Certificate{cert}, nil
+}
+
+// DescribePublicKey returns algorithm and size in bits of the given public key.
+func DescribePublicKey(publicKey interface{}) (string, int) {
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		return "ECDSA " + key.Curve.Params().Name, key.Curve.Params().BitSize
+	case *rsa.PublicKey:
+		return "RSA", key.N.BitLen()
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	default:
+		return fmt.Sprintf("%T", publicKey), 0
+	}
+}
+
+// SHA256Fingerprint returns the SHA-256 fingerprint of the DER encoded certificate.
+func SHA256Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return FormatHex(sum[:])
+}
+
+func sha1Fingerprint(cert *x509.Certificate) string {
+	sum := sha1.Sum(cert.Raw)
+	return FormatHex(sum[:])
+}
+
+// FormatHex formats bytes as colon separated upper case hex like openssl.
+func FormatHex(data []byte) string {
+	parts := make([]string, len(data))
+	for i, b := range data {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// FormatRemainingLifetime formats the given duration in days and hours.
+func FormatRemainingLifetime(d time.Duration) string {
+	if d < 0 {
+		return fmt.Sprintf("expired %s ago", formatDays(-d))
+	}
+	return formatDays(d)
+}
+
+func formatDays(d time.Duration) string {
+	days := int(d / (24 * time.Hour))
+	hours := int((d % (24 * time.Hour)) / time.Hour)
+	return fmt.Sprintf("%dd %dh", days, hours)
+}
+
+var keyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "Digital Signature"},
+	{x509.KeyUsageContentCommitment, "Content Commitment"},
+	{x509.KeyUsageKeyEncipherment, "Key Encipherment"},
+	{x509.KeyUsageDataEncipherment, "Data Encipherment"},
+	{x509.KeyUsageKeyAgreement, "Key Agreement"},
+	{x509.KeyUsageCertSign, "Certificate Sign"},
+	{x509.KeyUsageCRLSign, "CRL Sign"},
+	{x509.KeyUsageEncipherOnly, "Encipher Only"},
+	{x509.KeyUsageDecipherOnly, "Decipher Only"},
+}
+
+// KeyUsageNames returns the names of all bits set in usage.
+func KeyUsageNames(usage x509.KeyUsage) []string {
+	var result []string
+	for _, k := range keyUsageNames {
+		if usage&k.usage != 0 {
+			result = append(result, k.name)
+		}
+	}
+	return result
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "Any",
+	x509.ExtKeyUsageServerAuth:      "TLS Web Server Authentication",
+	x509.ExtKeyUsageClientAuth:      "TLS Web Client Authentication",
+	x509.ExtKeyUsageCodeSigning:     "Code Signing",
+	x509.ExtKeyUsageEmailProtection: "E-mail Protection",
+	x509.ExtKeyUsageTimeStamping:    "Time Stamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSP Signing",
+}
+
+// ExtKeyUsageNames returns the names of the given extended key usages.
+func ExtKeyUsageNames(usages []x509.ExtKeyUsage) []string {
+	var result []string
+	for _, usage := range usages {
+		name, ok := extKeyUsageNames[usage]
+		if !ok {
+			name = fmt.Sprintf("Unknown (%d)", usage)
+		}
+		result = append(result, name)
+	}
+	return result
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CertificateInfo", func() {
+	var ctx context.Context
+	var ca *pkg.CA
+	var keyPair *pkg.KeyPair
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		ca, err = pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		keyPair, err = pkg.IssueCertificate(ctx, ca, pkg.DefaultServerIssueRequest())
+		Expect(err).To(BeNil())
+	})
+	It("parses pem bundle", func() {
+		certs, err := pkg.ParseCertificates(ctx, append(keyPair.CertificatePEM(), ca.CertificatePEM()...))
+		Expect(err).To(BeNil())
+		Expect(certs).To(HaveLen(2))
+	})
+	It("parses der", func() {
+		certs, err := pkg.ParseCertificates(ctx, keyPair.Certificate.Raw)
+		Expect(err).To(BeNil())
+		Expect(certs).To(HaveLen(1))
+	})
+	It("returns error for garbage", func() {
+		_, err := pkg.ParseCertificates(ctx, []byte("banana"))
+		Expect(err).NotTo(BeNil())
+	})
+	It("describes certificate", func() {
+		info := pkg.NewCertificateInfo("server_cert.pem", keyPair.Certificate, keyPair.Certificate.NotAfter.Add(time.Hour))
+		Expect(info.Subject).To(Equal("CN=localhost,O=My Server Organization"))
+		Expect(info.KeyType).To(Equal("ECDSA P-256"))
+		Expect(info.KeySize).To(Equal(256))
+		Expect(info.DNSNames).To(Equal([]string{"localhost"}))
+		Expect(info.ExtKeyUsages).To(Equal([]string{"TLS Web Server Authentication"}))
+		Expect(info.Expired).To(BeTrue())
+		Expect(info.RemainingLifetime).To(Equal("expired 0d 1h ago"))
+	})
+})
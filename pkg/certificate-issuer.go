@@ -0,0 +1,60 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+
+	"github.com/bborbe/errors"
+)
+
+//counterfeiter:generate -o ../mocks/certificate-issuer.go --fake-name CertificateIssuer . CertificateIssuer
+
+// CertificateIssuer issues leaf certificates signed by a CA.
+type CertificateIssuer interface {
+	// Issue generates a new private key and a certificate for it.
+	Issue(ctx context.Context, req IssueRequest) (*KeyPair, error)
+	// Sign creates a certificate for an existing public key.
+	Sign(ctx context.Context, req IssueRequest, publicKey crypto.PublicKey) (*x509.Certificate, error)
+}
+
+// NewCertificateIssuer returns a CertificateIssuer loading the CA from caStore
+// and creating private keys with keyGenerator.
+func NewCertificateIssuer(caStore CAStore, keyGenerator KeyGenerator) CertificateIssuer {
+	return &certificateIssuer{
+		caStore:      caStore,
+		keyGenerator: keyGenerator,
+	}
+}
+
+type certificateIssuer struct {
+	caStore      CAStore
+	keyGenerator KeyGenerator
+}
+
+func (c *certificateIssuer) Issue(ctx context.Context, req IssueRequest) (*KeyPair, error) {
+	priv, err := c.keyGenerator.GenerateKey(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "generate key failed")
+	}
+	cert, err := c.Sign(ctx, req, priv.Public())
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "sign failed")
+	}
+	return &KeyPair{
+		Certificate: cert,
+		PrivateKey:  priv,
+	}, nil
+}
+
+func (c *certificateIssuer) Sign(ctx context.Context, req IssueRequest, publicKey crypto.PublicKey) (*x509.Certificate, error) {
+	ca, err := c.caStore.Load(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "load ca failed")
+	}
+	return SignCertificate(ctx, ca, req, publicKey)
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	stderrors "errors"
+
+	"github.com/bborbe/sample_cert/mocks"
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CertificateIssuer", func() {
+	var ctx context.Context
+	var caStore *mocks.CAStore
+	var keyGenerator *mocks.KeyGenerator
+	var certificateIssuer pkg.CertificateIssuer
+	var keyPair *pkg.KeyPair
+	var err error
+	BeforeEach(func() {
+		ctx = context.Background()
+		ca, err := pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		caStore = &mocks.CAStore{}
+		caStore.LoadReturns(ca, nil)
+		keyGenerator = &mocks.KeyGenerator{}
+		key, err := pkg.NewKeyGenerator(pkg.KeyTypeEd25519).GenerateKey(ctx)
+		Expect(err).To(BeNil())
+		keyGenerator.GenerateKeyReturns(key, nil)
+		certificateIssuer = pkg.NewCertificateIssuer(caStore, keyGenerator)
+	})
+	JustBeforeEach(func() {
+		keyPair, err = certificateIssuer.Issue(ctx, pkg.DefaultClientIssueRequest())
+	})
+	It("returns no error", func() {
+		Expect(err).To(BeNil())
+	})
+	It("uses key from generator", func() {
+		Expect(keyGenerator.GenerateKeyCallCount()).To(Equal(1))
+		Expect(keyPair.Certificate.PublicKey).To(Equal(keyPair.PrivateKey.Public()))
+	})
+	It("loads ca from store", func() {
+		Expect(caStore.LoadCallCount()).To(Equal(1))
+	})
+	Context("load ca fails", func() {
+		BeforeEach(func() {
+			caStore.LoadReturns(nil, stderrors.New("banana"))
+		})
+		It("returns error", func() {
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
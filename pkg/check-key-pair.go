@@ -0,0 +1,60 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"os"
+
+	"github.com/bborbe/errors"
+)
+
+// CheckKeyPairFiles loads certificate and private key from files and checks they belong together.
+func CheckKeyPairFiles(ctx context.Context, certPath string, keyPath string) error {
+	cert, err := LoadCertificate(ctx, certPath)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "load certificate failed")
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "read %s failed", keyPath)
+	}
+	key, err := ParsePrivateKeyPEM(ctx, keyPEM)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "parse %s failed", keyPath)
+	}
+	if err := CheckKeyPair(ctx, cert, key); err != nil {
+		return errors.Wrapf(ctx, err, "%s does not match %s", keyPath, certPath)
+	}
+	return nil
+}
+
+// CheckKeyPair returns an error if the private key does not belong to the certificate.
+func CheckKeyPair(ctx context.Context, cert *x509.Certificate, key crypto.Signer) error {
+	if !publicKeyEqual(key.Public(), cert.PublicKey) {
+		keyType, keySize := DescribePublicKey(key.Public())
+		certKeyType, certKeySize := DescribePublicKey(cert.PublicKey)
+		return errors.Errorf(ctx, "private key (%s %d bit) does not match public key (%s %d bit) of certificate '%s'", keyType, keySize, certKeyType, certKeySize, cert.Subject.String())
+	}
+	return nil
+}
+
+// CheckCertificateRequest returns an error if the certificate was not issued for the given CSR.
+func CheckCertificateRequest(ctx context.Context, cert *x509.Certificate, csr *x509.CertificateRequest) error {
+	if err := csr.CheckSignature(); err != nil {
+		return errors.Wrapf(ctx, err, "csr signature is invalid")
+	}
+	if !publicKeyEqual(csr.PublicKey, cert.PublicKey) {
+		return errors.Errorf(ctx, "public key of csr '%s' does not match public key of certificate '%s'", csr.Subject.String(), cert.Subject.String())
+	}
+	return nil
+}
+
+func publicKeyEqual(a crypto.PublicKey, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
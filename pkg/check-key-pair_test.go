@@ -0,0 +1,59 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CheckKeyPair", func() {
+	var ctx context.Context
+	var ca *pkg.CA
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		ca, err = pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+	})
+	DescribeTable("key types",
+		func(keyType pkg.KeyType) {
+			issuer := pkg.NewCertificateIssuer(pkg.NewMemoryCAStore(ca), pkg.NewKeyGenerator(keyType))
+			keyPair, err := issuer.Issue(ctx, pkg.DefaultServerIssueRequest())
+			Expect(err).To(BeNil())
+			Expect(pkg.CheckKeyPair(ctx, keyPair.Certificate, keyPair.PrivateKey)).To(Succeed())
+
+			other, err := pkg.NewKeyGenerator(keyType).GenerateKey(ctx)
+			Expect(err).To(BeNil())
+			Expect(pkg.CheckKeyPair(ctx, keyPair.Certificate, other)).NotTo(Succeed())
+		},
+		Entry("ecdsa p256", pkg.KeyTypeECDSAP256),
+		Entry("ecdsa p384", pkg.KeyTypeECDSAP384),
+		Entry("rsa 2048", pkg.KeyTypeRSA2048),
+		Entry("ed25519", pkg.KeyTypeEd25519),
+	)
+	It("checks certificate against csr", func() {
+		key, err := pkg.NewKeyGenerator(pkg.KeyTypeECDSAP256).GenerateKey(ctx)
+		Expect(err).To(BeNil())
+		der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "localhost"}}, key)
+		Expect(err).To(BeNil())
+		csr, err := x509.ParseCertificateRequest(der)
+		Expect(err).To(BeNil())
+
+		cert, err := pkg.SignCertificate(ctx, ca, pkg.DefaultServerIssueRequest(), csr.PublicKey)
+		Expect(err).To(BeNil())
+		Expect(pkg.CheckCertificateRequest(ctx, cert, csr)).To(Succeed())
+
+		keyPair, err := pkg.IssueCertificate(ctx, ca, pkg.DefaultServerIssueRequest())
+		Expect(err).To(BeNil())
+		Expect(pkg.CheckCertificateRequest(ctx, keyPair.Certificate, csr)).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,190 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bborbe/errors"
+)
+
+const pemTypeCRL = "X509 CRL"
+
+// crlValidity is the time until the next update of published CRLs.
+const crlValidity = 7 * 24 * time.Hour
+
+// generationCRLFile is the CRL of a previous CA generation inside its ca-g<n>/ directory.
+const generationCRLFile = "crl.pem"
+
+// revocationReasons are the CRL reason codes of RFC 5280 section 5.3.1.
+// removeFromCRL is left out, it is only valid in delta CRLs.
+var revocationReasons = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"cACompromise":         2,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+	"certificateHold":      6,
+	"privilegeWithdrawn":   9,
+	"aACompromise":         10,
+}
+
+// ParseRevocationReason returns the reason code of an RFC 5280 reason name like keyCompromise or code like 1.
+// An empty value is unspecified.
+func ParseRevocationReason(ctx context.Context, value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	if reason, err := strconv.Atoi(value); err == nil {
+		return reason, ValidateRevocationReason(ctx, reason)
+	}
+	for name, reason := range revocationReasons {
+		if strings.EqualFold(name, value) {
+			return reason, nil
+		}
+	}
+	return 0, errors.Errorf(ctx, "unknown revocation reason '%s'", value)
+}
+
+// ValidateRevocationReason returns an error if reason is no RFC 5280 reason code usable in a CRL.
+func ValidateRevocationReason(ctx context.Context, reason int) error {
+	for _, code := range revocationReasons {
+		if code == reason {
+			return nil
+		}
+	}
+	return errors.Errorf(ctx, "invalid revocation reason %d", reason)
+}
+
+// CreateCRL returns a PEM encoded CRL signed by ca listing all revoked inventory entries issued by ca.
+// Entries are assigned by their authority key id, entries without one are listed as well.
+func CreateCRL(ctx context.Context, ca *CA, entries []InventoryEntry, now time.Time, validity time.Duration) ([]byte, error) {
+	keyID := FormatHex(ca.Certificate.SubjectKeyId)
+	var revoked []x509.RevocationListEntry
+	for _, entry := range entries {
+		if !entry.Revoked() || (entry.AuthorityKeyID != "" && entry.AuthorityKeyID != keyID) {
+			continue
+		}
+		serialNumber, err := ParseSerialNumber(ctx, entry.SerialNumber)
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "parse serial failed")
+		}
+		revoked = append(revoked, x509.RevocationListEntry{
+			SerialNumber:   serialNumber,
+			RevocationTime: *entry.RevokedAt,
+			ReasonCode:     entry.RevocationReason,
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		RevokedCertificateEntries: revoked,
+		Number:                    big.NewInt(now.UnixNano()),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(validity),
+	}, ca.Certificate, ca.Signer)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "create revocation list failed")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemTypeCRL, Bytes: der}), nil
+}
+
+// ParseSerialNumber parses a hex serial number with or without colons.
+func ParseSerialNumber(ctx context.Context, value string) (*big.Int, error) {
+	serialNumber, ok := new(big.Int).SetString(normalizeSerialNumber(value), 16)
+	if !ok {
+		return nil, errors.Errorf(ctx, "invalid serial number '%s'", value)
+	}
+	return serialNumber, nil
+}
+
+//counterfeiter:generate -o ../mocks/crl-publisher.go --fake-name CRLPublisher . CRLPublisher
+
+// CRLPublisher writes the CRLs after a revocation.
+type CRLPublisher interface {
+	Publish(ctx context.Context, entries []InventoryEntry, now time.Time) error
+}
+
+// NewDataDirCRLPublisher returns a CRLPublisher writing the CRL of ca to ca_crl.pem and the CRL of every previous
+// CA generation to ca-g<n>/crl.pem, each listing the revoked entries issued by that generation.
+// Keys of previous generations are loaded from their files with keyPassword.
+func NewDataDirCRLPublisher(dataDir DataDir, ca *CA, keyPassword []byte) CRLPublisher {
+	return &dataDirCRLPublisher{
+		dataDir:     dataDir,
+		ca:          ca,
+		keyPassword: keyPassword,
+	}
+}
+
+type dataDirCRLPublisher struct {
+	dataDir     DataDir
+	ca          *CA
+	keyPassword []byte
+}
+
+func (d *dataDirCRLPublisher) Publish(ctx context.Context, entries []InventoryEntry, now time.Time) error {
+	if err := d.write(ctx, d.ca, CACRLFile, entries, now); err != nil {
+		return err
+	}
+	generations, err := d.dataDir.CAGenerations(ctx)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "load ca generations failed")
+	}
+	if generations == nil {
+		return nil
+	}
+	for _, generation := range generations.Generations {
+		if generation.Status != CAGenerationStatusPrevious || generation.SubjectKeyID == FormatHex(d.ca.Certificate.SubjectKeyId) {
+			continue
+		}
+		if err := d.publishGeneration(ctx, generation, entries, now); err != nil {
+			return errors.Wrapf(ctx, err, "publish crl of generation %d failed", generation.Generation)
+		}
+	}
+	return nil
+}
+
+func (d *dataDirCRLPublisher) publishGeneration(ctx context.Context, generation CAGeneration, entries []InventoryEntry, now time.Time) error {
+	if generation.KeyPath == "" {
+		return errors.Errorf(ctx, "key of generation %d is not stored in a file", generation.Generation)
+	}
+	certPath, err := d.dataDir.Path(ctx, generation.CertPath)
+	if err != nil {
+		return err
+	}
+	keyPath, err := d.dataDir.Path(ctx, generation.KeyPath)
+	if err != nil {
+		return err
+	}
+	ca, err := LoadCAWithSignerConfig(ctx, certPath, SignerConfig{KeyPath: keyPath, KeyPassword: string(d.keyPassword)})
+	if err != nil {
+		return errors.Wrapf(ctx, err, "load ca failed")
+	}
+	defer ca.Close()
+	return d.write(ctx, ca, path.Join(generationDir(generation.Generation), generationCRLFile), entries, now)
+}
+
+func (d *dataDirCRLPublisher) write(ctx context.Context, ca *CA, name string, entries []InventoryEntry, now time.Time) error {
+	crl, err := CreateCRL(ctx, ca, entries, now, crlValidity)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "create crl failed")
+	}
+	crlPath, err := d.dataDir.Path(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err := WriteCertificateFile(ctx, crlPath, crl); err != nil {
+		return errors.Wrapf(ctx, err, "write crl failed")
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bborbe/errors"
+)
+
+// Well known files inside a DataDir.
+const (
+	CACertFile     = "ca_cert.pem"
+	CAKeyFile      = "ca_key.pem"
+	CACRLFile      = "ca_crl.pem"
+	ServerCertFile = "server_cert.pem"
+	ServerKeyFile  = "server_key.pem"
+	ClientCertFile = "client_cert.pem"
+	ClientKeyFile  = "client_key.pem"
+	InventoryFile  = "inventory.json"
+)
+
+// Files inside the directory of a named identity.
+const (
+	NamedCertFile  = "cert.pem"
+	NamedKeyFile   = "key.pem"
+	NamedChainFile = "chain.pem"
+)
+
+// IdentityPaths are the files of one certificate.
+// An empty ChainPath means no chain file is written.
+type IdentityPaths struct {
+	CertPath  string
+	KeyPath   string
+	ChainPath string
+}
+
+// NewIdentityPaths returns <name>/cert.pem, <name>/key.pem and <name>/chain.pem.
+func NewIdentityPaths(name string) IdentityPaths {
+	return IdentityPaths{
+		CertPath:  path.Join(name, NamedCertFile),
+		KeyPath:   path.Join(name, NamedKeyFile),
+		ChainPath: path.Join(name, NamedChainFile),
+	}
+}
+
+// DataDir is the directory holding CA, certificates and keys.
+type DataDir string
+
+// Path returns the absolute path of name inside the DataDir.
+// Absolute names are returned unchanged.
+func (d DataDir) Path(ctx context.Context, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+	result, err := filepath.Abs(path.Join(string(d), name))
+	if err != nil {
+		return "", errors.Wrapf(ctx, err, "abs path of %s failed", name)
+	}
+	return result, nil
+}
+
+// IdentityPaths resolves each file in order from explicit, the named layout if name is set, or fallback.
+// The returned paths are absolute.
+func (d DataDir) IdentityPaths(ctx context.Context, name string, explicit IdentityPaths, fallback IdentityPaths) (IdentityPaths, error) {
+	if name != "" {
+		if err := validateIdentityName(ctx, name); err != nil {
+			return IdentityPaths{}, err
+		}
+		fallback = NewIdentityPaths(name)
+	}
+	var result IdentityPaths
+	var err error
+	if result.CertPath, err = d.optionalPath(ctx, explicit.CertPath, fallback.CertPath); err != nil {
+		return IdentityPaths{}, err
+	}
+	if result.KeyPath, err = d.optionalPath(ctx, explicit.KeyPath, fallback.KeyPath); err != nil {
+		return IdentityPaths{}, err
+	}
+	if result.ChainPath, err = d.optionalPath(ctx, explicit.ChainPath, fallback.ChainPath); err != nil {
+		return IdentityPaths{}, err
+	}
+	return result, nil
+}
+
+// ServerIdentity resolves the certificate and key of a server like IdentityPaths with server_cert.pem and
+// server_key.pem as fallback and checks they belong together, so the server fails at start instead of failing every handshake.
+func (d DataDir) ServerIdentity(ctx context.Context, name string, explicit IdentityPaths) (IdentityPaths, error) {
+	paths, err := d.IdentityPaths(ctx, name, explicit, IdentityPaths{CertPath: ServerCertFile, KeyPath: ServerKeyFile})
+	if err != nil {
+		return IdentityPaths{}, errors.Wrapf(ctx, err, "generate server paths failed")
+	}
+	if err := CheckKeyPairFiles(ctx, paths.CertPath, paths.KeyPath); err != nil {
+		return IdentityPaths{}, errors.Wrapf(ctx, err, "check server cert and key failed")
+	}
+	return paths, nil
+}
+
+func (d DataDir) optionalPath(ctx context.Context, value string, fallback string) (string, error) {
+	if value == "" {
+		value = fallback
+	}
+	if value == "" {
+		return "", nil
+	}
+	return d.Path(ctx, value)
+}
+
+// validateIdentityName rejects names escaping the DataDir.
+func validateIdentityName(ctx context.Context, name string) error {
+	if filepath.IsAbs(name) || path.Clean(name) != name || name == ".." || strings.HasPrefix(name, "../") {
+		return errors.Errorf(ctx, "invalid name '%s'", name)
+	}
+	return nil
+}
+
+// CreateParentDirs creates the missing parent directories of all given paths.
+func CreateParentDirs(ctx context.Context, paths ...string) error {
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return errors.Wrapf(ctx, err, "create directory for %s failed", path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DataDir", func() {
+	var ctx context.Context
+	var dir string
+	var dataDir pkg.DataDir
+	var fallback pkg.IdentityPaths
+	BeforeEach(func() {
+		ctx = context.Background()
+		dir = GinkgoT().TempDir()
+		dataDir = pkg.DataDir(dir)
+		fallback = pkg.IdentityPaths{CertPath: pkg.ServerCertFile, KeyPath: pkg.ServerKeyFile}
+	})
+	It("uses fallback without name", func() {
+		paths, err := dataDir.IdentityPaths(ctx, "", pkg.IdentityPaths{}, fallback)
+		Expect(err).To(BeNil())
+		Expect(paths).To(Equal(pkg.IdentityPaths{
+			CertPath: filepath.Join(dir, "server_cert.pem"),
+			KeyPath:  filepath.Join(dir, "server_key.pem"),
+		}))
+	})
+	It("uses named layout", func() {
+		paths, err := dataDir.IdentityPaths(ctx, "api", pkg.IdentityPaths{}, fallback)
+		Expect(err).To(BeNil())
+		Expect(paths).To(Equal(pkg.IdentityPaths{
+			CertPath:  filepath.Join(dir, "api", "cert.pem"),
+			KeyPath:   filepath.Join(dir, "api", "key.pem"),
+			ChainPath: filepath.Join(dir, "api", "chain.pem"),
+		}))
+	})
+	It("prefers explicit paths", func() {
+		paths, err := dataDir.IdentityPaths(ctx, "api", pkg.IdentityPaths{KeyPath: "/secret/api.key"}, fallback)
+		Expect(err).To(BeNil())
+		Expect(paths.CertPath).To(Equal(filepath.Join(dir, "api", "cert.pem")))
+		Expect(paths.KeyPath).To(Equal("/secret/api.key"))
+	})
+	DescribeTable("rejects names outside the datadir",
+		func(name string) {
+			_, err := dataDir.IdentityPaths(ctx, name, pkg.IdentityPaths{}, fallback)
+			Expect(err).NotTo(BeNil())
+		},
+		Entry("parent", ".."),
+		Entry("parent prefix", "../api"),
+		Entry("absolute", "/api"),
+		Entry("unclean", "a/../../api"),
+	)
+	It("checks the server identity", func() {
+		ca, err := pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		keyPair, err := pkg.IssueCertificate(ctx, ca, pkg.DefaultServerIssueRequest())
+		Expect(err).To(BeNil())
+		Expect(pkg.WriteKeyPair(ctx, keyPair, filepath.Join(dir, pkg.ServerCertFile), filepath.Join(dir, pkg.ServerKeyFile))).To(Succeed())
+		paths, err := dataDir.ServerIdentity(ctx, "", pkg.IdentityPaths{})
+		Expect(err).To(BeNil())
+		Expect(paths.CertPath).To(Equal(filepath.Join(dir, pkg.ServerCertFile)))
+		_, err = dataDir.ServerIdentity(ctx, "api", pkg.IdentityPaths{})
+		Expect(err).NotTo(BeNil())
+	})
+	It("writes identity with chain of intermediate", func() {
+		root, err := pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		Expect(root.Intermediates()).To(BeEmpty())
+		intermediate, err := pkg.CreateIntermediateCA(ctx, root, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		Expect(intermediate.Intermediates()).To(HaveLen(1))
+		keyPair, err := pkg.IssueCertificate(ctx, intermediate, pkg.DefaultServerIssueRequest())
+		Expect(err).To(BeNil())
+		paths, err := dataDir.IdentityPaths(ctx, "api", pkg.IdentityPaths{}, fallback)
+		Expect(err).To(BeNil())
+		Expect(pkg.WriteIdentity(ctx, keyPair, intermediate, paths)).To(Succeed())
+		data, err := os.ReadFile(paths.ChainPath)
+		Expect(err).To(BeNil())
+		chain, err := pkg.ParseCertificates(ctx, data)
+		Expect(err).To(BeNil())
+		Expect(chain).To(HaveLen(2))
+		Expect(chain[0].Equal(keyPair.Certificate)).To(BeTrue())
+		Expect(chain[1].Equal(intermediate.Certificate)).To(BeTrue())
+	})
+})
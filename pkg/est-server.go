@@ -0,0 +1,242 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/bborbe/errors"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+// ESTPathPrefix is the path all EST operations are served below (RFC 7030 section 3.2.2).
+const ESTPathPrefix = "/.well-known/est"
+
+// estMaxRequestSize limits the size of a CSR upload.
+const estMaxRequestSize = 64 * 1024
+
+// ESTServerOptions configures the EST server.
+type ESTServerOptions struct {
+	// Validity of issued certificates, the client default if zero.
+	Validity time.Duration
+	// Username and Password allow the initial enrollment with HTTP basic auth, disabled if empty.
+	Username string
+	Password string
+	// NamePatterns allow names in enrollment requests beyond the authenticated identity,
+	// e.g. device-* or *.devices.example.com. '*' matches any sequence without '/'.
+	// URI names like SPIFFE IDs are only issued if they match a pattern.
+	NamePatterns []string
+	// Inventory records issued certificates and blocks revoked ones, optional.
+	Inventory Inventory
+	// AuditLog records enrollments, optional.
+	AuditLog AuditLog
+}
+
+// NewESTServer returns an http.Handler implementing cacerts, simpleenroll and simplereenroll
+// of EST (RFC 7030) issuing client certificates signed by ca.
+// Enrollment is authenticated by a client certificate of ca or HTTP basic auth and limited to the common name
+// of the client certificate or the username plus NamePatterns, re-enrollment only by the client certificate that is renewed.
+func NewESTServer(ca *CA, options ESTServerOptions) http.Handler {
+	if options.Validity == 0 {
+		options.Validity = DefaultClientIssueRequest().Validity
+	}
+	s := &estServer{
+		ca:      ca,
+		options: options,
+	}
+	router := mux.NewRouter()
+	router.Path(ESTPathPrefix + "/cacerts").Methods(http.MethodGet).HandlerFunc(s.handleCACerts)
+	router.Path(ESTPathPrefix + "/simpleenroll").Methods(http.MethodPost).HandlerFunc(s.handleSimpleEnroll)
+	router.Path(ESTPathPrefix + "/simplereenroll").Methods(http.MethodPost).HandlerFunc(s.handleSimpleReenroll)
+	return router
+}
+
+type estServer struct {
+	ca      *CA
+	options ESTServerOptions
+}
+
+func (s *estServer) handleCACerts(resp http.ResponseWriter, req *http.Request) {
+	s.writeCertificates(req.Context(), resp, "application/pkcs7-mime", s.ca.Certificate)
+}
+
+func (s *estServer) handleSimpleEnroll(resp http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	var identity string
+	current, err := verifyClientCertificate(ctx, req, s.ca, s.options.Inventory)
+	if err == nil {
+		identity = current.Subject.CommonName
+	} else {
+		username, ok := s.basicAuth(req)
+		if !ok {
+			glog.V(2).Infof("est enroll unauthorized: %v", err)
+			if s.options.Username != "" {
+				resp.Header().Set("WWW-Authenticate", `Basic realm="est"`)
+			}
+			http.Error(resp, "client certificate or basic auth required", http.StatusUnauthorized)
+			return
+		}
+		identity = username
+	}
+	csr, err := readESTCSR(ctx, req)
+	if err != nil {
+		glog.V(2).Infof("est enroll failed: %v", err)
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.checkEnrollCSR(ctx, csr, identity, current); err != nil {
+		glog.V(2).Infof("est enroll of %s rejected: %v", identity, err)
+		http.Error(resp, err.Error(), http.StatusForbidden)
+		return
+	}
+	s.issue(ctx, resp, csr, AuditOperationIssue, "est:"+identity)
+}
+
+func (s *estServer) handleSimpleReenroll(resp http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	current, err := verifyClientCertificate(ctx, req, s.ca, s.options.Inventory)
+	if err != nil {
+		glog.V(2).Infof("est reenroll unauthorized: %v", err)
+		http.Error(resp, "client certificate required", http.StatusUnauthorized)
+		return
+	}
+	csr, err := readESTCSR(ctx, req)
+	if err != nil {
+		glog.V(2).Infof("est reenroll failed: %v", err)
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// RFC 7030 section 4.2.2, subject and subjectAltName must be identical to the current certificate
+	if err := checkESTReenrollCSR(ctx, csr, current); err != nil {
+		glog.V(2).Infof("est reenroll of %s rejected: %v", current.Subject, err)
+		http.Error(resp, err.Error(), http.StatusForbidden)
+		return
+	}
+	s.issue(ctx, resp, csr, AuditOperationRenew, "est:"+current.Subject.CommonName)
+}
+
+func (s *estServer) issue(ctx context.Context, resp http.ResponseWriter, csr *x509.CertificateRequest, operation AuditOperation, requester string) {
+	issueRequest := NewIssueRequestFromCSR(csr, ProfileClient, s.options.Validity)
+	if issueRequest.CommonName == "" {
+		http.Error(resp, "csr without common name", http.StatusBadRequest)
+		return
+	}
+	cert, err := SignCertificate(ctx, s.ca, issueRequest, csr.PublicKey)
+	if rejection := rejectionOf(err); rejection != nil {
+		glog.V(2).Infof("est enrollment of %s rejected: %v", issueRequest.CommonName, rejection)
+		http.Error(resp, rejection.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		glog.Warningf("est sign certificate failed: %v", err)
+		http.Error(resp, "sign certificate failed", http.StatusInternalServerError)
+		return
+	}
+	recorder := IssuanceRecorder{Inventory: s.options.Inventory, AuditLog: s.options.AuditLog, Requester: requester}
+	if err := recorder.Record(ctx, operation, cert, ProfileClient, "", ""); err != nil {
+		glog.Warningf("est record certificate failed: %v", err)
+		http.Error(resp, "record certificate failed", http.StatusInternalServerError)
+		return
+	}
+	glog.V(2).Infof("est issued certificate %s for %s", FormatHex(cert.SerialNumber.Bytes()), cert.Subject)
+	certs := append([]*x509.Certificate{cert}, s.ca.Intermediates()...)
+	s.writeCertificates(ctx, resp, "application/pkcs7-mime; smime-type=certs-only", certs...)
+}
+
+// checkEnrollCSR allows the authenticated identity as common name, names of the current client certificate
+// and names matching NamePatterns. URI names must match NamePatterns.
+func (s *estServer) checkEnrollCSR(ctx context.Context, csr *x509.CertificateRequest, identity string, current *x509.Certificate) error {
+	if csr.Subject.CommonName != identity && !matchNamePatterns(s.options.NamePatterns, csr.Subject.CommonName) {
+		return errors.Errorf(ctx, "common name '%s' is not allowed for '%s'", csr.Subject.CommonName, identity)
+	}
+	var currentNames []string
+	if current != nil {
+		currentNames = append(currentNames, current.DNSNames...)
+		currentNames = append(currentNames, current.EmailAddresses...)
+		currentNames = append(currentNames, ipStrings(current.IPAddresses)...)
+	}
+	names := append(append(append([]string{}, csr.DNSNames...), csr.EmailAddresses...), ipStrings(csr.IPAddresses)...)
+	for _, name := range names {
+		if !slices.Contains(currentNames, name) && !matchNamePatterns(s.options.NamePatterns, name) {
+			return errors.Errorf(ctx, "name '%s' is not allowed for '%s'", name, identity)
+		}
+	}
+	for _, uri := range csr.URIs {
+		if !matchNamePatterns(s.options.NamePatterns, uri.String()) {
+			return errors.Errorf(ctx, "uri '%s' is not allowed", uri)
+		}
+	}
+	return nil
+}
+
+// basicAuth returns the username if the request carries the configured credentials.
+func (s *estServer) basicAuth(req *http.Request) (string, bool) {
+	if s.options.Username == "" || s.options.Password == "" {
+		return "", false
+	}
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	usernameOK := subtle.ConstantTimeCompare([]byte(username), []byte(s.options.Username)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(s.options.Password)) == 1
+	return username, usernameOK && passwordOK
+}
+
+func (s *estServer) writeCertificates(ctx context.Context, resp http.ResponseWriter, contentType string, certs ...*x509.Certificate) {
+	der, err := CertsOnlyPKCS7(ctx, certs...)
+	if err != nil {
+		glog.Warningf("encode est response failed: %v", err)
+		http.Error(resp, "encode response failed", http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", contentType)
+	resp.Header().Set("Content-Transfer-Encoding", "base64")
+	if _, err := resp.Write([]byte(base64.StdEncoding.EncodeToString(der))); err != nil {
+		glog.Warningf("write est response failed: %v", err)
+	}
+}
+
+// readESTCSR reads a base64 encoded PKCS#10 request and checks its signature.
+func readESTCSR(ctx context.Context, req *http.Request) (*x509.CertificateRequest, error) {
+	body, err := io.ReadAll(io.LimitReader(req.Body, estMaxRequestSize))
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "read body failed")
+	}
+	der, err := base64.StdEncoding.DecodeString(string(bytes.Join(bytes.Fields(body), nil)))
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "decode base64 failed")
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "parse csr failed")
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, errors.Wrapf(ctx, err, "invalid csr signature")
+	}
+	return csr, nil
+}
+
+func checkESTReenrollCSR(ctx context.Context, csr *x509.CertificateRequest, current *x509.Certificate) error {
+	if !bytes.Equal(csr.RawSubject, current.RawSubject) {
+		return errors.Errorf(ctx, "subject '%s' differs from current certificate '%s'", csr.Subject, current.Subject)
+	}
+	if !slices.Equal(csr.DNSNames, current.DNSNames) ||
+		!slices.Equal(csr.EmailAddresses, current.EmailAddresses) ||
+		!slices.Equal(ipStrings(csr.IPAddresses), ipStrings(current.IPAddresses)) ||
+		!slices.Equal(uriStrings(&x509.Certificate{URIs: csr.URIs}), uriStrings(current)) {
+		return errors.Errorf(ctx, "subject alternative names differ from current certificate")
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ESTServer", func() {
+	var ctx context.Context
+	var ca *pkg.CA
+	var inventory pkg.Inventory
+	var server *httptest.Server
+	var clientCert *tls.Certificate
+	var username, password string
+	var namePatterns []string
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		ca, err = pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		inventory = pkg.NewFileInventory(filepath.Join(GinkgoT().TempDir(), pkg.InventoryFile))
+		clientCert = nil
+		username, password = "", ""
+		namePatterns = nil
+	})
+	JustBeforeEach(func() {
+		server = httptest.NewUnstartedServer(pkg.NewESTServer(ca, pkg.ESTServerOptions{
+			Validity:     time.Hour,
+			Username:     username,
+			Password:     password,
+			NamePatterns: namePatterns,
+			Inventory:    inventory,
+		}))
+		server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+		server.StartTLS()
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+	post := func(operation string, csr []byte, auth bool) *http.Response {
+		client := server.Client()
+		if clientCert != nil {
+			client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{*clientCert}
+		}
+		req, err := http.NewRequest(http.MethodPost, server.URL+pkg.ESTPathPrefix+"/"+operation, strings.NewReader(base64.StdEncoding.EncodeToString(csr)))
+		Expect(err).To(BeNil())
+		req.Header.Set("Content-Type", "application/pkcs10")
+		if auth {
+			req.SetBasicAuth("device", "secret")
+		}
+		resp, err := client.Do(req)
+		Expect(err).To(BeNil())
+		return resp
+	}
+	readCerts := func(resp *http.Response) []*x509.Certificate {
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		Expect(err).To(BeNil())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK), string(body))
+		Expect(resp.Header.Get("Content-Transfer-Encoding")).To(Equal("base64"))
+		der, err := base64.StdEncoding.DecodeString(string(body))
+		Expect(err).To(BeNil())
+		certs, err := pkg.ParseCertsOnlyPKCS7(ctx, der)
+		Expect(err).To(BeNil())
+		return certs
+	}
+	createCSRWithURIs := func(commonName string, uris []*url.URL, dnsNames ...string) []byte {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).To(BeNil())
+		csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			Subject:  pkix.Name{CommonName: commonName},
+			DNSNames: dnsNames,
+			URIs:     uris,
+		}, key)
+		Expect(err).To(BeNil())
+		return csr
+	}
+	createCSR := func(commonName string, dnsNames ...string) []byte {
+		return createCSRWithURIs(commonName, nil, dnsNames...)
+	}
+	expectForbidden := func(resp *http.Response) {
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+	}
+	useClientCert := func(req pkg.IssueRequest) *x509.Certificate {
+		keyPair, err := pkg.IssueCertificate(ctx, ca, req)
+		Expect(err).To(BeNil())
+		Expect(inventory.Add(ctx, pkg.NewInventoryEntry(keyPair.Certificate, pkg.ProfileClient, "", ""))).To(Succeed())
+		clientCert = &tls.Certificate{
+			Certificate: [][]byte{keyPair.Certificate.Raw},
+			PrivateKey:  keyPair.PrivateKey,
+		}
+		return keyPair.Certificate
+	}
+	It("returns ca certs", func() {
+		resp, err := server.Client().Get(server.URL + pkg.ESTPathPrefix + "/cacerts")
+		Expect(err).To(BeNil())
+		certs := readCerts(resp)
+		Expect(certs).To(HaveLen(1))
+		Expect(certs[0].Equal(ca.Certificate)).To(BeTrue())
+	})
+	Context("with basic auth", func() {
+		BeforeEach(func() {
+			username, password = "device", "secret"
+			namePatterns = []string{"device-*", "*.devices.example.com", "spiffe://example.org/device/*"}
+		})
+		It("enrolls", func() {
+			certs := readCerts(post("simpleenroll", createCSR("device-1"), true))
+			Expect(certs).To(HaveLen(1))
+			Expect(certs[0].Subject.CommonName).To(Equal("device-1"))
+			Expect(certs[0].ExtKeyUsage).To(Equal([]x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}))
+			Expect(certs[0].CheckSignatureFrom(ca.Certificate)).To(Succeed())
+
+			entries, err := inventory.List(ctx)
+			Expect(err).To(BeNil())
+			Expect(entries).To(HaveLen(1))
+		})
+		It("enrolls allowed names", func() {
+			spiffeID, err := url.Parse("spiffe://example.org/device/1")
+			Expect(err).To(BeNil())
+			certs := readCerts(post("simpleenroll", createCSRWithURIs("device-1", []*url.URL{spiffeID}, "a.devices.example.com"), true))
+			Expect(certs[0].DNSNames).To(Equal([]string{"a.devices.example.com"}))
+			Expect(certs[0].URIs).To(HaveLen(1))
+		})
+		It("rejects names not allowed", func() {
+			expectForbidden(post("simpleenroll", createCSR("admin"), true))
+			expectForbidden(post("simpleenroll", createCSR("device-1", "api.example.com"), true))
+		})
+		It("rejects uris not allowed", func() {
+			spiffeID, err := url.Parse("spiffe://example.org/admin")
+			Expect(err).To(BeNil())
+			expectForbidden(post("simpleenroll", createCSRWithURIs("device-1", []*url.URL{spiffeID}), true))
+		})
+		It("rejects enroll without credentials", func() {
+			resp := post("simpleenroll", createCSR("device-1"), false)
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(resp.Header.Get("WWW-Authenticate")).To(ContainSubstring("Basic"))
+		})
+		It("rejects reenroll with basic auth only", func() {
+			resp := post("simplereenroll", createCSR("device-1"), true)
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+	})
+	It("enrolls the identity of the client certificate", func() {
+		useClientCert(pkg.IssueRequest{
+			Profile:    pkg.ProfileClient,
+			CommonName: "device-2",
+			DNSNames:   []string{"device-2.local"},
+			Validity:   time.Hour,
+		})
+		certs := readCerts(post("simpleenroll", createCSR("device-2", "device-2.local"), false))
+		Expect(certs[0].Subject.CommonName).To(Equal("device-2"))
+	})
+	It("rejects other identity with client certificate", func() {
+		useClientCert(pkg.DefaultClientIssueRequest())
+		expectForbidden(post("simpleenroll", createCSR("device-2"), false))
+		expectForbidden(post("simpleenroll", createCSR("client", "device-2.local"), false))
+	})
+	It("rejects enroll without authentication", func() {
+		resp := post("simpleenroll", createCSR("device-1"), false)
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+	})
+	Context("reenroll", func() {
+		var current *x509.Certificate
+		BeforeEach(func() {
+			current = useClientCert(pkg.IssueRequest{
+				Profile:    pkg.ProfileClient,
+				CommonName: "device-3",
+				DNSNames:   []string{"device-3.local"},
+				Validity:   time.Hour,
+			})
+		})
+		It("issues new certificate with same subject", func() {
+			certs := readCerts(post("simplereenroll", createCSR("device-3", "device-3.local"), false))
+			Expect(certs[0].Subject.String()).To(Equal(current.Subject.String()))
+			Expect(certs[0].DNSNames).To(Equal(current.DNSNames))
+			Expect(certs[0].SerialNumber).NotTo(Equal(current.SerialNumber))
+		})
+		It("rejects other subject", func() {
+			resp := post("simplereenroll", createCSR("device-4", "device-3.local"), false)
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+		})
+		It("rejects other subject alternative names", func() {
+			resp := post("simplereenroll", createCSR("device-3", "other.local"), false)
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+		})
+		It("rejects revoked certificate", func() {
+			_, err := inventory.Revoke(ctx, pkg.FormatHex(current.SerialNumber.Bytes()), 1, time.Now())
+			Expect(err).To(BeNil())
+			resp := post("simplereenroll", createCSR("device-3", "device-3.local"), false)
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+	})
+	It("rejects certificate of other ca", func() {
+		otherCA, err := pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		keyPair, err := pkg.IssueCertificate(ctx, otherCA, pkg.DefaultClientIssueRequest())
+		Expect(err).To(BeNil())
+		clientCert = &tls.Certificate{Certificate: [][]byte{keyPair.Certificate.Raw}, PrivateKey: keyPair.PrivateKey}
+		resp := post("simplereenroll", createCSR("client"), false)
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+	})
+})
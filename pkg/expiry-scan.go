@@ -0,0 +1,248 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bborbe/errors"
+)
+
+// ExpiryStatus classifies a certificate found by ScanExpiry.
+type ExpiryStatus string
+
+const (
+	ExpiryStatusOK       ExpiryStatus = "ok"
+	ExpiryStatusExpiring ExpiryStatus = "expiring"
+	ExpiryStatusExpired  ExpiryStatus = "expired"
+)
+
+// maxScanFileSize skips larger files, certificates and chains are far smaller.
+const maxScanFileSize = 1 << 20
+
+// derExtensions are the file extensions tried as DER if a file contains no PEM certificate.
+var derExtensions = []string{".der", ".crt", ".cer"}
+
+// ExpiryEntry is one certificate found by ScanExpiry. Index is its position inside the file.
+type ExpiryEntry struct {
+	Path             string       `json:"path"`
+	Index            int          `json:"index"`
+	Subject          string       `json:"subject"`
+	SerialNumber     string       `json:"serialNumber"`
+	IsCA             bool         `json:"isCA"`
+	NotAfter         time.Time    `json:"notAfter"`
+	RemainingSeconds int64        `json:"remainingSeconds"`
+	Status           ExpiryStatus `json:"status"`
+}
+
+// ExpiryScanError is a file or directory that could not be read or a file that looked like a certificate but
+// could not be parsed.
+type ExpiryScanError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// ExpiryReport is the result of ScanExpiry.
+type ExpiryReport struct {
+	ScannedAt        time.Time         `json:"scannedAt"`
+	ThresholdSeconds int64             `json:"thresholdSeconds"`
+	Certificates     []ExpiryEntry     `json:"certificates"`
+	Errors           []ExpiryScanError `json:"errors,omitempty"`
+}
+
+// ScanExpiry walks root and reports all PEM certificates and DER files with extension .der, .crt or .cer.
+// Certificates expiring before now plus threshold are expiring, those expired before now are expired.
+// Files and directories whose name or path relative to root matches one of the exclude globs are skipped.
+// Unreadable files and directories below root are reported as errors, only an unreadable root fails the scan.
+func ScanExpiry(ctx context.Context, root string, now time.Time, threshold time.Duration, exclude []string) (*ExpiryReport, error) {
+	for _, pattern := range exclude {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, errors.Wrapf(ctx, err, "invalid exclude pattern '%s'", pattern)
+		}
+	}
+	report := &ExpiryReport{
+		ScannedAt:        now.UTC(),
+		ThresholdSeconds: int64(threshold / time.Second),
+		Certificates:     []ExpiryEntry{},
+	}
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			// record unreadable files and directories and continue with the rest of the tree
+			report.Errors = append(report.Errors, ExpiryScanError{Path: path, Error: err.Error()})
+			return nil
+		}
+		if path != root && excludedScanPath(root, path, exclude) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			report.Errors = append(report.Errors, ExpiryScanError{Path: path, Error: err.Error()})
+			return nil
+		}
+		if info.Size() > maxScanFileSize {
+			return nil
+		}
+		certs, err := readScanFile(ctx, path)
+		if err != nil {
+			report.Errors = append(report.Errors, ExpiryScanError{Path: path, Error: err.Error()})
+			return nil
+		}
+		for i, cert := range certs {
+			report.Certificates = append(report.Certificates, newExpiryEntry(path, i, cert, now, threshold))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "scan %s failed", root)
+	}
+	sort.SliceStable(report.Certificates, func(i, j int) bool {
+		return report.Certificates[i].NotAfter.Before(report.Certificates[j].NotAfter)
+	})
+	return report, nil
+}
+
+// excludedScanPath returns true if the name or the path relative to root of path matches one of the globs.
+func excludedScanPath(root string, path string, exclude []string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = path
+	}
+	for _, pattern := range exclude {
+		for _, name := range []string{filepath.Base(path), rel} {
+			if matched, _ := filepath.Match(pattern, name); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readScanFile returns the certificates of path, nothing for files without certificates.
+func readScanFile(ctx context.Context, path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "read failed")
+	}
+	if bytes.Contains(data, []byte("-----BEGIN "+pemTypeCertificate+"-----")) {
+		return ParseCertificates(ctx, data)
+	}
+	for _, extension := range derExtensions {
+		if strings.EqualFold(filepath.Ext(path), extension) {
+			cert, err := x509.ParseCertificate(data)
+			if err != nil {
+				return nil, errors.Wrapf(ctx, err, "parse der failed")
+			}
+			return []*x509.Certificate{cert}, nil
+		}
+	}
+	return nil, nil
+}
+
+func newExpiryEntry(path string, index int, cert *x509.Certificate, now time.Time, threshold time.Duration) ExpiryEntry {
+	status := ExpiryStatusOK
+	if now.After(cert.NotAfter) {
+		status = ExpiryStatusExpired
+	} else if now.Add(threshold).After(cert.NotAfter) {
+		status = ExpiryStatusExpiring
+	}
+	return ExpiryEntry{
+		Path:             path,
+		Index:            index,
+		Subject:          cert.Subject.String(),
+		SerialNumber:     FormatHex(cert.SerialNumber.Bytes()),
+		IsCA:             cert.IsCA,
+		NotAfter:         cert.NotAfter,
+		RemainingSeconds: int64(cert.NotAfter.Sub(now) / time.Second),
+		Status:           status,
+	}
+}
+
+// Count returns the number of certificates with the given status.
+func (e *ExpiryReport) Count(status ExpiryStatus) int {
+	result := 0
+	for _, entry := range e.Certificates {
+		if entry.Status == status {
+			result++
+		}
+	}
+	return result
+}
+
+// WriteTable writes one line per certificate, soonest expiry first, followed by the scan errors.
+func (e *ExpiryReport) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tNOT AFTER\tREMAINING\tPATH\tSUBJECT")
+	for _, entry := range e.Certificates {
+		path := entry.Path
+		if entry.Index > 0 {
+			path = fmt.Sprintf("%s#%d", entry.Path, entry.Index)
+		}
+		remaining := (time.Duration(entry.RemainingSeconds) * time.Second).Truncate(time.Hour)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", entry.Status, entry.NotAfter.UTC().Format(time.RFC3339), remaining, path, entry.Subject)
+	}
+	for _, scanError := range e.Errors {
+		fmt.Fprintf(tw, "error\t\t\t%s\t%s\n", scanError.Path, scanError.Error)
+	}
+	return tw.Flush()
+}
+
+// WritePrometheus writes the report in the Prometheus text format for the node exporter textfile collector.
+func (e *ExpiryReport) WritePrometheus(w io.Writer) error {
+	var buf bytes.Buffer
+	gauge := func(name string, help string) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+	labels := func(entry ExpiryEntry) string {
+		return fmt.Sprintf(
+			`path="%s",index="%d",subject="%s",serial="%s",ca="%t"`,
+			escapeLabelValue(entry.Path), entry.Index, escapeLabelValue(entry.Subject), entry.SerialNumber, entry.IsCA,
+		)
+	}
+	gauge("cert_expiry_not_after_seconds", "Unix time the certificate expires.")
+	for _, entry := range e.Certificates {
+		fmt.Fprintf(&buf, "cert_expiry_not_after_seconds{%s} %d\n", labels(entry), entry.NotAfter.Unix())
+	}
+	gauge("cert_expiry_remaining_seconds", "Seconds until the certificate expires, negative if expired.")
+	for _, entry := range e.Certificates {
+		fmt.Fprintf(&buf, "cert_expiry_remaining_seconds{%s} %d\n", labels(entry), entry.RemainingSeconds)
+	}
+	gauge("cert_expiry_certificates", "Number of certificates by status.")
+	for _, status := range []ExpiryStatus{ExpiryStatusOK, ExpiryStatusExpiring, ExpiryStatusExpired} {
+		fmt.Fprintf(&buf, "cert_expiry_certificates{status=\"%s\"} %d\n", status, e.Count(status))
+	}
+	gauge("cert_expiry_threshold_seconds", "Threshold for expiring certificates.")
+	fmt.Fprintf(&buf, "cert_expiry_threshold_seconds %d\n", e.ThresholdSeconds)
+	gauge("cert_expiry_scan_errors", "Number of files and directories that could not be read or parsed.")
+	fmt.Fprintf(&buf, "cert_expiry_scan_errors %d\n", len(e.Errors))
+	gauge("cert_expiry_scan_timestamp_seconds", "Unix time of the scan.")
+	fmt.Fprintf(&buf, "cert_expiry_scan_timestamp_seconds %d\n", e.ScannedAt.Unix())
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// escapeLabelValue escapes backslash, double quote and line feed as required by the Prometheus text format.
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ScanExpiry", func() {
+	var ctx context.Context
+	var dir string
+	var now time.Time
+	var report *pkg.ExpiryReport
+	var err error
+	BeforeEach(func() {
+		ctx = context.Background()
+		dir = GinkgoT().TempDir()
+		ca, err := pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		Expect(pkg.WriteCA(ctx, ca, filepath.Join(dir, pkg.CACertFile), filepath.Join(dir, pkg.CAKeyFile))).To(BeNil())
+		for name, validity := range map[string]time.Duration{"short": 24 * time.Hour, "long": 90 * 24 * time.Hour} {
+			keyPair, err := pkg.IssueCertificate(ctx, ca, pkg.IssueRequest{
+				Profile:  pkg.ProfileServer,
+				DNSNames: []string{name + ".example.com"},
+				Validity: validity,
+			})
+			Expect(err).To(BeNil())
+			Expect(pkg.WriteIdentity(ctx, keyPair, ca, pkg.IdentityPaths{
+				CertPath: filepath.Join(dir, name, pkg.NamedCertFile),
+				KeyPath:  filepath.Join(dir, name, pkg.NamedKeyFile),
+			})).To(BeNil())
+		}
+		Expect(os.WriteFile(filepath.Join(dir, "long", "cert.der"), ca.Certificate.Raw, 0600)).To(BeNil())
+		Expect(os.WriteFile(filepath.Join(dir, "broken.crt"), []byte("garbage"), 0600)).To(BeNil())
+		now = time.Now().Add(2 * time.Hour)
+		report, err = pkg.ScanExpiry(ctx, dir, now, 30*24*time.Hour, nil)
+		Expect(err).To(BeNil())
+	})
+	It("finds pem and der certificates", func() {
+		Expect(report.Certificates).To(HaveLen(4))
+		Expect(report.Errors).To(HaveLen(1))
+		Expect(report.Errors[0].Path).To(Equal(filepath.Join(dir, "broken.crt")))
+	})
+	It("classifies by threshold", func() {
+		Expect(report.Count(pkg.ExpiryStatusExpiring)).To(Equal(1))
+		Expect(report.Count(pkg.ExpiryStatusOK)).To(Equal(3))
+		Expect(report.Certificates[0].Path).To(Equal(filepath.Join(dir, "short", "cert.pem")))
+	})
+	It("reports expired certificates", func() {
+		report, err = pkg.ScanExpiry(ctx, dir, now.Add(48*time.Hour), 0, nil)
+		Expect(err).To(BeNil())
+		Expect(report.Count(pkg.ExpiryStatusExpired)).To(Equal(1))
+		Expect(report.Certificates[0].RemainingSeconds).To(BeNumerically("<", 0))
+	})
+	It("writes prometheus format", func() {
+		var buf bytes.Buffer
+		Expect(report.WritePrometheus(&buf)).To(BeNil())
+		Expect(buf.String()).To(ContainSubstring("# TYPE cert_expiry_not_after_seconds gauge\n"))
+		Expect(buf.String()).To(ContainSubstring(`cert_expiry_certificates{status="expiring"} 1`))
+		Expect(buf.String()).To(ContainSubstring("cert_expiry_scan_errors 1\n"))
+	})
+	It("writes table", func() {
+		var buf bytes.Buffer
+		Expect(report.WriteTable(&buf)).To(BeNil())
+		Expect(buf.String()).To(ContainSubstring("expiring"))
+		Expect(buf.String()).To(ContainSubstring("broken.crt"))
+	})
+	It("records unreadable directories and continues", func() {
+		if os.Geteuid() == 0 {
+			Skip("root can read every directory")
+		}
+		locked := filepath.Join(dir, "locked")
+		Expect(os.Mkdir(locked, 0700)).To(BeNil())
+		Expect(os.Chmod(locked, 0)).To(BeNil())
+		DeferCleanup(os.Chmod, locked, os.FileMode(0700))
+		report, err = pkg.ScanExpiry(ctx, dir, now, 30*24*time.Hour, nil)
+		Expect(err).To(BeNil())
+		Expect(report.Certificates).To(HaveLen(4))
+		Expect(report.Errors).To(HaveLen(2))
+		Expect([]string{report.Errors[0].Path, report.Errors[1].Path}).To(ContainElement(locked))
+	})
+	It("skips excluded files and directories", func() {
+		Expect(os.WriteFile(filepath.Join(dir, "short", "cert.pem.20240101T000000Z.bak"), []byte("garbage"), 0600)).To(BeNil())
+		report, err = pkg.ScanExpiry(ctx, dir, now, 30*24*time.Hour, []string{"*.bak", "short", "long/*.der"})
+		Expect(err).To(BeNil())
+		Expect(report.Certificates).To(HaveLen(2))
+		Expect(report.Errors).To(HaveLen(1))
+		for _, entry := range report.Certificates {
+			Expect(entry.Path).NotTo(HavePrefix(filepath.Join(dir, "short")))
+			Expect(entry.Path).NotTo(HaveSuffix(".der"))
+		}
+	})
+	It("rejects invalid exclude pattern", func() {
+		_, err = pkg.ScanExpiry(ctx, dir, now, 0, []string{"["})
+		Expect(err).NotTo(BeNil())
+	})
+	It("fails if root is missing", func() {
+		_, err = pkg.ScanExpiry(ctx, filepath.Join(dir, "missing"), now, 0, nil)
+		Expect(err).NotTo(BeNil())
+	})
+})
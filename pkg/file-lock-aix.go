@@ -0,0 +1,28 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build aix
+
+package pkg
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// AIX has no flock, fcntl record locks of the whole file are used instead.
+
+func lockFileHandle(file *os.File, exclusive bool) error {
+	lockType := int16(unix.F_RDLCK)
+	if exclusive {
+		lockType = unix.F_WRLCK
+	}
+	return unix.FcntlFlock(file.Fd(), unix.F_SETLKW, &unix.Flock_t{Type: lockType, Whence: io.SeekStart})
+}
+
+func unlockFileHandle(file *os.File) error {
+	return unix.FcntlFlock(file.Fd(), unix.F_SETLK, &unix.Flock_t{Type: unix.F_UNLCK, Whence: io.SeekStart})
+}
@@ -0,0 +1,25 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build unix && !aix
+
+package pkg
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func lockFileHandle(file *os.File, exclusive bool) error {
+	how := unix.LOCK_SH
+	if exclusive {
+		how = unix.LOCK_EX
+	}
+	return unix.Flock(int(file.Fd()), how)
+}
+
+func unlockFileHandle(file *os.File) error {
+	return unix.Flock(int(file.Fd()), unix.LOCK_UN)
+}
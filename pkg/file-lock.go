@@ -0,0 +1,23 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"os"
+
+	"github.com/bborbe/errors"
+)
+
+// lockFile locks file exclusive or shared against other processes and returns the unlock function.
+// It blocks until the lock is granted.
+func lockFile(ctx context.Context, file *os.File, exclusive bool) (func(), error) {
+	if err := lockFileHandle(file, exclusive); err != nil {
+		return nil, errors.Wrapf(ctx, err, "lock %s failed", file.Name())
+	}
+	return func() {
+		_ = unlockFileHandle(file)
+	}, nil
+}
@@ -6,86 +6,18 @@ package pkg
 
 import (
 	"context"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
-	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/pem"
-	"math/big"
-	"os"
-	"time"
 
 	"github.com/bborbe/errors"
 )
 
+// GenerateCaCerts generates a new CA and writes its certificate and private key to the given paths.
 func GenerateCaCerts(ctx context.Context, caCertPath string, caKeyPath string) error {
-	// Generate ECDSA private key for CA
-	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca, err := CreateCA(ctx, DefaultCARequest())
 	if err != nil {
-		return errors.Wrapf(ctx, err, "generate key failed")
+		return errors.Wrapf(ctx, err, "create ca failed")
 	}
-
-	// Certificate validity period
-	notBefore := time.Now()
-	notAfter := notBefore.Add(10 * 365 * 24 * time.Hour) // 10 years
-
-	// Generate a serial number for the certificate
-	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
-	if err != nil {
-		return err
-	}
-
-	// Create CA certificate template
-	template := x509.Certificate{
-		SerialNumber: serialNumber,
-		Subject: pkix.Name{
-			Organization:  []string{"My CA Organization"},
-			Country:       []string{"US"},
-			Province:      []string{"California"},
-			Locality:      []string{"San Francisco"},
-			StreetAddress: []string{"123 CA Street"},
-			PostalCode:    []string{"94111"},
-		},
-		NotBefore:             notBefore,
-		NotAfter:              notAfter,
-		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
-		BasicConstraintsValid: true,
-		IsCA:                  true,
-		MaxPathLen:            0, // Only this CA can issue certificates
-	}
-
-	// Self-sign the CA certificate
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
-	if err != nil {
-		return err
-	}
-
-	// Write the certificate to cert.pem
-	certOut, err := os.Create(caCertPath)
-	if err != nil {
-		return err
-	}
-	defer certOut.Close()
-
-	if err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes}); err != nil {
-		return err
-	}
-
-	// Write the private key to key.pem
-	keyOut, err := os.Create(caKeyPath)
-	if err != nil {
-		return err
-	}
-	defer keyOut.Close()
-
-	privBytes, err := x509.MarshalECPrivateKey(priv)
-	if err != nil {
-		return err
-	}
-
-	if err := pem.Encode(keyOut, &pem.Block{Type: "EC PRIVATE KEY", Bytes: privBytes}); err != nil {
-		return err
+	if err := WriteCA(ctx, ca, caCertPath, caKeyPath); err != nil {
+		return errors.Wrapf(ctx, err, "write ca failed")
 	}
 	return nil
 }
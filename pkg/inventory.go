@@ -0,0 +1,236 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bborbe/errors"
+)
+
+// InventoryEntry records one issued certificate.
+type InventoryEntry struct {
+	SerialNumber     string     `json:"serialNumber"`
+	Profile          Profile    `json:"profile"`
+	Subject          string     `json:"subject"`
+	CommonName       string     `json:"commonName,omitempty"`
+	DNSNames         []string   `json:"dnsNames,omitempty"`
+	IPAddresses      []string   `json:"ipAddresses,omitempty"`
+	EmailAddresses   []string   `json:"emailAddresses,omitempty"`
+	URIs             []string   `json:"uris,omitempty"`
+	AuthorityKeyID   string     `json:"authorityKeyId,omitempty"`
+	NotBefore        time.Time  `json:"notBefore"`
+	NotAfter         time.Time  `json:"notAfter"`
+	CertPath         string     `json:"certPath,omitempty"`
+	KeyPath          string     `json:"keyPath,omitempty"`
+	RevokedAt        *time.Time `json:"revokedAt,omitempty"`
+	RevocationReason int        `json:"revocationReason,omitempty"`
+}
+
+// Revoked returns true if the certificate was revoked.
+func (i InventoryEntry) Revoked() bool {
+	return i.RevokedAt != nil
+}
+
+// NewInventoryEntry creates the InventoryEntry for an issued certificate.
+func NewInventoryEntry(cert *x509.Certificate, profile Profile, certPath string, keyPath string) InventoryEntry {
+	entry := InventoryEntry{
+		SerialNumber:   FormatHex(cert.SerialNumber.Bytes()),
+		Profile:        profile,
+		Subject:        cert.Subject.String(),
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		IPAddresses:    ipStrings(cert.IPAddresses),
+		EmailAddresses: cert.EmailAddresses,
+		URIs:           uriStrings(cert),
+		AuthorityKeyID: FormatHex(cert.AuthorityKeyId),
+		NotBefore:      cert.NotBefore,
+		NotAfter:       cert.NotAfter,
+		CertPath:       certPath,
+		KeyPath:        keyPath,
+	}
+	return entry
+}
+
+// Inventory returns the file Inventory of the DataDir.
+func (d DataDir) Inventory(ctx context.Context) (Inventory, error) {
+	path, err := d.Path(ctx, InventoryFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewFileInventory(path), nil
+}
+
+//counterfeiter:generate -o ../mocks/inventory.go --fake-name Inventory . Inventory
+
+// Inventory keeps track of all issued certificates.
+type Inventory interface {
+	Add(ctx context.Context, entry InventoryEntry) error
+	Get(ctx context.Context, serialNumber string) (*InventoryEntry, error)
+	List(ctx context.Context) ([]InventoryEntry, error)
+	Revoke(ctx context.Context, serialNumber string, reason int, revokedAt time.Time) (*InventoryEntry, error)
+}
+
+// NewFileInventory returns an Inventory stored as JSON file.
+// A lock file next to it is locked during each operation, so several processes can share it.
+func NewFileInventory(path string) Inventory {
+	return &fileInventory{
+		path: path,
+	}
+}
+
+type fileInventory struct {
+	path string
+	mux  sync.Mutex
+}
+
+func (f *fileInventory) Add(ctx context.Context, entry InventoryEntry) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	unlock, err := f.lock(ctx, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	entries, err := f.read(ctx)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "read inventory failed")
+	}
+	for _, existing := range entries {
+		if existing.SerialNumber == entry.SerialNumber {
+			return errors.Errorf(ctx, "serial %s already in inventory", entry.SerialNumber)
+		}
+	}
+	return f.write(ctx, append(entries, entry))
+}
+
+func (f *fileInventory) Get(ctx context.Context, serialNumber string) (*InventoryEntry, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	unlock, err := f.lock(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	entries, err := f.read(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "read inventory failed")
+	}
+	index, err := findInventoryEntry(ctx, entries, serialNumber)
+	if err != nil {
+		return nil, err
+	}
+	return &entries[index], nil
+}
+
+func (f *fileInventory) List(ctx context.Context) ([]InventoryEntry, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	unlock, err := f.lock(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return f.read(ctx)
+}
+
+func (f *fileInventory) Revoke(ctx context.Context, serialNumber string, reason int, revokedAt time.Time) (*InventoryEntry, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	unlock, err := f.lock(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if err := ValidateRevocationReason(ctx, reason); err != nil {
+		return nil, err
+	}
+	entries, err := f.read(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "read inventory failed")
+	}
+	index, err := findInventoryEntry(ctx, entries, serialNumber)
+	if err != nil {
+		return nil, err
+	}
+	if entries[index].Revoked() {
+		return nil, errors.Errorf(ctx, "serial %s already revoked", entries[index].SerialNumber)
+	}
+	entries[index].RevokedAt = &revokedAt
+	entries[index].RevocationReason = reason
+	if err := f.write(ctx, entries); err != nil {
+		return nil, err
+	}
+	return &entries[index], nil
+}
+
+// lock locks the lock file of the inventory, the inventory itself is replaced on every write.
+func (f *fileInventory) lock(ctx context.Context, exclusive bool) (func(), error) {
+	file, err := os.OpenFile(f.path+".lock", os.O_RDWR|os.O_CREATE, CertificateFileMode)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "open lock of %s failed", f.path)
+	}
+	unlock, err := lockFile(ctx, file, exclusive)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() {
+		unlock()
+		file.Close()
+	}, nil
+}
+
+func (f *fileInventory) read(ctx context.Context) ([]InventoryEntry, error) {
+	exists, err := fileExists(f.path)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "check %s failed", f.path)
+	}
+	if !exists {
+		return nil, nil
+	}
+	data, err := readFile(ctx, f.path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []InventoryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, errors.Wrapf(ctx, err, "unmarshal %s failed", f.path)
+	}
+	return entries, nil
+}
+
+func (f *fileInventory) write(ctx context.Context, entries []InventoryEntry) error {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].NotBefore.Before(entries[j].NotBefore)
+	})
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return errors.Wrapf(ctx, err, "marshal inventory failed")
+	}
+	return WriteFileAtomic(ctx, f.path, data, CertificateFileMode)
+}
+
+// findInventoryEntry matches the serial number case insensitive with or without colons.
+func findInventoryEntry(ctx context.Context, entries []InventoryEntry, serialNumber string) (int, error) {
+	normalized := normalizeSerialNumber(serialNumber)
+	for i, entry := range entries {
+		if normalizeSerialNumber(entry.SerialNumber) == normalized {
+			return i, nil
+		}
+	}
+	return -1, errors.Errorf(ctx, "serial %s not found in inventory", serialNumber)
+}
+
+func normalizeSerialNumber(serialNumber string) string {
+	return strings.ToUpper(strings.ReplaceAll(serialNumber, ":", ""))
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Inventory", func() {
+	var ctx context.Context
+	var ca *pkg.CA
+	var keyPair *pkg.KeyPair
+	var inventory pkg.Inventory
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		ca, err = pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		keyPair, err = pkg.IssueCertificate(ctx, ca, pkg.DefaultServerIssueRequest())
+		Expect(err).To(BeNil())
+		inventory = pkg.NewFileInventory(filepath.Join(GinkgoT().TempDir(), pkg.InventoryFile))
+	})
+	It("is empty without file", func() {
+		entries, err := inventory.List(ctx)
+		Expect(err).To(BeNil())
+		Expect(entries).To(BeEmpty())
+	})
+	It("parses revocation reasons by name and code", func() {
+		reason, err := pkg.ParseRevocationReason(ctx, "keycompromise")
+		Expect(err).To(BeNil())
+		Expect(reason).To(Equal(1))
+		reason, err = pkg.ParseRevocationReason(ctx, "4")
+		Expect(err).To(BeNil())
+		Expect(reason).To(Equal(4))
+		_, err = pkg.ParseRevocationReason(ctx, "7")
+		Expect(err).NotTo(BeNil())
+		_, err = pkg.ParseRevocationReason(ctx, "stolen")
+		Expect(err).NotTo(BeNil())
+	})
+	It("keeps concurrent adds", func() {
+		path := filepath.Join(GinkgoT().TempDir(), pkg.InventoryFile)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				keyPair, err := pkg.IssueCertificate(ctx, ca, pkg.DefaultServerIssueRequest())
+				Expect(err).To(BeNil())
+				// separate instances like separate processes only share the file lock
+				Expect(pkg.NewFileInventory(path).Add(ctx, pkg.NewInventoryEntry(keyPair.Certificate, pkg.ProfileServer, "", ""))).To(Succeed())
+			}()
+		}
+		wg.Wait()
+		entries, err := pkg.NewFileInventory(path).List(ctx)
+		Expect(err).To(BeNil())
+		Expect(entries).To(HaveLen(10))
+	})
+	Context("with entry", func() {
+		var entry pkg.InventoryEntry
+		BeforeEach(func() {
+			entry = pkg.NewInventoryEntry(keyPair.Certificate, pkg.ProfileServer, "server_cert.pem", "server_key.pem")
+			Expect(inventory.Add(ctx, entry)).To(Succeed())
+		})
+		It("lists the entry", func() {
+			entries, err := inventory.List(ctx)
+			Expect(err).To(BeNil())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].SerialNumber).To(Equal(entry.SerialNumber))
+			Expect(entries[0].DNSNames).To(Equal([]string{"localhost"}))
+		})
+		It("rejects duplicate serial", func() {
+			Expect(inventory.Add(ctx, entry)).NotTo(Succeed())
+		})
+		It("finds serial without colons in lower case", func() {
+			result, err := inventory.Get(ctx, strings.ToLower(strings.ReplaceAll(entry.SerialNumber, ":", "")))
+			Expect(err).To(BeNil())
+			Expect(result.CertPath).To(Equal("server_cert.pem"))
+		})
+		It("returns error for unknown serial", func() {
+			_, err := inventory.Get(ctx, "01")
+			Expect(err).NotTo(BeNil())
+		})
+		It("revokes once", func() {
+			result, err := inventory.Revoke(ctx, entry.SerialNumber, 1, time.Now())
+			Expect(err).To(BeNil())
+			Expect(result.Revoked()).To(BeTrue())
+			_, err = inventory.Revoke(ctx, entry.SerialNumber, 1, time.Now())
+			Expect(err).NotTo(BeNil())
+		})
+		It("rejects invalid reason", func() {
+			_, err := inventory.Revoke(ctx, entry.SerialNumber, 7, time.Now())
+			Expect(err).NotTo(BeNil())
+		})
+		It("creates crl with revoked serial", func() {
+			_, err := inventory.Revoke(ctx, entry.SerialNumber, 4, time.Now())
+			Expect(err).To(BeNil())
+			entries, err := inventory.List(ctx)
+			Expect(err).To(BeNil())
+			data, err := pkg.CreateCRL(ctx, ca, entries, time.Now(), time.Hour)
+			Expect(err).To(BeNil())
+			block, _ := pem.Decode(data)
+			Expect(block).NotTo(BeNil())
+			crl, err := x509.ParseRevocationList(block.Bytes)
+			Expect(err).To(BeNil())
+			Expect(crl.CheckSignatureFrom(ca.Certificate)).To(Succeed())
+			Expect(crl.RevokedCertificateEntries).To(HaveLen(1))
+			Expect(crl.RevokedCertificateEntries[0].SerialNumber).To(Equal(keyPair.Certificate.SerialNumber))
+			Expect(crl.RevokedCertificateEntries[0].ReasonCode).To(Equal(4))
+		})
+	})
+})
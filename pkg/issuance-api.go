@@ -0,0 +1,441 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bborbe/errors"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+// IssuanceAPIPathPrefix is the path all endpoints of the issuance API are served below.
+const IssuanceAPIPathPrefix = "/api/v1"
+
+// issuanceAPIMaxRequestSize limits the size of a request body.
+const issuanceAPIMaxRequestSize = 64 * 1024
+
+// IssuanceAPIOptions configures the issuance API.
+type IssuanceAPIOptions struct {
+	// Operators allowed to use the API, identified by the SPIFFE ID or fingerprint of their client certificate.
+	// Their names and SPIFFE IDs are never issued through the API.
+	Operators []Operator
+	// Inventory records issued and revoked certificates.
+	Inventory Inventory
+	// CRLPublisher writes the CRLs after every revocation, optional.
+	CRLPublisher CRLPublisher
+	// AuditLog records issued and revoked certificates, optional.
+	AuditLog AuditLog
+}
+
+// IssuanceAPIIssueRequest is the body of POST /api/v1/certificates.
+// The private key is generated by the server and returned once.
+type IssuanceAPIIssueRequest struct {
+	Profile        Profile  `json:"profile"`
+	CommonName     string   `json:"commonName"`
+	Organization   []string `json:"organization,omitempty"`
+	DNSNames       []string `json:"dnsNames,omitempty"`
+	IPAddresses    []string `json:"ipAddresses,omitempty"`
+	EmailAddresses []string `json:"emailAddresses,omitempty"`
+	URIs           []string `json:"uris,omitempty"`
+	// Validity like 720h, the profile default if empty.
+	Validity string `json:"validity,omitempty"`
+}
+
+// IssuanceAPISignRequest is the body of POST /api/v1/sign.
+type IssuanceAPISignRequest struct {
+	Profile Profile `json:"profile"`
+	// CSR is the PEM encoded certificate request.
+	CSR string `json:"csr"`
+	// Validity like 720h, the profile default if empty.
+	Validity string `json:"validity,omitempty"`
+}
+
+// IssuanceAPIRevokeRequest is the body of POST /api/v1/certificates/{serial}/revoke.
+type IssuanceAPIRevokeRequest struct {
+	// Reason is the CRL reason code (RFC 5280 section 5.3.1).
+	Reason int `json:"reason"`
+}
+
+// IssuanceAPICertificate is the response of issue and sign.
+type IssuanceAPICertificate struct {
+	SerialNumber string    `json:"serialNumber"`
+	NotAfter     time.Time `json:"notAfter"`
+	Certificate  string    `json:"certificate"`
+	Chain        string    `json:"chain"`
+	PrivateKey   string    `json:"privateKey,omitempty"`
+}
+
+// NewIssuanceAPI returns an http.Handler with a JSON API to issue, sign, revoke, list and get certificates.
+// Callers authenticate with a client certificate of ca pinned by a configured operator.
+func NewIssuanceAPI(ca *CA, options IssuanceAPIOptions) http.Handler {
+	s := &issuanceAPI{
+		ca:            ca,
+		inventory:     options.Inventory,
+		crlPublisher:  options.CRLPublisher,
+		auditLog:      options.AuditLog,
+		operators:     options.Operators,
+		reservedNames: make(map[string]bool),
+	}
+	for _, operator := range options.Operators {
+		for _, name := range operator.identities() {
+			s.reservedNames[strings.ToLower(name)] = true
+		}
+	}
+	router := mux.NewRouter()
+	router.Path(IssuanceAPIPathPrefix + "/certificates").Methods(http.MethodPost).HandlerFunc(s.handle(s.issue))
+	router.Path(IssuanceAPIPathPrefix + "/certificates").Methods(http.MethodGet).HandlerFunc(s.handle(s.list))
+	router.Path(IssuanceAPIPathPrefix + "/certificates/{serial}").Methods(http.MethodGet).HandlerFunc(s.handle(s.get))
+	router.Path(IssuanceAPIPathPrefix + "/certificates/{serial}/revoke").Methods(http.MethodPost).HandlerFunc(s.handle(s.revoke))
+	router.Path(IssuanceAPIPathPrefix + "/sign").Methods(http.MethodPost).HandlerFunc(s.handle(s.sign))
+	return router
+}
+
+type issuanceAPI struct {
+	ca           *CA
+	inventory    Inventory
+	crlPublisher CRLPublisher
+	auditLog     AuditLog
+	operators    []Operator
+	// reservedNames are the lower case operator identities
+	reservedNames map[string]bool
+	// revokeMux serializes revocations and the CRL update
+	revokeMux sync.Mutex
+}
+
+// issuanceAPIError is returned by handlers to respond with status and message.
+type issuanceAPIError struct {
+	status  int
+	message string
+}
+
+func (i issuanceAPIError) Error() string {
+	return i.message
+}
+
+func newIssuanceAPIError(status int, message string) error {
+	return issuanceAPIError{status: status, message: message}
+}
+
+type issuanceAPIHandler func(ctx context.Context, req *http.Request, operator Operator) (int, interface{}, error)
+
+// handle authenticates the operator, calls the handler and writes the result as JSON.
+func (s *issuanceAPI) handle(handler issuanceAPIHandler) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		operator, err := s.authenticate(ctx, req)
+		if err != nil {
+			glog.V(2).Infof("issuance api %s %s unauthorized: %v", req.Method, req.URL.Path, err)
+			s.writeJSON(resp, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		status, value, err := handler(ctx, req, operator)
+		if err != nil {
+			var apiErr issuanceAPIError
+			if !errors.As(err, &apiErr) {
+				glog.Warningf("issuance api %s %s by %s failed: %v", req.Method, req.URL.Path, operator.Name, err)
+				apiErr = issuanceAPIError{status: http.StatusInternalServerError, message: "internal error"}
+			}
+			glog.V(2).Infof("issuance api %s %s by %s failed: %s", req.Method, req.URL.Path, operator.Name, apiErr.message)
+			s.writeJSON(resp, apiErr.status, map[string]string{"error": apiErr.message})
+			return
+		}
+		s.writeJSON(resp, status, value)
+	}
+}
+
+func (s *issuanceAPI) authenticate(ctx context.Context, req *http.Request) (Operator, error) {
+	cert, err := verifyClientCertificate(ctx, req, s.ca, s.inventory)
+	if err != nil {
+		return Operator{}, err
+	}
+	for _, operator := range s.operators {
+		if operator.Authenticates(ctx, cert) {
+			return operator, nil
+		}
+	}
+	return Operator{}, errors.Errorf(ctx, "'%s' is not an operator", cert.Subject.String())
+}
+
+// authorize returns an error if the operator may not issue req or req contains the identity of an operator.
+func (s *issuanceAPI) authorize(ctx context.Context, operator Operator, req IssueRequest) error {
+	if err := operator.Authorize(ctx, req); err != nil {
+		return err
+	}
+	for _, name := range IssueRequestNames(req) {
+		if s.reservedNames[strings.ToLower(name)] {
+			return errors.Errorf(ctx, "name '%s' is reserved for an operator", name)
+		}
+	}
+	return nil
+}
+
+func (s *issuanceAPI) issue(ctx context.Context, req *http.Request, operator Operator) (int, interface{}, error) {
+	var body IssuanceAPIIssueRequest
+	if err := readIssuanceAPIRequest(ctx, req, &body); err != nil {
+		return 0, nil, err
+	}
+	issueRequest, err := body.issueRequest(ctx)
+	if err != nil {
+		return 0, nil, newIssuanceAPIError(http.StatusBadRequest, err.Error())
+	}
+	if err := s.authorize(ctx, operator, issueRequest); err != nil {
+		return 0, nil, newIssuanceAPIError(http.StatusForbidden, err.Error())
+	}
+	keyPair, err := IssueCertificate(ctx, s.ca, issueRequest)
+	if rejection := rejectionOf(err); rejection != nil {
+		return 0, nil, newIssuanceAPIError(http.StatusForbidden, rejection.Error())
+	}
+	if err != nil {
+		return 0, nil, errors.Wrapf(ctx, err, "issue certificate failed")
+	}
+	keyPEM, err := keyPair.PrivateKeyPEM(ctx)
+	if err != nil {
+		return 0, nil, errors.Wrapf(ctx, err, "encode key failed")
+	}
+	result, err := s.record(ctx, operator, AuditOperationIssue, keyPair.Certificate, issueRequest.Profile)
+	if err != nil {
+		return 0, nil, err
+	}
+	result.PrivateKey = string(keyPEM)
+	return http.StatusCreated, result, nil
+}
+
+func (s *issuanceAPI) sign(ctx context.Context, req *http.Request, operator Operator) (int, interface{}, error) {
+	var body IssuanceAPISignRequest
+	if err := readIssuanceAPIRequest(ctx, req, &body); err != nil {
+		return 0, nil, err
+	}
+	block, _ := pem.Decode([]byte(body.CSR))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return 0, nil, newIssuanceAPIError(http.StatusBadRequest, "csr is not a PEM encoded CERTIFICATE REQUEST")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return 0, nil, newIssuanceAPIError(http.StatusBadRequest, "parse csr failed: "+err.Error())
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return 0, nil, newIssuanceAPIError(http.StatusBadRequest, "invalid csr signature")
+	}
+	validity, err := parseIssuanceAPIValidity(ctx, body.Profile, body.Validity)
+	if err != nil {
+		return 0, nil, newIssuanceAPIError(http.StatusBadRequest, err.Error())
+	}
+	issueRequest := NewIssueRequestFromCSR(csr, body.Profile, validity)
+	if err := s.authorize(ctx, operator, issueRequest); err != nil {
+		return 0, nil, newIssuanceAPIError(http.StatusForbidden, err.Error())
+	}
+	cert, err := SignCertificate(ctx, s.ca, issueRequest, csr.PublicKey)
+	if rejection := rejectionOf(err); rejection != nil {
+		return 0, nil, newIssuanceAPIError(http.StatusForbidden, rejection.Error())
+	}
+	if err != nil {
+		return 0, nil, errors.Wrapf(ctx, err, "sign certificate failed")
+	}
+	result, err := s.record(ctx, operator, AuditOperationSign, cert, issueRequest.Profile)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, result, nil
+}
+
+// recorder records in the inventory and audit log of the API on behalf of operator.
+func (s *issuanceAPI) recorder(operator Operator) IssuanceRecorder {
+	return IssuanceRecorder{Inventory: s.inventory, AuditLog: s.auditLog, Requester: operator.requester()}
+}
+
+// record adds cert to the inventory and audit log and returns the API representation.
+func (s *issuanceAPI) record(ctx context.Context, operator Operator, operation AuditOperation, cert *x509.Certificate, profile Profile) (*IssuanceAPICertificate, error) {
+	if err := s.recorder(operator).Record(ctx, operation, cert, profile, "", ""); err != nil {
+		return nil, err
+	}
+	serialNumber := FormatHex(cert.SerialNumber.Bytes())
+	glog.V(2).Infof("issuance api issued %s certificate %s for %s by %s", profile, serialNumber, cert.Subject, operator.Name)
+	keyPair := &KeyPair{Certificate: cert}
+	return &IssuanceAPICertificate{
+		SerialNumber: serialNumber,
+		NotAfter:     cert.NotAfter,
+		Certificate:  string(keyPair.CertificatePEM()),
+		Chain:        string(keyPair.ChainPEM(s.ca.Intermediates()...)),
+	}, nil
+}
+
+func (s *issuanceAPI) list(ctx context.Context, req *http.Request, operator Operator) (int, interface{}, error) {
+	entries, err := s.inventory.List(ctx)
+	if err != nil {
+		return 0, nil, errors.Wrapf(ctx, err, "list inventory failed")
+	}
+	result := []InventoryEntry{}
+	for _, entry := range entries {
+		if operator.AuthorizeNames(ctx, entry.Profile, inventoryEntryNames(entry)) == nil {
+			result = append(result, entry)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].NotBefore.Before(result[j].NotBefore)
+	})
+	return http.StatusOK, result, nil
+}
+
+func (s *issuanceAPI) get(ctx context.Context, req *http.Request, operator Operator) (int, interface{}, error) {
+	entry, err := s.findEntry(ctx, operator, mux.Vars(req)["serial"])
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, entry, nil
+}
+
+func (s *issuanceAPI) revoke(ctx context.Context, req *http.Request, operator Operator) (int, interface{}, error) {
+	var body IssuanceAPIRevokeRequest
+	if err := readIssuanceAPIRequest(ctx, req, &body); err != nil {
+		return 0, nil, err
+	}
+	if err := ValidateRevocationReason(ctx, body.Reason); err != nil {
+		return 0, nil, newIssuanceAPIError(http.StatusBadRequest, "invalid revocation reason")
+	}
+
+	s.revokeMux.Lock()
+	defer s.revokeMux.Unlock()
+
+	entry, err := s.findEntry(ctx, operator, mux.Vars(req)["serial"])
+	if err != nil {
+		return 0, nil, err
+	}
+	if entry.Revoked() {
+		return 0, nil, newIssuanceAPIError(http.StatusConflict, "certificate already revoked")
+	}
+	if err := s.recorder(operator).Audit(ctx, NewAuditEntryFromInventory(AuditOperationRevoke, operator.requester(), *entry)); err != nil {
+		return 0, nil, err
+	}
+	now := time.Now()
+	entry, err = s.inventory.Revoke(ctx, entry.SerialNumber, body.Reason, now)
+	if err != nil {
+		return 0, nil, errors.Wrapf(ctx, err, "revoke failed")
+	}
+	glog.V(2).Infof("issuance api revoked certificate %s by %s", entry.SerialNumber, operator.Name)
+	if s.crlPublisher != nil {
+		entries, err := s.inventory.List(ctx)
+		if err != nil {
+			return 0, nil, errors.Wrapf(ctx, err, "list inventory failed")
+		}
+		if err := s.crlPublisher.Publish(ctx, entries, now); err != nil {
+			return 0, nil, errors.Wrapf(ctx, err, "publish crl failed")
+		}
+	}
+	return http.StatusOK, entry, nil
+}
+
+// findEntry returns the inventory entry if the operator may manage it.
+// Entries of other operators are reported as not found.
+func (s *issuanceAPI) findEntry(ctx context.Context, operator Operator, serialNumber string) (*InventoryEntry, error) {
+	entries, err := s.inventory.List(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "list inventory failed")
+	}
+	index, err := findInventoryEntry(ctx, entries, serialNumber)
+	if err != nil || operator.AuthorizeNames(ctx, entries[index].Profile, inventoryEntryNames(entries[index])) != nil {
+		return nil, newIssuanceAPIError(http.StatusNotFound, "certificate not found")
+	}
+	return &entries[index], nil
+}
+
+func (s *issuanceAPI) writeJSON(resp http.ResponseWriter, status int, value interface{}) {
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+	if err := json.NewEncoder(resp).Encode(value); err != nil {
+		glog.Warningf("write issuance api response failed: %v", err)
+	}
+}
+
+func readIssuanceAPIRequest(ctx context.Context, req *http.Request, value interface{}) error {
+	decoder := json.NewDecoder(io.LimitReader(req.Body, issuanceAPIMaxRequestSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(value); err != nil {
+		return newIssuanceAPIError(http.StatusBadRequest, "invalid json: "+err.Error())
+	}
+	return nil
+}
+
+func (i IssuanceAPIIssueRequest) issueRequest(ctx context.Context) (IssueRequest, error) {
+	validity, err := parseIssuanceAPIValidity(ctx, i.Profile, i.Validity)
+	if err != nil {
+		return IssueRequest{}, err
+	}
+	if i.CommonName == "" {
+		return IssueRequest{}, errors.Errorf(ctx, "common name missing")
+	}
+	result := IssueRequest{
+		Profile:        i.Profile,
+		CommonName:     i.CommonName,
+		Organization:   i.Organization,
+		DNSNames:       i.DNSNames,
+		EmailAddresses: i.EmailAddresses,
+		Validity:       validity,
+	}
+	for _, value := range i.IPAddresses {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return IssueRequest{}, errors.Errorf(ctx, "invalid ip address '%s'", value)
+		}
+		result.IPAddresses = append(result.IPAddresses, ip)
+	}
+	for _, value := range i.URIs {
+		uri, err := url.Parse(value)
+		if err != nil || uri.Scheme == "" {
+			return IssueRequest{}, errors.Errorf(ctx, "invalid uri '%s'", value)
+		}
+		result.URIs = append(result.URIs, uri)
+	}
+	return result, nil
+}
+
+// parseIssuanceAPIValidity returns the given duration or the default of the profile.
+func parseIssuanceAPIValidity(ctx context.Context, profile Profile, value string) (time.Duration, error) {
+	var defaults IssueRequest
+	switch profile {
+	case ProfileServer:
+		defaults = DefaultServerIssueRequest()
+	case ProfileClient:
+		defaults = DefaultClientIssueRequest()
+	default:
+		return 0, errors.Errorf(ctx, "unknown profile '%s'", profile)
+	}
+	if strings.TrimSpace(value) == "" {
+		return defaults.Validity, nil
+	}
+	validity, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Wrapf(ctx, err, "invalid validity '%s'", value)
+	}
+	if validity <= 0 {
+		return 0, errors.Errorf(ctx, "validity must be positive")
+	}
+	return validity, nil
+}
+
+// inventoryEntryNames returns the common name and subject alternative names of an entry.
+func inventoryEntryNames(entry InventoryEntry) []string {
+	var result []string
+	if entry.CommonName != "" {
+		result = append(result, entry.CommonName)
+	}
+	result = append(result, entry.DNSNames...)
+	result = append(result, entry.IPAddresses...)
+	result = append(result, entry.EmailAddresses...)
+	result = append(result, entry.URIs...)
+	return result
+}
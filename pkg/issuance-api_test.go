@@ -0,0 +1,266 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	stderrors "errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/bborbe/sample_cert/mocks"
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IssuanceAPI", func() {
+	var ctx context.Context
+	var ca *pkg.CA
+	var inventory pkg.Inventory
+	var crlPath string
+	var auditLog *mocks.AuditLog
+	var server *httptest.Server
+	var client *http.Client
+	var hrBot *pkg.KeyPair
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		ca, err = pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		dir := GinkgoT().TempDir()
+		inventory = pkg.NewFileInventory(filepath.Join(dir, pkg.InventoryFile))
+		crlPath = filepath.Join(dir, pkg.CACRLFile)
+		auditLog = &mocks.AuditLog{}
+		req := pkg.DefaultClientIssueRequest()
+		req.CommonName = "hr-bot"
+		hrBot, err = pkg.IssueCertificate(ctx, ca, req)
+		Expect(err).To(BeNil())
+
+		server = httptest.NewUnstartedServer(pkg.NewIssuanceAPI(ca, pkg.IssuanceAPIOptions{
+			Operators: []pkg.Operator{
+				{Name: "deploy-bot", SPIFFEID: "spiffe://example.org/operator/deploy-bot", Profiles: []pkg.Profile{pkg.ProfileServer}, NamePatterns: []string{"*.example.com"}},
+				{Name: "hr-bot", Fingerprints: []string{pkg.SHA256Fingerprint(hrBot.Certificate)}, Profiles: []pkg.Profile{pkg.ProfileClient}, NamePatterns: []string{"*@example.com", "*-bot", "spiffe://example.org/*/*"}},
+			},
+			Inventory:    inventory,
+			CRLPublisher: pkg.NewDataDirCRLPublisher(pkg.DataDir(dir), ca, nil),
+			AuditLog:     auditLog,
+		}))
+		server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+		server.StartTLS()
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+	useKeyPair := func(keyPair *pkg.KeyPair) {
+		// new transport to not reuse the connection of another operator
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = []tls.Certificate{{
+			Certificate: [][]byte{keyPair.Certificate.Raw},
+			PrivateKey:  keyPair.PrivateKey,
+		}}
+		client = &http.Client{Transport: transport}
+	}
+	loginWith := func(commonName string, spiffeID string) {
+		req := pkg.DefaultClientIssueRequest()
+		req.CommonName = commonName
+		if spiffeID != "" {
+			var err error
+			req, err = pkg.NewSVIDIssueRequest(ctx, req, spiffeID)
+			Expect(err).To(BeNil())
+		}
+		keyPair, err := pkg.IssueCertificate(ctx, ca, req)
+		Expect(err).To(BeNil())
+		useKeyPair(keyPair)
+	}
+	login := func(name string) {
+		if name == "hr-bot" {
+			useKeyPair(hrBot)
+			return
+		}
+		loginWith(name, "spiffe://example.org/operator/"+name)
+	}
+	call := func(method string, path string, body interface{}, result interface{}) int {
+		var buf bytes.Buffer
+		if body != nil {
+			Expect(json.NewEncoder(&buf).Encode(body)).To(Succeed())
+		}
+		req, err := http.NewRequest(method, server.URL+pkg.IssuanceAPIPathPrefix+path, &buf)
+		Expect(err).To(BeNil())
+		resp, err := client.Do(req)
+		Expect(err).To(BeNil())
+		defer resp.Body.Close()
+		if result != nil && resp.StatusCode < 300 {
+			Expect(json.NewDecoder(resp.Body).Decode(result)).To(Succeed())
+		}
+		return resp.StatusCode
+	}
+	issueServer := func() pkg.IssuanceAPICertificate {
+		var result pkg.IssuanceAPICertificate
+		Expect(call(http.MethodPost, "/certificates", pkg.IssuanceAPIIssueRequest{
+			Profile:    pkg.ProfileServer,
+			CommonName: "api.example.com",
+			DNSNames:   []string{"api.example.com"},
+			Validity:   "720h",
+		}, &result)).To(Equal(http.StatusCreated))
+		return result
+	}
+	It("rejects unknown operator", func() {
+		login("somebody")
+		Expect(call(http.MethodGet, "/certificates", nil, nil)).To(Equal(http.StatusUnauthorized))
+	})
+	It("rejects common name of an operator without pinned identity", func() {
+		loginWith("deploy-bot", "")
+		Expect(call(http.MethodGet, "/certificates", nil, nil)).To(Equal(http.StatusUnauthorized))
+		loginWith("hr-bot", "")
+		Expect(call(http.MethodGet, "/certificates", nil, nil)).To(Equal(http.StatusUnauthorized))
+	})
+	It("refuses to issue operator identities", func() {
+		login("hr-bot")
+		Expect(call(http.MethodPost, "/certificates", pkg.IssuanceAPIIssueRequest{
+			Profile:    pkg.ProfileClient,
+			CommonName: "deploy-bot",
+		}, nil)).To(Equal(http.StatusForbidden))
+		Expect(call(http.MethodPost, "/certificates", pkg.IssuanceAPIIssueRequest{
+			Profile:    pkg.ProfileClient,
+			CommonName: "ci-bot",
+			URIs:       []string{"spiffe://example.org/operator/deploy-bot"},
+		}, nil)).To(Equal(http.StatusForbidden))
+		Expect(call(http.MethodPost, "/certificates", pkg.IssuanceAPIIssueRequest{
+			Profile:    pkg.ProfileClient,
+			CommonName: "ci-bot",
+		}, nil)).To(Equal(http.StatusCreated))
+	})
+	It("rejects request without client certificate", func() {
+		client = server.Client()
+		Expect(call(http.MethodGet, "/certificates", nil, nil)).To(Equal(http.StatusUnauthorized))
+	})
+	Context("as deploy-bot", func() {
+		BeforeEach(func() {
+			login("deploy-bot")
+		})
+		It("issues certificate with key", func() {
+			result := issueServer()
+			block, _ := pem.Decode([]byte(result.Certificate))
+			Expect(block).NotTo(BeNil())
+			cert, err := x509.ParseCertificate(block.Bytes)
+			Expect(err).To(BeNil())
+			Expect(cert.DNSNames).To(Equal([]string{"api.example.com"}))
+			Expect(cert.CheckSignatureFrom(ca.Certificate)).To(Succeed())
+			Expect(result.PrivateKey).To(ContainSubstring("PRIVATE KEY"))
+
+			entries, err := inventory.List(ctx)
+			Expect(err).To(BeNil())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].SerialNumber).To(Equal(result.SerialNumber))
+		})
+		It("returns no certificate if the audit log fails", func() {
+			auditLog.AppendReturns(nil, stderrors.New("disk full"))
+			var result pkg.IssuanceAPICertificate
+			Expect(call(http.MethodPost, "/certificates", pkg.IssuanceAPIIssueRequest{
+				Profile:    pkg.ProfileServer,
+				CommonName: "api.example.com",
+			}, &result)).To(Equal(http.StatusInternalServerError))
+			Expect(result.Certificate).To(BeEmpty())
+			entries, err := inventory.List(ctx)
+			Expect(err).To(BeNil())
+			Expect(entries).To(BeEmpty())
+		})
+		It("rejects name outside of patterns", func() {
+			Expect(call(http.MethodPost, "/certificates", pkg.IssuanceAPIIssueRequest{
+				Profile:    pkg.ProfileServer,
+				CommonName: "api.example.org",
+			}, nil)).To(Equal(http.StatusForbidden))
+		})
+		It("rejects request violating the ca policy", func() {
+			ca.Policy = &pkg.Policy{Profiles: map[pkg.Profile]pkg.ProfilePolicy{
+				pkg.ProfileServer: {ForbidWildcards: true},
+			}}
+			Expect(call(http.MethodPost, "/certificates", pkg.IssuanceAPIIssueRequest{
+				Profile:    pkg.ProfileServer,
+				CommonName: "api.example.com",
+				DNSNames:   []string{"*.example.com"},
+			}, nil)).To(Equal(http.StatusForbidden))
+			Expect(auditLog.AppendCallCount()).To(Equal(0))
+		})
+		It("rejects client profile", func() {
+			Expect(call(http.MethodPost, "/certificates", pkg.IssuanceAPIIssueRequest{
+				Profile:    pkg.ProfileClient,
+				CommonName: "a.example.com",
+			}, nil)).To(Equal(http.StatusForbidden))
+		})
+		It("signs csr", func() {
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Expect(err).To(BeNil())
+			der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+				Subject:  pkix.Name{CommonName: "web.example.com"},
+				DNSNames: []string{"web.example.com"},
+			}, key)
+			Expect(err).To(BeNil())
+			var result pkg.IssuanceAPICertificate
+			Expect(call(http.MethodPost, "/sign", pkg.IssuanceAPISignRequest{
+				Profile: pkg.ProfileServer,
+				CSR:     string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})),
+			}, &result)).To(Equal(http.StatusCreated))
+			Expect(result.PrivateKey).To(BeEmpty())
+			block, _ := pem.Decode([]byte(result.Certificate))
+			cert, err := x509.ParseCertificate(block.Bytes)
+			Expect(err).To(BeNil())
+			Expect(pkg.CheckKeyPair(ctx, cert, key)).To(Succeed())
+		})
+		It("gets, lists and revokes", func() {
+			result := issueServer()
+
+			var entry pkg.InventoryEntry
+			Expect(call(http.MethodGet, "/certificates/"+result.SerialNumber, nil, &entry)).To(Equal(http.StatusOK))
+			Expect(entry.CommonName).To(Equal("api.example.com"))
+
+			var entries []pkg.InventoryEntry
+			Expect(call(http.MethodGet, "/certificates", nil, &entries)).To(Equal(http.StatusOK))
+			Expect(entries).To(HaveLen(1))
+
+			Expect(call(http.MethodPost, "/certificates/"+result.SerialNumber+"/revoke", pkg.IssuanceAPIRevokeRequest{Reason: 1}, &entry)).To(Equal(http.StatusOK))
+			Expect(entry.Revoked()).To(BeTrue())
+			Expect(crlPath).To(BeAnExistingFile())
+			crlPEM, err := os.ReadFile(crlPath)
+			Expect(err).To(BeNil())
+			block, _ := pem.Decode(crlPEM)
+			crl, err := x509.ParseRevocationList(block.Bytes)
+			Expect(err).To(BeNil())
+			Expect(crl.RevokedCertificateEntries).To(HaveLen(1))
+
+			Expect(call(http.MethodPost, "/certificates/"+result.SerialNumber+"/revoke", pkg.IssuanceAPIRevokeRequest{Reason: 1}, nil)).To(Equal(http.StatusConflict))
+
+			Expect(auditLog.AppendCallCount()).To(Equal(2))
+			_, issued := auditLog.AppendArgsForCall(0)
+			Expect(issued.Operation).To(Equal(pkg.AuditOperationIssue))
+			Expect(issued.Requester).To(Equal("operator:deploy-bot"))
+			Expect(issued.SerialNumber).To(Equal(result.SerialNumber))
+			_, revoked := auditLog.AppendArgsForCall(1)
+			Expect(revoked.Operation).To(Equal(pkg.AuditOperationRevoke))
+			Expect(revoked.SerialNumber).To(Equal(result.SerialNumber))
+		})
+		It("hides certificates of other operators", func() {
+			result := issueServer()
+			login("hr-bot")
+			Expect(call(http.MethodGet, "/certificates/"+result.SerialNumber, nil, nil)).To(Equal(http.StatusNotFound))
+			Expect(call(http.MethodPost, "/certificates/"+result.SerialNumber+"/revoke", pkg.IssuanceAPIRevokeRequest{}, nil)).To(Equal(http.StatusNotFound))
+			var entries []pkg.InventoryEntry
+			Expect(call(http.MethodGet, "/certificates", nil, &entries)).To(Equal(http.StatusOK))
+			Expect(entries).To(BeEmpty())
+		})
+	})
+})
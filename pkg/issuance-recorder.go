@@ -0,0 +1,64 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto/x509"
+
+	"github.com/bborbe/errors"
+)
+
+// IssuanceRecorder records issued certificates in Inventory and AuditLog, nil ones are skipped.
+// Operations record before a certificate is written or handed out and abort if recording fails,
+// so no certificate leaves the CA unrecorded.
+type IssuanceRecorder struct {
+	Inventory Inventory
+	AuditLog  AuditLog
+	Requester string
+}
+
+// IssuanceRecorder returns the recorder with inventory and audit log of the DataDir for operations of the local user.
+// Every issued leaf is added to the inventory, so CAGenerations.CountLeaves, revocation and CRLs cover it.
+func (d DataDir) IssuanceRecorder(ctx context.Context) (IssuanceRecorder, error) {
+	inventory, err := d.Inventory(ctx)
+	if err != nil {
+		return IssuanceRecorder{}, err
+	}
+	auditLog, err := d.AuditLog(ctx)
+	if err != nil {
+		return IssuanceRecorder{}, err
+	}
+	return IssuanceRecorder{Inventory: inventory, AuditLog: auditLog, Requester: LocalRequester()}, nil
+}
+
+// Record appends operation to the audit log and adds cert to the inventory.
+// The audit log comes first, a failed append leaves no inventory entry for a certificate never handed out.
+func (r IssuanceRecorder) Record(ctx context.Context, operation AuditOperation, cert *x509.Certificate, profile Profile, certPath string, keyPath string) error {
+	if err := r.Audit(ctx, NewAuditEntry(operation, r.Requester, cert, profile)); err != nil {
+		return err
+	}
+	if r.Inventory == nil {
+		return nil
+	}
+	if err := r.Inventory.Add(ctx, NewInventoryEntry(cert, profile, certPath, keyPath)); err != nil {
+		return errors.Wrapf(ctx, err, "add to inventory failed")
+	}
+	return nil
+}
+
+// Audit appends entry to the audit log, used for CAs and revocations which are not added to the inventory.
+func (r IssuanceRecorder) Audit(ctx context.Context, entry AuditEntry) error {
+	if r.AuditLog == nil {
+		return nil
+	}
+	if entry.Requester == "" {
+		entry.Requester = r.Requester
+	}
+	if _, err := r.AuditLog.Append(ctx, entry); err != nil {
+		return errors.Wrapf(ctx, err, "append audit log failed")
+	}
+	return nil
+}
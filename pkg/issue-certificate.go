@@ -0,0 +1,217 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/bborbe/errors"
+)
+
+// Profile selects key usages and extended key usages of an issued certificate.
+type Profile string
+
+const (
+	// ProfileServer issues certificates for TLS servers.
+	ProfileServer Profile = "server"
+	// ProfileClient issues certificates for TLS client authentication.
+	ProfileClient Profile = "client"
+)
+
+// IssueRequest describes the leaf certificate to issue.
+type IssueRequest struct {
+	Profile        Profile
+	CommonName     string
+	Organization   []string
+	DNSNames       []string
+	IPAddresses    []net.IP
+	EmailAddresses []string
+	URIs           []*url.URL
+	Validity       time.Duration
+}
+
+// DefaultServerIssueRequest returns the request used by GenerateServerCert.
+func DefaultServerIssueRequest() IssueRequest {
+	return IssueRequest{
+		Profile:      ProfileServer,
+		CommonName:   "localhost",
+		Organization: []string{"My Server Organization"},
+		DNSNames:     []string{"localhost"},
+		Validity:     365 * 24 * time.Hour, // 1 year validity
+	}
+}
+
+// DefaultClientIssueRequest returns the request used by GenerateClientCert.
+func DefaultClientIssueRequest() IssueRequest {
+	return IssueRequest{
+		Profile:      ProfileClient,
+		CommonName:   "client", // Adjust as necessary for client identity
+		Organization: []string{"My Client Organization"},
+		Validity:     365 * 24 * time.Hour, // 1 year validity
+	}
+}
+
+// NewIssueRequestFromCertificate returns a request for a certificate with the same names,
+// profile and validity as cert, used to renew it.
+func NewIssueRequestFromCertificate(ctx context.Context, cert *x509.Certificate) (IssueRequest, error) {
+	profile, err := ProfileOfCertificate(ctx, cert)
+	if err != nil {
+		return IssueRequest{}, errors.Wrapf(ctx, err, "detect profile failed")
+	}
+	return IssueRequest{
+		Profile:        profile,
+		CommonName:     cert.Subject.CommonName,
+		Organization:   cert.Subject.Organization,
+		DNSNames:       cert.DNSNames,
+		IPAddresses:    cert.IPAddresses,
+		EmailAddresses: cert.EmailAddresses,
+		URIs:           cert.URIs,
+		Validity:       cert.NotAfter.Sub(cert.NotBefore),
+	}, nil
+}
+
+// NewIssueRequestFromCSR returns a request with the names of the given CSR.
+func NewIssueRequestFromCSR(csr *x509.CertificateRequest, profile Profile, validity time.Duration) IssueRequest {
+	return IssueRequest{
+		Profile:        profile,
+		CommonName:     csr.Subject.CommonName,
+		Organization:   csr.Subject.Organization,
+		DNSNames:       csr.DNSNames,
+		IPAddresses:    csr.IPAddresses,
+		EmailAddresses: csr.EmailAddresses,
+		URIs:           csr.URIs,
+		Validity:       validity,
+	}
+}
+
+// ProfileOfCertificate derives the Profile from the extended key usages of cert.
+func ProfileOfCertificate(ctx context.Context, cert *x509.Certificate) (Profile, error) {
+	for _, usage := range cert.ExtKeyUsage {
+		switch usage {
+		case x509.ExtKeyUsageServerAuth:
+			return ProfileServer, nil
+		case x509.ExtKeyUsageClientAuth:
+			return ProfileClient, nil
+		}
+	}
+	return "", errors.Errorf(ctx, "certificate '%s' has no server or client auth usage", cert.Subject.String())
+}
+
+// IssueCertificate generates a new private key and a certificate for it signed by the given CA.
+func IssueCertificate(ctx context.Context, ca *CA, req IssueRequest) (*KeyPair, error) {
+	priv, err := generateKey(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "generate key failed")
+	}
+	cert, err := SignCertificate(ctx, ca, req, priv.Public())
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "sign certificate failed")
+	}
+	return &KeyPair{
+		Certificate: cert,
+		PrivateKey:  priv,
+	}, nil
+}
+
+// SignCertificate creates a certificate for the given public key signed by the given CA.
+// It returns a *PolicyViolationError if the Policy of the CA rejects the request
+// and a *NameConstraintError if the names are outside the name constraints of the CA, its issuers or cross certificates.
+func SignCertificate(ctx context.Context, ca *CA, req IssueRequest, publicKey crypto.PublicKey) (*x509.Certificate, error) {
+	if req.Validity <= 0 {
+		return nil, errors.Errorf(ctx, "validity must be positive, got %s", req.Validity)
+	}
+	if err := ca.Policy.Check(ctx, req, publicKey); err != nil {
+		return nil, err
+	}
+	if err := ca.CheckNameConstraints(ctx, req); err != nil {
+		return nil, err
+	}
+	template, err := createTemplate(ctx, req)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "create template failed")
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Certificate, publicKey, ca.Signer)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "create certificate failed")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "parse certificate failed")
+	}
+	return cert, nil
+}
+
+// rejectionOf returns the *PolicyViolationError or *NameConstraintError causing err,
+// nil if err is no rejection of the request.
+func rejectionOf(err error) error {
+	var policyErr *PolicyViolationError
+	if errors.As(err, &policyErr) {
+		return policyErr
+	}
+	var nameConstraintErr *NameConstraintError
+	if errors.As(err, &nameConstraintErr) {
+		return nameConstraintErr
+	}
+	return nil
+}
+
+func createTemplate(ctx context.Context, req IssueRequest) (*x509.Certificate, error) {
+	serialNumber, err := newSerialNumber(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "generate serial number failed")
+	}
+	notBefore := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: req.Organization,
+			CommonName:   req.CommonName,
+		},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(req.Validity),
+		BasicConstraintsValid: true,
+		DNSNames:              req.DNSNames,
+		IPAddresses:           req.IPAddresses,
+		EmailAddresses:        req.EmailAddresses,
+		URIs:                  req.URIs,
+	}
+	template.KeyUsage, template.ExtKeyUsage, err = profileKeyUsages(ctx, req.Profile)
+	if err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+// profileKeyUsages returns the key usages and extended key usages of profile.
+func profileKeyUsages(ctx context.Context, profile Profile) (x509.KeyUsage, []x509.ExtKeyUsage, error) {
+	switch profile {
+	case ProfileServer:
+		return x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, nil
+	case ProfileClient:
+		return x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, nil
+	default:
+		return 0, nil, errors.Errorf(ctx, "unknown profile '%s'", profile)
+	}
+}
+
+func generateKey(ctx context.Context) (crypto.Signer, error) {
+	return NewKeyGenerator(KeyTypeECDSAP256).GenerateKey(ctx)
+}
+
+func newSerialNumber(ctx context.Context) (*big.Int, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "generate random failed")
+	}
+	return serialNumber, nil
+}
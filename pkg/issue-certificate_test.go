@@ -0,0 +1,74 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"crypto/x509"
+	"path/filepath"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IssueCertificate", func() {
+	var ctx context.Context
+	var ca *pkg.CA
+	var err error
+	BeforeEach(func() {
+		ctx = context.Background()
+		ca, err = pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+	})
+	It("creates self-signed ca", func() {
+		Expect(ca.Certificate.IsCA).To(BeTrue())
+		Expect(ca.Certificate.CheckSignatureFrom(ca.Certificate)).To(Succeed())
+	})
+	It("issues server certificate trusted by ca", func() {
+		keyPair, err := pkg.IssueCertificate(ctx, ca, pkg.DefaultServerIssueRequest())
+		Expect(err).To(BeNil())
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.Certificate)
+		_, err = keyPair.Certificate.Verify(x509.VerifyOptions{
+			DNSName:   "localhost",
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})
+		Expect(err).To(BeNil())
+	})
+	It("issues client certificate trusted by ca", func() {
+		keyPair, err := pkg.IssueCertificate(ctx, ca, pkg.DefaultClientIssueRequest())
+		Expect(err).To(BeNil())
+		Expect(keyPair.Certificate.Subject.CommonName).To(Equal("client"))
+		Expect(keyPair.Certificate.ExtKeyUsage).To(Equal([]x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}))
+	})
+	It("rejects unknown profile", func() {
+		_, err := pkg.IssueCertificate(ctx, ca, pkg.IssueRequest{Profile: "banana"})
+		Expect(err).NotTo(BeNil())
+	})
+	It("rejects non-positive validity", func() {
+		req := pkg.DefaultServerIssueRequest()
+		req.Validity = 0
+		_, err := pkg.IssueCertificate(ctx, ca, req)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("validity must be positive"))
+	})
+	It("roundtrips ca through pem", func() {
+		keyPEM, err := ca.PrivateKeyPEM(ctx)
+		Expect(err).To(BeNil())
+		parsed, err := pkg.ParseCA(ctx, ca.CertificatePEM(), keyPEM)
+		Expect(err).To(BeNil())
+		Expect(parsed.Certificate.Equal(ca.Certificate)).To(BeTrue())
+	})
+	It("path based functions wrap in-memory api", func() {
+		dir := GinkgoT().TempDir()
+		caCertPath := filepath.Join(dir, "ca_cert.pem")
+		caKeyPath := filepath.Join(dir, "ca_key.pem")
+		Expect(pkg.GenerateCaCerts(ctx, caCertPath, caKeyPath)).To(Succeed())
+		Expect(pkg.GenerateServerCert(ctx, caCertPath, caKeyPath, filepath.Join(dir, "server_cert.pem"), filepath.Join(dir, "server_key.pem"))).To(Succeed())
+		Expect(pkg.GenerateClientCert(ctx, caCertPath, caKeyPath, filepath.Join(dir, "client_cert.pem"), filepath.Join(dir, "client_key.pem"))).To(Succeed())
+	})
+})
@@ -0,0 +1,102 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+
+	"github.com/bborbe/errors"
+)
+
+// KeyType defines algorithm and size of generated private keys.
+type KeyType string
+
+const (
+	KeyTypeECDSAP256 KeyType = "ecdsa-p256"
+	KeyTypeECDSAP384 KeyType = "ecdsa-p384"
+	KeyTypeRSA2048   KeyType = "rsa-2048"
+	KeyTypeRSA4096   KeyType = "rsa-4096"
+	KeyTypeEd25519   KeyType = "ed25519"
+)
+
+// KeyTypes contains all supported key types.
+var KeyTypes = []KeyType{
+	KeyTypeECDSAP256,
+	KeyTypeECDSAP384,
+	KeyTypeRSA2048,
+	KeyTypeRSA4096,
+	KeyTypeEd25519,
+}
+
+// ParseKeyType returns the KeyType for the given string.
+func ParseKeyType(ctx context.Context, value string) (KeyType, error) {
+	for _, keyType := range KeyTypes {
+		if string(keyType) == value {
+			return keyType, nil
+		}
+	}
+	return "", errors.Errorf(ctx, "unknown key type '%s'", value)
+}
+
+//counterfeiter:generate -o ../mocks/key-generator.go --fake-name KeyGenerator . KeyGenerator
+
+// KeyGenerator creates new private keys.
+type KeyGenerator interface {
+	GenerateKey(ctx context.Context) (crypto.Signer, error)
+}
+
+// NewKeyGenerator returns a KeyGenerator for the given key type.
+func NewKeyGenerator(keyType KeyType) KeyGenerator {
+	return &keyGenerator{
+		keyType: keyType,
+	}
+}
+
+type keyGenerator struct {
+	keyType KeyType
+}
+
+func (k *keyGenerator) GenerateKey(ctx context.Context) (crypto.Signer, error) {
+	switch k.keyType {
+	case KeyTypeECDSAP256:
+		return generateECDSAKey(ctx, elliptic.P256())
+	case KeyTypeECDSAP384:
+		return generateECDSAKey(ctx, elliptic.P384())
+	case KeyTypeRSA2048:
+		return generateRSAKey(ctx, 2048)
+	case KeyTypeRSA4096:
+		return generateRSAKey(ctx, 4096)
+	case KeyTypeEd25519:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "generate ed25519 key failed")
+		}
+		return priv, nil
+	default:
+		return nil, errors.Errorf(ctx, "unknown key type '%s'", k.keyType)
+	}
+}
+
+func generateECDSAKey(ctx context.Context, curve elliptic.Curve) (crypto.Signer, error) {
+	priv, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "generate ecdsa key failed")
+	}
+	return priv, nil
+}
+
+func generateRSAKey(ctx context.Context, bits int) (crypto.Signer, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "generate rsa key failed")
+	}
+	return priv, nil
+}
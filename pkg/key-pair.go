@@ -0,0 +1,75 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+
+	"github.com/bborbe/errors"
+)
+
+// KeyPair is an issued certificate together with its private key.
+type KeyPair struct {
+	Certificate *x509.Certificate
+	PrivateKey  crypto.Signer
+}
+
+// CertificatePEM returns the PEM encoded certificate.
+func (k *KeyPair) CertificatePEM() []byte {
+	return EncodeCertificatePEM(k.Certificate)
+}
+
+// ChainPEM returns the PEM encoded certificate followed by the given intermediates.
+func (k *KeyPair) ChainPEM(intermediates ...*x509.Certificate) []byte {
+	result := k.CertificatePEM()
+	for _, cert := range intermediates {
+		result = append(result, EncodeCertificatePEM(cert)...)
+	}
+	return result
+}
+
+// PrivateKeyPEM returns the PEM encoded private key.
+func (k *KeyPair) PrivateKeyPEM(ctx context.Context) ([]byte, error) {
+	return EncodePrivateKeyPEM(ctx, k.PrivateKey)
+}
+
+// WriteKeyPair writes certificate and private key of the given KeyPair to files.
+func WriteKeyPair(ctx context.Context, keyPair *KeyPair, certPath string, keyPath string) error {
+	return writeKeyPairFiles(ctx, keyPair, IdentityPaths{CertPath: certPath, KeyPath: keyPath}, nil)
+}
+
+// WriteIdentity writes certificate and key and, if paths has a ChainPath, the chain up to the root of ca.
+// Missing directories are created.
+func WriteIdentity(ctx context.Context, keyPair *KeyPair, ca *CA, paths IdentityPaths) error {
+	if err := CreateParentDirs(ctx, paths.CertPath, paths.KeyPath, paths.ChainPath); err != nil {
+		return err
+	}
+	if paths.ChainPath == "" {
+		return writeKeyPairFiles(ctx, keyPair, paths, nil)
+	}
+	return writeKeyPairFiles(ctx, keyPair, paths, keyPair.ChainPEM(ca.Intermediates()...))
+}
+
+// writeKeyPairFiles writes all files before replacing any of them, the key first. A reader between the renames
+// sees the new key with the old certificate and fails on the mismatch instead of using an outdated pair.
+func writeKeyPairFiles(ctx context.Context, keyPair *KeyPair, paths IdentityPaths, chain []byte) error {
+	keyPEM, err := keyPair.PrivateKeyPEM(ctx)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "encode key failed")
+	}
+	files := []atomicFile{
+		{path: paths.KeyPath, data: keyPEM, perm: PrivateKeyFileMode},
+		{path: paths.CertPath, data: keyPair.CertificatePEM(), perm: CertificateFileMode},
+	}
+	if chain != nil {
+		files = append(files, atomicFile{path: paths.ChainPath, data: chain, perm: CertificateFileMode})
+	}
+	if err := writeFilesAtomic(ctx, files...); err != nil {
+		return errors.Wrapf(ctx, err, "write key pair failed")
+	}
+	return nil
+}
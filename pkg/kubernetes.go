@@ -0,0 +1,228 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"regexp"
+	"strings"
+
+	"github.com/bborbe/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Keys used in kubernetes.io/tls Secrets and the CA ConfigMap.
+const (
+	KubernetesTLSCertKey = "tls.crt"
+	KubernetesTLSKeyKey  = "tls.key"
+	KubernetesCACertKey  = "ca.crt"
+)
+
+var (
+	kubernetesNameRegexp      = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+	kubernetesNamespaceRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	kubernetesLabelKeyRegexp  = regexp.MustCompile(`^([a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*/)?[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+	kubernetesLabelValRegexp  = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?)?$`)
+)
+
+// KubernetesMeta is the metadata of a generated Kubernetes object.
+type KubernetesMeta struct {
+	Name      string            `yaml:"name"`
+	Namespace string            `yaml:"namespace,omitempty"`
+	Labels    map[string]string `yaml:"labels,omitempty"`
+}
+
+// NewKubernetesMeta validates name and namespace and parses labels given as comma separated key=value pairs.
+func NewKubernetesMeta(ctx context.Context, name string, namespace string, labels string) (KubernetesMeta, error) {
+	if len(name) > 253 || !kubernetesNameRegexp.MatchString(name) {
+		return KubernetesMeta{}, errors.Errorf(ctx, "invalid kubernetes name '%s'", name)
+	}
+	if namespace != "" && (len(namespace) > 63 || !kubernetesNamespaceRegexp.MatchString(namespace)) {
+		return KubernetesMeta{}, errors.Errorf(ctx, "invalid kubernetes namespace '%s'", namespace)
+	}
+	meta := KubernetesMeta{
+		Name:      name,
+		Namespace: namespace,
+	}
+	for _, label := range strings.Split(labels, ",") {
+		label = strings.TrimSpace(label)
+		if label == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(label, "=")
+		if len(key) > 316 || !kubernetesLabelKeyRegexp.MatchString(key) {
+			return KubernetesMeta{}, errors.Errorf(ctx, "invalid kubernetes label key '%s'", key)
+		}
+		if len(value) > 63 || !kubernetesLabelValRegexp.MatchString(value) {
+			return KubernetesMeta{}, errors.Errorf(ctx, "invalid kubernetes label value '%s'", value)
+		}
+		if meta.Labels == nil {
+			meta.Labels = make(map[string]string)
+		}
+		meta.Labels[key] = value
+	}
+	return meta, nil
+}
+
+type kubernetesObject struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   KubernetesMeta    `yaml:"metadata"`
+	Type       string            `yaml:"type,omitempty"`
+	Data       map[string]string `yaml:"data"`
+}
+
+// KubernetesTLSSecretYAML returns a kubernetes.io/tls Secret with certificate, key and, if given, the CA certificate.
+func KubernetesTLSSecretYAML(ctx context.Context, meta KubernetesMeta, certPEM []byte, keyPEM []byte, caPEM []byte) ([]byte, error) {
+	data := map[string]string{
+		KubernetesTLSCertKey: base64.StdEncoding.EncodeToString(certPEM),
+		KubernetesTLSKeyKey:  base64.StdEncoding.EncodeToString(keyPEM),
+	}
+	if len(caPEM) > 0 {
+		data[KubernetesCACertKey] = base64.StdEncoding.EncodeToString(caPEM)
+	}
+	return marshalKubernetesObject(ctx, kubernetesObject{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   meta,
+		Type:       "kubernetes.io/tls",
+		Data:       data,
+	})
+}
+
+// KubernetesCAConfigMapYAML returns a ConfigMap holding the CA bundle as ca.crt.
+func KubernetesCAConfigMapYAML(ctx context.Context, meta KubernetesMeta, caPEM []byte) ([]byte, error) {
+	return marshalKubernetesObject(ctx, kubernetesObject{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Metadata:   meta,
+		Data: map[string]string{
+			KubernetesCACertKey: string(caPEM),
+		},
+	})
+}
+
+func marshalKubernetesObject(ctx context.Context, object kubernetesObject) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(object); err != nil {
+		return nil, errors.Wrapf(ctx, err, "marshal %s failed", object.Kind)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, errors.Wrapf(ctx, err, "marshal %s failed", object.Kind)
+	}
+	return buf.Bytes(), nil
+}
+
+// KubernetesOutput configures the Kubernetes manifests written next to the PEM files.
+// Empty paths disable the manifest.
+type KubernetesOutput struct {
+	SecretPath    string
+	SecretName    string
+	ConfigMapPath string
+	ConfigMapName string
+	Namespace     string
+	Labels        string
+	// CABundlePath is the trust bundle added to ca.crt if it exists, set by Resolve to ca_bundle.pem of the data dir.
+	CABundlePath string
+}
+
+// Resolve returns the output with absolute paths inside dataDir after validating names and labels.
+func (k KubernetesOutput) Resolve(ctx context.Context, dataDir DataDir) (KubernetesOutput, error) {
+	var err error
+	if k.SecretPath, err = dataDir.optionalPath(ctx, k.SecretPath, ""); err != nil {
+		return KubernetesOutput{}, err
+	}
+	if k.ConfigMapPath, err = dataDir.optionalPath(ctx, k.ConfigMapPath, ""); err != nil {
+		return KubernetesOutput{}, err
+	}
+	if k.CABundlePath, err = dataDir.Path(ctx, CABundleFile); err != nil {
+		return KubernetesOutput{}, err
+	}
+	if k.SecretPath != "" {
+		if _, err := NewKubernetesMeta(ctx, k.SecretName, k.Namespace, k.Labels); err != nil {
+			return KubernetesOutput{}, errors.Wrapf(ctx, err, "invalid secret")
+		}
+	}
+	if k.ConfigMapPath != "" {
+		if _, err := NewKubernetesMeta(ctx, k.ConfigMapName, k.Namespace, k.Labels); err != nil {
+			return KubernetesOutput{}, errors.Wrapf(ctx, err, "invalid configmap")
+		}
+	}
+	return k, nil
+}
+
+// Paths returns the configured manifest files.
+func (k KubernetesOutput) Paths() []string {
+	var result []string
+	for _, path := range []string{k.SecretPath, k.ConfigMapPath} {
+		if path != "" {
+			result = append(result, path)
+		}
+	}
+	return result
+}
+
+// WriteKubernetesManifests writes the Secret for keyPair, if keyPair is not nil, and the ConfigMap.
+// Both carry the trust bundle as ca.crt: the root of ca and the certificates of CABundlePath,
+// so clients keep trusting old and new CA during a rotation.
+func WriteKubernetesManifests(ctx context.Context, output KubernetesOutput, keyPair *KeyPair, ca *CA) error {
+	if output.SecretPath == "" && output.ConfigMapPath == "" {
+		return nil
+	}
+	trustPEM, err := output.trustBundlePEM(ctx, ca)
+	if err != nil {
+		return err
+	}
+	if output.SecretPath != "" && keyPair != nil {
+		meta, err := NewKubernetesMeta(ctx, output.SecretName, output.Namespace, output.Labels)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "invalid secret")
+		}
+		keyPEM, err := keyPair.PrivateKeyPEM(ctx)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "encode key failed")
+		}
+		secret, err := KubernetesTLSSecretYAML(ctx, meta, keyPair.ChainPEM(ca.Intermediates()...), keyPEM, trustPEM)
+		if err != nil {
+			return err
+		}
+		if err := WritePrivateKeyFile(ctx, output.SecretPath, secret); err != nil {
+			return errors.Wrapf(ctx, err, "write secret failed")
+		}
+	}
+	if output.ConfigMapPath != "" {
+		meta, err := NewKubernetesMeta(ctx, output.ConfigMapName, output.Namespace, output.Labels)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "invalid configmap")
+		}
+		configMap, err := KubernetesCAConfigMapYAML(ctx, meta, trustPEM)
+		if err != nil {
+			return err
+		}
+		if err := WriteCertificateFile(ctx, output.ConfigMapPath, configMap); err != nil {
+			return errors.Wrapf(ctx, err, "write configmap failed")
+		}
+	}
+	return nil
+}
+
+// trustBundlePEM returns the root of ca followed by the certificates of CABundlePath not contained yet.
+func (k KubernetesOutput) trustBundlePEM(ctx context.Context, ca *CA) ([]byte, error) {
+	bundle := CABundle{ca.Root()}
+	if k.CABundlePath != "" {
+		caBundle, err := LoadCABundle(ctx, k.CABundlePath)
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "load ca bundle failed")
+		}
+		if bundle, _, err = bundle.Add(ctx, caBundle...); err != nil {
+			return nil, errors.Wrapf(ctx, err, "add ca bundle failed")
+		}
+	}
+	return bundle.PEM(), nil
+}
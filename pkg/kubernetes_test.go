@@ -0,0 +1,123 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+)
+
+var _ = Describe("Kubernetes", func() {
+	type object struct {
+		APIVersion string `yaml:"apiVersion"`
+		Kind       string `yaml:"kind"`
+		Metadata   struct {
+			Name      string            `yaml:"name"`
+			Namespace string            `yaml:"namespace"`
+			Labels    map[string]string `yaml:"labels"`
+		} `yaml:"metadata"`
+		Type string            `yaml:"type"`
+		Data map[string]string `yaml:"data"`
+	}
+	var ctx context.Context
+	var ca *pkg.CA
+	var keyPair *pkg.KeyPair
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		ca, err = pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		keyPair, err = pkg.IssueCertificate(ctx, ca, pkg.DefaultServerIssueRequest())
+		Expect(err).To(BeNil())
+	})
+	Context("NewKubernetesMeta", func() {
+		It("parses labels", func() {
+			meta, err := pkg.NewKubernetesMeta(ctx, "server-tls", "prod", "app=http-server, app.kubernetes.io/part-of=sample")
+			Expect(err).To(BeNil())
+			Expect(meta.Labels).To(Equal(map[string]string{"app": "http-server", "app.kubernetes.io/part-of": "sample"}))
+		})
+		DescribeTable("rejects invalid input",
+			func(name string, namespace string, labels string) {
+				_, err := pkg.NewKubernetesMeta(ctx, name, namespace, labels)
+				Expect(err).NotTo(BeNil())
+			},
+			Entry("empty name", "", "", ""),
+			Entry("upper case name", "Server", "", ""),
+			Entry("namespace with dot", "server", "a.b", ""),
+			Entry("label value with space", "server", "", "app=http server"),
+			Entry("empty label key", "server", "", "=value"),
+		)
+	})
+	It("writes tls secret and ca configmap", func() {
+		dir := GinkgoT().TempDir()
+		output, err := pkg.KubernetesOutput{
+			SecretPath:    "secret.yaml",
+			SecretName:    "server-tls",
+			ConfigMapPath: "configmap.yaml",
+			ConfigMapName: "ca-bundle",
+			Namespace:     "prod",
+			Labels:        "app=http-server",
+		}.Resolve(ctx, pkg.DataDir(dir))
+		Expect(err).To(BeNil())
+		Expect(output.Paths()).To(Equal([]string{filepath.Join(dir, "secret.yaml"), filepath.Join(dir, "configmap.yaml")}))
+		Expect(pkg.WriteKubernetesManifests(ctx, output, keyPair, ca)).To(Succeed())
+
+		data, err := os.ReadFile(output.SecretPath)
+		Expect(err).To(BeNil())
+		var secret object
+		Expect(yaml.Unmarshal(data, &secret)).To(Succeed())
+		Expect(secret.Kind).To(Equal("Secret"))
+		Expect(secret.Type).To(Equal("kubernetes.io/tls"))
+		Expect(secret.Metadata.Namespace).To(Equal("prod"))
+		Expect(secret.Metadata.Labels).To(HaveKeyWithValue("app", "http-server"))
+		tlsCrt, err := base64.StdEncoding.DecodeString(secret.Data["tls.crt"])
+		Expect(err).To(BeNil())
+		Expect(tlsCrt).To(Equal(keyPair.CertificatePEM()))
+		tlsKey, err := base64.StdEncoding.DecodeString(secret.Data["tls.key"])
+		Expect(err).To(BeNil())
+		key, err := pkg.ParsePrivateKeyPEM(ctx, tlsKey)
+		Expect(err).To(BeNil())
+		Expect(pkg.CheckKeyPair(ctx, keyPair.Certificate, key)).To(Succeed())
+		caCrt, err := base64.StdEncoding.DecodeString(secret.Data["ca.crt"])
+		Expect(err).To(BeNil())
+		Expect(caCrt).To(Equal(ca.CertificatePEM()))
+
+		data, err = os.ReadFile(output.ConfigMapPath)
+		Expect(err).To(BeNil())
+		var configMap object
+		Expect(yaml.Unmarshal(data, &configMap)).To(Succeed())
+		Expect(configMap.Kind).To(Equal("ConfigMap"))
+		Expect(configMap.Metadata.Name).To(Equal("ca-bundle"))
+		Expect(configMap.Data["ca.crt"]).To(Equal(string(ca.CertificatePEM())))
+	})
+	It("writes root and ca bundle as ca.crt", func() {
+		dir := GinkgoT().TempDir()
+		intermediate, err := pkg.CreateIntermediateCA(ctx, ca, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		next, err := pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		Expect(pkg.WriteCABundle(ctx, pkg.CABundle{ca.Certificate, next.Certificate}, filepath.Join(dir, pkg.CABundleFile), "", time.Now())).To(Succeed())
+		output, err := pkg.KubernetesOutput{
+			ConfigMapPath: "configmap.yaml",
+			ConfigMapName: "ca-bundle",
+		}.Resolve(ctx, pkg.DataDir(dir))
+		Expect(err).To(BeNil())
+		Expect(pkg.WriteKubernetesManifests(ctx, output, nil, intermediate)).To(Succeed())
+
+		data, err := os.ReadFile(output.ConfigMapPath)
+		Expect(err).To(BeNil())
+		var configMap object
+		Expect(yaml.Unmarshal(data, &configMap)).To(Succeed())
+		Expect(configMap.Data["ca.crt"]).To(Equal(string(ca.CertificatePEM()) + string(next.CertificatePEM())))
+	})
+})
@@ -6,9 +6,8 @@ package pkg
 
 import (
 	"context"
-	"crypto/ecdsa"
+	"crypto"
 	"crypto/x509"
-	"encoding/pem"
 	"os"
 	"path/filepath"
 
@@ -16,44 +15,87 @@ import (
 )
 
 // LoadCACertificate loads a CA certificate and private key from files.
-func LoadCACertificate(ctx context.Context, certPath, keyPath string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
-	var err error
-	certPath, err = filepath.Abs(certPath)
+func LoadCACertificate(ctx context.Context, certPath, keyPath string) (*x509.Certificate, crypto.Signer, error) {
+	ca, err := LoadCA(ctx, certPath, keyPath)
 	if err != nil {
-		return nil, nil, errors.Wrapf(ctx, err, "abs certPath failed")
-	}
-	keyPath, err = filepath.Abs(keyPath)
-	if err != nil {
-		return nil, nil, errors.Wrapf(ctx, err, "abs keyPath failed")
+		return nil, nil, errors.Wrapf(ctx, err, "load ca failed")
 	}
+	return ca.Certificate, ca.Signer, nil
+}
+
+// LoadCA loads a CA certificate and private key from files.
+func LoadCA(ctx context.Context, certPath, keyPath string) (*CA, error) {
+	return LoadCAWithSignerConfig(ctx, certPath, SignerConfig{KeyPath: keyPath})
+}
 
-	// Read CA certificate
-	caCertPEM, err := os.ReadFile(certPath)
+// LoadCAWithSignerConfig loads the CA certificate from certPath and the signer selected by config.
+// Further certificates in certPath are the issuers of an intermediate CA up to the root.
+func LoadCAWithSignerConfig(ctx context.Context, certPath string, config SignerConfig) (*CA, error) {
+	certs, err := loadCertificates(ctx, certPath)
 	if err != nil {
-		return nil, nil, err
+		return nil, errors.Wrapf(ctx, err, "load ca certificate failed")
 	}
-	block, _ := pem.Decode(caCertPEM)
-	if block == nil || block.Type != "CERTIFICATE" {
-		return nil, nil, err
+	caCert := certs[0]
+	if config.KeyPath != "" {
+		config.KeyPath, err = filepath.Abs(config.KeyPath)
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "abs keyPath failed")
+		}
 	}
-	caCert, err := x509.ParseCertificate(block.Bytes)
+	signerLoader, err := NewSignerLoader(ctx, config, caCert.PublicKey)
 	if err != nil {
-		return nil, nil, err
+		return nil, errors.Wrapf(ctx, err, "create signer loader failed")
 	}
+	ca, err := LoadCAWithSigner(ctx, caCert, signerLoader)
+	if err != nil {
+		return nil, err
+	}
+	ca.Issuers = certs[1:]
+	return ca, nil
+}
 
-	// Read CA private key
-	caKeyPEM, err := os.ReadFile(keyPath)
+// LoadCAWithSigner combines the given CA certificate with the signer of signerLoader
+// and makes sure both belong together.
+func LoadCAWithSigner(ctx context.Context, caCert *x509.Certificate, signerLoader SignerLoader) (*CA, error) {
+	signer, err := signerLoader.LoadSigner(ctx)
 	if err != nil {
-		return nil, nil, err
+		return nil, errors.Wrapf(ctx, err, "load ca signer failed")
+	}
+	if err := CheckKeyPair(ctx, caCert, signer); err != nil {
+		_ = CloseSigner(signer)
+		return nil, errors.Wrapf(ctx, err, "ca key does not match ca certificate")
 	}
-	block, _ = pem.Decode(caKeyPEM)
-	if block == nil || block.Type != "EC PRIVATE KEY" {
-		return nil, nil, err
+	return &CA{
+		Certificate: caCert,
+		Signer:      signer,
+	}, nil
+}
+
+// LoadCertificate loads a PEM encoded certificate from file.
+func LoadCertificate(ctx context.Context, certPath string) (*x509.Certificate, error) {
+	certPath, err := filepath.Abs(certPath)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "abs certPath failed")
 	}
-	caKey, err := x509.ParseECPrivateKey(block.Bytes)
+	certPEM, err := os.ReadFile(certPath)
 	if err != nil {
-		return nil, nil, err
+		return nil, errors.Wrapf(ctx, err, "read %s failed", certPath)
 	}
+	return ParseCertificatePEM(ctx, certPEM)
+}
 
-	return caCert, caKey, nil
+// loadCertificates loads all PEM encoded certificates of a file, at least one.
+func loadCertificates(ctx context.Context, certPath string) ([]*x509.Certificate, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "read %s failed", certPath)
+	}
+	certs, err := ParseCertificates(ctx, certPEM)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "parse %s failed", certPath)
+	}
+	if len(certs) == 0 {
+		return nil, errors.Errorf(ctx, "no %s pem block found in %s", pemTypeCertificate, certPath)
+	}
+	return certs, nil
 }
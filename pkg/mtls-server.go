@@ -0,0 +1,100 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"net/http"
+
+	"github.com/bborbe/errors"
+	libhttp "github.com/bborbe/http"
+	"github.com/bborbe/run"
+	"github.com/golang/glog"
+)
+
+// NewMTLSServer works like libhttp.NewServerTLS but requests client certificates signed by clientCAs.
+// clientAuth selects whether a client certificate is optional or required.
+func NewMTLSServer(
+	addr string,
+	router http.Handler,
+	serverCertPath string,
+	serverKeyPath string,
+	clientCAs *x509.CertPool,
+	clientAuth tls.ClientAuthType,
+) run.Func {
+	return newTLSServer(
+		addr,
+		router,
+		&tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ClientCAs:  clientCAs,
+			ClientAuth: clientAuth,
+		},
+		serverCertPath,
+		serverKeyPath,
+	)
+}
+
+// NewTLSServerWithConfig serves router with tlsConfig, which provides the server certificate,
+// e.g. by GetCertificate of an AutoRenewer.
+func NewTLSServerWithConfig(addr string, router http.Handler, tlsConfig *tls.Config) run.Func {
+	return newTLSServer(addr, router, tlsConfig, "", "")
+}
+
+func newTLSServer(addr string, router http.Handler, tlsConfig *tls.Config, serverCertPath string, serverKeyPath string) run.Func {
+	return func(ctx context.Context) error {
+		server := &http.Server{
+			Addr:      addr,
+			Handler:   router,
+			ErrorLog:  log.New(libhttp.NewSkipErrorWriter(log.Writer()), "", log.LstdFlags),
+			TLSConfig: tlsConfig,
+		}
+		go func() {
+			<-ctx.Done()
+			if err := server.Shutdown(context.Background()); err != nil {
+				glog.Warningf("shutdown failed: %v", err)
+			}
+		}()
+		err := server.ListenAndServeTLS(serverCertPath, serverKeyPath)
+		if errors.Is(err, http.ErrServerClosed) {
+			glog.V(0).Info(err)
+			return nil
+		}
+		return errors.Wrapf(ctx, err, "httpServer failed")
+	}
+}
+
+// verifyClientCertificate returns the TLS client certificate of req if it was issued by ca
+// and, if an inventory is given, is not revoked.
+func verifyClientCertificate(ctx context.Context, req *http.Request, ca *CA, inventory Inventory) (*x509.Certificate, error) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return nil, errors.Errorf(ctx, "no client certificate")
+	}
+	cert := req.TLS.PeerCertificates[0]
+	if _, err := VerifyCertificate(ctx, VerifyRequest{
+		Leaf:          cert,
+		Intermediates: req.TLS.PeerCertificates[1:],
+		Roots:         []*x509.Certificate{ca.Certificate},
+		Purpose:       ProfileClient,
+	}); err != nil {
+		return nil, errors.Wrapf(ctx, err, "verify client certificate failed")
+	}
+	if inventory != nil {
+		entries, err := inventory.List(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "list inventory failed")
+		}
+		serialNumber := FormatHex(cert.SerialNumber.Bytes())
+		for _, entry := range entries {
+			if entry.SerialNumber == serialNumber && entry.Revoked() {
+				return nil, errors.Errorf(ctx, "client certificate %s is revoked", serialNumber)
+			}
+		}
+	}
+	return cert, nil
+}
@@ -0,0 +1,312 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/bborbe/errors"
+)
+
+// NameConstraints restrict the subject alternative names of all certificates below a CA (RFC 5280 section 4.2.1.10).
+// Domains starting with '.' match subdomains only, other domains match the domain and its subdomains.
+// Email constraints are a mailbox, a host or a '.domain'. URI domains match the host of the URI.
+type NameConstraints struct {
+	PermittedDNSDomains     []string `yaml:"permittedDNSDomains" json:"permittedDNSDomains,omitempty"`
+	ExcludedDNSDomains      []string `yaml:"excludedDNSDomains" json:"excludedDNSDomains,omitempty"`
+	PermittedIPRanges       []string `yaml:"permittedIPRanges" json:"permittedIPRanges,omitempty"`
+	ExcludedIPRanges        []string `yaml:"excludedIPRanges" json:"excludedIPRanges,omitempty"`
+	PermittedEmailAddresses []string `yaml:"permittedEmailAddresses" json:"permittedEmailAddresses,omitempty"`
+	ExcludedEmailAddresses  []string `yaml:"excludedEmailAddresses" json:"excludedEmailAddresses,omitempty"`
+	PermittedURIDomains     []string `yaml:"permittedURIDomains" json:"permittedURIDomains,omitempty"`
+	ExcludedURIDomains      []string `yaml:"excludedURIDomains" json:"excludedURIDomains,omitempty"`
+}
+
+// NameConstraintsOfCertificate returns the name constraints of cert.
+func NameConstraintsOfCertificate(cert *x509.Certificate) NameConstraints {
+	return NameConstraints{
+		PermittedDNSDomains:     cert.PermittedDNSDomains,
+		ExcludedDNSDomains:      cert.ExcludedDNSDomains,
+		PermittedIPRanges:       ipNetStrings(cert.PermittedIPRanges),
+		ExcludedIPRanges:        ipNetStrings(cert.ExcludedIPRanges),
+		PermittedEmailAddresses: cert.PermittedEmailAddresses,
+		ExcludedEmailAddresses:  cert.ExcludedEmailAddresses,
+		PermittedURIDomains:     cert.PermittedURIDomains,
+		ExcludedURIDomains:      cert.ExcludedURIDomains,
+	}
+}
+
+// IsEmpty returns true if no constraint is set.
+func (n NameConstraints) IsEmpty() bool {
+	return n.Equal(NameConstraints{})
+}
+
+// Equal returns true if both contain the same constraints, ignoring their order.
+func (n NameConstraints) Equal(other NameConstraints) bool {
+	return equalStrings(n.PermittedDNSDomains, other.PermittedDNSDomains) &&
+		equalStrings(n.ExcludedDNSDomains, other.ExcludedDNSDomains) &&
+		equalStrings(n.PermittedIPRanges, other.PermittedIPRanges) &&
+		equalStrings(n.ExcludedIPRanges, other.ExcludedIPRanges) &&
+		equalStrings(n.PermittedEmailAddresses, other.PermittedEmailAddresses) &&
+		equalStrings(n.ExcludedEmailAddresses, other.ExcludedEmailAddresses) &&
+		equalStrings(n.PermittedURIDomains, other.PermittedURIDomains) &&
+		equalStrings(n.ExcludedURIDomains, other.ExcludedURIDomains)
+}
+
+// String lists all constraints, e.g. "permitted dns: example.com; excluded ip: 10.0.0.0/8".
+func (n NameConstraints) String() string {
+	var parts []string
+	add := func(name string, values []string) {
+		if len(values) > 0 {
+			parts = append(parts, name+": "+strings.Join(values, ", "))
+		}
+	}
+	add("permitted dns", n.PermittedDNSDomains)
+	add("excluded dns", n.ExcludedDNSDomains)
+	add("permitted ip", n.PermittedIPRanges)
+	add("excluded ip", n.ExcludedIPRanges)
+	add("permitted email", n.PermittedEmailAddresses)
+	add("excluded email", n.ExcludedEmailAddresses)
+	add("permitted uri", n.PermittedURIDomains)
+	add("excluded uri", n.ExcludedURIDomains)
+	return strings.Join(parts, "; ")
+}
+
+// Validate returns an error if an IP range is no valid CIDR or a domain contains a wildcard.
+func (n NameConstraints) Validate(ctx context.Context) error {
+	if _, err := parseIPRanges(ctx, n.PermittedIPRanges); err != nil {
+		return errors.Wrapf(ctx, err, "invalid permitted ip range")
+	}
+	if _, err := parseIPRanges(ctx, n.ExcludedIPRanges); err != nil {
+		return errors.Wrapf(ctx, err, "invalid excluded ip range")
+	}
+	for _, domains := range [][]string{n.PermittedDNSDomains, n.ExcludedDNSDomains, n.PermittedEmailAddresses, n.ExcludedEmailAddresses, n.PermittedURIDomains, n.ExcludedURIDomains} {
+		for _, domain := range domains {
+			if domain == "" || strings.Contains(domain, "*") {
+				return errors.Errorf(ctx, "invalid name constraint '%s'", domain)
+			}
+		}
+	}
+	return nil
+}
+
+// apply adds the constraints to the CA template, marked critical as required by RFC 5280.
+func (n NameConstraints) apply(ctx context.Context, template *x509.Certificate) error {
+	if n.IsEmpty() {
+		return nil
+	}
+	if err := n.Validate(ctx); err != nil {
+		return err
+	}
+	template.PermittedDNSDomainsCritical = true
+	template.PermittedDNSDomains = n.PermittedDNSDomains
+	template.ExcludedDNSDomains = n.ExcludedDNSDomains
+	template.PermittedIPRanges, _ = parseIPRanges(ctx, n.PermittedIPRanges)
+	template.ExcludedIPRanges, _ = parseIPRanges(ctx, n.ExcludedIPRanges)
+	template.PermittedEmailAddresses = n.PermittedEmailAddresses
+	template.ExcludedEmailAddresses = n.ExcludedEmailAddresses
+	template.PermittedURIDomains = n.PermittedURIDomains
+	template.ExcludedURIDomains = n.ExcludedURIDomains
+	return nil
+}
+
+// NameConstraintLists are name constraints given as comma separated lists, e.g. by command line flags.
+type NameConstraintLists struct {
+	PermittedDNSDomains     string
+	ExcludedDNSDomains      string
+	PermittedIPRanges       string
+	ExcludedIPRanges        string
+	PermittedEmailAddresses string
+	ExcludedEmailAddresses  string
+	PermittedURIDomains     string
+	ExcludedURIDomains      string
+}
+
+// NameConstraints splits all lists and validates the result.
+func (n NameConstraintLists) NameConstraints(ctx context.Context) (NameConstraints, error) {
+	result := NameConstraints{
+		PermittedDNSDomains:     SplitList(n.PermittedDNSDomains, ","),
+		ExcludedDNSDomains:      SplitList(n.ExcludedDNSDomains, ","),
+		PermittedIPRanges:       SplitList(n.PermittedIPRanges, ","),
+		ExcludedIPRanges:        SplitList(n.ExcludedIPRanges, ","),
+		PermittedEmailAddresses: SplitList(n.PermittedEmailAddresses, ","),
+		ExcludedEmailAddresses:  SplitList(n.ExcludedEmailAddresses, ","),
+		PermittedURIDomains:     SplitList(n.PermittedURIDomains, ","),
+		ExcludedURIDomains:      SplitList(n.ExcludedURIDomains, ","),
+	}
+	if err := result.Validate(ctx); err != nil {
+		return NameConstraints{}, err
+	}
+	return result, nil
+}
+
+// NameConstraintError lists all names of a request violating the name constraints of the issuing CA.
+type NameConstraintError struct {
+	Issuer     string
+	Violations []string
+}
+
+func (n *NameConstraintError) Error() string {
+	return fmt.Sprintf("name constraints of ca '%s' violated: %s", n.Issuer, strings.Join(n.Violations, "; "))
+}
+
+// CheckNameConstraints returns a *NameConstraintError if a certificate for req issued by issuer
+// would be rejected by verifiers because of the name constraints of issuer.
+func CheckNameConstraints(ctx context.Context, issuer *x509.Certificate, req IssueRequest) error {
+	var violations []string
+	check := func(kind string, name string, permitted []string, excluded []string, match func(name string, constraint string) bool) {
+		if len(permitted) > 0 && !slices.ContainsFunc(permitted, func(constraint string) bool { return match(name, constraint) }) {
+			violations = append(violations, fmt.Sprintf("%s '%s' is not permitted by %s", kind, name, strings.Join(permitted, ", ")))
+		}
+		for _, constraint := range excluded {
+			if match(name, constraint) {
+				violations = append(violations, fmt.Sprintf("%s '%s' is excluded by %s", kind, name, constraint))
+			}
+		}
+	}
+	for _, dnsName := range req.DNSNames {
+		check("dns name", dnsName, issuer.PermittedDNSDomains, issuer.ExcludedDNSDomains, matchDomainConstraint)
+	}
+	for _, ip := range req.IPAddresses {
+		check("ip address", ip.String(), ipNetStrings(issuer.PermittedIPRanges), ipNetStrings(issuer.ExcludedIPRanges), matchIPConstraint)
+	}
+	for _, email := range req.EmailAddresses {
+		check("email address", email, issuer.PermittedEmailAddresses, issuer.ExcludedEmailAddresses, matchEmailConstraint)
+	}
+	for _, uri := range req.URIs {
+		check("uri", uri.String(), issuer.PermittedURIDomains, issuer.ExcludedURIDomains, matchURIConstraint)
+	}
+	if len(violations) > 0 {
+		return &NameConstraintError{
+			Issuer:     issuer.Subject.String(),
+			Violations: violations,
+		}
+	}
+	return nil
+}
+
+// CheckNameConstraints returns a *NameConstraintError if a certificate for req would be rejected by verifiers
+// because of the name constraints of the CA, its issuers up to the root or its cross certificates.
+func (c *CA) CheckNameConstraints(ctx context.Context, req IssueRequest) error {
+	for _, cert := range c.constrainingCertificates() {
+		if err := CheckNameConstraints(ctx, cert, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkIntermediateNameConstraints returns a *NameConstraintError if constraints of a new intermediate below c
+// permit names the CA, its issuers or cross certificates do not permit.
+func (c *CA) checkIntermediateNameConstraints(ctx context.Context, constraints NameConstraints) error {
+	for _, cert := range c.constrainingCertificates() {
+		var violations []string
+		check := func(kind string, values []string, permitted []string, within func(value string, constraint string) bool) {
+			for _, value := range values {
+				if len(permitted) > 0 && !slices.ContainsFunc(permitted, func(constraint string) bool { return within(value, constraint) }) {
+					violations = append(violations, fmt.Sprintf("%s '%s' is not permitted by %s", kind, value, strings.Join(permitted, ", ")))
+				}
+			}
+		}
+		check("dns domain", constraints.PermittedDNSDomains, cert.PermittedDNSDomains, matchDomainConstraint)
+		check("ip range", constraints.PermittedIPRanges, ipNetStrings(cert.PermittedIPRanges), withinIPRange)
+		check("email", constraints.PermittedEmailAddresses, cert.PermittedEmailAddresses, withinEmailConstraint)
+		check("uri domain", constraints.PermittedURIDomains, cert.PermittedURIDomains, matchHostConstraint)
+		if len(violations) > 0 {
+			return &NameConstraintError{
+				Issuer:     cert.Subject.String(),
+				Violations: violations,
+			}
+		}
+	}
+	return nil
+}
+
+// withinIPRange returns true if the CIDR ipRange is part of the CIDR constraint.
+func withinIPRange(ipRange string, constraint string) bool {
+	_, ipNet, err := net.ParseCIDR(ipRange)
+	if err != nil {
+		return false
+	}
+	_, constraintNet, err := net.ParseCIDR(constraint)
+	if err != nil {
+		return false
+	}
+	ones, bits := ipNet.Mask.Size()
+	constraintOnes, constraintBits := constraintNet.Mask.Size()
+	return bits == constraintBits && constraintOnes <= ones && constraintNet.Contains(ipNet.IP)
+}
+
+// withinEmailConstraint returns true if all addresses allowed by the email constraint value are allowed by constraint.
+func withinEmailConstraint(value string, constraint string) bool {
+	if strings.Contains(value, "@") {
+		return matchEmailConstraint(value, constraint)
+	}
+	return !strings.Contains(constraint, "@") && matchHostConstraint(value, constraint)
+}
+
+func matchDomainConstraint(name string, constraint string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	constraint = strings.ToLower(constraint)
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(name, constraint)
+	}
+	return name == constraint || strings.HasSuffix(name, "."+constraint)
+}
+
+// matchHostConstraint matches host exactly or, if constraint starts with '.', its subdomains.
+func matchHostConstraint(host string, constraint string) bool {
+	host = strings.ToLower(host)
+	constraint = strings.ToLower(constraint)
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(host, constraint)
+	}
+	return host == constraint
+}
+
+func matchIPConstraint(name string, constraint string) bool {
+	_, ipNet, err := net.ParseCIDR(constraint)
+	if err != nil {
+		return false
+	}
+	return ipNet.Contains(net.ParseIP(name))
+}
+
+func matchEmailConstraint(name string, constraint string) bool {
+	if strings.Contains(constraint, "@") {
+		return strings.EqualFold(name, constraint)
+	}
+	at := strings.LastIndex(name, "@")
+	if at < 0 {
+		return false
+	}
+	return matchHostConstraint(name[at+1:], constraint)
+}
+
+func matchURIConstraint(name string, constraint string) bool {
+	uri, err := url.Parse(name)
+	if err != nil {
+		return false
+	}
+	host := uri.Hostname()
+	if host == "" || net.ParseIP(host) != nil {
+		return false
+	}
+	return matchHostConstraint(host, constraint)
+}
+
+func ipNetStrings(ipNets []*net.IPNet) []string {
+	var result []string
+	for _, ipNet := range ipNets {
+		result = append(result, ipNet.String())
+	}
+	return result
+}
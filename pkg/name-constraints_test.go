@@ -0,0 +1,181 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bborbe/errors"
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NameConstraints", func() {
+	var ctx context.Context
+	var ca *pkg.CA
+	var req pkg.IssueRequest
+	BeforeEach(func() {
+		ctx = context.Background()
+		caRequest := pkg.DefaultCARequest()
+		caRequest.NameConstraints = pkg.NameConstraints{
+			PermittedDNSDomains:     []string{"example.com", ".example.net"},
+			ExcludedDNSDomains:      []string{"secret.example.com"},
+			PermittedIPRanges:       []string{"10.0.0.0/8"},
+			ExcludedIPRanges:        []string{"10.0.0.0/24"},
+			PermittedEmailAddresses: []string{"example.com"},
+			PermittedURIDomains:     []string{".example.com"},
+		}
+		var err error
+		ca, err = pkg.CreateCA(ctx, caRequest)
+		Expect(err).To(BeNil())
+		req = pkg.IssueRequest{
+			Profile:        pkg.ProfileServer,
+			CommonName:     "api.example.com",
+			DNSNames:       []string{"example.com", "api.example.com", "api.example.net"},
+			IPAddresses:    []net.IP{net.ParseIP("10.1.0.1")},
+			EmailAddresses: []string{"admin@example.com"},
+			URIs:           []*url.URL{{Scheme: "spiffe", Host: "prod.example.com", Path: "/api"}},
+			Validity:       24 * time.Hour,
+		}
+	})
+	violations := func(err error) []string {
+		var nameConstraintErr *pkg.NameConstraintError
+		Expect(errors.As(err, &nameConstraintErr)).To(BeTrue())
+		return nameConstraintErr.Violations
+	}
+	It("writes constraints into the ca certificate", func() {
+		Expect(ca.Certificate.PermittedDNSDomainsCritical).To(BeTrue())
+		Expect(pkg.NameConstraintsOfCertificate(ca.Certificate)).To(Equal(pkg.NameConstraints{
+			PermittedDNSDomains:     []string{"example.com", ".example.net"},
+			ExcludedDNSDomains:      []string{"secret.example.com"},
+			PermittedIPRanges:       []string{"10.0.0.0/8"},
+			ExcludedIPRanges:        []string{"10.0.0.0/24"},
+			PermittedEmailAddresses: []string{"example.com"},
+			PermittedURIDomains:     []string{".example.com"},
+		}))
+	})
+	It("issues certificate accepted by verifiers", func() {
+		keyPair, err := pkg.IssueCertificate(ctx, ca, req)
+		Expect(err).To(BeNil())
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.Certificate)
+		_, err = keyPair.Certificate.Verify(x509.VerifyOptions{
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})
+		Expect(err).To(BeNil())
+	})
+	It("reports all violating names", func() {
+		req.DNSNames = []string{"example.net", "secret.example.com", "example.org"}
+		req.IPAddresses = []net.IP{net.ParseIP("10.0.0.5"), net.ParseIP("192.168.0.1")}
+		req.EmailAddresses = []string{"admin@sub.example.com"}
+		req.URIs = []*url.URL{{Scheme: "spiffe", Host: "example.com"}}
+		_, err := pkg.IssueCertificate(ctx, ca, req)
+		Expect(violations(err)).To(Equal([]string{
+			"dns name 'example.net' is not permitted by example.com, .example.net",
+			"dns name 'secret.example.com' is excluded by secret.example.com",
+			"dns name 'example.org' is not permitted by example.com, .example.net",
+			"ip address '10.0.0.5' is excluded by 10.0.0.0/24",
+			"ip address '192.168.0.1' is not permitted by 10.0.0.0/8",
+			"email address 'admin@sub.example.com' is not permitted by example.com",
+			"uri 'spiffe://example.com' is not permitted by .example.com",
+		}))
+	})
+	It("does not check leaves of unconstrained ca", func() {
+		unconstrained, err := pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		req.DNSNames = []string{"example.org"}
+		_, err = pkg.IssueCertificate(ctx, unconstrained, req)
+		Expect(err).To(BeNil())
+	})
+	It("applies constraints to intermediate ca", func() {
+		intermediateRequest := pkg.DefaultCARequest()
+		intermediateRequest.NameConstraints = pkg.NameConstraints{PermittedDNSDomains: []string{"api.example.com"}}
+		intermediate, err := pkg.CreateIntermediateCA(ctx, ca, intermediateRequest)
+		Expect(err).To(BeNil())
+		_, err = pkg.IssueCertificate(ctx, intermediate, req)
+		Expect(violations(err)).To(ConsistOf(
+			"dns name 'example.com' is not permitted by api.example.com",
+			"dns name 'api.example.net' is not permitted by api.example.com",
+		))
+	})
+	It("applies constraints of all issuers", func() {
+		intermediate, err := pkg.CreateIntermediateCA(ctx, ca, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		issuing, err := pkg.CreateIntermediateCA(ctx, intermediate, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		Expect(issuing.Intermediates()).To(HaveLen(2))
+		req.DNSNames = []string{"example.org"}
+		_, err = pkg.IssueCertificate(ctx, issuing, req)
+		Expect(violations(err)).To(Equal([]string{"dns name 'example.org' is not permitted by example.com, .example.net"}))
+	})
+	It("applies constraints of issuers in the ca file", func() {
+		intermediate, err := pkg.CreateIntermediateCA(ctx, ca, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		dir := GinkgoT().TempDir()
+		certPath, keyPath := filepath.Join(dir, pkg.CACertFile), filepath.Join(dir, pkg.CAKeyFile)
+		Expect(pkg.WriteCA(ctx, intermediate, certPath, keyPath)).To(Succeed())
+		Expect(os.WriteFile(certPath, append(intermediate.CertificatePEM(), ca.CertificatePEM()...), 0600)).To(Succeed())
+		loaded, err := pkg.LoadCA(ctx, certPath, keyPath)
+		Expect(err).To(BeNil())
+		Expect(loaded.Issuers).To(HaveLen(1))
+		req.DNSNames = []string{"example.org"}
+		_, err = pkg.IssueCertificate(ctx, loaded, req)
+		Expect(violations(err)).To(HaveLen(1))
+	})
+	It("rejects intermediate permitting names outside of its issuer", func() {
+		intermediateRequest := pkg.DefaultCARequest()
+		intermediateRequest.NameConstraints = pkg.NameConstraints{
+			PermittedDNSDomains:     []string{"api.example.com", "example.net", "example.org"},
+			PermittedIPRanges:       []string{"10.1.0.0/16", "0.0.0.0/0"},
+			PermittedEmailAddresses: []string{"a@example.com", "example.org"},
+			PermittedURIDomains:     []string{".prod.example.com", "example.com"},
+		}
+		_, err := pkg.CreateIntermediateCA(ctx, ca, intermediateRequest)
+		Expect(violations(err)).To(Equal([]string{
+			"dns domain 'example.net' is not permitted by example.com, .example.net",
+			"dns domain 'example.org' is not permitted by example.com, .example.net",
+			"ip range '0.0.0.0/0' is not permitted by 10.0.0.0/8",
+			"email 'example.org' is not permitted by example.com",
+			"uri domain 'example.com' is not permitted by .example.com",
+		}))
+	})
+	It("rejects invalid ip range", func() {
+		caRequest := pkg.DefaultCARequest()
+		caRequest.NameConstraints = pkg.NameConstraints{PermittedIPRanges: []string{"10.0.0.1"}}
+		_, err := pkg.CreateCA(ctx, caRequest)
+		Expect(err).NotTo(BeNil())
+	})
+	Context("NameConstraintLists", func() {
+		It("splits comma separated lists", func() {
+			nameConstraints, err := pkg.NameConstraintLists{
+				PermittedDNSDomains: "example.com, .example.net",
+				ExcludedIPRanges:    "10.0.0.0/8",
+			}.NameConstraints(ctx)
+			Expect(err).To(BeNil())
+			Expect(nameConstraints).To(Equal(pkg.NameConstraints{
+				PermittedDNSDomains: []string{"example.com", ".example.net"},
+				ExcludedIPRanges:    []string{"10.0.0.0/8"},
+			}))
+			Expect(nameConstraints.String()).To(Equal("permitted dns: example.com, .example.net; excluded ip: 10.0.0.0/8"))
+		})
+		It("returns empty constraints for empty lists", func() {
+			nameConstraints, err := pkg.NameConstraintLists{}.NameConstraints(ctx)
+			Expect(err).To(BeNil())
+			Expect(nameConstraints.IsEmpty()).To(BeTrue())
+		})
+		It("rejects wildcard domain", func() {
+			_, err := pkg.NameConstraintLists{PermittedDNSDomains: "*.example.com"}.NameConstraints(ctx)
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,187 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto/x509"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bborbe/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// OperatorsFile is the default name of the operator configuration in the DataDir.
+const OperatorsFile = "operators.yaml"
+
+// Operator is a caller of the issuance API. It is authenticated by the SPIFFE ID or the fingerprint
+// of its client certificate, the common name is not trusted.
+type Operator struct {
+	Name string `yaml:"name" json:"name"`
+	// SPIFFEID of the client certificates of the operator, e.g. spiffe://example.org/operator/deploy-bot.
+	SPIFFEID string `yaml:"spiffeID" json:"spiffeID"`
+	// Fingerprints are the SHA-256 fingerprints of the client certificates of the operator.
+	Fingerprints []string `yaml:"fingerprints" json:"fingerprints"`
+	// Profiles the operator may issue, sign and revoke.
+	Profiles []Profile `yaml:"profiles" json:"profiles"`
+	// NamePatterns every common name and subject alternative name must match,
+	// e.g. *.example.com or *@example.com. '*' matches any sequence without '/'.
+	NamePatterns []string `yaml:"namePatterns" json:"namePatterns"`
+	// MaxValidity limits the validity of issued certificates, unlimited if zero.
+	MaxValidity time.Duration `yaml:"maxValidity" json:"maxValidity"`
+}
+
+// Authorize returns an error if the operator is not allowed to issue a certificate for req.
+func (o Operator) Authorize(ctx context.Context, req IssueRequest) error {
+	if err := o.AuthorizeNames(ctx, req.Profile, IssueRequestNames(req)); err != nil {
+		return err
+	}
+	if o.MaxValidity > 0 && req.Validity > o.MaxValidity {
+		return errors.Errorf(ctx, "operator '%s' may not issue certificates valid longer than %s", o.Name, o.MaxValidity)
+	}
+	return nil
+}
+
+// AuthorizeNames returns an error if the profile or one of the names is not allowed for the operator.
+func (o Operator) AuthorizeNames(ctx context.Context, profile Profile, names []string) error {
+	if !slices.Contains(o.Profiles, profile) {
+		return errors.Errorf(ctx, "operator '%s' may not use profile '%s'", o.Name, profile)
+	}
+	for _, name := range names {
+		if !o.matchName(name) {
+			return errors.Errorf(ctx, "operator '%s' may not use name '%s'", o.Name, name)
+		}
+	}
+	return nil
+}
+
+// Authenticates returns true if cert is a client certificate of the operator.
+func (o Operator) Authenticates(ctx context.Context, cert *x509.Certificate) bool {
+	if o.SPIFFEID != "" {
+		if id, err := SPIFFEIDOfCertificate(ctx, cert); err == nil && id == o.SPIFFEID {
+			return true
+		}
+	}
+	fingerprint := normalizeFingerprint(SHA256Fingerprint(cert))
+	for _, pinned := range o.Fingerprints {
+		if normalizeFingerprint(pinned) == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// identities returns the names that must not be issued to anybody else.
+func (o Operator) identities() []string {
+	result := []string{o.Name}
+	if o.SPIFFEID != "" {
+		result = append(result, o.SPIFFEID)
+	}
+	return result
+}
+
+// requester identifies the operator in the audit log.
+func (o Operator) requester() string {
+	return "operator:" + o.Name
+}
+
+func (o Operator) matchName(name string) bool {
+	return matchNamePatterns(o.NamePatterns, name)
+}
+
+// matchNamePatterns returns true if name matches one of the patterns, '*' matches any sequence without '/'.
+func matchNamePatterns(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateNamePatterns returns an error for the first malformed pattern.
+func ValidateNamePatterns(ctx context.Context, patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(ctx, err, "invalid name pattern '%s'", pattern)
+		}
+	}
+	return nil
+}
+
+// IssueRequestNames returns the common name and all subject alternative names of req.
+func IssueRequestNames(req IssueRequest) []string {
+	var result []string
+	if req.CommonName != "" {
+		result = append(result, req.CommonName)
+	}
+	result = append(result, req.DNSNames...)
+	result = append(result, ipStrings(req.IPAddresses)...)
+	result = append(result, req.EmailAddresses...)
+	for _, uri := range req.URIs {
+		result = append(result, uri.String())
+	}
+	return result
+}
+
+// LoadOperators reads a YAML or JSON list of operators.
+func LoadOperators(ctx context.Context, path string) ([]Operator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "read %s failed", path)
+	}
+	return ParseOperators(ctx, data)
+}
+
+// ParseOperators parses a YAML or JSON list of operators and validates it.
+func ParseOperators(ctx context.Context, data []byte) ([]Operator, error) {
+	var operators []Operator
+	if err := yaml.Unmarshal(data, &operators); err != nil {
+		return nil, errors.Wrapf(ctx, err, "unmarshal operators failed")
+	}
+	names := make(map[string]bool)
+	spiffeIDs := make(map[string]bool)
+	for i, operator := range operators {
+		if operator.Name == "" {
+			return nil, errors.Errorf(ctx, "name of operator %d missing", i+1)
+		}
+		if names[operator.Name] {
+			return nil, errors.Errorf(ctx, "duplicate operator '%s'", operator.Name)
+		}
+		names[operator.Name] = true
+		if operator.SPIFFEID == "" && len(operator.Fingerprints) == 0 {
+			return nil, errors.Errorf(ctx, "operator '%s' needs a spiffeID or fingerprints", operator.Name)
+		}
+		if operator.SPIFFEID != "" {
+			id, err := ParseSPIFFEID(ctx, operator.SPIFFEID)
+			if err != nil {
+				return nil, errors.Wrapf(ctx, err, "invalid operator '%s'", operator.Name)
+			}
+			if spiffeIDs[id.String()] {
+				return nil, errors.Errorf(ctx, "duplicate spiffe id '%s'", id.String())
+			}
+			spiffeIDs[id.String()] = true
+			operators[i].SPIFFEID = id.String()
+		}
+		for _, fingerprint := range operator.Fingerprints {
+			if len(normalizeFingerprint(fingerprint)) != 64 || strings.Trim(normalizeFingerprint(fingerprint), "0123456789ABCDEF") != "" {
+				return nil, errors.Errorf(ctx, "invalid fingerprint '%s' of operator '%s'", fingerprint, operator.Name)
+			}
+		}
+		for _, profile := range operator.Profiles {
+			if profile != ProfileServer && profile != ProfileClient {
+				return nil, errors.Errorf(ctx, "unknown profile '%s' of operator '%s'", profile, operator.Name)
+			}
+		}
+		if err := ValidateNamePatterns(ctx, operator.NamePatterns); err != nil {
+			return nil, errors.Wrapf(ctx, err, "invalid operator '%s'", operator.Name)
+		}
+	}
+	return operators, nil
+}
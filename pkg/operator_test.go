@@ -0,0 +1,91 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Operator", func() {
+	var ctx context.Context
+	var operator pkg.Operator
+	BeforeEach(func() {
+		ctx = context.Background()
+		operator = pkg.Operator{
+			Name:         "deploy-bot",
+			Profiles:     []pkg.Profile{pkg.ProfileServer},
+			NamePatterns: []string{"*.example.com", "10.0.0.*"},
+			MaxValidity:  90 * 24 * time.Hour,
+		}
+	})
+	It("allows matching request", func() {
+		Expect(operator.Authorize(ctx, pkg.IssueRequest{
+			Profile:     pkg.ProfileServer,
+			CommonName:  "api.example.com",
+			DNSNames:    []string{"api.example.com", "www.api.example.com"},
+			IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+			Validity:    24 * time.Hour,
+		})).To(Succeed())
+	})
+	It("rejects other profile", func() {
+		Expect(operator.Authorize(ctx, pkg.IssueRequest{
+			Profile:    pkg.ProfileClient,
+			CommonName: "api.example.com",
+			Validity:   24 * time.Hour,
+		})).NotTo(Succeed())
+	})
+	It("rejects name not matching", func() {
+		Expect(operator.Authorize(ctx, pkg.IssueRequest{
+			Profile:    pkg.ProfileServer,
+			CommonName: "api.example.com",
+			DNSNames:   []string{"api.example.org"},
+			Validity:   24 * time.Hour,
+		})).NotTo(Succeed())
+	})
+	It("rejects too long validity", func() {
+		Expect(operator.Authorize(ctx, pkg.IssueRequest{
+			Profile:    pkg.ProfileServer,
+			CommonName: "api.example.com",
+			Validity:   365 * 24 * time.Hour,
+		})).NotTo(Succeed())
+	})
+	Context("ParseOperators", func() {
+		It("parses yaml", func() {
+			operators, err := pkg.ParseOperators(ctx, []byte(`
+- name: deploy-bot
+  spiffeID: spiffe://example.org/operator/deploy-bot
+  profiles: [server]
+  namePatterns: ["*.example.com"]
+  maxValidity: 2160h
+`))
+			Expect(err).To(BeNil())
+			Expect(operators).To(HaveLen(1))
+			Expect(operators[0].Profiles).To(Equal([]pkg.Profile{pkg.ProfileServer}))
+			Expect(operators[0].MaxValidity).To(Equal(2160 * time.Hour))
+		})
+		It("rejects unknown profile", func() {
+			_, err := pkg.ParseOperators(ctx, []byte(`[{"name": "a", "spiffeID": "spiffe://example.org/a", "profiles": ["ca"]}]`))
+			Expect(err).NotTo(BeNil())
+		})
+		It("rejects duplicate operator", func() {
+			_, err := pkg.ParseOperators(ctx, []byte(`[{"name": "a", "spiffeID": "spiffe://example.org/a"}, {"name": "a", "spiffeID": "spiffe://example.org/b"}]`))
+			Expect(err).NotTo(BeNil())
+		})
+		It("rejects operator without pinned identity", func() {
+			_, err := pkg.ParseOperators(ctx, []byte(`[{"name": "a"}]`))
+			Expect(err).NotTo(BeNil())
+		})
+		It("rejects invalid fingerprint", func() {
+			_, err := pkg.ParseOperators(ctx, []byte(`[{"name": "a", "fingerprints": ["AB:CD"]}]`))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,109 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bborbe/errors"
+	"github.com/golang/glog"
+)
+
+// OverwriteMode defines how generators handle already existing output files.
+type OverwriteMode string
+
+const (
+	// OverwriteModeFail refuses to replace existing files.
+	OverwriteModeFail OverwriteMode = "fail"
+	// OverwriteModeForce replaces existing files without a copy.
+	OverwriteModeForce OverwriteMode = "force"
+	// OverwriteModeBackup keeps a timestamped copy of existing files before they are replaced.
+	OverwriteModeBackup OverwriteMode = "backup"
+)
+
+// NewOverwriteMode returns the OverwriteMode for the given force and backup flags.
+// Backup wins if both are set, because it also allows overwriting.
+func NewOverwriteMode(force bool, backup bool) OverwriteMode {
+	if backup {
+		return OverwriteModeBackup
+	}
+	if force {
+		return OverwriteModeForce
+	}
+	return OverwriteModeFail
+}
+
+// PrepareOverwrite checks the given output paths before a generator writes them.
+// With OverwriteModeFail it returns an error listing all existing files,
+// with OverwriteModeBackup it copies each existing file to <path>.<timestamp>.bak.
+func PrepareOverwrite(ctx context.Context, mode OverwriteMode, paths ...string) error {
+	var existing []string
+	for _, path := range paths {
+		exists, err := fileExists(path)
+		if err != nil {
+			return errors.Wrapf(ctx, err, "check file %s failed", path)
+		}
+		if exists {
+			existing = append(existing, path)
+		}
+	}
+	if len(existing) == 0 {
+		return nil
+	}
+	switch mode {
+	case OverwriteModeForce:
+		glog.V(2).Infof("overwrite existing files %s", strings.Join(existing, ", "))
+		return nil
+	case OverwriteModeBackup:
+		timestamp := time.Now().UTC().Format("20060102T150405Z")
+		for _, path := range existing {
+			backupPath := fmt.Sprintf("%s.%s.bak", path, timestamp)
+			if err := copyFile(path, backupPath); err != nil {
+				return errors.Wrapf(ctx, err, "backup %s to %s failed", path, backupPath)
+			}
+			glog.V(2).Infof("backup %s to %s", path, backupPath)
+		}
+		return nil
+	default:
+		return errors.Errorf(ctx, "refuse to overwrite existing %s, use -force to replace or -backup to keep a copy", strings.Join(existing, ", "))
+	}
+}
+
+func fileExists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+func copyFile(src string, dst string) error {
+	stat, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PrepareOverwrite", func() {
+	var ctx context.Context
+	var dir string
+	var path string
+	var err error
+	BeforeEach(func() {
+		ctx = context.Background()
+		dir = GinkgoT().TempDir()
+		path = filepath.Join(dir, "ca_cert.pem")
+	})
+	Context("file missing", func() {
+		It("returns no error", func() {
+			err = pkg.PrepareOverwrite(ctx, pkg.OverwriteModeFail, path)
+			Expect(err).To(BeNil())
+		})
+	})
+	Context("file exists", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(path, []byte("old"), 0600)).To(Succeed())
+		})
+		It("fails without force", func() {
+			err = pkg.PrepareOverwrite(ctx, pkg.OverwriteModeFail, path)
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring(path))
+		})
+		It("allows overwrite with force", func() {
+			err = pkg.PrepareOverwrite(ctx, pkg.OverwriteModeForce, path)
+			Expect(err).To(BeNil())
+		})
+		It("creates backup", func() {
+			err = pkg.PrepareOverwrite(ctx, pkg.OverwriteModeBackup, path)
+			Expect(err).To(BeNil())
+			matches, err := filepath.Glob(path + ".*.bak")
+			Expect(err).To(BeNil())
+			Expect(matches).To(HaveLen(1))
+			content, err := os.ReadFile(matches[0])
+			Expect(err).To(BeNil())
+			Expect(string(content)).To(Equal("old"))
+		})
+	})
+})
@@ -0,0 +1,111 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/bborbe/errors"
+)
+
+const (
+	pemTypeCertificate  = "CERTIFICATE"
+	pemTypeCSR          = "CERTIFICATE REQUEST"
+	pemTypeECPrivateKey = "EC PRIVATE KEY"
+	pemTypePrivateKey   = "PRIVATE KEY"
+	pemTypeRSAKey       = "RSA PRIVATE KEY"
+)
+
+// EncodeCertificatePEM returns the PEM encoding of the given certificate.
+func EncodeCertificatePEM(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: pemTypeCertificate, Bytes: cert.Raw})
+}
+
+// EncodePrivateKeyPEM returns the PEM encoding of the given private key.
+// ECDSA keys are written as EC PRIVATE KEY to stay compatible with existing files,
+// all other keys as PKCS#8.
+func EncodePrivateKeyPEM(ctx context.Context, key crypto.Signer) ([]byte, error) {
+	if ecKey, ok := key.(*ecdsa.PrivateKey); ok {
+		der, err := x509.MarshalECPrivateKey(ecKey)
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "marshal ec private key failed")
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: pemTypeECPrivateKey, Bytes: der}), nil
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "marshal pkcs8 private key failed")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemTypePrivateKey, Bytes: der}), nil
+}
+
+// ParseCertificatePEM parses the first certificate in the given PEM data.
+func ParseCertificatePEM(ctx context.Context, data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != pemTypeCertificate {
+		return nil, errors.Errorf(ctx, "no %s pem block found", pemTypeCertificate)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "parse certificate failed")
+	}
+	return cert, nil
+}
+
+// ParseCertificateRequestPEM parses the first certificate request in the given PEM data.
+// DER encoded requests are accepted as well.
+func ParseCertificateRequestPEM(ctx context.Context, data []byte) (*x509.CertificateRequest, error) {
+	der := data
+	block, _ := pem.Decode(data)
+	if block != nil {
+		if block.Type != pemTypeCSR && block.Type != "NEW "+pemTypeCSR {
+			return nil, errors.Errorf(ctx, "unexpected pem type %s", block.Type)
+		}
+		der = block.Bytes
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "parse certificate request failed")
+	}
+	return csr, nil
+}
+
+// ParsePrivateKeyPEM parses the first private key in the given PEM data.
+func ParsePrivateKeyPEM(ctx context.Context, data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Errorf(ctx, "no private key pem block found")
+	}
+	switch block.Type {
+	case pemTypeECPrivateKey:
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "parse ec private key failed")
+		}
+		return key, nil
+	case pemTypeRSAKey:
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "parse rsa private key failed")
+		}
+		return key, nil
+	case pemTypePrivateKey:
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "parse pkcs8 private key failed")
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.Errorf(ctx, "private key of type %T is not a signer", key)
+		}
+		return signer, nil
+	default:
+		return nil, errors.Errorf(ctx, "unsupported private key pem type %s", block.Type)
+	}
+}
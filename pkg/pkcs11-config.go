@@ -0,0 +1,43 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+// DefaultPKCS11KeyLabel is used if no key label is configured.
+const DefaultPKCS11KeyLabel = "sample_cert_ca"
+
+// PKCS11Config selects module, token and key of a PKCS#11 backed CA key.
+// The token is selected by SlotNumber if it is set, otherwise by TokenLabel.
+type PKCS11Config struct {
+	ModulePath string
+	SlotNumber *int
+	TokenLabel string
+	PIN        string
+	KeyLabel   string
+}
+
+func (p PKCS11Config) keyLabel() string {
+	if p.KeyLabel == "" {
+		return DefaultPKCS11KeyLabel
+	}
+	return p.KeyLabel
+}
+
+// NewPKCS11Config returns the PKCS11Config for the given command line values,
+// or nil if modulePath is empty. A negative slot means the token is selected by label.
+func NewPKCS11Config(modulePath string, slot int, tokenLabel string, pin string, keyLabel string) *PKCS11Config {
+	if modulePath == "" {
+		return nil
+	}
+	config := &PKCS11Config{
+		ModulePath: modulePath,
+		TokenLabel: tokenLabel,
+		PIN:        pin,
+		KeyLabel:   keyLabel,
+	}
+	if slot >= 0 {
+		config.SlotNumber = &slot
+	}
+	return config
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+
+	"github.com/bborbe/errors"
+)
+
+var (
+	oidPKCS7Data       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS7SignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+// pkcs7SignedData without signers, used as certs-only structure (RFC 5652 section 5.1).
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue `asn1:"optional"`
+	SignerInfos      asn1.RawValue `asn1:"optional"`
+}
+
+// CertsOnlyPKCS7 returns a degenerate PKCS#7 SignedData in DER holding only the given certificates.
+func CertsOnlyPKCS7(ctx context.Context, certs ...*x509.Certificate) ([]byte, error) {
+	var raw []byte
+	for _, cert := range certs {
+		raw = append(raw, cert.Raw...)
+	}
+	emptySet := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: []byte{}}
+	signedData, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo:      pkcs7ContentInfo{ContentType: oidPKCS7Data},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos:      emptySet,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "marshal signed data failed")
+	}
+	der, err := asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidPKCS7SignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "marshal content info failed")
+	}
+	return der, nil
+}
+
+// ParseCertsOnlyPKCS7 returns the certificates of a PKCS#7 SignedData in DER.
+func ParseCertsOnlyPKCS7(ctx context.Context, der []byte) ([]*x509.Certificate, error) {
+	var contentInfo pkcs7ContentInfo
+	if rest, err := asn1.Unmarshal(der, &contentInfo); err != nil {
+		return nil, errors.Wrapf(ctx, err, "unmarshal content info failed")
+	} else if len(rest) > 0 {
+		return nil, errors.Errorf(ctx, "trailing data after content info")
+	}
+	if !contentInfo.ContentType.Equal(oidPKCS7SignedData) {
+		return nil, errors.Errorf(ctx, "content type %s is not signed data", contentInfo.ContentType)
+	}
+	if contentInfo.Content.Class != asn1.ClassContextSpecific || contentInfo.Content.Tag != 0 {
+		return nil, errors.Errorf(ctx, "signed data content missing")
+	}
+	var signedData pkcs7SignedData
+	if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+		return nil, errors.Wrapf(ctx, err, "unmarshal signed data failed")
+	}
+	if signedData.Certificates.Class != asn1.ClassContextSpecific || signedData.Certificates.Tag != 0 {
+		return nil, errors.Errorf(ctx, "signed data contains no certificates")
+	}
+	certs, err := x509.ParseCertificates(signedData.Certificates.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "parse certificates failed")
+	}
+	return certs, nil
+}
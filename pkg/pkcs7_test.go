@@ -0,0 +1,38 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PKCS7", func() {
+	var ctx context.Context
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+	It("round trips certificates", func() {
+		ca, err := pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		keyPair, err := pkg.IssueCertificate(ctx, ca, pkg.DefaultClientIssueRequest())
+		Expect(err).To(BeNil())
+
+		der, err := pkg.CertsOnlyPKCS7(ctx, keyPair.Certificate, ca.Certificate)
+		Expect(err).To(BeNil())
+		certs, err := pkg.ParseCertsOnlyPKCS7(ctx, der)
+		Expect(err).To(BeNil())
+		Expect(certs).To(HaveLen(2))
+		Expect(certs[0].Equal(keyPair.Certificate)).To(BeTrue())
+		Expect(certs[1].Equal(ca.Certificate)).To(BeTrue())
+	})
+	It("rejects garbage", func() {
+		_, err := pkg.ParseCertsOnlyPKCS7(ctx, []byte("garbage"))
+		Expect(err).NotTo(BeNil())
+	})
+})
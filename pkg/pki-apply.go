@@ -0,0 +1,377 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/bborbe/errors"
+)
+
+// PKIAction describes what ApplyPKIConfig did for one CA or leaf.
+type PKIAction struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"`
+	// Certificate is the created or renewed certificate, nil if unchanged.
+	Certificate *x509.Certificate `json:"-"`
+}
+
+func (p PKIAction) String() string {
+	if p.Reason == "" {
+		return fmt.Sprintf("%s %s %s", p.Kind, p.Name, p.Action)
+	}
+	return fmt.Sprintf("%s %s %s (%s)", p.Kind, p.Name, p.Action, p.Reason)
+}
+
+const (
+	PKIActionCreated   = "created"
+	PKIActionRenewed   = "renewed"
+	PKIActionUnchanged = "unchanged"
+)
+
+// ApplyPKIConfig creates all CAs and leaves of config below dataDir that are missing
+// and renews those near expiry, not signed by their current issuer or whose names changed.
+// Renewed CAs keep their key, so certificates issued before stay valid.
+// Created and renewed certificates are recorded with recorder before they are written.
+// Running it twice without changes is a no-op.
+func ApplyPKIConfig(ctx context.Context, dataDir string, config PKIConfig, overwriteMode OverwriteMode, recorder IssuanceRecorder) ([]PKIAction, error) {
+	applier := &pkiApplier{
+		dataDir:       dataDir,
+		config:        config,
+		overwriteMode: overwriteMode,
+		recorder:      recorder,
+		now:           time.Now(),
+		cas:           make(map[string]*CA),
+	}
+	return applier.apply(ctx)
+}
+
+type pkiApplier struct {
+	dataDir       string
+	config        PKIConfig
+	overwriteMode OverwriteMode
+	recorder      IssuanceRecorder
+	now           time.Time
+	cas           map[string]*CA
+	policy        *Policy
+}
+
+func (p *pkiApplier) apply(ctx context.Context) ([]PKIAction, error) {
+	var err error
+	if p.policy, err = DataDir(p.dataDir).Policy(ctx); err != nil {
+		return nil, errors.Wrapf(ctx, err, "load policy failed")
+	}
+	var actions []PKIAction
+	remaining := append([]PKICAConfig{}, p.config.CAs...)
+	for len(remaining) > 0 {
+		var next []PKICAConfig
+		for _, caConfig := range remaining {
+			if caConfig.Parent != "" && p.cas[caConfig.Parent] == nil {
+				next = append(next, caConfig)
+				continue
+			}
+			action, err := p.applyCA(ctx, caConfig)
+			if err != nil {
+				return nil, errors.Wrapf(ctx, err, "apply ca '%s' failed", caConfig.Name)
+			}
+			actions = append(actions, action)
+		}
+		if len(next) == len(remaining) {
+			return nil, errors.Errorf(ctx, "unable to resolve parents of %d cas", len(next))
+		}
+		remaining = next
+	}
+	for _, leafConfig := range p.config.Leaves {
+		action, err := p.applyLeaf(ctx, leafConfig)
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "apply leaf '%s' failed", leafConfig.Name)
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+func (p *pkiApplier) applyCA(ctx context.Context, caConfig PKICAConfig) (PKIAction, error) {
+	action := PKIAction{Kind: "ca", Name: caConfig.Name}
+	certPath := p.path(caConfig.CertPath)
+	keyPath := p.path(caConfig.KeyPath)
+	parent := p.cas[caConfig.Parent]
+	req := caConfig.CARequest()
+
+	existing, err := p.loadExistingCA(ctx, certPath, keyPath)
+	if err != nil {
+		return action, errors.Wrapf(ctx, err, "load existing ca failed")
+	}
+
+	var ca *CA
+	switch {
+	case existing == nil:
+		ca, err = p.createCA(ctx, req, parent)
+		if err != nil {
+			return action, errors.Wrapf(ctx, err, "create ca failed")
+		}
+		if err := p.recorder.Audit(ctx, NewAuditEntry(AuditOperationCreateCA, "", ca.Certificate, "")); err != nil {
+			return action, err
+		}
+		if err := p.prepare(ctx, certPath, keyPath); err != nil {
+			return action, err
+		}
+		if err := WriteCA(ctx, ca, certPath, keyPath); err != nil {
+			return action, errors.Wrapf(ctx, err, "write ca failed")
+		}
+		action.Action = PKIActionCreated
+	default:
+		action.Reason = p.caRenewReason(existing.Certificate, req, parent)
+		if action.Reason == "" {
+			ca = existing
+			action.Action = PKIActionUnchanged
+			break
+		}
+		if parent == nil {
+			ca, err = CreateCAWithSigner(ctx, req, existing.Signer)
+		} else {
+			ca, err = SignIntermediateCA(ctx, parent, req, existing.Signer)
+		}
+		if err != nil {
+			return action, errors.Wrapf(ctx, err, "renew ca failed")
+		}
+		if err := p.recorder.Audit(ctx, NewAuditEntry(AuditOperationRenew, "", ca.Certificate, "")); err != nil {
+			return action, err
+		}
+		if err := p.prepare(ctx, certPath); err != nil {
+			return action, err
+		}
+		if err := WriteCertificateFile(ctx, certPath, ca.CertificatePEM()); err != nil {
+			return action, errors.Wrapf(ctx, err, "write ca cert failed")
+		}
+		action.Action = PKIActionRenewed
+	}
+	if action.Action != PKIActionUnchanged {
+		action.Certificate = ca.Certificate
+	}
+
+	ca.Policy = p.policy
+	if parent != nil {
+		ca.Issuers = append([]*x509.Certificate{parent.Certificate}, parent.Issuers...)
+	}
+	p.cas[caConfig.Name] = ca
+	return action, nil
+}
+
+func (p *pkiApplier) createCA(ctx context.Context, req CARequest, parent *CA) (*CA, error) {
+	if parent == nil {
+		return CreateCA(ctx, req)
+	}
+	return CreateIntermediateCA(ctx, parent, req)
+}
+
+func (p *pkiApplier) caRenewReason(cert *x509.Certificate, req CARequest, parent *CA) string {
+	if reason := p.expiryReason(cert); reason != "" {
+		return reason
+	}
+	if parent != nil && !issuedBy(cert, parent.Certificate) {
+		return "not signed by current parent"
+	}
+	if cert.Subject.CommonName != req.Subject.CommonName || !equalStrings(cert.Subject.Organization, req.Subject.Organization) {
+		return "subject changed"
+	}
+	if !NameConstraintsOfCertificate(cert).Equal(req.NameConstraints) {
+		return "name constraints changed"
+	}
+	return ""
+}
+
+func (p *pkiApplier) applyLeaf(ctx context.Context, leafConfig PKILeafConfig) (PKIAction, error) {
+	action := PKIAction{Kind: "leaf", Name: leafConfig.Name}
+	certPath := p.path(leafConfig.CertPath)
+	keyPath := p.path(leafConfig.KeyPath)
+	chainPath := p.path(leafConfig.ChainPath)
+	issuer := p.cas[leafConfig.Issuer]
+	req, err := leafConfig.IssueRequest(ctx)
+	if err != nil {
+		return action, errors.Wrapf(ctx, err, "create issue request failed")
+	}
+
+	existing, err := p.loadExistingLeaf(ctx, certPath, keyPath, chainPath)
+	if err != nil {
+		return action, errors.Wrapf(ctx, err, "load existing leaf failed")
+	}
+	if existing != nil {
+		action.Reason = p.leafRenewReason(existing, req, issuer)
+		if action.Reason == "" {
+			action.Action = PKIActionUnchanged
+			return action, nil
+		}
+		action.Action = PKIActionRenewed
+	} else {
+		action.Action = PKIActionCreated
+	}
+
+	keyPair, err := IssueCertificate(ctx, issuer, req)
+	if err != nil {
+		return action, errors.Wrapf(ctx, err, "issue certificate failed")
+	}
+	operation := AuditOperationRenew
+	if action.Action == PKIActionCreated {
+		operation = AuditOperationIssue
+	}
+	if err := p.recorder.Record(ctx, operation, keyPair.Certificate, req.Profile, certPath, keyPath); err != nil {
+		return action, err
+	}
+	if err := p.prepare(ctx, certPath, keyPath, chainPath); err != nil {
+		return action, err
+	}
+	if err := WriteKeyPair(ctx, keyPair, certPath, keyPath); err != nil {
+		return action, errors.Wrapf(ctx, err, "write key pair failed")
+	}
+	if err := WriteCertificateFile(ctx, chainPath, keyPair.ChainPEM(issuer.Intermediates()...)); err != nil {
+		return action, errors.Wrapf(ctx, err, "write chain failed")
+	}
+	action.Certificate = keyPair.Certificate
+	return action, nil
+}
+
+func (p *pkiApplier) leafRenewReason(cert *x509.Certificate, req IssueRequest, issuer *CA) string {
+	if reason := p.expiryReason(cert); reason != "" {
+		return reason
+	}
+	if !issuedBy(cert, issuer.Certificate) {
+		return "not signed by current issuer"
+	}
+	template, err := createTemplate(context.Background(), req)
+	if err != nil {
+		return err.Error()
+	}
+	if cert.KeyUsage != template.KeyUsage || !equalExtKeyUsages(cert.ExtKeyUsage, template.ExtKeyUsage) {
+		return "profile changed"
+	}
+	if cert.Subject.CommonName != req.CommonName ||
+		!equalStrings(cert.Subject.Organization, req.Organization) ||
+		!equalStrings(cert.DNSNames, req.DNSNames) ||
+		!equalStrings(cert.EmailAddresses, req.EmailAddresses) ||
+		!equalStrings(ipStrings(cert.IPAddresses), ipStrings(req.IPAddresses)) ||
+		!equalStrings(uriStrings(cert), uriStrings(template)) {
+		return "names changed"
+	}
+	if issuer.CheckNameConstraints(context.Background(), req) != nil {
+		return "outside name constraints of issuer"
+	}
+	return ""
+}
+
+func (p *pkiApplier) expiryReason(cert *x509.Certificate) string {
+	if p.now.Add(p.config.RenewBefore).After(cert.NotAfter) {
+		return fmt.Sprintf("expires at %s", cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return ""
+}
+
+func (p *pkiApplier) loadExistingCA(ctx context.Context, certPath string, keyPath string) (*CA, error) {
+	exists, err := allFilesExist(certPath, keyPath)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return LoadCA(ctx, certPath, keyPath)
+}
+
+func (p *pkiApplier) loadExistingLeaf(ctx context.Context, certPath string, keyPath string, chainPath string) (*x509.Certificate, error) {
+	exists, err := allFilesExist(certPath, keyPath, chainPath)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return LoadCertificate(ctx, certPath)
+}
+
+func (p *pkiApplier) prepare(ctx context.Context, paths ...string) error {
+	if err := CreateParentDirs(ctx, paths...); err != nil {
+		return err
+	}
+	if err := PrepareOverwrite(ctx, p.overwriteMode, paths...); err != nil {
+		return errors.Wrapf(ctx, err, "prepare overwrite failed")
+	}
+	return nil
+}
+
+func (p *pkiApplier) path(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(p.dataDir, path)
+}
+
+func allFilesExist(paths ...string) (bool, error) {
+	for _, path := range paths {
+		exists, err := fileExists(path)
+		if err != nil || !exists {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalExtKeyUsages(a []x509.ExtKeyUsage, b []x509.ExtKeyUsage) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func ipStrings(ips []net.IP) []string {
+	var result []string
+	for _, ip := range ips {
+		result = append(result, ip.String())
+	}
+	return result
+}
+
+func uriStrings(cert *x509.Certificate) []string {
+	var result []string
+	for _, uri := range cert.URIs {
+		result = append(result, uri.String())
+	}
+	return result
+}
+
+// issuedBy returns true if cert names parent as issuer and is signed by its key.
+// The signature alone is not enough, a renamed or re-keyed parent may keep the old key.
+func issuedBy(cert *x509.Certificate, parent *x509.Certificate) bool {
+	if !bytes.Equal(cert.RawIssuer, parent.RawSubject) {
+		return false
+	}
+	if (len(cert.AuthorityKeyId) > 0 || len(parent.SubjectKeyId) > 0) && !bytes.Equal(cert.AuthorityKeyId, parent.SubjectKeyId) {
+		return false
+	}
+	return cert.CheckSignatureFrom(parent) == nil
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ApplyPKIConfig", func() {
+	var ctx context.Context
+	var dir string
+	var config *pkg.PKIConfig
+	var actions []pkg.PKIAction
+	var recorder pkg.IssuanceRecorder
+	var err error
+	BeforeEach(func() {
+		ctx = context.Background()
+		dir = GinkgoT().TempDir()
+		recorder, err = pkg.DataDir(dir).IssuanceRecorder(ctx)
+		Expect(err).To(BeNil())
+		config, err = pkg.ParsePKIConfig(ctx, []byte(`
+cas:
+  - name: root
+    commonName: Root
+  - name: intermediate
+    parent: root
+    commonName: Intermediate
+leaves:
+  - name: api
+    issuer: intermediate
+    profile: server
+    commonName: api
+    dnsNames: [api.example.com]
+`))
+		Expect(err).To(BeNil())
+		actions, err = pkg.ApplyPKIConfig(ctx, dir, *config, pkg.OverwriteModeForce, recorder)
+		Expect(err).To(BeNil())
+	})
+	actionNames := func(actions []pkg.PKIAction) []string {
+		var result []string
+		for _, action := range actions {
+			result = append(result, action.Name+":"+action.Action)
+		}
+		return result
+	}
+	It("creates everything", func() {
+		Expect(actionNames(actions)).To(Equal([]string{"root:created", "intermediate:created", "api:created"}))
+	})
+	It("writes verifiable chain", func() {
+		root, err := pkg.LoadCertificate(ctx, filepath.Join(dir, "root", "cert.pem"))
+		Expect(err).To(BeNil())
+		chainPEM, err := os.ReadFile(filepath.Join(dir, "api", "chain.pem"))
+		Expect(err).To(BeNil())
+		chain, err := pkg.ParseCertificates(ctx, chainPEM)
+		Expect(err).To(BeNil())
+		Expect(chain).To(HaveLen(2))
+		_, err = pkg.VerifyCertificate(ctx, pkg.VerifyRequest{
+			Leaf:          chain[0],
+			Intermediates: chain[1:],
+			Roots:         []*x509.Certificate{root},
+			Purpose:       pkg.ProfileServer,
+			Hostname:      "api.example.com",
+		})
+		Expect(err).To(BeNil())
+		Expect(pkg.CheckKeyPairFiles(ctx, filepath.Join(dir, "api", "cert.pem"), filepath.Join(dir, "api", "key.pem"))).To(Succeed())
+	})
+	It("is idempotent", func() {
+		actions, err = pkg.ApplyPKIConfig(ctx, dir, *config, pkg.OverwriteModeForce, recorder)
+		Expect(err).To(BeNil())
+		Expect(actionNames(actions)).To(Equal([]string{"root:unchanged", "intermediate:unchanged", "api:unchanged"}))
+	})
+	It("renews leaf if names change", func() {
+		config.Leaves[0].DNSNames = []string{"api.example.com", "api2.example.com"}
+		actions, err = pkg.ApplyPKIConfig(ctx, dir, *config, pkg.OverwriteModeForce, recorder)
+		Expect(err).To(BeNil())
+		Expect(actionNames(actions)).To(Equal([]string{"root:unchanged", "intermediate:unchanged", "api:renewed"}))
+	})
+	It("records created and renewed leaves in the inventory", func() {
+		config.Leaves[0].DNSNames = []string{"api.example.com", "api2.example.com"}
+		_, err = pkg.ApplyPKIConfig(ctx, dir, *config, pkg.OverwriteModeForce, recorder)
+		Expect(err).To(BeNil())
+		entries, err := recorder.Inventory.List(ctx)
+		Expect(err).To(BeNil())
+		Expect(entries).To(HaveLen(2))
+		cert, err := pkg.LoadCertificate(ctx, filepath.Join(dir, "api", "cert.pem"))
+		Expect(err).To(BeNil())
+		Expect(entries[1].SerialNumber).To(Equal(pkg.FormatHex(cert.SerialNumber.Bytes())))
+		_, count, err := pkg.VerifyAuditLog(ctx, filepath.Join(dir, pkg.AuditLogFile))
+		Expect(err).To(BeNil())
+		Expect(count).To(Equal(4))
+	})
+	It("renews ca if name constraints change", func() {
+		config.CAs[1].NameConstraints = pkg.NameConstraints{PermittedDNSDomains: []string{"example.com"}}
+		actions, err = pkg.ApplyPKIConfig(ctx, dir, *config, pkg.OverwriteModeForce, recorder)
+		Expect(err).To(BeNil())
+		Expect(actionNames(actions)).To(Equal([]string{"root:unchanged", "intermediate:renewed", "api:unchanged"}))
+	})
+	It("renews children if the parent is renamed with the same key", func() {
+		config.CAs[0].CommonName = "Root G2"
+		actions, err = pkg.ApplyPKIConfig(ctx, dir, *config, pkg.OverwriteModeForce, recorder)
+		Expect(err).To(BeNil())
+		Expect(actionNames(actions)).To(Equal([]string{"root:renewed", "intermediate:renewed", "api:unchanged"}))
+		intermediate, err := pkg.LoadCertificate(ctx, filepath.Join(dir, "intermediate", "cert.pem"))
+		Expect(err).To(BeNil())
+		Expect(intermediate.Issuer.CommonName).To(Equal("Root G2"))
+	})
+	It("rejects leaf outside the name constraints of its issuer", func() {
+		config.CAs[1].NameConstraints = pkg.NameConstraints{PermittedDNSDomains: []string{"example.org"}}
+		_, err = pkg.ApplyPKIConfig(ctx, dir, *config, pkg.OverwriteModeForce, recorder)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("dns name 'api.example.com' is not permitted by example.org"))
+	})
+	It("renews everything near expiry", func() {
+		config.RenewBefore = 20 * 365 * 24 * time.Hour
+		actions, err = pkg.ApplyPKIConfig(ctx, dir, *config, pkg.OverwriteModeForce, recorder)
+		Expect(err).To(BeNil())
+		Expect(actionNames(actions)).To(Equal([]string{"root:renewed", "intermediate:renewed", "api:renewed"}))
+	})
+	It("rejects unknown issuer", func() {
+		_, err = pkg.ParsePKIConfig(ctx, []byte(`{"cas":[{"name":"root"}],"leaves":[{"name":"a","issuer":"b","profile":"server"}]}`))
+		Expect(err).NotTo(BeNil())
+	})
+})
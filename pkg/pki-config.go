@@ -0,0 +1,218 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/bborbe/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultRenewBefore is used if a PKIConfig defines no renewBefore.
+const DefaultRenewBefore = 30 * 24 * time.Hour
+
+// PKIConfig declares a CA hierarchy and all leaf certificates issued from it.
+// It is read from YAML or JSON, durations are written like 720h.
+type PKIConfig struct {
+	// RenewBefore renews certificates expiring within this duration.
+	RenewBefore time.Duration   `yaml:"renewBefore" json:"renewBefore"`
+	CAs         []PKICAConfig   `yaml:"cas" json:"cas"`
+	Leaves      []PKILeafConfig `yaml:"leaves" json:"leaves"`
+}
+
+// PKICAConfig declares a root CA, or an intermediate CA if Parent is set.
+type PKICAConfig struct {
+	Name         string        `yaml:"name" json:"name"`
+	Parent       string        `yaml:"parent" json:"parent"`
+	CommonName   string        `yaml:"commonName" json:"commonName"`
+	Organization []string      `yaml:"organization" json:"organization"`
+	Validity     time.Duration `yaml:"validity" json:"validity"`
+	MaxPathLen   *int          `yaml:"maxPathLen" json:"maxPathLen"`
+	CertPath     string        `yaml:"certPath" json:"certPath"`
+	KeyPath      string        `yaml:"keyPath" json:"keyPath"`
+	// NameConstraints restrict the names of all certificates below this CA.
+	NameConstraints NameConstraints `yaml:"nameConstraints" json:"nameConstraints"`
+}
+
+// PKILeafConfig declares a leaf certificate issued by the CA named Issuer.
+type PKILeafConfig struct {
+	Name           string        `yaml:"name" json:"name"`
+	Issuer         string        `yaml:"issuer" json:"issuer"`
+	Profile        Profile       `yaml:"profile" json:"profile"`
+	CommonName     string        `yaml:"commonName" json:"commonName"`
+	Organization   []string      `yaml:"organization" json:"organization"`
+	DNSNames       []string      `yaml:"dnsNames" json:"dnsNames"`
+	IPAddresses    []string      `yaml:"ipAddresses" json:"ipAddresses"`
+	EmailAddresses []string      `yaml:"emailAddresses" json:"emailAddresses"`
+	URIs           []string      `yaml:"uris" json:"uris"`
+	Validity       time.Duration `yaml:"validity" json:"validity"`
+	CertPath       string        `yaml:"certPath" json:"certPath"`
+	KeyPath        string        `yaml:"keyPath" json:"keyPath"`
+	ChainPath      string        `yaml:"chainPath" json:"chainPath"`
+}
+
+// LoadPKIConfig reads a PKIConfig from a YAML or JSON file.
+func LoadPKIConfig(ctx context.Context, path string) (*PKIConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "read %s failed", path)
+	}
+	return ParsePKIConfig(ctx, data)
+}
+
+// ParsePKIConfig parses YAML or JSON, applies defaults and validates the result.
+func ParsePKIConfig(ctx context.Context, data []byte) (*PKIConfig, error) {
+	var config PKIConfig
+	// JSON is a subset of YAML, so the yaml decoder reads both
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, errors.Wrapf(ctx, err, "unmarshal config failed")
+	}
+	config.applyDefaults()
+	if err := config.Validate(ctx); err != nil {
+		return nil, errors.Wrapf(ctx, err, "validate config failed")
+	}
+	return &config, nil
+}
+
+func (p *PKIConfig) applyDefaults() {
+	if p.RenewBefore == 0 {
+		p.RenewBefore = DefaultRenewBefore
+	}
+	for i := range p.CAs {
+		ca := &p.CAs[i]
+		if ca.Validity == 0 {
+			ca.Validity = 10 * 365 * 24 * time.Hour
+		}
+		paths := NewIdentityPaths(ca.Name)
+		if ca.CertPath == "" {
+			ca.CertPath = paths.CertPath
+		}
+		if ca.KeyPath == "" {
+			ca.KeyPath = paths.KeyPath
+		}
+	}
+	for i := range p.Leaves {
+		leaf := &p.Leaves[i]
+		if leaf.Issuer == "" && len(p.CAs) > 0 {
+			leaf.Issuer = p.CAs[0].Name
+		}
+		if leaf.Validity == 0 {
+			leaf.Validity = 365 * 24 * time.Hour
+		}
+		paths := NewIdentityPaths(leaf.Name)
+		if leaf.CertPath == "" {
+			leaf.CertPath = paths.CertPath
+		}
+		if leaf.KeyPath == "" {
+			leaf.KeyPath = paths.KeyPath
+		}
+		if leaf.ChainPath == "" {
+			leaf.ChainPath = paths.ChainPath
+		}
+	}
+}
+
+// Validate returns an error if names are missing or duplicated, or references are unknown.
+func (p *PKIConfig) Validate(ctx context.Context) error {
+	if len(p.CAs) == 0 {
+		return errors.Errorf(ctx, "at least one ca required")
+	}
+	cas := make(map[string]PKICAConfig)
+	for _, ca := range p.CAs {
+		if ca.Name == "" {
+			return errors.Errorf(ctx, "ca name missing")
+		}
+		if _, ok := cas[ca.Name]; ok {
+			return errors.Errorf(ctx, "ca '%s' defined twice", ca.Name)
+		}
+		if err := ca.NameConstraints.Validate(ctx); err != nil {
+			return errors.Wrapf(ctx, err, "name constraints of ca '%s' invalid", ca.Name)
+		}
+		cas[ca.Name] = ca
+	}
+	for _, ca := range p.CAs {
+		if ca.Parent == "" {
+			continue
+		}
+		seen := map[string]bool{ca.Name: true}
+		for parent := ca.Parent; parent != ""; parent = cas[parent].Parent {
+			if _, ok := cas[parent]; !ok {
+				return errors.Errorf(ctx, "parent '%s' of ca '%s' not found", parent, ca.Name)
+			}
+			if seen[parent] {
+				return errors.Errorf(ctx, "ca '%s' has a parent cycle", ca.Name)
+			}
+			seen[parent] = true
+		}
+	}
+	leaves := make(map[string]bool)
+	for _, leaf := range p.Leaves {
+		if leaf.Name == "" {
+			return errors.Errorf(ctx, "leaf name missing")
+		}
+		if leaves[leaf.Name] {
+			return errors.Errorf(ctx, "leaf '%s' defined twice", leaf.Name)
+		}
+		leaves[leaf.Name] = true
+		if _, ok := cas[leaf.Issuer]; !ok {
+			return errors.Errorf(ctx, "issuer '%s' of leaf '%s' not found", leaf.Issuer, leaf.Name)
+		}
+		if _, err := leaf.IssueRequest(ctx); err != nil {
+			return errors.Wrapf(ctx, err, "leaf '%s' invalid", leaf.Name)
+		}
+	}
+	return nil
+}
+
+// CARequest returns the CARequest for this CA.
+func (p PKICAConfig) CARequest() CARequest {
+	return CARequest{
+		Subject: pkix.Name{
+			CommonName:   p.CommonName,
+			Organization: p.Organization,
+		},
+		Validity:        p.Validity,
+		MaxPathLen:      p.MaxPathLen,
+		NameConstraints: p.NameConstraints,
+	}
+}
+
+// IssueRequest returns the IssueRequest for this leaf.
+func (p PKILeafConfig) IssueRequest(ctx context.Context) (IssueRequest, error) {
+	req := IssueRequest{
+		Profile:        p.Profile,
+		CommonName:     p.CommonName,
+		Organization:   p.Organization,
+		DNSNames:       p.DNSNames,
+		EmailAddresses: p.EmailAddresses,
+		Validity:       p.Validity,
+	}
+	switch p.Profile {
+	case ProfileServer, ProfileClient:
+	default:
+		return IssueRequest{}, errors.Errorf(ctx, "unknown profile '%s'", p.Profile)
+	}
+	for _, value := range p.IPAddresses {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return IssueRequest{}, errors.Errorf(ctx, "invalid ip address '%s'", value)
+		}
+		req.IPAddresses = append(req.IPAddresses, ip)
+	}
+	for _, value := range p.URIs {
+		uri, err := url.Parse(value)
+		if err != nil {
+			return IssueRequest{}, errors.Wrapf(ctx, err, "invalid uri '%s'", value)
+		}
+		req.URIs = append(req.URIs, uri)
+	}
+	return req, nil
+}
@@ -0,0 +1,276 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bborbe/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// PolicyFile is the name of the issuance policy in the DataDir.
+const PolicyFile = "policy.yaml"
+
+// Policy restricts which leaf certificates a CA signs.
+// Profiles without ProfilePolicy are not restricted.
+type Policy struct {
+	Profiles map[Profile]ProfilePolicy `yaml:"profiles" json:"profiles"`
+}
+
+// ProfilePolicy restricts certificates of one profile. Empty fields allow everything.
+type ProfilePolicy struct {
+	// DNSSuffixes every DNS name must equal or end with, e.g. example.com allows example.com and a.example.com.
+	DNSSuffixes []string `yaml:"dnsSuffixes" json:"dnsSuffixes,omitempty"`
+	// EmailDomains every email address must be in, e.g. example.com allows a@example.com and a@mail.example.com.
+	EmailDomains []string `yaml:"emailDomains" json:"emailDomains,omitempty"`
+	// URIPatterns every URI must match, e.g. spiffe://example.org/*. '*' matches any sequence without '/'.
+	URIPatterns []string `yaml:"uriPatterns" json:"uriPatterns,omitempty"`
+	// IPRanges in CIDR notation every IP address must be part of.
+	IPRanges []string `yaml:"ipRanges" json:"ipRanges,omitempty"`
+	// MaxValidity limits the validity, unlimited if zero.
+	MaxValidity time.Duration `yaml:"maxValidity" json:"maxValidity,omitempty"`
+	// KeyTypes the public key must have, e.g. ecdsa-p256 or rsa-2048.
+	KeyTypes []KeyType `yaml:"keyTypes" json:"keyTypes,omitempty"`
+	// ExtKeyUsages the certificate must contain, e.g. serverAuth or clientAuth.
+	ExtKeyUsages []string `yaml:"extKeyUsages" json:"extKeyUsages,omitempty"`
+	// ForbidWildcards rejects DNS names starting with '*.'.
+	// DNSSuffixes and ForbidWildcards also apply to a common name that looks like a hostname.
+	ForbidWildcards bool `yaml:"forbidWildcards" json:"forbidWildcards,omitempty"`
+}
+
+// policyExtKeyUsages maps the extended key usage names of a ProfilePolicy.
+var policyExtKeyUsages = map[string]x509.ExtKeyUsage{
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// PolicyViolationError lists all reasons a request was rejected by a Policy.
+type PolicyViolationError struct {
+	Profile    Profile
+	Violations []string
+}
+
+func (p *PolicyViolationError) Error() string {
+	return fmt.Sprintf("policy of profile '%s' violated: %s", p.Profile, strings.Join(p.Violations, "; "))
+}
+
+// Check returns a *PolicyViolationError if a certificate for req and publicKey is not allowed.
+// A nil Policy allows everything.
+func (p *Policy) Check(ctx context.Context, req IssueRequest, publicKey crypto.PublicKey) error {
+	if p == nil {
+		return nil
+	}
+	profilePolicy, ok := p.Profiles[req.Profile]
+	if !ok {
+		return nil
+	}
+	violations, err := profilePolicy.violations(ctx, req, publicKey)
+	if err != nil {
+		return errors.Wrapf(ctx, err, "check policy of profile '%s' failed", req.Profile)
+	}
+	if len(violations) > 0 {
+		return &PolicyViolationError{
+			Profile:    req.Profile,
+			Violations: violations,
+		}
+	}
+	return nil
+}
+
+func (p ProfilePolicy) violations(ctx context.Context, req IssueRequest, publicKey crypto.PublicKey) ([]string, error) {
+	var result []string
+	dnsNames := req.DNSNames
+	if isHostnameLike(req.CommonName) && !slices.Contains(dnsNames, req.CommonName) {
+		dnsNames = append([]string{req.CommonName}, dnsNames...)
+	}
+	for _, dnsName := range dnsNames {
+		if p.ForbidWildcards && strings.HasPrefix(dnsName, "*.") {
+			result = append(result, fmt.Sprintf("wildcard dns name '%s' is forbidden", dnsName))
+			continue
+		}
+		if len(p.DNSSuffixes) > 0 && !matchDomainSuffix(p.DNSSuffixes, dnsName) {
+			result = append(result, fmt.Sprintf("dns name '%s' does not end with one of %s", dnsName, strings.Join(p.DNSSuffixes, ", ")))
+		}
+	}
+	if len(p.EmailDomains) > 0 {
+		for _, email := range req.EmailAddresses {
+			at := strings.LastIndex(email, "@")
+			if at < 0 || !matchDomainSuffix(p.EmailDomains, email[at+1:]) {
+				result = append(result, fmt.Sprintf("email address '%s' is not in %s", email, strings.Join(p.EmailDomains, ", ")))
+			}
+		}
+	}
+	if len(p.URIPatterns) > 0 {
+		for _, uri := range req.URIs {
+			if !matchNamePatterns(p.URIPatterns, uri.String()) {
+				result = append(result, fmt.Sprintf("uri '%s' does not match one of %s", uri, strings.Join(p.URIPatterns, ", ")))
+			}
+		}
+	}
+	if len(p.IPRanges) > 0 {
+		ipNets, err := parseIPRanges(ctx, p.IPRanges)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range req.IPAddresses {
+			if !slices.ContainsFunc(ipNets, func(ipNet *net.IPNet) bool { return ipNet.Contains(ip) }) {
+				result = append(result, fmt.Sprintf("ip address '%s' is not in %s", ip, strings.Join(p.IPRanges, ", ")))
+			}
+		}
+	}
+	if p.MaxValidity > 0 && req.Validity > p.MaxValidity {
+		result = append(result, fmt.Sprintf("validity %s exceeds maximum %s", req.Validity, p.MaxValidity))
+	}
+	if len(p.KeyTypes) > 0 {
+		keyType := keyTypeOfPublicKey(publicKey)
+		if !slices.Contains(p.KeyTypes, keyType) {
+			result = append(result, fmt.Sprintf("key type '%s' is not one of %s", keyType, joinKeyTypes(p.KeyTypes)))
+		}
+	}
+	if len(p.ExtKeyUsages) > 0 {
+		_, extKeyUsages, err := profileKeyUsages(ctx, req.Profile)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range p.ExtKeyUsages {
+			if !slices.Contains(extKeyUsages, policyExtKeyUsages[name]) {
+				result = append(result, fmt.Sprintf("required ext key usage '%s' is missing", name))
+			}
+		}
+	}
+	return result, nil
+}
+
+// matchDomainSuffix returns true if domain equals or ends with one of the suffixes, ignoring case.
+func matchDomainSuffix(suffixes []string, domain string) bool {
+	domain = strings.ToLower(domain)
+	for _, suffix := range suffixes {
+		suffix = strings.ToLower(suffix)
+		if domain == suffix || strings.HasSuffix(domain, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHostnameLike returns true for common names like api.example.com or *.example.com,
+// which clients not checking subject alternative names accept as hostname.
+func isHostnameLike(commonName string) bool {
+	if !strings.Contains(commonName, ".") || net.ParseIP(commonName) != nil {
+		return false
+	}
+	return strings.Trim(strings.ToLower(commonName), "abcdefghijklmnopqrstuvwxyz0123456789-_.*") == ""
+}
+
+func parseIPRanges(ctx context.Context, ipRanges []string) ([]*net.IPNet, error) {
+	result := make([]*net.IPNet, 0, len(ipRanges))
+	for _, ipRange := range ipRanges {
+		_, ipNet, err := net.ParseCIDR(ipRange)
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "invalid ip range '%s'", ipRange)
+		}
+		result = append(result, ipNet)
+	}
+	return result, nil
+}
+
+// keyTypeOfPublicKey returns the KeyType of publicKey, RSA keys of other sizes result in rsa-<bits>.
+func keyTypeOfPublicKey(publicKey crypto.PublicKey) KeyType {
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		return KeyType("ecdsa-" + strings.ToLower(strings.ReplaceAll(key.Curve.Params().Name, "-", "")))
+	case *rsa.PublicKey:
+		return KeyType(fmt.Sprintf("rsa-%d", key.N.BitLen()))
+	case ed25519.PublicKey:
+		return KeyTypeEd25519
+	default:
+		return KeyType(fmt.Sprintf("%T", publicKey))
+	}
+}
+
+func joinKeyTypes(keyTypes []KeyType) string {
+	result := make([]string, 0, len(keyTypes))
+	for _, keyType := range keyTypes {
+		result = append(result, string(keyType))
+	}
+	return strings.Join(result, ", ")
+}
+
+// Policy returns the policy of the DataDir or nil if it has none.
+func (d DataDir) Policy(ctx context.Context) (*Policy, error) {
+	path, err := d.Path(ctx, PolicyFile)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return LoadPolicy(ctx, path)
+}
+
+// LoadPolicy reads a YAML or JSON policy.
+func LoadPolicy(ctx context.Context, path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "read %s failed", path)
+	}
+	return ParsePolicy(ctx, data)
+}
+
+// ParsePolicy parses a YAML or JSON policy and validates it.
+func ParsePolicy(ctx context.Context, data []byte) (*Policy, error) {
+	var policy Policy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, errors.Wrapf(ctx, err, "unmarshal policy failed")
+	}
+	for profile, profilePolicy := range policy.Profiles {
+		if profile != ProfileServer && profile != ProfileClient {
+			return nil, errors.Errorf(ctx, "unknown profile '%s' in policy", profile)
+		}
+		for _, suffix := range profilePolicy.DNSSuffixes {
+			if suffix == "" || strings.Contains(suffix, "*") {
+				return nil, errors.Errorf(ctx, "invalid dns suffix '%s' of profile '%s'", suffix, profile)
+			}
+		}
+		for _, domain := range profilePolicy.EmailDomains {
+			if domain == "" || strings.ContainsAny(domain, "*@") {
+				return nil, errors.Errorf(ctx, "invalid email domain '%s' of profile '%s'", domain, profile)
+			}
+		}
+		if err := ValidateNamePatterns(ctx, profilePolicy.URIPatterns); err != nil {
+			return nil, errors.Wrapf(ctx, err, "invalid policy of profile '%s'", profile)
+		}
+		if _, err := parseIPRanges(ctx, profilePolicy.IPRanges); err != nil {
+			return nil, errors.Wrapf(ctx, err, "invalid policy of profile '%s'", profile)
+		}
+		for _, keyType := range profilePolicy.KeyTypes {
+			if _, err := ParseKeyType(ctx, string(keyType)); err != nil {
+				return nil, errors.Wrapf(ctx, err, "invalid policy of profile '%s'", profile)
+			}
+		}
+		for _, name := range profilePolicy.ExtKeyUsages {
+			if _, ok := policyExtKeyUsages[name]; !ok {
+				return nil, errors.Errorf(ctx, "unknown ext key usage '%s' of profile '%s'", name, profile)
+			}
+		}
+	}
+	return &policy, nil
+}
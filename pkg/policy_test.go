@@ -0,0 +1,167 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bborbe/errors"
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Policy", func() {
+	var ctx context.Context
+	var ca *pkg.CA
+	var req pkg.IssueRequest
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		ca, err = pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		ca.Policy, err = pkg.ParsePolicy(ctx, []byte(`
+profiles:
+  server:
+    dnsSuffixes: [example.com]
+    ipRanges: [10.0.0.0/8]
+    maxValidity: 2160h
+    keyTypes: [ecdsa-p256, ecdsa-p384]
+    extKeyUsages: [serverAuth]
+    forbidWildcards: true
+`))
+		Expect(err).To(BeNil())
+		req = pkg.IssueRequest{
+			Profile:     pkg.ProfileServer,
+			CommonName:  "api.example.com",
+			DNSNames:    []string{"api.example.com", "example.com"},
+			IPAddresses: []net.IP{net.ParseIP("10.1.2.3")},
+			Validity:    720 * time.Hour,
+		}
+	})
+	violations := func(err error) []string {
+		var policyErr *pkg.PolicyViolationError
+		Expect(errors.As(err, &policyErr)).To(BeTrue())
+		return policyErr.Violations
+	}
+	It("issues allowed certificate", func() {
+		_, err := pkg.IssueCertificate(ctx, ca, req)
+		Expect(err).To(BeNil())
+	})
+	It("does not restrict other profiles", func() {
+		_, err := pkg.IssueCertificate(ctx, ca, pkg.DefaultClientIssueRequest())
+		Expect(err).To(BeNil())
+	})
+	It("does not restrict without policy", func() {
+		ca.Policy = nil
+		req.DNSNames = []string{"*.example.org"}
+		_, err := pkg.IssueCertificate(ctx, ca, req)
+		Expect(err).To(BeNil())
+	})
+	It("reports all violations", func() {
+		req.DNSNames = []string{"api.example.org", "*.example.com", "badexample.com"}
+		req.IPAddresses = []net.IP{net.ParseIP("192.168.0.1")}
+		req.Validity = 365 * 24 * time.Hour
+		_, err := pkg.IssueCertificate(ctx, ca, req)
+		Expect(violations(err)).To(Equal([]string{
+			"dns name 'api.example.org' does not end with one of example.com",
+			"wildcard dns name '*.example.com' is forbidden",
+			"dns name 'badexample.com' does not end with one of example.com",
+			"ip address '192.168.0.1' is not in 10.0.0.0/8",
+			"validity 8760h0m0s exceeds maximum 2160h0m0s",
+		}))
+	})
+	It("applies dns rules to a hostname common name", func() {
+		req.CommonName = "*.example.org"
+		_, err := pkg.IssueCertificate(ctx, ca, req)
+		Expect(violations(err)).To(Equal([]string{"wildcard dns name '*.example.org' is forbidden"}))
+		req.CommonName = "api.example.org"
+		_, err = pkg.IssueCertificate(ctx, ca, req)
+		Expect(violations(err)).To(Equal([]string{"dns name 'api.example.org' does not end with one of example.com"}))
+		req.CommonName = "api"
+		_, err = pkg.IssueCertificate(ctx, ca, req)
+		Expect(err).To(BeNil())
+	})
+	It("rejects emails and uris", func() {
+		ca.Policy.Profiles[pkg.ProfileClient] = pkg.ProfilePolicy{
+			EmailDomains: []string{"example.com"},
+			URIPatterns:  []string{"spiffe://example.org/user/*"},
+		}
+		clientReq := pkg.DefaultClientIssueRequest()
+		clientReq.EmailAddresses = []string{"a@mail.example.com", "b@example.org"}
+		for _, uri := range []string{"spiffe://example.org/user/a", "spiffe://example.org/admin"} {
+			parsed, err := url.Parse(uri)
+			Expect(err).To(BeNil())
+			clientReq.URIs = append(clientReq.URIs, parsed)
+		}
+		_, err := pkg.IssueCertificate(ctx, ca, clientReq)
+		Expect(violations(err)).To(Equal([]string{
+			"email address 'b@example.org' is not in example.com",
+			"uri 'spiffe://example.org/admin' does not match one of spiffe://example.org/user/*",
+		}))
+	})
+	It("rejects key type", func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).To(BeNil())
+		_, err = pkg.SignCertificate(ctx, ca, req, key.Public())
+		Expect(violations(err)).To(Equal([]string{"key type 'rsa-2048' is not one of ecdsa-p256, ecdsa-p384"}))
+	})
+	It("rejects missing ext key usage", func() {
+		ca.Policy.Profiles[pkg.ProfileClient] = pkg.ProfilePolicy{ExtKeyUsages: []string{"serverAuth"}}
+		_, err := pkg.IssueCertificate(ctx, ca, pkg.DefaultClientIssueRequest())
+		Expect(violations(err)).To(Equal([]string{"required ext key usage 'serverAuth' is missing"}))
+	})
+	Context("ParsePolicy", func() {
+		It("rejects unknown profile", func() {
+			_, err := pkg.ParsePolicy(ctx, []byte(`{"profiles": {"ca": {}}}`))
+			Expect(err).NotTo(BeNil())
+		})
+		It("rejects invalid ip range", func() {
+			_, err := pkg.ParsePolicy(ctx, []byte(`{"profiles": {"server": {"ipRanges": ["10.0.0.0"]}}}`))
+			Expect(err).NotTo(BeNil())
+		})
+		It("rejects unknown key type", func() {
+			_, err := pkg.ParsePolicy(ctx, []byte(`{"profiles": {"server": {"keyTypes": ["dsa"]}}}`))
+			Expect(err).NotTo(BeNil())
+		})
+		It("rejects unknown ext key usage", func() {
+			_, err := pkg.ParsePolicy(ctx, []byte(`{"profiles": {"server": {"extKeyUsages": ["any"]}}}`))
+			Expect(err).NotTo(BeNil())
+		})
+		It("rejects invalid email domain", func() {
+			_, err := pkg.ParsePolicy(ctx, []byte(`{"profiles": {"client": {"emailDomains": ["@example.com"]}}}`))
+			Expect(err).NotTo(BeNil())
+		})
+		It("rejects invalid uri pattern", func() {
+			_, err := pkg.ParsePolicy(ctx, []byte(`{"profiles": {"client": {"uriPatterns": ["spiffe://example.org/["]}}}`))
+			Expect(err).NotTo(BeNil())
+		})
+		It("rejects wildcard dns suffix", func() {
+			_, err := pkg.ParsePolicy(ctx, []byte(`{"profiles": {"server": {"dnsSuffixes": ["*.example.com"]}}}`))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+	Context("DataDir", func() {
+		It("returns nil without policy file", func() {
+			policy, err := pkg.DataDir(GinkgoT().TempDir()).Policy(ctx)
+			Expect(err).To(BeNil())
+			Expect(policy).To(BeNil())
+		})
+		It("loads policy file", func() {
+			dir := GinkgoT().TempDir()
+			Expect(os.WriteFile(filepath.Join(dir, pkg.PolicyFile), []byte(`{"profiles": {"client": {"maxValidity": "24h"}}}`), 0600)).To(Succeed())
+			policy, err := pkg.DataDir(dir).Policy(ctx)
+			Expect(err).To(BeNil())
+			Expect(policy.Profiles[pkg.ProfileClient].MaxValidity).To(Equal(24 * time.Hour))
+		})
+	})
+})
@@ -0,0 +1,122 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"os"
+
+	"github.com/bborbe/errors"
+)
+
+//counterfeiter:generate -o ../mocks/signer-loader.go --fake-name SignerLoader . SignerLoader
+
+// SignerLoader provides the private key of a CA as crypto.Signer.
+type SignerLoader interface {
+	LoadSigner(ctx context.Context) (crypto.Signer, error)
+}
+
+// SignerLoaderFunc allows to use a func as SignerLoader.
+type SignerLoaderFunc func(ctx context.Context) (crypto.Signer, error)
+
+// LoadSigner calls the func.
+func (s SignerLoaderFunc) LoadSigner(ctx context.Context) (crypto.Signer, error) {
+	return s(ctx)
+}
+
+// SignerConfig selects where the CA private key comes from.
+// PKCS11 wins over SocketPath, SocketPath wins over KeyPath,
+// KeyPassword is only used for KeyPath.
+type SignerConfig struct {
+	KeyPath     string
+	KeyPassword string
+	SocketPath  string
+	PKCS11      *PKCS11Config
+}
+
+// NewSignerLoader returns the SignerLoader for the given config.
+// publicKey is the public key of the CA certificate and is required for the socket signer.
+func NewSignerLoader(ctx context.Context, config SignerConfig, publicKey crypto.PublicKey) (SignerLoader, error) {
+	switch {
+	case config.PKCS11 != nil:
+		return NewPKCS11SignerLoader(*config.PKCS11), nil
+	case config.SocketPath != "":
+		return NewUnixSocketSignerLoader(config.SocketPath, publicKey), nil
+	case config.KeyPath != "" && config.KeyPassword != "":
+		return NewEncryptedFileSignerLoader(config.KeyPath, []byte(config.KeyPassword)), nil
+	case config.KeyPath != "":
+		return NewFileSignerLoader(config.KeyPath), nil
+	default:
+		return nil, errors.Errorf(ctx, "no ca key source configured")
+	}
+}
+
+// CloseSigner releases signers holding resources like a PKCS#11 session, other signers are left alone.
+func CloseSigner(signer crypto.Signer) error {
+	if closer, ok := signer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// NewFileSignerLoader returns a SignerLoader reading an unencrypted PEM private key.
+func NewFileSignerLoader(keyPath string) SignerLoader {
+	return SignerLoaderFunc(func(ctx context.Context) (crypto.Signer, error) {
+		keyPEM, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "read %s failed", keyPath)
+		}
+		return ParsePrivateKeyPEM(ctx, keyPEM)
+	})
+}
+
+// NewEncryptedFileSignerLoader returns a SignerLoader reading a password protected PEM private key
+// as written by EncryptPrivateKeyPEM or openssl ec -aes256.
+func NewEncryptedFileSignerLoader(keyPath string, password []byte) SignerLoader {
+	return SignerLoaderFunc(func(ctx context.Context) (crypto.Signer, error) {
+		keyPEM, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "read %s failed", keyPath)
+		}
+		return DecryptPrivateKeyPEM(ctx, keyPEM, password)
+	})
+}
+
+// EncryptPrivateKeyPEM returns the PEM encoding of the given key encrypted with AES-256 and password.
+func EncryptPrivateKeyPEM(ctx context.Context, key crypto.Signer, password []byte) ([]byte, error) {
+	keyPEM, err := EncodePrivateKeyPEM(ctx, key)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "encode private key failed")
+	}
+	block, _ := pem.Decode(keyPEM)
+	// legacy PEM encryption is deprecated, but it is the format openssl uses for traditional keys
+	encrypted, err := x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, password, x509.PEMCipherAES256)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "encrypt private key failed")
+	}
+	return pem.EncodeToMemory(encrypted), nil
+}
+
+// DecryptPrivateKeyPEM parses a password protected PEM private key.
+// Unencrypted keys are accepted as well.
+func DecryptPrivateKeyPEM(ctx context.Context, data []byte, password []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Errorf(ctx, "no private key pem block found")
+	}
+	if !x509.IsEncryptedPEMBlock(block) {
+		return ParsePrivateKeyPEM(ctx, data)
+	}
+	der, err := x509.DecryptPEMBlock(block, password)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "decrypt private key failed")
+	}
+	return ParsePrivateKeyPEM(ctx, pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}))
+}
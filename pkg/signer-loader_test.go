@@ -0,0 +1,108 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SignerLoader", func() {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var dir string
+	var ca *pkg.CA
+	var err error
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		dir = GinkgoT().TempDir()
+		ca, err = pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+	})
+	AfterEach(func() {
+		cancel()
+	})
+	It("loads encrypted ca key", func() {
+		certPath := filepath.Join(dir, "ca_cert.pem")
+		keyPath := filepath.Join(dir, "ca_key.pem")
+		Expect(pkg.WriteEncryptedCA(ctx, ca, certPath, keyPath, []byte("secret"))).To(Succeed())
+
+		_, err = pkg.LoadCA(ctx, certPath, keyPath)
+		Expect(err).NotTo(BeNil())
+
+		_, err = pkg.LoadCAWithSignerConfig(ctx, certPath, pkg.SignerConfig{KeyPath: keyPath, KeyPassword: "wrong"})
+		Expect(err).NotTo(BeNil())
+
+		loaded, err := pkg.LoadCAWithSignerConfig(ctx, certPath, pkg.SignerConfig{KeyPath: keyPath, KeyPassword: "secret"})
+		Expect(err).To(BeNil())
+		_, err = pkg.IssueCertificate(ctx, loaded, pkg.DefaultServerIssueRequest())
+		Expect(err).To(BeNil())
+	})
+	It("leaves the encrypted ca unchanged if the key can't be written", func() {
+		certPath := filepath.Join(dir, "ca_cert.pem")
+		Expect(os.WriteFile(certPath, []byte("old"), 0644)).To(Succeed())
+		Expect(pkg.WriteEncryptedCA(ctx, ca, certPath, filepath.Join(dir, "missing", "ca_key.pem"), []byte("secret"))).NotTo(Succeed())
+		content, err := os.ReadFile(certPath)
+		Expect(err).To(BeNil())
+		Expect(string(content)).To(Equal("old"))
+	})
+	It("rejects key not matching certificate", func() {
+		other, err := pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		_, err = pkg.LoadCAWithSigner(ctx, ca.Certificate, pkg.SignerLoaderFunc(func(ctx context.Context) (crypto.Signer, error) {
+			return other.Signer, nil
+		}))
+		Expect(err).NotTo(BeNil())
+	})
+	It("closes signers holding resources", func() {
+		closer := &closingSigner{Signer: ca.Signer}
+		Expect(pkg.CloseSigner(closer)).To(Succeed())
+		Expect(closer.closed).To(BeTrue())
+		Expect(ca.Close()).To(Succeed())
+	})
+	It("signs via unix socket", func() {
+		socketPath := filepath.Join(dir, "signer.sock")
+		listener, err := pkg.ListenUnixSocket(ctx, socketPath)
+		Expect(err).To(BeNil())
+		info, err := os.Stat(socketPath)
+		Expect(err).To(BeNil())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		go func() {
+			defer GinkgoRecover()
+			Expect(pkg.ServeUnixSocketSigner(ctx, listener, ca.Signer)).To(Succeed())
+		}()
+		certPath := filepath.Join(dir, "ca_cert.pem")
+		Expect(os.WriteFile(certPath, ca.CertificatePEM(), 0644)).To(Succeed())
+
+		remote, err := pkg.LoadCAWithSignerConfig(ctx, certPath, pkg.SignerConfig{SocketPath: socketPath})
+		Expect(err).To(BeNil())
+		keyPair, err := pkg.IssueCertificate(ctx, remote, pkg.DefaultClientIssueRequest())
+		Expect(err).To(BeNil())
+		Expect(keyPair.Certificate.CheckSignatureFrom(ca.Certificate)).To(Succeed())
+
+		digest := sha1.Sum([]byte("data"))
+		_, err = remote.Signer.Sign(rand.Reader, digest[:], crypto.SHA1)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("unsupported hash"))
+	})
+})
+
+type closingSigner struct {
+	crypto.Signer
+	closed bool
+}
+
+func (c *closingSigner) Close() error {
+	c.closed = true
+	return nil
+}
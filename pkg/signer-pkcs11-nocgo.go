@@ -0,0 +1,27 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !cgo
+// +build !cgo
+
+package pkg
+
+import (
+	"context"
+	"crypto"
+
+	"github.com/bborbe/errors"
+)
+
+// NewPKCS11SignerLoader is not available without cgo.
+func NewPKCS11SignerLoader(config PKCS11Config) SignerLoader {
+	return SignerLoaderFunc(func(ctx context.Context) (crypto.Signer, error) {
+		return nil, errors.Errorf(ctx, "pkcs11 requires a build with cgo")
+	})
+}
+
+// GeneratePKCS11Key is not available without cgo.
+func GeneratePKCS11Key(ctx context.Context, config PKCS11Config) (crypto.Signer, error) {
+	return nil, errors.Errorf(ctx, "pkcs11 requires a build with cgo")
+}
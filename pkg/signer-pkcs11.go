@@ -0,0 +1,94 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build cgo
+// +build cgo
+
+package pkg
+
+import (
+	"context"
+	"crypto"
+	"crypto/elliptic"
+
+	"github.com/ThalesGroup/crypto11"
+	"github.com/bborbe/errors"
+)
+
+// NewPKCS11SignerLoader returns a SignerLoader for a key stored in a PKCS#11 token.
+// The returned signer keeps the session open until it is released with CloseSigner or CA.Close.
+func NewPKCS11SignerLoader(config PKCS11Config) SignerLoader {
+	return SignerLoaderFunc(func(ctx context.Context) (crypto.Signer, error) {
+		pkcs11Ctx, err := configurePKCS11(ctx, config)
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "configure pkcs11 failed")
+		}
+		signer, err := pkcs11Ctx.FindKeyPair([]byte(config.keyLabel()), []byte(config.keyLabel()))
+		if err != nil {
+			_ = pkcs11Ctx.Close()
+			return nil, errors.Wrapf(ctx, err, "find key pair %s failed", config.keyLabel())
+		}
+		if signer == nil {
+			_ = pkcs11Ctx.Close()
+			return nil, errors.Errorf(ctx, "key pair %s not found in token", config.keyLabel())
+		}
+		return &pkcs11Signer{
+			Signer:    signer,
+			pkcs11Ctx: pkcs11Ctx,
+		}, nil
+	})
+}
+
+// GeneratePKCS11Key generates a new ECDSA P-256 key pair inside the PKCS#11 token.
+// It fails if a key with the configured label already exists.
+func GeneratePKCS11Key(ctx context.Context, config PKCS11Config) (crypto.Signer, error) {
+	pkcs11Ctx, err := configurePKCS11(ctx, config)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "configure pkcs11 failed")
+	}
+	existing, err := pkcs11Ctx.FindKeyPair([]byte(config.keyLabel()), []byte(config.keyLabel()))
+	if err != nil {
+		_ = pkcs11Ctx.Close()
+		return nil, errors.Wrapf(ctx, err, "find key pair %s failed", config.keyLabel())
+	}
+	if existing != nil {
+		_ = pkcs11Ctx.Close()
+		return nil, errors.Errorf(ctx, "key pair %s already exists in token", config.keyLabel())
+	}
+	signer, err := pkcs11Ctx.GenerateECDSAKeyPairWithLabel([]byte(config.keyLabel()), []byte(config.keyLabel()), elliptic.P256())
+	if err != nil {
+		_ = pkcs11Ctx.Close()
+		return nil, errors.Wrapf(ctx, err, "generate key pair %s failed", config.keyLabel())
+	}
+	return &pkcs11Signer{
+		Signer:    signer,
+		pkcs11Ctx: pkcs11Ctx,
+	}, nil
+}
+
+func configurePKCS11(ctx context.Context, config PKCS11Config) (*crypto11.Context, error) {
+	c11Config := &crypto11.Config{
+		Path: config.ModulePath,
+		Pin:  config.PIN,
+	}
+	if config.SlotNumber != nil {
+		c11Config.SlotNumber = config.SlotNumber
+	} else {
+		c11Config.TokenLabel = config.TokenLabel
+	}
+	pkcs11Ctx, err := crypto11.Configure(c11Config)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "open pkcs11 module %s failed", config.ModulePath)
+	}
+	return pkcs11Ctx, nil
+}
+
+type pkcs11Signer struct {
+	crypto11.Signer
+	pkcs11Ctx *crypto11.Context
+}
+
+func (p *pkcs11Signer) Close() error {
+	return p.pkcs11Ctx.Close()
+}
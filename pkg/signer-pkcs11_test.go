@@ -0,0 +1,49 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// Runs against SoftHSM if PKCS11_MODULE, PKCS11_TOKEN_LABEL and PKCS11_PIN are set, see README.md.
+var _ = Describe("PKCS11Signer", func() {
+	var ctx context.Context
+	var config pkg.PKCS11Config
+	BeforeEach(func() {
+		ctx = context.Background()
+		if os.Getenv("PKCS11_MODULE") == "" {
+			Skip("PKCS11_MODULE not set")
+		}
+		config = pkg.PKCS11Config{
+			ModulePath: os.Getenv("PKCS11_MODULE"),
+			TokenLabel: os.Getenv("PKCS11_TOKEN_LABEL"),
+			PIN:        os.Getenv("PKCS11_PIN"),
+			KeyLabel:   fmt.Sprintf("test-%d", time.Now().UnixNano()),
+		}
+	})
+	It("generates ca key in token and signs with it", func() {
+		signer, err := pkg.GeneratePKCS11Key(ctx, config)
+		Expect(err).To(BeNil())
+		ca, err := pkg.CreateCAWithSigner(ctx, pkg.DefaultCARequest(), signer)
+		Expect(err).To(BeNil())
+		Expect(signer.(io.Closer).Close()).To(Succeed())
+
+		loaded, err := pkg.LoadCAWithSigner(ctx, ca.Certificate, pkg.NewPKCS11SignerLoader(config))
+		Expect(err).To(BeNil())
+		defer loaded.Signer.(io.Closer).Close()
+		keyPair, err := pkg.IssueCertificate(ctx, loaded, pkg.DefaultServerIssueRequest())
+		Expect(err).To(BeNil())
+		Expect(keyPair.Certificate.CheckSignatureFrom(ca.Certificate)).To(Succeed())
+	})
+})
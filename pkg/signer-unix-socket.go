@@ -0,0 +1,185 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bborbe/errors"
+	"github.com/golang/glog"
+)
+
+// The unix socket signer protocol sends one JSON request per connection
+// and reads one JSON response:
+//
+//	request:  {"hash":"SHA-256","digest":"<base64>"}
+//	response: {"signature":"<base64>"} or {"error":"<message>"}
+//
+// unixSocketSignTimeout bounds connect, request and response of one signature on both sides,
+// so a stalled signer or idle peer does not block forever.
+const unixSocketSignTimeout = 30 * time.Second
+
+type unixSocketSignRequest struct {
+	Hash   string `json:"hash"`
+	Digest []byte `json:"digest"`
+}
+
+type unixSocketSignResponse struct {
+	Signature []byte `json:"signature,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// NewUnixSocketSignerLoader returns a SignerLoader for a signer running in an external process
+// listening on the given unix socket. publicKey must match the key of the external signer.
+func NewUnixSocketSignerLoader(socketPath string, publicKey crypto.PublicKey) SignerLoader {
+	return SignerLoaderFunc(func(ctx context.Context) (crypto.Signer, error) {
+		if publicKey == nil {
+			return nil, errors.Errorf(ctx, "public key for unix socket signer missing")
+		}
+		return &unixSocketSigner{
+			socketPath: socketPath,
+			publicKey:  publicKey,
+		}, nil
+	})
+}
+
+type unixSocketSigner struct {
+	socketPath string
+	publicKey  crypto.PublicKey
+}
+
+func (u *unixSocketSigner) Public() crypto.PublicKey {
+	return u.publicKey
+}
+
+func (u *unixSocketSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if _, ok := opts.(crypto.Hash); !ok {
+		return nil, errors.Errorf(context.Background(), "signer opts %T not supported by unix socket signer", opts)
+	}
+	conn, err := net.DialTimeout("unix", u.socketPath, unixSocketSignTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(context.Background(), err, "dial %s failed", u.socketPath)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(unixSocketSignTimeout)); err != nil {
+		return nil, errors.Wrapf(context.Background(), err, "set deadline failed")
+	}
+	if err := json.NewEncoder(conn).Encode(unixSocketSignRequest{
+		Hash:   opts.HashFunc().String(),
+		Digest: digest,
+	}); err != nil {
+		return nil, errors.Wrapf(context.Background(), err, "send sign request failed")
+	}
+	var response unixSocketSignResponse
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return nil, errors.Wrapf(context.Background(), err, "read sign response failed")
+	}
+	if response.Error != "" {
+		return nil, errors.Errorf(context.Background(), "remote signer failed: %s", response.Error)
+	}
+	return response.Signature, nil
+}
+
+// ListenUnixSocket listens on a unix socket at path only the current user can connect to.
+// The socket is created in a private directory, restricted to 0600 and then moved to path,
+// so other users can not connect before the permissions are set.
+func ListenUnixSocket(ctx context.Context, path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".socket-")
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "create socket dir failed")
+	}
+	defer os.RemoveAll(dir)
+	tmpPath := filepath.Join(dir, "socket")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmpPath, Net: "unix"})
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "listen on %s failed", path)
+	}
+	listener.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmpPath, 0600); err != nil {
+		_ = listener.Close()
+		return nil, errors.Wrapf(ctx, err, "chmod %s failed", path)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = listener.Close()
+		return nil, errors.Wrapf(ctx, err, "move socket to %s failed", path)
+	}
+	return listener, nil
+}
+
+// ServeUnixSocketSigner answers sign requests on the given listener with signer until ctx is canceled.
+func ServeUnixSocketSigner(ctx context.Context, listener net.Listener, signer crypto.Signer) error {
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+				return errors.Wrapf(ctx, err, "accept failed")
+			}
+		}
+		go handleUnixSocketSignRequest(ctx, conn, signer)
+	}
+}
+
+func handleUnixSocketSignRequest(ctx context.Context, conn net.Conn, signer crypto.Signer) {
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(unixSocketSignTimeout)); err != nil {
+		glog.Warningf("set deadline of sign request failed: %v", err)
+		return
+	}
+	var response unixSocketSignResponse
+	signature, err := signUnixSocketRequest(ctx, conn, signer)
+	if err != nil {
+		glog.Warningf("sign request failed: %v", err)
+		response.Error = err.Error()
+	} else {
+		response.Signature = signature
+	}
+	if err := json.NewEncoder(conn).Encode(response); err != nil {
+		glog.Warningf("send sign response failed: %v", err)
+	}
+}
+
+func signUnixSocketRequest(ctx context.Context, conn net.Conn, signer crypto.Signer) ([]byte, error) {
+	var request unixSocketSignRequest
+	if err := json.NewDecoder(conn).Decode(&request); err != nil {
+		return nil, errors.Wrapf(ctx, err, "decode sign request failed")
+	}
+	hash, err := parseHash(ctx, request.Hash)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "parse hash failed")
+	}
+	if hash != 0 && len(request.Digest) != hash.Size() {
+		return nil, errors.Errorf(ctx, "digest length %d does not match %s", len(request.Digest), hash)
+	}
+	return signer.Sign(rand.Reader, request.Digest, hash)
+}
+
+func parseHash(ctx context.Context, name string) (crypto.Hash, error) {
+	// SHA-1 is not offered, certificates and CRLs are never signed with it
+	for _, hash := range []crypto.Hash{crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+		if hash.String() == name {
+			return hash, nil
+		}
+	}
+	// ed25519 signs the message without pre hashing
+	if crypto.Hash(0).String() == name {
+		return crypto.Hash(0), nil
+	}
+	return 0, errors.Errorf(ctx, "unsupported hash '%s'", name)
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bborbe/errors"
+	"github.com/golang/glog"
+)
+
+// SPIFFEScheme is the URI scheme of SPIFFE IDs.
+const SPIFFEScheme = "spiffe"
+
+// ParseSPIFFEID parses and validates a SPIFFE ID of a workload like spiffe://example.org/ns/prod/sa/web.
+func ParseSPIFFEID(ctx context.Context, value string) (*url.URL, error) {
+	id, err := url.Parse(value)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "parse spiffe id '%s' failed", value)
+	}
+	if id.Scheme != SPIFFEScheme {
+		return nil, errors.Errorf(ctx, "spiffe id '%s' must start with spiffe://", value)
+	}
+	if id.Host == "" || strings.Trim(id.Host, "abcdefghijklmnopqrstuvwxyz0123456789.-_") != "" {
+		return nil, errors.Errorf(ctx, "invalid trust domain of spiffe id '%s'", value)
+	}
+	if id.User != nil || id.RawQuery != "" || id.Fragment != "" || id.Opaque != "" {
+		return nil, errors.Errorf(ctx, "spiffe id '%s' must not contain user, port, query or fragment", value)
+	}
+	if id.Path == "" {
+		return nil, errors.Errorf(ctx, "spiffe id '%s' has no workload path", value)
+	}
+	for _, segment := range strings.Split(strings.TrimPrefix(id.Path, "/"), "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.Trim(segment, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789.-_") != "" {
+			return nil, errors.Errorf(ctx, "invalid path of spiffe id '%s'", value)
+		}
+	}
+	return id, nil
+}
+
+// NewSVIDIssueRequest returns req as request for an X.509-SVID with spiffeID as only URI SAN.
+// The key usages of the server and client profile satisfy the X.509-SVID specification.
+func NewSVIDIssueRequest(ctx context.Context, req IssueRequest, spiffeID string) (IssueRequest, error) {
+	id, err := ParseSPIFFEID(ctx, spiffeID)
+	if err != nil {
+		return IssueRequest{}, err
+	}
+	req.URIs = []*url.URL{id}
+	return req, nil
+}
+
+// SPIFFEIDOfCertificate returns the SPIFFE ID of an X.509-SVID,
+// which must have exactly one URI SAN and must not be a CA.
+func SPIFFEIDOfCertificate(ctx context.Context, cert *x509.Certificate) (string, error) {
+	if cert.IsCA {
+		return "", errors.Errorf(ctx, "certificate '%s' is a ca", cert.Subject.String())
+	}
+	if len(cert.URIs) != 1 {
+		return "", errors.Errorf(ctx, "certificate '%s' has %d uris, expected one spiffe id", cert.Subject.String(), len(cert.URIs))
+	}
+	id, err := ParseSPIFFEID(ctx, cert.URIs[0].String())
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// ParseSPIFFEIDPatterns splits a comma separated list of SPIFFE ID patterns and validates them.
+func ParseSPIFFEIDPatterns(ctx context.Context, value string) ([]string, error) {
+	patterns := SplitList(value, ",")
+	if err := ValidateSPIFFEIDPatterns(ctx, patterns); err != nil {
+		return nil, err
+	}
+	return patterns, nil
+}
+
+// ValidateSPIFFEIDPatterns returns an error if a pattern is no spiffe:// URI or malformed.
+func ValidateSPIFFEIDPatterns(ctx context.Context, patterns []string) error {
+	for _, pattern := range patterns {
+		if !strings.HasPrefix(pattern, SPIFFEScheme+"://") {
+			return errors.Errorf(ctx, "spiffe id pattern '%s' must start with spiffe://", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(ctx, err, "invalid spiffe id pattern '%s'", pattern)
+		}
+	}
+	return nil
+}
+
+// MatchSPIFFEID returns true if id matches one of the patterns,
+// e.g. spiffe://example.org/ns/*/sa/web. '*' matches any sequence without '/'.
+func MatchSPIFFEID(patterns []string, id string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, id); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSPIFFEAuthorizer passes requests to handler only if the client certificate verified by the TLS server
+// is an X.509-SVID matching one of the patterns.
+func NewSPIFFEAuthorizer(patterns []string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+			http.Error(resp, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+		id, err := SPIFFEIDOfCertificate(ctx, req.TLS.VerifiedChains[0][0])
+		if err != nil {
+			glog.V(2).Infof("spiffe authorization of %s failed: %v", req.URL.Path, err)
+			http.Error(resp, "client certificate is no x509-svid", http.StatusForbidden)
+			return
+		}
+		if !MatchSPIFFEID(patterns, id) {
+			glog.V(2).Infof("spiffe id %s not allowed for %s", id, req.URL.Path)
+			http.Error(resp, "spiffe id "+id+" not allowed", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(resp, req)
+	})
+}
+
+// SPIFFEBundle is a trust bundle in the SPIFFE bundle format, a JSON Web Key Set of the CA certificates.
+type SPIFFEBundle struct {
+	Keys []SPIFFEBundleKey `json:"keys"`
+	// Sequence is incremented by the publisher on every change, omitted if zero.
+	Sequence uint64 `json:"spiffe_sequence,omitempty"`
+	// RefreshHint in seconds tells consumers how often to fetch the bundle, omitted if zero.
+	RefreshHint int64 `json:"spiffe_refresh_hint,omitempty"`
+}
+
+// SPIFFEBundleKey is the JSON Web Key of one CA certificate.
+type SPIFFEBundleKey struct {
+	Use string   `json:"use"`
+	Kty string   `json:"kty"`
+	Crv string   `json:"crv,omitempty"`
+	X   string   `json:"x,omitempty"`
+	Y   string   `json:"y,omitempty"`
+	N   string   `json:"n,omitempty"`
+	E   string   `json:"e,omitempty"`
+	X5C []string `json:"x5c"`
+}
+
+// NewSPIFFEBundle returns the bundle with an x509-svid key for each CA certificate.
+func NewSPIFFEBundle(ctx context.Context, sequence uint64, refreshHint time.Duration, cas ...*x509.Certificate) (*SPIFFEBundle, error) {
+	bundle := &SPIFFEBundle{
+		Keys:        make([]SPIFFEBundleKey, 0, len(cas)),
+		Sequence:    sequence,
+		RefreshHint: int64(refreshHint / time.Second),
+	}
+	for _, ca := range cas {
+		if !ca.IsCA {
+			return nil, errors.Errorf(ctx, "certificate '%s' is no ca", ca.Subject.String())
+		}
+		key, err := newSPIFFEBundleKey(ctx, ca)
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "create key of '%s' failed", ca.Subject.String())
+		}
+		bundle.Keys = append(bundle.Keys, *key)
+	}
+	return bundle, nil
+}
+
+// Certificates returns the CA certificates of all x509-svid keys.
+func (s SPIFFEBundle) Certificates(ctx context.Context) ([]*x509.Certificate, error) {
+	var result []*x509.Certificate
+	for _, key := range s.Keys {
+		if key.Use != "x509-svid" {
+			continue
+		}
+		if len(key.X5C) != 1 {
+			return nil, errors.Errorf(ctx, "x509-svid key must contain exactly one certificate")
+		}
+		der, err := base64.StdEncoding.DecodeString(key.X5C[0])
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "decode x5c failed")
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "parse x5c failed")
+		}
+		result = append(result, cert)
+	}
+	return result, nil
+}
+
+func newSPIFFEBundleKey(ctx context.Context, ca *x509.Certificate) (*SPIFFEBundleKey, error) {
+	key := &SPIFFEBundleKey{
+		Use: "x509-svid",
+		X5C: []string{base64.StdEncoding.EncodeToString(ca.Raw)},
+	}
+	switch publicKey := ca.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		ecdhKey, err := publicKey.ECDH()
+		if err != nil {
+			return nil, errors.Wrapf(ctx, err, "convert ecdsa key failed")
+		}
+		// uncompressed point 0x04 || x || y
+		point := ecdhKey.Bytes()[1:]
+		key.Kty = "EC"
+		key.Crv = publicKey.Curve.Params().Name
+		key.X = base64.RawURLEncoding.EncodeToString(point[:len(point)/2])
+		key.Y = base64.RawURLEncoding.EncodeToString(point[len(point)/2:])
+	case *rsa.PublicKey:
+		key.Kty = "RSA"
+		key.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		key.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	case ed25519.PublicKey:
+		key.Kty = "OKP"
+		key.Crv = "Ed25519"
+		key.X = base64.RawURLEncoding.EncodeToString(publicKey)
+	default:
+		return nil, errors.Errorf(ctx, "unsupported key type %T", ca.PublicKey)
+	}
+	return key, nil
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SPIFFE", func() {
+	var ctx context.Context
+	var ca *pkg.CA
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		ca, err = pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+	})
+	issueSVID := func(profile pkg.Profile, spiffeID string) *pkg.KeyPair {
+		req := pkg.DefaultServerIssueRequest()
+		if profile == pkg.ProfileClient {
+			req = pkg.DefaultClientIssueRequest()
+		}
+		req, err := pkg.NewSVIDIssueRequest(ctx, req, spiffeID)
+		Expect(err).To(BeNil())
+		keyPair, err := pkg.IssueCertificate(ctx, ca, req)
+		Expect(err).To(BeNil())
+		return keyPair
+	}
+	DescribeTable("ParseSPIFFEID",
+		func(value string, valid bool) {
+			_, err := pkg.ParseSPIFFEID(ctx, value)
+			if valid {
+				Expect(err).To(BeNil())
+			} else {
+				Expect(err).NotTo(BeNil())
+			}
+		},
+		Entry("workload", "spiffe://example.org/ns/prod/sa/web", true),
+		Entry("other scheme", "https://example.org/web", false),
+		Entry("trust domain only", "spiffe://example.org", false),
+		Entry("uppercase trust domain", "spiffe://Example.org/web", false),
+		Entry("port", "spiffe://example.org:8443/web", false),
+		Entry("query", "spiffe://example.org/web?a=b", false),
+		Entry("empty segment", "spiffe://example.org/ns//web", false),
+		Entry("trailing slash", "spiffe://example.org/web/", false),
+		Entry("dot segment", "spiffe://example.org/ns/../web", false),
+	)
+	It("issues x509-svid", func() {
+		keyPair := issueSVID(pkg.ProfileClient, "spiffe://example.org/ns/prod/sa/web")
+		cert := keyPair.Certificate
+		Expect(cert.IsCA).To(BeFalse())
+		Expect(cert.KeyUsage & x509.KeyUsageDigitalSignature).NotTo(BeZero())
+		Expect(cert.KeyUsage & (x509.KeyUsageCertSign | x509.KeyUsageCRLSign)).To(BeZero())
+		id, err := pkg.SPIFFEIDOfCertificate(ctx, cert)
+		Expect(err).To(BeNil())
+		Expect(id).To(Equal("spiffe://example.org/ns/prod/sa/web"))
+	})
+	It("rejects certificate without spiffe id", func() {
+		keyPair, err := pkg.IssueCertificate(ctx, ca, pkg.DefaultClientIssueRequest())
+		Expect(err).To(BeNil())
+		_, err = pkg.SPIFFEIDOfCertificate(ctx, keyPair.Certificate)
+		Expect(err).NotTo(BeNil())
+	})
+	It("matches patterns per path segment", func() {
+		patterns := []string{"spiffe://example.org/ns/*/sa/web"}
+		Expect(pkg.MatchSPIFFEID(patterns, "spiffe://example.org/ns/prod/sa/web")).To(BeTrue())
+		Expect(pkg.MatchSPIFFEID(patterns, "spiffe://example.org/ns/prod/sa/db")).To(BeFalse())
+		Expect(pkg.MatchSPIFFEID(patterns, "spiffe://example.org/ns/a/b/sa/web")).To(BeFalse())
+		Expect(pkg.MatchSPIFFEID(patterns, "spiffe://other.org/ns/prod/sa/web")).To(BeFalse())
+	})
+	It("rejects invalid patterns", func() {
+		_, err := pkg.ParseSPIFFEIDPatterns(ctx, "spiffe://example.org/*, https://example.org/*")
+		Expect(err).NotTo(BeNil())
+		patterns, err := pkg.ParseSPIFFEIDPatterns(ctx, "spiffe://example.org/a, spiffe://example.org/b")
+		Expect(err).To(BeNil())
+		Expect(patterns).To(Equal([]string{"spiffe://example.org/a", "spiffe://example.org/b"}))
+	})
+	Context("NewSPIFFEAuthorizer", func() {
+		var server *httptest.Server
+		BeforeEach(func() {
+			server = httptest.NewUnstartedServer(pkg.NewSPIFFEAuthorizer(
+				[]string{"spiffe://example.org/ns/prod/sa/*"},
+				http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+					_, _ = resp.Write([]byte("ok"))
+				}),
+			))
+			clientCAs := x509.NewCertPool()
+			clientCAs.AddCert(ca.Certificate)
+			server.TLS = &tls.Config{ClientCAs: clientCAs, ClientAuth: tls.VerifyClientCertIfGiven}
+			server.StartTLS()
+		})
+		AfterEach(func() {
+			server.Close()
+		})
+		get := func(keyPair *pkg.KeyPair) int {
+			transport := server.Client().Transport.(*http.Transport).Clone()
+			if keyPair != nil {
+				transport.TLSClientConfig.Certificates = []tls.Certificate{{
+					Certificate: [][]byte{keyPair.Certificate.Raw},
+					PrivateKey:  keyPair.PrivateKey,
+				}}
+			}
+			resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+			Expect(err).To(BeNil())
+			defer resp.Body.Close()
+			return resp.StatusCode
+		}
+		It("allows matching spiffe id", func() {
+			Expect(get(issueSVID(pkg.ProfileClient, "spiffe://example.org/ns/prod/sa/web"))).To(Equal(http.StatusOK))
+		})
+		It("rejects other spiffe id", func() {
+			Expect(get(issueSVID(pkg.ProfileClient, "spiffe://example.org/ns/dev/sa/web"))).To(Equal(http.StatusForbidden))
+		})
+		It("rejects certificate without spiffe id", func() {
+			keyPair, err := pkg.IssueCertificate(ctx, ca, pkg.DefaultClientIssueRequest())
+			Expect(err).To(BeNil())
+			Expect(get(keyPair)).To(Equal(http.StatusForbidden))
+		})
+		It("rejects request without client certificate", func() {
+			Expect(get(nil)).To(Equal(http.StatusUnauthorized))
+		})
+	})
+	Context("SPIFFEBundle", func() {
+		It("contains jwk of ca", func() {
+			bundle, err := pkg.NewSPIFFEBundle(ctx, 3, time.Hour, ca.Certificate)
+			Expect(err).To(BeNil())
+			data, err := json.Marshal(bundle)
+			Expect(err).To(BeNil())
+			var parsed pkg.SPIFFEBundle
+			Expect(json.Unmarshal(data, &parsed)).To(Succeed())
+			Expect(parsed.Sequence).To(Equal(uint64(3)))
+			Expect(parsed.RefreshHint).To(Equal(int64(3600)))
+			Expect(parsed.Keys).To(HaveLen(1))
+			key := parsed.Keys[0]
+			Expect(key.Use).To(Equal("x509-svid"))
+			Expect(key.Kty).To(Equal("EC"))
+			Expect(key.Crv).To(Equal("P-256"))
+			x, err := base64.RawURLEncoding.DecodeString(key.X)
+			Expect(err).To(BeNil())
+			y, err := base64.RawURLEncoding.DecodeString(key.Y)
+			Expect(err).To(BeNil())
+			publicKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			Expect(publicKey.Equal(ca.Certificate.PublicKey)).To(BeTrue())
+
+			certs, err := parsed.Certificates(ctx)
+			Expect(err).To(BeNil())
+			Expect(certs).To(HaveLen(1))
+			Expect(certs[0].Equal(ca.Certificate)).To(BeTrue())
+		})
+		It("rejects leaf certificate", func() {
+			keyPair := issueSVID(pkg.ProfileServer, "spiffe://example.org/web")
+			_, err := pkg.NewSPIFFEBundle(ctx, 0, 0, keyPair.Certificate)
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
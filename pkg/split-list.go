@@ -0,0 +1,19 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import "strings"
+
+// SplitList splits value at separator, trims the parts and drops empty ones.
+func SplitList(value string, separator string) []string {
+	var result []string
+	for _, part := range strings.Split(value, separator) {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bborbe/errors"
+)
+
+// VerifyRequest describes what a leaf certificate is verified against.
+type VerifyRequest struct {
+	Leaf          *x509.Certificate
+	Intermediates []*x509.Certificate
+	Roots         []*x509.Certificate
+	// Purpose is checked against the extended key usages of the chain.
+	Purpose Profile
+	// Hostname is a DNS name or IP address, it is not checked if empty.
+	Hostname string
+	// Time is the point in time the chain must be valid, now if zero.
+	Time time.Time
+}
+
+// VerifyCertificate verifies the leaf certificate and returns the verified chains.
+// Errors carry a plain language explanation of what is wrong.
+func VerifyCertificate(ctx context.Context, req VerifyRequest) ([][]*x509.Certificate, error) {
+	keyUsage, err := extKeyUsageForPurpose(ctx, req.Purpose)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "invalid purpose")
+	}
+	verifyTime := req.Time
+	if verifyTime.IsZero() {
+		verifyTime = time.Now()
+	}
+	roots := x509.NewCertPool()
+	for _, root := range req.Roots {
+		roots.AddCert(root)
+	}
+	intermediates := x509.NewCertPool()
+	for _, intermediate := range req.Intermediates {
+		intermediates.AddCert(intermediate)
+	}
+	chains, err := req.Leaf.Verify(x509.VerifyOptions{
+		DNSName:       req.Hostname,
+		Intermediates: intermediates,
+		Roots:         roots,
+		CurrentTime:   verifyTime,
+		KeyUsages:     []x509.ExtKeyUsage{keyUsage},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(ctx, VerifyError{
+			Explanation: explainVerifyError(err, req, verifyTime),
+			Err:         err,
+		}, "verify certificate failed")
+	}
+	return chains, nil
+}
+
+// VerifyError is returned by VerifyCertificate if no valid chain was found.
+type VerifyError struct {
+	// Explanation describes the problem in plain language.
+	Explanation string
+	Err         error
+}
+
+func (v VerifyError) Error() string {
+	return v.Explanation
+}
+
+func (v VerifyError) Unwrap() error {
+	return v.Err
+}
+
+// explainVerifyError turns an error of x509.Certificate.Verify into a plain language sentence.
+func explainVerifyError(err error, req VerifyRequest, verifyTime time.Time) string {
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &invalidErr) {
+		subject := describeCertificate(invalidErr.Cert)
+		switch invalidErr.Reason {
+		case x509.Expired:
+			if verifyTime.Before(invalidErr.Cert.NotBefore) {
+				return fmt.Sprintf("%s is not valid before %s", subject, invalidErr.Cert.NotBefore.UTC().Format(time.RFC3339))
+			}
+			return fmt.Sprintf("%s expired at %s", subject, invalidErr.Cert.NotAfter.UTC().Format(time.RFC3339))
+		case x509.IncompatibleUsage:
+			return fmt.Sprintf("wrong extended key usage: %s allows %s, but %s authentication is required",
+				describeCertificate(req.Leaf),
+				strings.Join(ExtKeyUsageNames(req.Leaf.ExtKeyUsage), ", "),
+				req.Purpose,
+			)
+		case x509.TooManyIntermediates:
+			return fmt.Sprintf("path length exceeded: %s allows at most %d intermediate CAs below it", subject, invalidErr.Cert.MaxPathLen)
+		case x509.NotAuthorizedToSign:
+			return fmt.Sprintf("%s is not a CA and must not sign certificates", subject)
+		case x509.CANotAuthorizedForThisName, x509.CANotAuthorizedForExtKeyUsage:
+			return fmt.Sprintf("%s is not allowed to issue this certificate: %s", subject, invalidErr.Detail)
+		default:
+			return fmt.Sprintf("%s is invalid: %s", subject, invalidErr.Error())
+		}
+	}
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &hostnameErr) {
+		names := append([]string{}, hostnameErr.Certificate.DNSNames...)
+		for _, ip := range hostnameErr.Certificate.IPAddresses {
+			names = append(names, ip.String())
+		}
+		return fmt.Sprintf("hostname mismatch: %s is valid for [%s], not for %s", describeCertificate(hostnameErr.Certificate), strings.Join(names, ", "), hostnameErr.Host)
+	}
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthorityErr) {
+		return fmt.Sprintf("unknown authority: %s is issued by %s, which is not a trusted CA", describeCertificate(req.Leaf), req.Leaf.Issuer.String())
+	}
+	return err.Error()
+}
+
+func describeCertificate(cert *x509.Certificate) string {
+	if cert == nil {
+		return "certificate"
+	}
+	return fmt.Sprintf("certificate '%s'", cert.Subject.String())
+}
+
+func extKeyUsageForPurpose(ctx context.Context, purpose Profile) (x509.ExtKeyUsage, error) {
+	switch purpose {
+	case ProfileServer:
+		return x509.ExtKeyUsageServerAuth, nil
+	case ProfileClient:
+		return x509.ExtKeyUsageClientAuth, nil
+	default:
+		return 0, errors.Errorf(ctx, "unknown purpose '%s'", purpose)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"crypto/x509"
+	"time"
+
+	"github.com/bborbe/errors"
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("VerifyCertificate", func() {
+	var ctx context.Context
+	var ca *pkg.CA
+	var req pkg.VerifyRequest
+	var err error
+	BeforeEach(func() {
+		ctx = context.Background()
+		ca, err = pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		keyPair, err := pkg.IssueCertificate(ctx, ca, pkg.DefaultServerIssueRequest())
+		Expect(err).To(BeNil())
+		req = pkg.VerifyRequest{
+			Leaf:     keyPair.Certificate,
+			Roots:    []*x509.Certificate{ca.Certificate},
+			Purpose:  pkg.ProfileServer,
+			Hostname: "localhost",
+		}
+	})
+	explanation := func() string {
+		_, err := pkg.VerifyCertificate(ctx, req)
+		Expect(err).NotTo(BeNil())
+		var verifyErr pkg.VerifyError
+		Expect(errors.As(err, &verifyErr)).To(BeTrue())
+		return verifyErr.Explanation
+	}
+	It("accepts valid certificate", func() {
+		chains, err := pkg.VerifyCertificate(ctx, req)
+		Expect(err).To(BeNil())
+		Expect(chains).To(HaveLen(1))
+	})
+	It("explains expired", func() {
+		req.Time = time.Now().Add(2 * 365 * 24 * time.Hour)
+		Expect(explanation()).To(ContainSubstring("expired at"))
+	})
+	It("explains wrong eku", func() {
+		req.Purpose = pkg.ProfileClient
+		Expect(explanation()).To(ContainSubstring("wrong extended key usage"))
+	})
+	It("explains hostname mismatch", func() {
+		req.Hostname = "example.com"
+		Expect(explanation()).To(ContainSubstring("hostname mismatch"))
+	})
+	It("explains unknown authority", func() {
+		other, err := pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		req.Roots = []*x509.Certificate{other.Certificate}
+		Expect(explanation()).To(ContainSubstring("unknown authority"))
+	})
+})
@@ -0,0 +1,124 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/bborbe/errors"
+)
+
+const (
+	// PrivateKeyFileMode is only readable by the owner.
+	PrivateKeyFileMode os.FileMode = 0600
+	// CertificateFileMode is readable by everyone.
+	CertificateFileMode os.FileMode = 0644
+)
+
+// WritePrivateKeyFile writes a private key atomically with PrivateKeyFileMode.
+func WritePrivateKeyFile(ctx context.Context, path string, data []byte) error {
+	return WriteFileAtomic(ctx, path, data, PrivateKeyFileMode)
+}
+
+// WriteCertificateFile writes a certificate atomically with CertificateFileMode.
+func WriteCertificateFile(ctx context.Context, path string, data []byte) error {
+	return WriteFileAtomic(ctx, path, data, CertificateFileMode)
+}
+
+// WriteFileAtomic writes data to a temp file next to path, fsyncs it and renames it to path.
+// Readers see either the old or the complete new content, never a partial write.
+func WriteFileAtomic(ctx context.Context, path string, data []byte, perm os.FileMode) error {
+	return writeFilesAtomic(ctx, atomicFile{path: path, data: data, perm: perm})
+}
+
+// atomicFile is one file written by writeFilesAtomic.
+type atomicFile struct {
+	path string
+	data []byte
+	perm os.FileMode
+}
+
+// writeFilesAtomic writes all files to fsynced temp files before renaming them in the given order,
+// so a failing write leaves all files unchanged. The renames are not atomic together,
+// callers order them so a reader in between fails instead of using a mismatched set.
+func writeFilesAtomic(ctx context.Context, files ...atomicFile) error {
+	tmpPaths := make([]string, 0, len(files))
+	defer func() {
+		for _, tmpPath := range tmpPaths {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+	for _, file := range files {
+		tmpPath, err := stageFile(ctx, file)
+		if err != nil {
+			return err
+		}
+		tmpPaths = append(tmpPaths, tmpPath)
+	}
+	for len(tmpPaths) > 0 {
+		tmpPath, path := tmpPaths[0], files[0].path
+		if err := os.Rename(tmpPath, path); err != nil {
+			return errors.Wrapf(ctx, err, "rename %s to %s failed", tmpPath, path)
+		}
+		tmpPaths, files = tmpPaths[1:], files[1:]
+		dir := filepath.Dir(path)
+		if err := syncDir(dir); err != nil {
+			return errors.Wrapf(ctx, err, "sync dir %s failed", dir)
+		}
+	}
+	return nil
+}
+
+// stageFile writes file to a fsynced temp file next to its path and returns the temp path.
+func stageFile(ctx context.Context, file atomicFile) (string, error) {
+	dir := filepath.Dir(file.path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(file.path)+".tmp-*")
+	if err != nil {
+		return "", errors.Wrapf(ctx, err, "create temp file in %s failed", dir)
+	}
+	tmpPath := tmp.Name()
+	if err := writeTemp(tmp, file); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return "", errors.Wrapf(ctx, err, "write %s failed", tmpPath)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return "", errors.Wrapf(ctx, err, "close %s failed", tmpPath)
+	}
+	return tmpPath, nil
+}
+
+func writeTemp(tmp *os.File, file atomicFile) error {
+	if err := tmp.Chmod(file.perm); err != nil {
+		return err
+	}
+	if _, err := tmp.Write(file.data); err != nil {
+		return err
+	}
+	return tmp.Sync()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+func readFile(ctx context.Context, path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(ctx, err, "read %s failed", path)
+	}
+	return data, nil
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2024 Benjamin Borbe All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/bborbe/sample_cert/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteFileAtomic", func() {
+	var ctx context.Context
+	var dir string
+	BeforeEach(func() {
+		ctx = context.Background()
+		dir = GinkgoT().TempDir()
+	})
+	It("writes private keys only readable by owner", func() {
+		path := filepath.Join(dir, "key.pem")
+		Expect(pkg.WritePrivateKeyFile(ctx, path, []byte("key"))).To(Succeed())
+		stat, err := os.Stat(path)
+		Expect(err).To(BeNil())
+		Expect(stat.Mode().Perm()).To(Equal(os.FileMode(0600)))
+	})
+	It("writes certificates readable by everyone", func() {
+		path := filepath.Join(dir, "cert.pem")
+		Expect(pkg.WriteCertificateFile(ctx, path, []byte("cert"))).To(Succeed())
+		stat, err := os.Stat(path)
+		Expect(err).To(BeNil())
+		Expect(stat.Mode().Perm()).To(Equal(os.FileMode(0644)))
+	})
+	It("replaces existing content and leaves no temp files", func() {
+		path := filepath.Join(dir, "cert.pem")
+		Expect(os.WriteFile(path, []byte("old"), 0666)).To(Succeed())
+		Expect(pkg.WriteCertificateFile(ctx, path, []byte("new"))).To(Succeed())
+		content, err := os.ReadFile(path)
+		Expect(err).To(BeNil())
+		Expect(string(content)).To(Equal("new"))
+		entries, err := os.ReadDir(dir)
+		Expect(err).To(BeNil())
+		Expect(entries).To(HaveLen(1))
+	})
+	It("returns error if directory is missing", func() {
+		Expect(pkg.WriteCertificateFile(ctx, filepath.Join(dir, "missing", "cert.pem"), []byte("cert"))).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("WriteKeyPair", func() {
+	var ctx context.Context
+	var dir string
+	var keyPair *pkg.KeyPair
+	BeforeEach(func() {
+		ctx = context.Background()
+		dir = GinkgoT().TempDir()
+		ca, err := pkg.CreateCA(ctx, pkg.DefaultCARequest())
+		Expect(err).To(BeNil())
+		keyPair = &pkg.KeyPair{Certificate: ca.Certificate, PrivateKey: ca.Signer}
+	})
+	It("writes a matching pair and leaves no temp files", func() {
+		certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+		Expect(pkg.WriteKeyPair(ctx, keyPair, certPath, keyPath)).To(Succeed())
+		Expect(pkg.CheckKeyPairFiles(ctx, certPath, keyPath)).To(Succeed())
+		entries, err := os.ReadDir(dir)
+		Expect(err).To(BeNil())
+		Expect(entries).To(HaveLen(2))
+	})
+	It("leaves the certificate unchanged if the key can't be written", func() {
+		certPath := filepath.Join(dir, "cert.pem")
+		Expect(os.WriteFile(certPath, []byte("old"), 0644)).To(Succeed())
+		Expect(pkg.WriteKeyPair(ctx, keyPair, certPath, filepath.Join(dir, "missing", "key.pem"))).NotTo(Succeed())
+		content, err := os.ReadFile(certPath)
+		Expect(err).To(BeNil())
+		Expect(string(content)).To(Equal("old"))
+		entries, err := os.ReadDir(dir)
+		Expect(err).To(BeNil())
+		Expect(entries).To(HaveLen(1))
+	})
+})